@@ -14,8 +14,15 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"expvar"
+	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/mendersoftware/go-lib-micro/log"
@@ -32,15 +39,70 @@ const (
 	uriDevices      = "/api/management/v1/admission/devices"
 	uriDevice       = "/api/management/v1/admission/devices/:id"
 	uriDeviceStatus = "/api/management/v1/admission/devices/:id/status"
+	uriDevicesBatch = "/api/management/v1/admission/devices/batch"
+	uriDevicesTags  = "/api/management/v1/admission/devices/tags"
+	uriDeviceTags   = "/api/management/v1/admission/devices/:id/tags"
+	uriDeviceTag    = "/api/management/v1/admission/devices/:id/tags/:tag"
+	uriDeviceNotes  = "/api/management/v1/admission/devices/:id/notes"
+
+	uriWebhooks          = "/api/management/v1/admission/webhooks"
+	uriWebhook           = "/api/management/v1/admission/webhooks/:id"
+	uriWebhookDeliveries = "/api/management/v1/admission/webhooks/:id/deliveries"
+
+	uriEvents = "/api/management/v1/admission/events"
+
+	uriSettings  = "/api/management/v1/admission/settings"
+	uriApprovals = "/api/management/v1/admission/approvals"
+	uriApproval  = "/api/management/v1/admission/approvals/:id"
+
+	uriBlocklist      = "/api/management/v1/admission/blocklist"
+	uriBlocklistEntry = "/api/management/v1/admission/blocklist/:id"
+	uriBlocklistHits  = "/api/management/v1/admission/blocklist/hits"
+
+	uriAllowlist      = "/api/management/v1/admission/allowlist"
+	uriAllowlistEntry = "/api/management/v1/admission/allowlist/:id"
+
+	uriEnrollmentTokens = "/api/management/v1/admission/enrollment_tokens"
+	uriEnrollmentToken  = "/api/management/v1/admission/enrollment_tokens/:id"
+
+	uriCACertificates = "/api/management/v1/admission/ca_certificates"
+	uriCACertificate  = "/api/management/v1/admission/ca_certificates/:id"
+
+	uriManifests    = "/api/management/v1/admission/manifests"
+	uriManifestKeys = "/api/management/v1/admission/manifest_keys"
+	uriManifestKey  = "/api/management/v1/admission/manifest_keys/:id"
+
+	uriQuota = "/api/management/v1/admission/quota"
+
+	uriIdentitySchema = "/api/management/v1/admission/identity_schema"
+
+	uriTrash        = "/api/management/v1/admission/trash"
+	uriTrashRestore = "/api/management/v1/admission/trash/:id/restore"
 
 	//internal api
 	uriDevicesInternal      = "/api/internal/v1/admission/devices"
 	uriDeviceInternal       = "/api/internal/v1/admission/devices/:id"
 	uriDeviceStatusInternal = "/api/internal/v1/admission/devices/:id/status"
 
-	uriTenants = "/api/internal/v1/admission/tenants"
+	uriTenants     = "/api/internal/v1/admission/tenants"
+	uriTenantQuota = "/api/internal/v1/admission/tenants/:tid/quota"
+
+	uriMetrics = "/api/internal/v1/admission/metrics"
 )
 
+// ExtraContentTypes lists media types accepted in request bodies besides
+// 'application/json', by URL path
+var ExtraContentTypes = map[string][]string{
+	uriAllowlist:    {"text/csv"},
+	uriDevicesBatch: {"text/csv", "application/x-ndjson"},
+}
+
+// ExtraMethodContentTypes lists media types accepted in request bodies
+// besides 'application/json', by request method
+var ExtraMethodContentTypes = map[string][]string{
+	http.MethodPatch: {"application/merge-patch+json"},
+}
+
 // model of device status response at /devices/:id/status endpoint,
 // the response is a stripped down version of the device containing
 // only the status field
@@ -48,6 +110,19 @@ type DevAdmApiStatus struct {
 	Status string `json:"status"`
 }
 
+// model of device response at /devices/:id endpoint, the auth set along
+// with statuses it may be moved to
+type DevAdmApiDevice struct {
+	*model.DeviceAuth
+	NextStatuses []string `json:"next_statuses"`
+}
+
+// detailed variant of DevAdmApiDevice
+type DevAdmApiDeviceDetails struct {
+	*model.DeviceAuthDetails
+	NextStatuses []string `json:"next_statuses"`
+}
+
 type DevAdmHandlers struct {
 	DevAdm devadm.App
 }
@@ -62,22 +137,75 @@ func NewDevAdmApiHandlers(devadm devadm.App) ApiHandler {
 func (d *DevAdmHandlers) GetApp() (rest.App, error) {
 	routes := []*rest.Route{
 		rest.Get(uriDevices, d.GetDevicesHandler),
-		rest.Post(uriDevices, d.PostDevicesHandler),
+		rest.Post(uriDevices, d.idempotent(d.PostDevicesHandler, idempotencyErrWithLog)),
+		rest.Post(uriDevicesBatch, d.idempotent(d.PostDevicesBatchHandler, idempotencyErrWithLog)),
+		rest.Post(uriDevicesTags, d.idempotent(d.PostDevicesTagsHandler, idempotencyErrWithLog)),
+		rest.Post(uriDeviceTags, d.PostDeviceTagsHandler),
+		rest.Delete(uriDeviceTag, d.DeleteDeviceTagHandler),
+		rest.Post(uriDeviceNotes, d.PostDeviceNotesHandler),
+		rest.Get(uriDeviceNotes, d.GetDeviceNotesHandler),
 		rest.Delete(uriDevicesInternal, d.DeleteDevicesHandler),
 
 		rest.Put(uriDevice, d.SubmitDeviceHandler),
 		rest.Get(uriDevice, d.GetDeviceHandler),
+		rest.Patch(uriDevice, d.PatchDeviceHandler),
 		rest.Delete(uriDeviceInternal, d.DeleteDeviceHandler),
 		rest.Delete(uriDevice, d.DeleteDeviceManagementHandler),
 
 		rest.Get(uriDeviceStatus, d.GetDeviceStatusHandler),
-		rest.Put(uriDeviceStatus, d.UpdateDeviceStatusHandler),
+		rest.Put(uriDeviceStatus, d.idempotent(d.UpdateDeviceStatusHandler, idempotencyErrWithLog)),
 		rest.Put(uriDeviceStatusInternal, d.AcceptPreauthorizedHandler),
 
 		rest.Post(uriTenants, d.ProvisionTenantHandler),
+		rest.Put(uriTenantQuota, d.PutTenantQuotaHandler),
+		rest.Get(uriMetrics, d.GetMetricsHandler),
+
+		rest.Post(uriWebhooks, d.PostWebhooksHandler),
+		rest.Get(uriWebhooks, d.GetWebhooksHandler),
+		rest.Get(uriWebhook, d.GetWebhookHandler),
+		rest.Delete(uriWebhook, d.DeleteWebhookHandler),
+		rest.Get(uriWebhookDeliveries, d.GetWebhookDeliveriesHandler),
+
+		rest.Get(uriEvents, d.GetEventsHandler),
+
+		rest.Get(uriSettings, d.GetSettingsHandler),
+		rest.Put(uriSettings, d.PutSettingsHandler),
+		rest.Get(uriApprovals, d.GetApprovalsHandler),
+		rest.Delete(uriApproval, d.DeleteApprovalHandler),
+
+		rest.Post(uriBlocklist, d.PostBlocklistHandler),
+		rest.Get(uriBlocklist, d.GetBlocklistHandler),
+		rest.Delete(uriBlocklistEntry, d.DeleteBlocklistEntryHandler),
+		rest.Get(uriBlocklistHits, d.GetBlocklistHitsHandler),
+
+		rest.Post(uriAllowlist, d.PostAllowlistHandler),
+		rest.Get(uriAllowlist, d.GetAllowlistHandler),
+		rest.Delete(uriAllowlistEntry, d.DeleteAllowlistEntryHandler),
+
+		rest.Post(uriEnrollmentTokens, d.PostEnrollmentTokensHandler),
+		rest.Get(uriEnrollmentTokens, d.GetEnrollmentTokensHandler),
+		rest.Delete(uriEnrollmentToken, d.DeleteEnrollmentTokenHandler),
+
+		rest.Post(uriCACertificates, d.PostCACertificatesHandler),
+		rest.Get(uriCACertificates, d.GetCACertificatesHandler),
+		rest.Delete(uriCACertificate, d.DeleteCACertificateHandler),
+
+		rest.Post(uriManifests, d.PostManifestsHandler),
+		rest.Post(uriManifestKeys, d.PostManifestKeysHandler),
+		rest.Get(uriManifestKeys, d.GetManifestKeysHandler),
+		rest.Delete(uriManifestKey, d.DeleteManifestKeyHandler),
+
+		rest.Get(uriQuota, d.GetQuotaHandler),
+
+		rest.Get(uriIdentitySchema, d.GetIdentitySchemaHandler),
+		rest.Put(uriIdentitySchema, d.PutIdentitySchemaHandler),
+		rest.Delete(uriIdentitySchema, d.DeleteIdentitySchemaHandler),
+
+		rest.Get(uriTrash, d.GetTrashHandler),
+		rest.Post(uriTrashRestore, d.RestoreDeviceHandler),
 	}
 
-	routes = append(routes)
+	routes = append(routes, d.v2Routes()...)
 
 	app, err := rest.MakeRouter(
 		// augment routes with OPTIONS handler
@@ -113,12 +241,19 @@ func (d *DevAdmHandlers) GetDevicesHandler(w rest.ResponseWriter, r *rest.Reques
 		return
 	}
 
+	tags := r.URL.Query()["tag"]
+	if err := model.ValidateTags(tags); err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
 	//get one extra device to see if there's a 'next' page
 	devs, err := d.DevAdm.ListDeviceAuths(ctx,
 		int((page-1)*perPage), int(perPage+1),
 		store.Filter{
 			Status:   status,
 			DeviceID: model.DeviceID(deviceId),
+			Tags:     tags,
 		})
 	if err != nil {
 		restErrWithLogInternal(w, r, l, errors.Wrap(err, "failed to list devices"))
@@ -145,7 +280,13 @@ func (d *DevAdmHandlers) PostDevicesHandler(w rest.ResponseWriter, r *rest.Reque
 	l := log.FromContext(ctx)
 
 	defer r.Body.Close()
-	authSet, err := model.ParseAuthSet(r.Body)
+	schema, err := d.DevAdm.GetIdentitySchema(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	authSet, err := model.ParseAuthSet(r.Body, schema)
 	if err != nil {
 		restErrWithLog(w, r, l, err, http.StatusBadRequest)
 		return
@@ -158,6 +299,155 @@ func (d *DevAdmHandlers) PostDevicesHandler(w rest.ResponseWriter, r *rest.Reque
 		w.WriteHeader(http.StatusCreated)
 	case devadm.AuthSetConflictError:
 		restErrWithLog(w, r, l, err, http.StatusConflict)
+	case devadm.ErrDeviceBlocked:
+		restErrWithLog(w, r, l, err, http.StatusForbidden)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+// PostDevicesBatchHandler preauthorizes auth sets given either as CSV or
+// as JSON Lines, and reports the outcome for each of them
+func (d *DevAdmHandlers) PostDevicesBatchHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	schema, err := d.DevAdm.GetIdentitySchema(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	var rows []model.AuthSetRow
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediatype {
+	case "text/csv":
+		rows, err = model.ParseAuthSetsCSV(r.Body, schema)
+	case "application/x-ndjson":
+		rows, err = model.ParseAuthSetsNDJSON(r.Body, schema)
+	default:
+		restErrWithLog(w, r, l,
+			errors.New("Content-Type must be one of text/csv, application/x-ndjson"),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	report, err := d.DevAdm.PreauthorizeDevices(ctx, rows, r.Header.Get("Authorization"))
+	switch {
+	case err == nil:
+		break
+	case report != nil:
+		// interrupted, tell what was done so far; not a success, so that
+		// the response is not replayed to a retry
+		l.Error(err.Error())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(report)
+}
+
+// PostDevicesTagsHandler adds and removes tags of multiple auth sets
+func (d *DevAdmHandlers) PostDevicesTagsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	req, err := model.ParseTagsReq(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	res, err := d.DevAdm.UpdateTags(ctx, *req)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(res)
+}
+
+func (d *DevAdmHandlers) PostDeviceTagsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	tags, err := model.ParseTags(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	err = d.DevAdm.TagDeviceAuth(ctx, model.AuthID(r.PathParam("id")), tags)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case store.ErrNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) DeleteDeviceTagHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.UntagDeviceAuth(ctx, model.AuthID(r.PathParam("id")),
+		r.PathParam("tag"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case store.ErrNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) PostDeviceNotesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	req, err := model.ParseNoteReq(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	note, err := d.DevAdm.AddNote(ctx, model.AuthID(r.PathParam("id")), *req)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusCreated)
+		w.WriteJson(note)
+	case store.ErrNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	case devadm.ErrNoNoteAuthor:
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetDeviceNotesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	notes, err := d.DevAdm.ListNotes(ctx, model.AuthID(r.PathParam("id")))
+	switch err {
+	case nil:
+		w.WriteJson(notes)
+	case store.ErrNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
 	default:
 		restErrWithLogInternal(w, r, l, err)
 	}
@@ -187,7 +477,13 @@ func (d *DevAdmHandlers) SubmitDeviceHandler(w rest.ResponseWriter, r *rest.Requ
 	ctx := r.Context()
 	l := log.FromContext(ctx)
 
-	dev, err := parseDevice(r)
+	schema, err := d.DevAdm.GetIdentitySchema(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	dev, err := parseDevice(r, schema)
 	if err != nil {
 		restErrWithLog(w, r, l, err, http.StatusBadRequest)
 		return
@@ -196,7 +492,13 @@ func (d *DevAdmHandlers) SubmitDeviceHandler(w rest.ResponseWriter, r *rest.Requ
 	//save device in pending state
 	dev.Status = model.DevStatusPending
 	err = d.DevAdm.SubmitDeviceAuth(ctx, *dev)
-	if err != nil {
+	if rerr, ok := errors.Cause(err).(*devadm.RateLimitError); ok {
+		// whole seconds, rounded up
+		secs := (rerr.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(secs)))
+		restErrWithLog(w, r, l, err, http.StatusTooManyRequests)
+		return
+	} else if err != nil {
 		restErrWithLogInternal(w, r, l, err)
 		return
 	}
@@ -204,7 +506,33 @@ func (d *DevAdmHandlers) SubmitDeviceHandler(w rest.ResponseWriter, r *rest.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func parseDevice(r *rest.Request) (*model.DeviceAuth, error) {
+// etag returns the entity tag of auth set 'dev', i.e. its quoted revision
+func etag(dev *model.DeviceAuth) string {
+	return strconv.Quote(strconv.Itoa(dev.Revision))
+}
+
+// ifMatchContext returns the request context, requiring the auth set
+// revision given in If-Match header if present; an entity tag that is not
+// a revision cannot match
+func ifMatchContext(r *rest.Request) (context.Context, error) {
+	ctx := r.Context()
+
+	tag := strings.TrimSpace(r.Header.Get("If-Match"))
+	if tag == "" || tag == "*" {
+		return ctx, nil
+	}
+
+	rev, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(tag, "W/"), `"`))
+	if err != nil {
+		return nil, devadm.ErrRevisionMismatch
+	}
+	return devadm.WithRevision(ctx, rev), nil
+}
+
+// parseDevice parses a submitted auth set; auth sets violating identity
+// schema 'schema', if set, are flagged but not rejected, so that they can
+// be inspected
+func parseDevice(r *rest.Request, schema *model.IdentitySchema) (*model.DeviceAuth, error) {
 	dev := model.DeviceAuth{}
 
 	//decode body
@@ -243,6 +571,8 @@ func parseDevice(r *rest.Request) (*model.DeviceAuth, error) {
 	if len(dev.Attributes) == 0 {
 		return nil, errors.New("no attributes provided")
 	}
+
+	dev.SchemaViolation = schema != nil && schema.Check(dev.Attributes) != nil
 	return &dev, nil
 }
 
@@ -271,23 +601,117 @@ func (d *DevAdmHandlers) getDeviceOrFail(w rest.ResponseWriter, r *rest.Request)
 }
 
 func (d *DevAdmHandlers) GetDeviceHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	details, err := utils.ParseQueryParmBool(r, "details", false, false)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	if details {
+		dev, err := d.DevAdm.GetDeviceAuthDetails(ctx,
+			model.AuthID(r.PathParam("id")))
+		switch err {
+		case nil:
+			break
+		case store.ErrNotFound:
+			restErrWithLog(w, r, l, err, http.StatusNotFound)
+			return
+		default:
+			restErrWithLogInternal(w, r, l, err)
+			return
+		}
+
+		next, err := d.DevAdm.NextStatuses(ctx, &dev.DeviceAuth)
+		if err != nil {
+			restErrWithLogInternal(w, r, l, err)
+			return
+		}
+		w.Header().Set("ETag", etag(&dev.DeviceAuth))
+		w.WriteJson(&DevAdmApiDeviceDetails{dev, next})
+		return
+	}
+
 	dev := d.getDeviceOrFail(w, r)
 	// getDeviceOrFail() has already produced a suitable error
 	// response if device was not found or something else happened
+	if dev == nil {
+		return
+	}
 
-	if dev != nil {
-		w.WriteJson(dev)
+	next, err := d.DevAdm.NextStatuses(ctx, dev)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+	w.Header().Set("ETag", etag(dev))
+	w.WriteJson(&DevAdmApiDevice{dev, next})
+}
+
+// PatchDeviceHandler applies a JSON Merge Patch of attributes, tags, expiry
+// and notes to an auth set
+func (d *DevAdmHandlers) PatchDeviceHandler(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	ctx, err := ifMatchContext(r)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	defer r.Body.Close()
+	patch, err := model.ParseDeviceAuthPatch(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l,
+			errors.Wrap(err, "failed to decode patch"),
+			http.StatusBadRequest)
+		return
+	}
+
+	dev, err := d.DevAdm.PatchDeviceAuth(ctx, model.AuthID(r.PathParam("id")), *patch)
+	switch {
+	case err == nil:
+		break
+	case err == store.ErrNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+		return
+	case err == devadm.ErrRevisionMismatch:
+		restErrWithLog(w, r, l, err, http.StatusPreconditionFailed)
+		return
+	case err == devadm.ErrNoNoteAuthor,
+		err == devadm.ErrNoAttributesLeft,
+		utils.IsUsageError(errors.Cause(err)):
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	default:
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	next, err := d.DevAdm.NextStatuses(ctx, dev)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
 	}
+	w.Header().Set("ETag", etag(dev))
+	w.WriteJson(&DevAdmApiDevice{dev, next})
 }
 
 func (d *DevAdmHandlers) UpdateDeviceStatusHandler(w rest.ResponseWriter, r *rest.Request) {
-	ctx := r.Context()
-	l := log.FromContext(ctx)
+	l := log.FromContext(r.Context())
 
 	authid := r.PathParam("id")
 
+	ctx, err := ifMatchContext(r)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusPreconditionFailed)
+		return
+	}
+
 	var status DevAdmApiStatus
-	err := r.DecodeJsonPayload(&status)
+	err = r.DecodeJsonPayload(&status)
 	if err != nil {
 		restErrWithLog(w, r, l,
 			errors.Wrap(err, "failed to decode status data"),
@@ -297,7 +721,8 @@ func (d *DevAdmHandlers) UpdateDeviceStatusHandler(w rest.ResponseWriter, r *res
 
 	// validate status
 	if status.Status != model.DevStatusAccepted &&
-		status.Status != model.DevStatusRejected {
+		status.Status != model.DevStatusRejected &&
+		status.Status != model.DevStatusSuspended {
 		restErrWithLog(w, r, l,
 			errors.New("incorrect device status"),
 			http.StatusBadRequest)
@@ -308,12 +733,25 @@ func (d *DevAdmHandlers) UpdateDeviceStatusHandler(w rest.ResponseWriter, r *res
 		err = d.DevAdm.AcceptDeviceAuth(ctx, model.AuthID(authid))
 	} else if status.Status == model.DevStatusRejected {
 		err = d.DevAdm.RejectDeviceAuth(ctx, model.AuthID(authid))
+	} else if status.Status == model.DevStatusSuspended {
+		err = d.DevAdm.SuspendDeviceAuth(ctx, model.AuthID(authid))
 	}
 	if err != nil {
 		if utils.IsUsageError(err) {
 			restErrWithLog(w, r, l, err, http.StatusUnprocessableEntity)
 		} else if err == store.ErrNotFound {
 			restErrWithLog(w, r, l, err, http.StatusNotFound)
+		} else if err == devadm.ErrApprovalsPending {
+			// approval was recorded, but the status did not change yet
+			w.WriteHeader(http.StatusAccepted)
+		} else if err == devadm.ErrNoApprover {
+			restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		} else if err == devadm.ErrQuotaExceeded {
+			restErrWithLog(w, r, l, err, http.StatusPaymentRequired)
+		} else if err == devadm.ErrInvalidTransition {
+			restErrWithLog(w, r, l, err, http.StatusConflict)
+		} else if err == devadm.ErrRevisionMismatch {
+			restErrWithLog(w, r, l, err, http.StatusPreconditionFailed)
 		} else {
 			restErrWithLogInternal(w, r, l,
 				errors.Wrap(err,
@@ -355,6 +793,8 @@ func (d *DevAdmHandlers) AcceptPreauthorizedHandler(w rest.ResponseWriter, r *re
 		restErrWithLog(w, r, l, err, http.StatusConflict)
 	case devadm.ErrAuthNotFound:
 		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	case devadm.ErrQuotaExceeded:
+		restErrWithLog(w, r, l, err, http.StatusPaymentRequired)
 	default:
 		restErrWithLogInternal(w, r, l, err)
 	}
@@ -366,6 +806,7 @@ func (d *DevAdmHandlers) GetDeviceStatusHandler(w rest.ResponseWriter, r *rest.R
 	// response if device was not found or something else happened
 
 	if dev != nil {
+		w.Header().Set("ETag", etag(dev))
 		w.WriteJson(DevAdmApiStatus{
 			dev.Status,
 		})
@@ -389,14 +830,25 @@ func (d *DevAdmHandlers) DeleteDeviceHandler(w rest.ResponseWriter, r *rest.Requ
 }
 
 func (d *DevAdmHandlers) DeleteDeviceManagementHandler(w rest.ResponseWriter, r *rest.Request) {
-	ctx := r.Context()
-	l := log.FromContext(ctx)
+	l := log.FromContext(r.Context())
 
 	devid := r.PathParam("id")
 
-	err := d.DevAdm.DeleteDeviceAuthPropagate(ctx, model.AuthID(devid), r.Header.Get("Authorization"))
+	ctx, err := ifMatchContext(r)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusPreconditionFailed)
+		return
+	}
+
+	err = d.DevAdm.DeleteDeviceAuthPropagate(ctx, model.AuthID(devid), r.Header.Get("Authorization"))
 
-	if err != nil && err != store.ErrNotFound {
+	switch err {
+	case nil, store.ErrNotFound:
+		break
+	case devadm.ErrRevisionMismatch:
+		restErrWithLog(w, r, l, err, http.StatusPreconditionFailed)
+		return
+	default:
 		restErrWithLogInternal(w, r, l, err)
 		return
 	}
@@ -425,6 +877,772 @@ func (d *DevAdmHandlers) ProvisionTenantHandler(w rest.ResponseWriter, r *rest.R
 	w.WriteHeader(http.StatusCreated)
 }
 
+func (d *DevAdmHandlers) PostWebhooksHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	req, err := model.ParseWebhookReq(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	hook, err := d.DevAdm.CreateWebhook(ctx, *req)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.Header().Add("Location", "webhooks/"+hook.ID)
+	w.WriteHeader(http.StatusCreated)
+	w.WriteJson(hook)
+}
+
+func (d *DevAdmHandlers) GetWebhooksHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	hooks, err := d.DevAdm.ListWebhooks(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(hooks)
+}
+
+func (d *DevAdmHandlers) GetWebhookHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	hook, err := d.DevAdm.GetWebhook(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteJson(hook)
+	case devadm.ErrWebhookNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) DeleteWebhookHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.DeleteWebhook(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrWebhookNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetWebhookDeliveriesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	page, perPage, err := utils.ParsePagination(r)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	//get one extra delivery to see if there's a 'next' page
+	deliveries, err := d.DevAdm.ListWebhookDeliveries(ctx, r.PathParam("id"),
+		int((page-1)*perPage), int(perPage+1))
+	switch err {
+	case nil:
+		break
+	case devadm.ErrWebhookNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+		return
+	default:
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	len := len(deliveries)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.WriteJson(deliveries[:len])
+}
+
+// GetEventsHandler streams admission events as Server-Sent Events; the
+// stream is resumed after the event given in Last-Event-ID header (or
+// 'last_event_id' query parameter, for clients unable to set headers).
+// An idle stream gets a comment line every poll interval, so that proxies
+// do not cut it.
+func (d *DevAdmHandlers) GetEventsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	lastID := int64(-1)
+	lastIDStr := r.Header.Get("Last-Event-ID")
+	if lastIDStr == "" {
+		lastIDStr = r.URL.Query().Get("last_event_id")
+	}
+	if lastIDStr != "" {
+		id, err := strconv.ParseInt(lastIDStr, 10, 64)
+		if err != nil || id < 0 {
+			restErrWithLog(w, r, l,
+				errors.New("invalid last event ID"),
+				http.StatusBadRequest)
+			return
+		}
+		lastID = id
+	}
+
+	hw, ok := w.(http.ResponseWriter)
+	flusher, fok := w.(http.Flusher)
+	if !ok || !fok {
+		restErrWithLogInternal(w, r, l,
+			errors.New("streaming is not supported by response writer"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// disable response buffering in nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err := d.DevAdm.WatchEvents(ctx, lastID, func(ev *model.Event) error {
+		if ev == nil {
+			if _, err := fmt.Fprint(hw, ": keepalive\n\n"); err != nil {
+				return errors.Wrap(err, "failed to send keepalive")
+			}
+			flusher.Flush()
+			return nil
+		}
+
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode event")
+		}
+
+		_, err = fmt.Fprintf(hw, "id: %d\nevent: %s\ndata: %s\n\n",
+			ev.ID, ev.Type, data)
+		if err != nil {
+			return errors.Wrap(err, "failed to send event")
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		// headers are gone already, client will reconnect
+		l.Errorf("event stream failed: %v", err)
+	}
+}
+
+func (d *DevAdmHandlers) GetSettingsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	settings, err := d.DevAdm.GetSettings(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(settings)
+}
+
+func (d *DevAdmHandlers) PutSettingsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	settings, err := model.ParseTenantSettings(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	err = d.DevAdm.UpdateSettings(ctx, *settings)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrApprovalsPending:
+		// approval was recorded, but the settings did not change yet
+		w.WriteHeader(http.StatusAccepted)
+	case devadm.ErrNoApprover:
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetApprovalsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	authId, err := utils.ParseQueryParmStr(r, "auth_id", false, nil)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	approvals, err := d.DevAdm.ListApprovals(ctx, model.AuthID(authId))
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(approvals)
+}
+
+func (d *DevAdmHandlers) DeleteApprovalHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.WithdrawApproval(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrApprovalNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	case devadm.ErrApprovalNotOwned:
+		restErrWithLog(w, r, l, err, http.StatusForbidden)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) PostBlocklistHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	req, err := model.ParseBlocklistEntryReq(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	entry, err := d.DevAdm.CreateBlocklistEntry(ctx, *req)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.Header().Add("Location", "blocklist/"+entry.ID)
+	w.WriteHeader(http.StatusCreated)
+	w.WriteJson(entry)
+}
+
+func (d *DevAdmHandlers) GetBlocklistHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	entries, err := d.DevAdm.ListBlocklist(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(entries)
+}
+
+func (d *DevAdmHandlers) DeleteBlocklistEntryHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.DeleteBlocklistEntry(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrBlocklistEntryNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetBlocklistHitsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	page, perPage, err := utils.ParsePagination(r)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	//get one extra hit to see if there's a 'next' page
+	hits, err := d.DevAdm.ListBlocklistHits(ctx,
+		int((page-1)*perPage), int(perPage+1))
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	len := len(hits)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.WriteJson(hits[:len])
+}
+
+// PostAllowlistHandler imports allowlist entries given either as CSV (with
+// a header row naming identity attributes) or as a JSON array of identity
+// attribute objects
+func (d *DevAdmHandlers) PostAllowlistHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	singleUse, err := utils.ParseQueryParmBool(r, "single_use", false, false)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+	batch := r.URL.Query().Get("batch")
+	tags := r.URL.Query()["tag"]
+	if err := model.ValidateTags(tags); err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	defer r.Body.Close()
+	var attrs []model.DeviceAuthAttributes
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediatype == "text/csv" {
+		attrs, err = model.ParseAllowlistCSV(r.Body)
+	} else {
+		attrs, err = model.ParseAllowlistJSON(r.Body)
+	}
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	entries, err := d.DevAdm.ImportAllowlist(ctx, batch, singleUse, tags, attrs)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.WriteJson(entries)
+}
+
+func (d *DevAdmHandlers) GetAllowlistHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	page, perPage, err := utils.ParsePagination(r)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	//get one extra entry to see if there's a 'next' page
+	entries, err := d.DevAdm.ListAllowlist(ctx, r.URL.Query().Get("batch"),
+		int((page-1)*perPage), int(perPage+1))
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	len := len(entries)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.WriteJson(entries[:len])
+}
+
+func (d *DevAdmHandlers) DeleteAllowlistEntryHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.DeleteAllowlistEntry(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrAllowlistEntryNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+// PostEnrollmentTokensHandler generates a new enrollment token; the token
+// secret is included in the response and cannot be retrieved later on
+func (d *DevAdmHandlers) PostEnrollmentTokensHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	req, err := model.ParseEnrollmentTokenReq(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := d.DevAdm.CreateEnrollmentToken(ctx, *req)
+	switch err {
+	case nil:
+		w.Header().Add("Location", "enrollment_tokens/"+token.ID)
+		w.WriteHeader(http.StatusCreated)
+		w.WriteJson(token)
+	case devadm.ErrEnrollmentTokenExpiry:
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetEnrollmentTokensHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	tokens, err := d.DevAdm.ListEnrollmentTokens(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(tokens)
+}
+
+func (d *DevAdmHandlers) DeleteEnrollmentTokenHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.RevokeEnrollmentToken(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrEnrollmentTokenNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+// PostCACertificatesHandler adds a CA certificate to the tenant's trusted
+// CAs; devices presenting a certificate issued by the CA are admitted
+// automatically
+func (d *DevAdmHandlers) PostCACertificatesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	req, err := model.ParseTrustedCAReq(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	ca, err := d.DevAdm.AddTrustedCA(ctx, *req)
+	switch err {
+	case nil:
+		w.Header().Add("Location", "ca_certificates/"+ca.ID)
+		w.WriteHeader(http.StatusCreated)
+		w.WriteJson(ca)
+	case devadm.ErrTrustedCAExists:
+		restErrWithLog(w, r, l, err, http.StatusConflict)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetCACertificatesHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	cas, err := d.DevAdm.ListTrustedCAs(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(cas)
+}
+
+func (d *DevAdmHandlers) DeleteCACertificateHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.DeleteTrustedCA(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrTrustedCANotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+// PostManifestsHandler preauthorizes auth sets listed in a manufacturing
+// manifest signed with one of the registered manifest keys, and reports
+// the outcome for each of them
+func (d *DevAdmHandlers) PostManifestsHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	schema, err := d.DevAdm.GetIdentitySchema(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	manifest, err := model.ParseSignedManifest(r.Body, schema)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	report, err := d.DevAdm.PreauthorizeManifest(ctx, manifest, r.Header.Get("Authorization"))
+	switch err {
+	case nil:
+		w.WriteJson(report)
+	case devadm.ErrManifestSignature:
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+	case devadm.ErrManifestReplayed:
+		restErrWithLog(w, r, l, err, http.StatusConflict)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) PostManifestKeysHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	req, err := model.ParseManifestKeyReq(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := d.DevAdm.AddManifestKey(ctx, *req)
+	switch err {
+	case nil:
+		w.Header().Add("Location", "manifest_keys/"+key.ID)
+		w.WriteHeader(http.StatusCreated)
+		w.WriteJson(key)
+	case devadm.ErrManifestKeyExists:
+		restErrWithLog(w, r, l, err, http.StatusConflict)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetManifestKeysHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	keys, err := d.DevAdm.ListManifestKeys(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(keys)
+}
+
+func (d *DevAdmHandlers) DeleteManifestKeyHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.DeleteManifestKey(ctx, r.PathParam("id"))
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrManifestKeyNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+// PutTenantQuotaHandler sets device quota of a tenant, as given by the
+// tenant's plan
+func (d *DevAdmHandlers) PutTenantQuotaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	quota, err := model.ParseQuota(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	err = d.DevAdm.SetQuota(ctx, r.PathParam("tid"), *quota)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *DevAdmHandlers) GetQuotaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	usage, err := d.DevAdm.GetQuotaUsage(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteJson(usage)
+}
+
+func (d *DevAdmHandlers) GetIdentitySchemaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	schema, err := d.DevAdm.GetIdentitySchema(ctx)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+	if schema == nil {
+		restErrWithLog(w, r, l, devadm.ErrIdentitySchemaNotFound, http.StatusNotFound)
+		return
+	}
+
+	w.WriteJson(schema)
+}
+
+func (d *DevAdmHandlers) PutIdentitySchemaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	schema, err := model.ParseIdentitySchema(r.Body)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	err = d.DevAdm.SetIdentitySchema(ctx, *schema)
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *DevAdmHandlers) DeleteIdentitySchemaHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	err := d.DevAdm.DeleteIdentitySchema(ctx)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case devadm.ErrIdentitySchemaNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+func (d *DevAdmHandlers) GetTrashHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	page, perPage, err := utils.ParsePagination(r)
+	if err != nil {
+		restErrWithLog(w, r, l, err, http.StatusBadRequest)
+		return
+	}
+
+	//get one extra device to see if there's a 'next' page
+	devs, err := d.DevAdm.ListDeletedDeviceAuths(ctx,
+		int((page-1)*perPage), int(perPage+1))
+	if err != nil {
+		restErrWithLogInternal(w, r, l, err)
+		return
+	}
+
+	len := len(devs)
+	hasNext := false
+	if uint64(len) > perPage {
+		hasNext = true
+		len = int(perPage)
+	}
+
+	links := utils.MakePageLinkHdrs(r, page, perPage, hasNext)
+
+	for _, l := range links {
+		w.Header().Add("Link", l)
+	}
+	w.WriteJson(devs[:len])
+}
+
+func (d *DevAdmHandlers) RestoreDeviceHandler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	dev, err := d.DevAdm.RestoreDeviceAuth(ctx,
+		model.AuthID(r.PathParam("id")), r.Header.Get("Authorization"))
+	switch err {
+	case nil:
+		w.WriteJson(dev)
+	case store.ErrNotFound:
+		restErrWithLog(w, r, l, err, http.StatusNotFound)
+	case devadm.AuthSetConflictError:
+		restErrWithLog(w, r, l, err, http.StatusConflict)
+	case devadm.ErrQuotaExceeded:
+		restErrWithLog(w, r, l, err, http.StatusPaymentRequired)
+	case devadm.ErrDeviceBlocked:
+		restErrWithLog(w, r, l, err, http.StatusForbidden)
+	default:
+		restErrWithLogInternal(w, r, l, err)
+	}
+}
+
+// GetMetricsHandler returns the service's metrics, as published with
+// expvar
+func (d *DevAdmHandlers) GetMetricsHandler(w rest.ResponseWriter, r *rest.Request) {
+	metrics := map[string]json.RawMessage{}
+	expvar.Do(func(kv expvar.KeyValue) {
+		metrics[kv.Key] = json.RawMessage(kv.Value.String())
+	})
+
+	w.WriteJson(metrics)
+}
+
 // return selected http code + error message directly taken from error
 // log error
 func restErrWithLog(w rest.ResponseWriter, r *rest.Request, l *log.Logger, e error, code int) {
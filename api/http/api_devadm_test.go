@@ -15,12 +15,24 @@ package http
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"net/http"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/ant0ine/go-json-rest/rest/test"
@@ -217,6 +229,22 @@ func TestApiDevAdmGetDevices(t *testing.T) {
 			code: 200,
 			body: ToJson(mockListDeviceAuths(2)),
 		},
+		{
+			limit:       21,
+			filter:      store.Filter{Tags: []string{"lab", "customer-A"}},
+			listDevices: mockListDeviceAuths(2),
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v1/admission/devices?tag=lab&tag=customer-A", nil),
+			code: 200,
+			body: ToJson(mockListDeviceAuths(2)),
+		},
+		{
+			//invalid tag
+			req: test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v1/admission/devices?tag=lab%2Frma", nil),
+			code: 400,
+			body: RestError(`invalid tag "lab/rma", only letters, digits and _.:- are allowed`),
+		},
 	}
 
 	for idx, tc := range testCases {
@@ -299,6 +327,10 @@ func TestApiDevAdmGetDevice(t *testing.T) {
 			return err
 		},
 	)
+	devadm.On("NextStatuses",
+		mock.MatchedBy(func(c context.Context) bool { return true }),
+		devs["foo"].dev).
+		Return([]string{"rejected", "suspended"}, nil)
 
 	apih := makeMockApiHandler(t, devadm)
 
@@ -314,7 +346,7 @@ func TestApiDevAdmGetDevice(t *testing.T) {
 			test.MakeSimpleRequest("GET",
 				"http://1.2.3.4/api/management/v1/admission/devices/foo", nil),
 			200,
-			ToJson(devs["foo"].dev),
+			ToJson(&DevAdmApiDevice{devs["foo"].dev, []string{"rejected", "suspended"}}),
 		},
 		{
 			test.MakeSimpleRequest("GET",
@@ -348,6 +380,22 @@ func TestApiDevAdmGetDevice(t *testing.T) {
 	for _, tc := range tcases {
 		runTestRequest(t, apih, tc.req, tc.code, tc.body)
 	}
+
+	// revision is exposed as entity tag
+	devs["foo"].dev.Revision = 7
+	rec := runTestRequest(t, apih,
+		test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/devices/foo", nil),
+		200,
+		ToJson(&DevAdmApiDevice{devs["foo"].dev, []string{"rejected", "suspended"}}))
+	rec.HeaderIs("ETag", `"7"`)
+
+	rec = runTestRequest(t, apih,
+		test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/devices/foo/status", nil),
+		200,
+		ToJson(DevAdmApiStatus{"accepted"}))
+	rec.HeaderIs("ETag", `"7"`)
 }
 
 func TestApiDevAdmUpdateStatusDevice(t *testing.T) {
@@ -379,6 +427,26 @@ func TestApiDevAdmUpdateStatusDevice(t *testing.T) {
 			},
 			&utils.UsageError{UserMsg: "max dev count limit reached"},
 		},
+		"pending": {
+			nil,
+			devadm.ErrApprovalsPending,
+		},
+		"noapprover": {
+			nil,
+			devadm.ErrNoApprover,
+		},
+		"overquota": {
+			nil,
+			devadm.ErrQuotaExceeded,
+		},
+		"notaccepted": {
+			nil,
+			devadm.ErrInvalidTransition,
+		},
+		"modified": {
+			nil,
+			devadm.ErrRevisionMismatch,
+		},
 	}
 
 	mockaction := func(_ context.Context, id model.AuthID) error {
@@ -398,6 +466,9 @@ func TestApiDevAdmUpdateStatusDevice(t *testing.T) {
 	devadm.On("RejectDeviceAuth",
 		mock.MatchedBy(func(c context.Context) bool { return true }),
 		mock.AnythingOfType("model.AuthID")).Return(mockaction)
+	devadm.On("SuspendDeviceAuth",
+		mock.MatchedBy(func(c context.Context) bool { return true }),
+		mock.AnythingOfType("model.AuthID")).Return(mockaction)
 
 	apih := makeMockApiHandler(t, devadm)
 	// enforce specific field naming in errors returned by API
@@ -405,6 +476,7 @@ func TestApiDevAdmUpdateStatusDevice(t *testing.T) {
 
 	accstatus := DevAdmApiStatus{"accepted"}
 	rejstatus := DevAdmApiStatus{"rejected"}
+	susstatus := DevAdmApiStatus{"suspended"}
 
 	tcases := []struct {
 		req  *http.Request
@@ -459,12 +531,75 @@ func TestApiDevAdmUpdateStatusDevice(t *testing.T) {
 			code: 422,
 			body: RestError("max dev count limit reached"),
 		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/pending/status",
+				accstatus),
+			code: 202,
+			body: "",
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/noapprover/status",
+				accstatus),
+			code: 400,
+			body: RestError("approver identity is unknown"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/overquota/status",
+				accstatus),
+			code: 402,
+			body: RestError("device quota exceeded"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/foo/status",
+				susstatus),
+			code: 200,
+			body: ToJson(susstatus),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/notaccepted/status",
+				susstatus),
+			code: 409,
+			body: RestError("status transition is not allowed"),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/baz/status",
+				susstatus),
+			code: 404,
+			body: RestError(store.ErrNotFound.Error()),
+		},
+		{
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/modified/status",
+				accstatus),
+			code: 412,
+			body: RestError("auth set was modified, revision does not match"),
+		},
 	}
 
 	for _, tc := range tcases {
 		runTestRequest(t, apih, tc.req, tc.code, tc.body)
 	}
 
+	// If-Match holding no revision cannot match
+	req := test.MakeSimpleRequest("PUT",
+		"http://1.2.3.4/api/management/v1/admission/devices/foo/status",
+		accstatus)
+	req.Header.Set("If-Match", `W/"abc"`)
+	runTestRequest(t, apih, req, 412,
+		RestError("auth set was modified, revision does not match"))
+
+	req = test.MakeSimpleRequest("PUT",
+		"http://1.2.3.4/api/management/v1/admission/devices/foo/status",
+		accstatus)
+	req.Header.Set("If-Match", `"3"`)
+	runTestRequest(t, apih, req, 200, ToJson(accstatus))
+
 }
 
 func TestApiDevAdmAcceptPreauthorized(t *testing.T) {
@@ -509,6 +644,14 @@ func TestApiDevAdmAcceptPreauthorized(t *testing.T) {
 			respCode:  409,
 			respBody:  RestError("auth set must be in 'preauthorized' state"),
 		},
+		"error: quota exceeded": {
+			id:   "3",
+			body: DevAdmApiStatus{"accepted"},
+
+			devAdmErr: devadm.ErrQuotaExceeded,
+			respCode:  402,
+			respBody:  RestError("device quota exceeded"),
+		},
 		"error: generic": {
 			id:   "3",
 			body: DevAdmApiStatus{"accepted"},
@@ -566,8 +709,15 @@ func TestApiDevAdmSubmitDevice(t *testing.T) {
 		req       *http.Request
 		devAdmErr error
 		id        model.AuthID
+		token     string
+		cert      string
 		respCode  int
 		respBody  string
+
+		schema          *model.IdentitySchema
+		schemaViolation bool
+
+		retryAfter string
 	}{
 		"empty body": {
 			req: test.MakeSimpleRequest("PUT",
@@ -600,6 +750,81 @@ func TestApiDevAdmSubmitDevice(t *testing.T) {
 			id:       "id-0001",
 			respCode: 204,
 		},
+		"body formatted ok, enrollment token": {
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/id-0001",
+				map[string]string{
+					"key":       "key-0001",
+					"device_id": "123",
+					"device_identity": makeJson(t,
+						map[string]string{
+							"mac": "00:00:00:01",
+						}),
+					"token": "secret",
+				},
+			),
+			id:       "id-0001",
+			token:    "secret",
+			respCode: 204,
+		},
+		"body formatted ok, certificate": {
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/id-0001",
+				map[string]string{
+					"key":       "key-0001",
+					"device_id": "123",
+					"device_identity": makeJson(t,
+						map[string]string{
+							"mac": "00:00:00:01",
+						}),
+					"certificate": "cert-0001",
+				},
+			),
+			id:       "id-0001",
+			cert:     "cert-0001",
+			respCode: 204,
+		},
+		"body formatted ok, identity schema": {
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/id-0001",
+				map[string]string{
+					"key":       "key-0001",
+					"device_id": "123",
+					"device_identity": makeJson(t,
+						map[string]string{
+							"mac": "00:00:00:01",
+						}),
+				},
+			),
+			id: "id-0001",
+			schema: &model.IdentitySchema{
+				Attributes: []model.AttributeSchema{
+					{Name: "mac", Required: true},
+				},
+			},
+			respCode: 204,
+		},
+		"body formatted ok, identity schema violated": {
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/id-0001",
+				map[string]string{
+					"key":       "key-0001",
+					"device_id": "123",
+					"device_identity": makeJson(t,
+						map[string]string{
+							"mac": "00:00:00:01",
+						}),
+				},
+			),
+			id: "id-0001",
+			schema: &model.IdentitySchema{
+				Attributes: []model.AttributeSchema{
+					{Name: "sn", Required: true},
+				},
+			},
+			schemaViolation: true,
+			respCode:        204,
+		},
 		"body formatted ok, 'key' missing": {
 			req: test.MakeSimpleRequest("PUT",
 				"http://1.2.3.4/api/management/v1/admission/devices/id-0001",
@@ -670,6 +895,26 @@ func TestApiDevAdmSubmitDevice(t *testing.T) {
 			respCode:  500,
 			respBody:  RestError("internal error"),
 		},
+		"body formatted ok, rate limited": {
+			req: test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v1/admission/devices/id-0001",
+				map[string]string{
+					"device_id": "123",
+					"key":       "key-0001",
+					"device_identity": makeJson(t,
+						map[string]string{
+							"mac": "00:00:00:01",
+						}),
+				},
+			),
+			devAdmErr: &devadm.RateLimitError{
+				RetryAfter: 1500 * time.Millisecond,
+			},
+			id:         "id-0001",
+			respCode:   429,
+			respBody:   RestError("too many requests, retry after 1.5s"),
+			retryAfter: "2",
+		},
 		"body formatted ok, missing device_id": {
 			req: test.MakeSimpleRequest("PUT",
 				"http://1.2.3.4/api/management/v1/admission/devices/id-0001",
@@ -691,20 +936,29 @@ func TestApiDevAdmSubmitDevice(t *testing.T) {
 	for name, tc := range testCases {
 		t.Logf("test case: %s", name)
 		devadm := &mdevadm.App{}
+		devadm.On("GetIdentitySchema",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(tc.schema, nil)
 		devadm.On("SubmitDeviceAuth",
 			mock.MatchedBy(func(c context.Context) bool { return true }),
 			mock.MatchedBy(
 				func(d model.DeviceAuth) bool {
 					return assert.NotEmpty(t, d.Attributes) &&
 						assert.NotEmpty(t, d.DeviceId) &&
-						assert.Equal(t, tc.id, d.ID)
+						assert.Equal(t, tc.id, d.ID) &&
+						assert.Equal(t, tc.token, d.EnrollmentToken) &&
+						assert.Equal(t, tc.cert, d.Certificate) &&
+						assert.Equal(t, tc.schemaViolation, d.SchemaViolation)
 				})).Return(tc.devAdmErr)
 
 		apih := makeMockApiHandler(t, devadm)
 
 		rest.ErrorFieldName = "error"
 
-		runTestRequest(t, apih, tc.req, tc.respCode, tc.respBody)
+		recorded := runTestRequest(t, apih, tc.req, tc.respCode, tc.respBody)
+		if tc.retryAfter != "" {
+			recorded.HeaderIs("Retry-After", tc.retryAfter)
+		}
 	}
 }
 
@@ -823,7 +1077,8 @@ func TestApiDeleteDeviceManagement(t *testing.T) {
 	rest.ErrorFieldName = "error"
 
 	tcases := map[string]struct {
-		req *http.Request
+		req     *http.Request
+		ifMatch string
 
 		devadmErr error
 		id        model.AuthID
@@ -840,6 +1095,35 @@ func TestApiDeleteDeviceManagement(t *testing.T) {
 			code: http.StatusNoContent,
 			body: "",
 		},
+		"success: if-match": {
+			req:     test.MakeSimpleRequest("DELETE", "http://1.2.3.4/api/management/v1/admission/devices/2", nil),
+			ifMatch: `"4"`,
+
+			devadmErr: nil,
+			id:        "2",
+
+			code: http.StatusNoContent,
+			body: "",
+		},
+		"error: revision mismatch": {
+			req:     test.MakeSimpleRequest("DELETE", "http://1.2.3.4/api/management/v1/admission/devices/2", nil),
+			ifMatch: `"3"`,
+
+			devadmErr: devadm.ErrRevisionMismatch,
+			id:        "2",
+
+			code: http.StatusPreconditionFailed,
+			body: RestError("auth set was modified, revision does not match"),
+		},
+		"error: not a revision": {
+			req:     test.MakeSimpleRequest("DELETE", "http://1.2.3.4/api/management/v1/admission/devices/2", nil),
+			ifMatch: `"v1"`,
+
+			id: "2",
+
+			code: http.StatusPreconditionFailed,
+			body: RestError("auth set was modified, revision does not match"),
+		},
 		"error: no device": {
 			req: test.MakeSimpleRequest("DELETE", "http://1.2.3.4/api/management/v1/admission/devices/1", nil),
 
@@ -868,6 +1152,9 @@ func TestApiDeleteDeviceManagement(t *testing.T) {
 
 			apih := makeMockApiHandler(t, devadm)
 
+			if tc.ifMatch != "" {
+				tc.req.Header.Set("If-Match", tc.ifMatch)
+			}
 			runTestRequest(t, apih, tc.req, tc.code, tc.body)
 		})
 	}
@@ -930,8 +1217,15 @@ func TestApiDevAdmPostTenants(t *testing.T) {
 }
 
 func TestApiDevAdmPostDeviceAuth(t *testing.T) {
+	schema := &model.IdentitySchema{
+		Attributes: []model.AttributeSchema{
+			{Name: "mac", Required: true, Pattern: "([0-9a-f]{2}:){3}[0-9a-f]{2}"},
+		},
+	}
+
 	testCases := map[string]struct {
 		input     interface{}
+		schema    *model.IdentitySchema
 		devAdmErr error
 		respCode  int
 		respBody  string
@@ -967,6 +1261,25 @@ func TestApiDevAdmPostDeviceAuth(t *testing.T) {
 			respCode: 400,
 			respBody: RestError("key: non zero value required"),
 		},
+		"ok, identity schema": {
+			input: model.AuthSet{Key: "foo-key", DeviceId: makeJson(t,
+				map[string]string{
+					"mac": "00:00:00:01",
+				})},
+			schema:   schema,
+			respCode: 201,
+			respBody: "",
+		},
+		"error: identity schema violated": {
+			input: model.AuthSet{Key: "foo-key", DeviceId: makeJson(t,
+				map[string]string{
+					"mac": "00:00:00:01",
+					"sn":  "0001",
+				})},
+			schema:   schema,
+			respCode: 400,
+			respBody: RestError(`identity attribute "sn" is not allowed`),
+		},
 		"error: no identity data": {
 			input:    model.AuthSet{Key: "foo-key"},
 			respCode: 400,
@@ -980,11 +1293,22 @@ func TestApiDevAdmPostDeviceAuth(t *testing.T) {
 			respCode: 409,
 			respBody: RestError("device already exists"),
 		},
+		"error: blocklisted": {
+			input: model.AuthSet{Key: "foo-key", DeviceId: makeJson(t,
+				map[string]string{
+					"mac": "00:00:00:01",
+				})}, devAdmErr: devadm.ErrDeviceBlocked,
+			respCode: 403,
+			respBody: RestError("device is blocklisted"),
+		},
 	}
 
 	for name, tc := range testCases {
 		t.Logf("test case: %s", name)
 		devadm := &mdevadm.App{}
+		devadm.On("GetIdentitySchema",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(tc.schema, nil)
 
 		if tc.respCode != 400 {
 			devadm.On("PreauthorizeDevice",
@@ -1010,3 +1334,3000 @@ func TestApiDevAdmPostDeviceAuth(t *testing.T) {
 		devadm.AssertExpectations(t)
 	}
 }
+
+func TestApiDevAdmPostWebhooks(t *testing.T) {
+	hook := &model.Webhook{
+		ID:     "hook1",
+		URL:    "https://example.com/hook",
+		Events: []string{model.WebhookEventStatusChanged},
+		Secret: "secret",
+	}
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input: model.WebhookReq{
+				URL:    "https://example.com/hook",
+				Events: []string{model.WebhookEventStatusChanged},
+				Secret: "secret",
+			},
+			respCode: 201,
+			respBody: ToJson(hook),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: no secret": {
+			input: model.WebhookReq{
+				URL:    "https://example.com/hook",
+				Events: []string{model.WebhookEventStatusChanged},
+			},
+			respCode: 400,
+			respBody: RestError("secret: non zero value required"),
+		},
+		"error: relative url": {
+			input: model.WebhookReq{
+				URL:    "/hook",
+				Events: []string{model.WebhookEventStatusChanged},
+				Secret: "secret",
+			},
+			respCode: 400,
+			respBody: RestError("url must be an absolute http(s) URL"),
+		},
+		"error: unsupported event": {
+			input: model.WebhookReq{
+				URL:    "https://example.com/hook",
+				Events: []string{"deleted"},
+				Secret: "secret",
+			},
+			respCode: 400,
+			respBody: RestError("unsupported event: deleted"),
+		},
+		"error: generic": {
+			input: model.WebhookReq{
+				URL:    "https://example.com/hook",
+				Events: []string{model.WebhookEventStatusChanged},
+				Secret: "secret",
+			},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.Webhook
+		if tc.devAdmErr == nil {
+			out = hook
+		}
+		devadm.On("CreateWebhook",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.AnythingOfType("model.WebhookReq")).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/webhooks",
+			tc.input)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		if tc.respCode == 201 {
+			recorded.HeaderIs("Location", "webhooks/hook1")
+			// secret is never returned
+			assert.NotContains(t, recorded.Recorder.Body.String(), "secret\"")
+		}
+	}
+}
+
+func TestApiDevAdmGetWebhooks(t *testing.T) {
+	hooks := []model.Webhook{
+		{
+			ID:     "hook1",
+			URL:    "https://example.com/hook",
+			Events: model.WebhookEvents,
+		},
+	}
+
+	testCases := map[string]struct {
+		hooks     []model.Webhook
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			hooks:    hooks,
+			respCode: 200,
+			respBody: ToJson(hooks),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListWebhooks",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(tc.hooks, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/webhooks", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetWebhook(t *testing.T) {
+	hook := &model.Webhook{
+		ID:     "hook1",
+		URL:    "https://example.com/hook",
+		Events: model.WebhookEvents,
+	}
+
+	testCases := map[string]struct {
+		hook      *model.Webhook
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			hook:     hook,
+			respCode: 200,
+			respBody: ToJson(hook),
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrWebhookNotFound,
+			respCode:  404,
+			respBody:  RestError("webhook not found"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("GetWebhook",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"hook1").
+			Return(tc.hook, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/webhooks/hook1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmDeleteWebhook(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrWebhookNotFound,
+			respCode:  404,
+			respBody:  RestError("webhook not found"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("DeleteWebhook",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"hook1").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/webhooks/hook1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetWebhookDeliveries(t *testing.T) {
+	deliveries := func(num int) []model.WebhookDelivery {
+		var res []model.WebhookDelivery
+		for i := 0; i < num; i++ {
+			res = append(res, model.WebhookDelivery{
+				ID:        strconv.Itoa(i),
+				WebhookID: "hook1",
+				Status:    model.WebhookDeliveryDelivered,
+			})
+		}
+		return res
+	}
+
+	testCases := map[string]struct {
+		url string
+
+		skip       int
+		limit      int
+		deliveries []model.WebhookDelivery
+		devAdmErr  error
+
+		respCode int
+		respBody string
+		hdrs     []string
+	}{
+		"ok, next page": {
+			url:        "?page=2&per_page=2",
+			skip:       2,
+			limit:      3,
+			deliveries: deliveries(3),
+			respCode:   200,
+			respBody:   ToJson(deliveries(2)),
+			hdrs: []string{
+				fmt.Sprintf(utils.LinkTmpl, "deliveries",
+					"page=1&per_page=2", "prev"),
+				fmt.Sprintf(utils.LinkTmpl, "deliveries",
+					"page=3&per_page=2", "next"),
+			},
+		},
+		"error: pagination": {
+			url:      "?page=foo",
+			respCode: 400,
+			respBody: RestError(utils.MsgQueryParmInvalid("page")),
+		},
+		"error: not found": {
+			skip:      0,
+			limit:     21,
+			devAdmErr: devadm.ErrWebhookNotFound,
+			respCode:  404,
+			respBody:  RestError("webhook not found"),
+		},
+		"error: generic": {
+			skip:      0,
+			limit:     21,
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListWebhookDeliveries",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"hook1", tc.skip, tc.limit).
+			Return(tc.deliveries, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/webhooks/hook1/deliveries"+tc.url,
+			nil)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		for _, h := range tc.hdrs {
+			assert.True(t, HasHeader("Link", h, recorded), "expected header %s", h)
+		}
+	}
+}
+
+func TestApiDevAdmGetEvents(t *testing.T) {
+	events := []model.Event{
+		{
+			ID:   3,
+			Type: model.EventAuthSetCreated,
+			AuthSet: &model.DeviceAuth{
+				ID:     "foo",
+				Status: model.DevStatusPending,
+			},
+		},
+		{
+			ID:   4,
+			Type: model.EventStatusChanged,
+			AuthSet: &model.DeviceAuth{
+				ID:     "foo",
+				Status: model.DevStatusAccepted,
+			},
+		},
+	}
+
+	stream := ""
+	for _, ev := range events {
+		stream += fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n",
+			ev.ID, ev.Type, ToJson(ev))
+	}
+	// nothing new since
+	stream += ": keepalive\n\n"
+
+	testCases := map[string]struct {
+		url    string
+		header string
+
+		lastID    int64
+		devAdmErr error
+
+		respCode int
+		respBody string
+	}{
+		"ok, new events": {
+			lastID:   -1,
+			respCode: 200,
+			respBody: stream,
+		},
+		"ok, resume": {
+			header:   "2",
+			lastID:   2,
+			respCode: 200,
+			respBody: stream,
+		},
+		"ok, resume with query": {
+			url:      "?last_event_id=2",
+			lastID:   2,
+			respCode: 200,
+			respBody: stream,
+		},
+		"ok, stream failed": {
+			lastID:    -1,
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  200,
+			respBody:  stream,
+		},
+		"error: bad last event ID": {
+			header:   "foo",
+			respCode: 400,
+			respBody: RestError("invalid last event ID"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("WatchEvents",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			tc.lastID,
+			mock.AnythingOfType("func(*model.Event) error")).
+			Run(func(args mock.Arguments) {
+				fn := args.Get(2).(func(*model.Event) error)
+				for i := range events {
+					assert.NoError(t, fn(&events[i]))
+				}
+				assert.NoError(t, fn(nil))
+			}).
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/events"+tc.url, nil)
+		if tc.header != "" {
+			req.Header.Set("Last-Event-ID", tc.header)
+		}
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		if tc.respCode == 200 {
+			recorded.HeaderIs("Content-Type", "text/event-stream")
+		}
+	}
+}
+
+func TestApiDevAdmGetSettings(t *testing.T) {
+	testCases := map[string]struct {
+		settings  *model.TenantSettings
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			settings: &model.TenantSettings{RequiredApprovals: 2},
+			respCode: 200,
+			respBody: ToJson(model.TenantSettings{RequiredApprovals: 2}),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("GetSettings",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(tc.settings, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/settings", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPutSettings(t *testing.T) {
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input:    model.TenantSettings{RequiredApprovals: 2},
+			respCode: 204,
+		},
+		"ok, approvals pending": {
+			input:     model.TenantSettings{RequiredApprovals: 2},
+			devAdmErr: devadm.ErrApprovalsPending,
+			respCode:  202,
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: no approver": {
+			input:     model.TenantSettings{RequiredApprovals: 2},
+			devAdmErr: devadm.ErrNoApprover,
+			respCode:  400,
+			respBody:  RestError(devadm.ErrNoApprover.Error()),
+		},
+		"error: invalid approvals": {
+			input:    model.TenantSettings{RequiredApprovals: -1},
+			respCode: 400,
+			respBody: RestError("required_approvals must be between 0 and 10"),
+		},
+		"error: unknown transition status": {
+			input: model.TenantSettings{
+				RequiredApprovals: 2,
+				Transitions: model.Transitions{
+					"quarantined": {model.DevStatusAccepted},
+				},
+			},
+			respCode: 400,
+			respBody: RestError("unknown status \"quarantined\" in transitions"),
+		},
+		"error: unsupported transition": {
+			input: model.TenantSettings{
+				RequiredApprovals: 2,
+				Transitions: model.Transitions{
+					model.DevStatusRejected: {model.DevStatusPending},
+				},
+			},
+			respCode: 400,
+			respBody: RestError("transition from \"rejected\" to \"pending\" is not supported, " +
+				"only accepted, rejected and suspended can be set"),
+		},
+		"error: suspending pending": {
+			input: model.TenantSettings{
+				RequiredApprovals: 2,
+				Transitions: model.Transitions{
+					model.DevStatusPending: {model.DevStatusSuspended},
+				},
+			},
+			respCode: 400,
+			respBody: RestError("transition from \"pending\" to \"suspended\" is not supported, " +
+				"only accepted auth sets can be suspended"),
+		},
+		"error: generic": {
+			input:     model.TenantSettings{RequiredApprovals: 2},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("UpdateSettings",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.TenantSettings{RequiredApprovals: 2}).
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("PUT",
+			"http://1.2.3.4/api/management/v1/admission/settings", tc.input)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetApprovals(t *testing.T) {
+	approvals := []model.Approval{
+		{ID: "1", AuthID: "foo", Approver: "alice"},
+	}
+
+	testCases := map[string]struct {
+		url       string
+		authId    model.AuthID
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 200,
+			respBody: ToJson(approvals),
+		},
+		"ok, auth set": {
+			url:      "?auth_id=foo",
+			authId:   "foo",
+			respCode: 200,
+			respBody: ToJson(approvals),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListApprovals",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			tc.authId).
+			Return(approvals, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/approvals"+tc.url, nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmDeleteApproval(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrApprovalNotFound,
+			respCode:  404,
+			respBody:  RestError("approval not found"),
+		},
+		"error: not owned": {
+			devAdmErr: devadm.ErrApprovalNotOwned,
+			respCode:  403,
+			respBody:  RestError("approval can be withdrawn only by its approver"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("WithdrawApproval",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"1").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/approvals/1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPostBlocklist(t *testing.T) {
+	entry := &model.BlocklistEntry{
+		ID:         "1",
+		Attributes: map[string]string{"sn": "1234"},
+		Reason:     "stolen",
+	}
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input: model.BlocklistEntryReq{
+				Attributes: map[string]string{"sn": "1234"},
+				Reason:     "stolen",
+			},
+			respCode: 201,
+			respBody: ToJson(entry),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: nothing to match": {
+			input: model.BlocklistEntryReq{
+				Reason: "stolen",
+			},
+			respCode: 400,
+			respBody: RestError("attributes, tags, key or key_fingerprint must be provided"),
+		},
+		"error: key and fingerprint": {
+			input: model.BlocklistEntryReq{
+				Key:            "foo-key",
+				KeyFingerprint: "abcd",
+			},
+			respCode: 400,
+			respBody: RestError("only one of key, key_fingerprint can be provided"),
+		},
+		"error: generic": {
+			input: model.BlocklistEntryReq{
+				Attributes: map[string]string{"sn": "1234"},
+			},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.BlocklistEntry
+		if tc.devAdmErr == nil {
+			out = entry
+		}
+		devadm.On("CreateBlocklistEntry",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.AnythingOfType("model.BlocklistEntryReq")).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/blocklist",
+			tc.input)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		if tc.respCode == 201 {
+			recorded.HeaderIs("Location", "blocklist/1")
+		}
+	}
+}
+
+func TestApiDevAdmDeleteBlocklistEntry(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrBlocklistEntryNotFound,
+			respCode:  404,
+			respBody:  RestError("blocklist entry not found"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("DeleteBlocklistEntry",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"1").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/blocklist/1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetBlocklistHits(t *testing.T) {
+	hits := func(num int) []model.BlocklistHit {
+		var res []model.BlocklistHit
+		for i := 0; i < num; i++ {
+			res = append(res, model.BlocklistHit{
+				ID:      strconv.Itoa(i),
+				EntryID: "1",
+				AuthID:  model.AuthID(strconv.Itoa(i)),
+			})
+		}
+		return res
+	}
+
+	testCases := map[string]struct {
+		url string
+
+		skip      int
+		limit     int
+		hits      []model.BlocklistHit
+		devAdmErr error
+
+		respCode int
+		respBody string
+		hdrs     []string
+	}{
+		"ok, next page": {
+			url:      "?page=2&per_page=2",
+			skip:     2,
+			limit:    3,
+			hits:     hits(3),
+			respCode: 200,
+			respBody: ToJson(hits(2)),
+			hdrs: []string{
+				fmt.Sprintf(utils.LinkTmpl, "hits",
+					"page=1&per_page=2", "prev"),
+				fmt.Sprintf(utils.LinkTmpl, "hits",
+					"page=3&per_page=2", "next"),
+			},
+		},
+		"ok, last page": {
+			skip:     0,
+			limit:    21,
+			hits:     hits(1),
+			respCode: 200,
+			respBody: ToJson(hits(1)),
+		},
+		"error: pagination": {
+			url:      "?per_page=foo",
+			respCode: 400,
+			respBody: RestError(utils.MsgQueryParmInvalid("per_page")),
+		},
+		"error: generic": {
+			skip:      0,
+			limit:     21,
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListBlocklistHits",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			tc.skip, tc.limit).
+			Return(tc.hits, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/blocklist/hits"+tc.url,
+			nil)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		for _, h := range tc.hdrs {
+			assert.True(t, HasHeader("Link", h, recorded), "expected header %s", h)
+		}
+	}
+}
+
+func TestApiDevAdmPostAllowlist(t *testing.T) {
+	attrs := []model.DeviceAuthAttributes{
+		{"sn": "0001", "mac": "00:01"},
+		{"sn": "0002"},
+	}
+	entries := []model.AllowlistEntry{
+		{ID: "1", Batch: "batch1", Attributes: attrs[0], SingleUse: true},
+		{ID: "2", Batch: "batch1", Attributes: attrs[1], SingleUse: true},
+	}
+
+	testCases := map[string]struct {
+		query       string
+		contentType string
+		body        string
+
+		devAdmCalled bool
+		singleUse    bool
+		tags         []string
+		devAdmErr    error
+
+		respCode int
+		respBody string
+	}{
+		"ok, json": {
+			query:        "?batch=batch1&single_use=true",
+			contentType:  "application/json",
+			body:         `[{"sn": "0001", "mac": "00:01"}, {"sn": "0002"}]`,
+			devAdmCalled: true,
+			singleUse:    true,
+			respCode:     201,
+			respBody:     ToJson(entries),
+		},
+		"ok, csv": {
+			query:        "?batch=batch1&single_use=1",
+			contentType:  "text/csv",
+			body:         "sn, mac\n0001, 00:01\n0002,\n",
+			devAdmCalled: true,
+			singleUse:    true,
+			respCode:     201,
+			respBody:     ToJson(entries),
+		},
+		"ok, tags": {
+			query:        "?batch=batch1&tag=lab&tag=customer-A",
+			contentType:  "application/json",
+			body:         `[{"sn": "0001", "mac": "00:01"}, {"sn": "0002"}]`,
+			devAdmCalled: true,
+			tags:         []string{"lab", "customer-A"},
+			respCode:     201,
+			respBody:     ToJson(entries),
+		},
+		"error: bad tag": {
+			query:       "?tag=a%20b",
+			contentType: "text/csv",
+			body:        "sn\n0001\n",
+			respCode:    400,
+			respBody:    RestError(`invalid tag "a b", only letters, digits and _.:- are allowed`),
+		},
+		"error: bad single_use": {
+			query:       "?single_use=maybe",
+			contentType: "text/csv",
+			body:        "sn\n0001\n",
+			respCode:    400,
+			respBody:    RestError(utils.MsgQueryParmInvalid("single_use")),
+		},
+		"error: csv without entries": {
+			contentType: "text/csv",
+			body:        "sn,mac\n",
+			respCode:    400,
+			respBody:    RestError("no allowlist entries"),
+		},
+		"error: csv, empty row": {
+			contentType: "text/csv",
+			body:        "sn,mac\n0001,00:01\n,\n",
+			respCode:    400,
+			respBody:    RestError("CSV row 2: no attributes"),
+		},
+		"error: csv, duplicate column": {
+			contentType: "text/csv",
+			body:        "sn,sn\n0001,0002\n",
+			respCode:    400,
+			respBody:    RestError("CSV header: duplicate column sn"),
+		},
+		"error: json, empty entry": {
+			contentType: "application/json",
+			body:        `[{"sn": "0001"}, {}]`,
+			respCode:    400,
+			respBody:    RestError("entry 2: no attributes"),
+		},
+		"error: generic": {
+			query:        "?batch=batch1&single_use=true",
+			contentType:  "application/json",
+			body:         `[{"sn": "0001", "mac": "00:01"}, {"sn": "0002"}]`,
+			devAdmCalled: true,
+			singleUse:    true,
+			devAdmErr:    errors.New("db connection failed"),
+			respCode:     500,
+			respBody:     RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		if tc.devAdmCalled {
+			var out []model.AllowlistEntry
+			if tc.devAdmErr == nil {
+				out = entries
+			}
+			devadm.On("ImportAllowlist",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				"batch1", tc.singleUse, tc.tags, attrs).
+				Return(out, tc.devAdmErr)
+		}
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req, _ := http.NewRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/allowlist"+tc.query,
+			strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		devadm.AssertExpectations(t)
+	}
+}
+
+func TestApiDevAdmGetAllowlist(t *testing.T) {
+	entries := func(num int) []model.AllowlistEntry {
+		var res []model.AllowlistEntry
+		for i := 0; i < num; i++ {
+			res = append(res, model.AllowlistEntry{
+				ID:         strconv.Itoa(i),
+				Batch:      "batch1",
+				Attributes: model.DeviceAuthAttributes{"sn": strconv.Itoa(i)},
+			})
+		}
+		return res
+	}
+
+	testCases := map[string]struct {
+		url string
+
+		batch     string
+		skip      int
+		limit     int
+		entries   []model.AllowlistEntry
+		devAdmErr error
+
+		respCode int
+		respBody string
+		hdrs     []string
+	}{
+		"ok, next page": {
+			url:      "?batch=batch1&page=2&per_page=2",
+			batch:    "batch1",
+			skip:     2,
+			limit:    3,
+			entries:  entries(3),
+			respCode: 200,
+			respBody: ToJson(entries(2)),
+			hdrs: []string{
+				fmt.Sprintf(utils.LinkTmpl, "allowlist",
+					"batch=batch1&page=1&per_page=2", "prev"),
+				fmt.Sprintf(utils.LinkTmpl, "allowlist",
+					"batch=batch1&page=3&per_page=2", "next"),
+			},
+		},
+		"ok, all batches": {
+			skip:     0,
+			limit:    21,
+			entries:  entries(1),
+			respCode: 200,
+			respBody: ToJson(entries(1)),
+		},
+		"error: pagination": {
+			url:      "?page=0",
+			respCode: 400,
+			respBody: RestError(utils.MsgQueryParmLimit("page")),
+		},
+		"error: generic": {
+			skip:      0,
+			limit:     21,
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListAllowlist",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			tc.batch, tc.skip, tc.limit).
+			Return(tc.entries, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/allowlist"+tc.url,
+			nil)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		for _, h := range tc.hdrs {
+			assert.True(t, HasHeader("Link", h, recorded), "expected header %s", h)
+		}
+	}
+}
+
+func TestApiDevAdmDeleteAllowlistEntry(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrAllowlistEntryNotFound,
+			respCode:  404,
+			respBody:  RestError("allowlist entry not found"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("DeleteAllowlistEntry",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"1").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/allowlist/1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPostEnrollmentTokens(t *testing.T) {
+	token := &model.EnrollmentToken{
+		ID:       "1",
+		Token:    "secret",
+		MaxUses:  5,
+		UsesLeft: 5,
+	}
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input:    map[string]interface{}{"max_uses": 5},
+			respCode: 201,
+			respBody: ToJson(token),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: negative max uses": {
+			input:    map[string]interface{}{"max_uses": -1},
+			respCode: 400,
+			respBody: RestError("max_uses must not be negative"),
+		},
+		"error: expiry": {
+			input:     map[string]interface{}{"max_uses": 5},
+			devAdmErr: devadm.ErrEnrollmentTokenExpiry,
+			respCode:  400,
+			respBody:  RestError("expires_ts must be in the future"),
+		},
+		"error: generic": {
+			input:     map[string]interface{}{"max_uses": 5},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.EnrollmentToken
+		if tc.devAdmErr == nil {
+			out = token
+		}
+		devadm.On("CreateEnrollmentToken",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.AnythingOfType("model.EnrollmentTokenReq")).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/enrollment_tokens",
+			tc.input)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		if tc.respCode == 201 {
+			recorded.HeaderIs("Location", "enrollment_tokens/1")
+		}
+	}
+}
+
+func TestApiDevAdmGetEnrollmentTokens(t *testing.T) {
+	tokens := []model.EnrollmentToken{
+		{ID: "1", MaxUses: 5, UsesLeft: 2},
+		{ID: "2", MaxUses: 1, UsesLeft: 0, Revoked: true},
+	}
+
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 200,
+			respBody: ToJson(tokens),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListEnrollmentTokens",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(tokens, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/enrollment_tokens", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmDeleteEnrollmentToken(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrEnrollmentTokenNotFound,
+			respCode:  404,
+			respBody:  RestError("enrollment token not found"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("RevokeEnrollmentToken",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"1").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/enrollment_tokens/1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+// makeCertPEM generates a self-signed, PEM encoded certificate
+func makeCertPEM(t *testing.T, isCA bool) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl,
+		&key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}))
+}
+
+func TestApiDevAdmPostCACertificates(t *testing.T) {
+	ca := &model.TrustedCA{
+		ID:          "1",
+		Certificate: makeCertPEM(t, true),
+		Subject:     "CN=test",
+	}
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input:    map[string]string{"certificate": ca.Certificate},
+			respCode: 201,
+			respBody: ToJson(ca),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: garbled certificate": {
+			input:    map[string]string{"certificate": "foo"},
+			respCode: 400,
+			respBody: RestError("no PEM encoded certificate found"),
+		},
+		"error: not a CA": {
+			input:    map[string]string{"certificate": makeCertPEM(t, false)},
+			respCode: 400,
+			respBody: RestError("not a CA certificate"),
+		},
+		"error: exists": {
+			input:     map[string]string{"certificate": ca.Certificate},
+			devAdmErr: devadm.ErrTrustedCAExists,
+			respCode:  409,
+			respBody:  RestError("CA certificate is already trusted"),
+		},
+		"error: generic": {
+			input:     map[string]string{"certificate": ca.Certificate},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.TrustedCA
+		if tc.devAdmErr == nil {
+			out = ca
+		}
+		devadm.On("AddTrustedCA",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.AnythingOfType("model.TrustedCAReq")).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/ca_certificates",
+			tc.input)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		if tc.respCode == 201 {
+			recorded.HeaderIs("Location", "ca_certificates/1")
+		}
+	}
+}
+
+func TestApiDevAdmGetCACertificates(t *testing.T) {
+	cas := []model.TrustedCA{
+		{ID: "1", Subject: "CN=ca1"},
+		{ID: "2", Subject: "CN=ca2"},
+	}
+
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 200,
+			respBody: ToJson(cas),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListTrustedCAs",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(cas, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/ca_certificates", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmDeleteCACertificate(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrTrustedCANotFound,
+			respCode:  404,
+			respBody:  RestError("trusted CA not found"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("DeleteTrustedCA",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"1").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/ca_certificates/1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPostManifests(t *testing.T) {
+	manifest := func(doc string) string {
+		return base64.StdEncoding.EncodeToString([]byte(doc))
+	}
+	signature := base64.StdEncoding.EncodeToString([]byte("signature"))
+
+	report := &model.PreauthReport{
+		Preauthorized: 1,
+		Results: []model.PreauthResult{
+			{
+				Row:      1,
+				DeviceId: `{"sn":"0001"}`,
+				Result:   model.PreauthResultPreauthorized,
+			},
+		},
+	}
+
+	valid := manifest(`{"id": "m-1", "devices": [` +
+		`{"device_identity": "{\"sn\":\"0001\"}", "key": "key1"}]}`)
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input: map[string]string{
+				"manifest":  valid,
+				"signature": signature,
+			},
+			respCode: 200,
+			respBody: ToJson(report),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: signature missing": {
+			input: map[string]string{
+				"manifest": valid,
+			},
+			respCode: 400,
+			respBody: RestError("manifest and signature must be provided"),
+		},
+		"error: manifest id missing": {
+			input: map[string]string{
+				"manifest":  manifest(`{"devices": []}`),
+				"signature": signature,
+			},
+			respCode: 400,
+			respBody: RestError("manifest id must be provided"),
+		},
+		"error: no auth sets": {
+			input: map[string]string{
+				"manifest":  manifest(`{"id": "m-1", "devices": []}`),
+				"signature": signature,
+			},
+			respCode: 400,
+			respBody: RestError("no auth sets"),
+		},
+		"error: signature": {
+			input: map[string]string{
+				"manifest":  valid,
+				"signature": signature,
+			},
+			devAdmErr: devadm.ErrManifestSignature,
+			respCode:  400,
+			respBody:  RestError("manifest signature does not match any registered key"),
+		},
+		"error: replayed": {
+			input: map[string]string{
+				"manifest":  valid,
+				"signature": signature,
+			},
+			devAdmErr: devadm.ErrManifestReplayed,
+			respCode:  409,
+			respBody:  RestError("manifest was already processed"),
+		},
+		"error: generic": {
+			input: map[string]string{
+				"manifest":  valid,
+				"signature": signature,
+			},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("GetIdentitySchema",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(nil, nil)
+
+		var out *model.PreauthReport
+		if tc.devAdmErr == nil {
+			out = report
+		}
+		devadm.On("PreauthorizeManifest",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.MatchedBy(func(m *model.SignedManifest) bool {
+				return m.Decoded.ID == "m-1" && len(m.Rows) == 1 &&
+					m.Rows[0].Err == nil
+			}),
+			"Bearer foo").
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/manifests",
+			tc.input)
+		req.Header.Set("Authorization", "Bearer foo")
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPostManifestKeys(t *testing.T) {
+	pemKey := func(pub interface{}) string {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		assert.NoError(t, err)
+		return string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: der,
+		}))
+	}
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	key := &model.ManifestKey{
+		ID:        "1",
+		PublicKey: pemKey(edPub),
+	}
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input:    map[string]string{"public_key": key.PublicKey},
+			respCode: 201,
+			respBody: ToJson(key),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: not Ed25519": {
+			input:    map[string]string{"public_key": pemKey(&ecKey.PublicKey)},
+			respCode: 400,
+			respBody: RestError("not an Ed25519 public key"),
+		},
+		"error: exists": {
+			input:     map[string]string{"public_key": key.PublicKey},
+			devAdmErr: devadm.ErrManifestKeyExists,
+			respCode:  409,
+			respBody:  RestError("manifest key is already registered"),
+		},
+		"error: generic": {
+			input:     map[string]string{"public_key": key.PublicKey},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.ManifestKey
+		if tc.devAdmErr == nil {
+			out = key
+		}
+		devadm.On("AddManifestKey",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.AnythingOfType("model.ManifestKeyReq")).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/manifest_keys",
+			tc.input)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		if tc.respCode == 201 {
+			recorded.HeaderIs("Location", "manifest_keys/1")
+		}
+	}
+}
+
+func TestApiDevAdmGetManifestKeys(t *testing.T) {
+	keys := []model.ManifestKey{
+		{ID: "1", Fingerprint: "0001"},
+		{ID: "2", Fingerprint: "0002"},
+	}
+
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 200,
+			respBody: ToJson(keys),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListManifestKeys",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(keys, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/manifest_keys", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmDeleteManifestKey(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrManifestKeyNotFound,
+			respCode:  404,
+			respBody:  RestError("manifest key not found"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("DeleteManifestKey",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"1").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/manifest_keys/1", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPutTenantQuota(t *testing.T) {
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input:    model.Quota{MaxDevices: 10},
+			respCode: 204,
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: negative": {
+			input:    model.Quota{MaxDevices: -1},
+			respCode: 400,
+			respBody: RestError("max_devices must not be negative"),
+		},
+		"error: generic": {
+			input:     model.Quota{MaxDevices: 10},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("SetQuota",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			"tenant-1", model.Quota{MaxDevices: 10}).
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("PUT",
+			"http://1.2.3.4/api/internal/v1/admission/tenants/tenant-1/quota",
+			tc.input)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetQuota(t *testing.T) {
+	usage := &model.QuotaUsage{Accepted: 4, Allowed: 10}
+
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 200,
+			respBody: ToJson(usage),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.QuotaUsage
+		if tc.devAdmErr == nil {
+			out = usage
+		}
+		devadm.On("GetQuotaUsage",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/quota", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPostDeviceTags(t *testing.T) {
+	testCases := map[string]struct {
+		input     interface{}
+		tags      []string
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input:    []string{"lab", "customer-A"},
+			tags:     []string{"lab", "customer-A"},
+			respCode: 204,
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: no tags": {
+			input:    []string{},
+			respCode: 400,
+			respBody: RestError("no tags"),
+		},
+		"error: invalid tag": {
+			input:    []string{"lab", ""},
+			respCode: 400,
+			respBody: RestError(`invalid tag "", only letters, digits and _.:- are allowed`),
+		},
+		"error: not found": {
+			input:     []string{"lab"},
+			tags:      []string{"lab"},
+			devAdmErr: store.ErrNotFound,
+			respCode:  404,
+			respBody:  RestError(store.ErrNotFound.Error()),
+		},
+		"error: generic": {
+			input:     []string{"lab"},
+			tags:      []string{"lab"},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("TagDeviceAuth",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.AuthID("foo"), tc.tags).
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/devices/foo/tags",
+			tc.input)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmDeleteDeviceTag(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: store.ErrNotFound,
+			respCode:  404,
+			respBody:  RestError(store.ErrNotFound.Error()),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("UntagDeviceAuth",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.AuthID("foo"), "rma").
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/devices/foo/tags/rma", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetDeviceDetails(t *testing.T) {
+	details := &model.DeviceAuthDetails{
+		DeviceAuth: model.DeviceAuth{
+			ID:     "foo",
+			Key:    "foobar",
+			Status: "pending",
+		},
+		Notes: []model.Note{
+			{ID: "1", AuthID: "foo", Author: "user-1", Text: "called customer, legit"},
+		},
+	}
+
+	testCases := map[string]struct {
+		query     string
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			query:    "?details=true",
+			respCode: 200,
+			respBody: ToJson(&DevAdmApiDeviceDetails{details, []string{"accepted", "rejected"}}),
+		},
+		"error: bad details": {
+			query:    "?details=maybe",
+			respCode: 400,
+			respBody: RestError("Can't parse param details"),
+		},
+		"error: not found": {
+			query:     "?details=1",
+			devAdmErr: store.ErrNotFound,
+			respCode:  404,
+			respBody:  RestError(store.ErrNotFound.Error()),
+		},
+		"error: generic": {
+			query:     "?details=true",
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.DeviceAuthDetails
+		if tc.devAdmErr == nil {
+			out = details
+		}
+		devadm.On("GetDeviceAuthDetails",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.AuthID("foo")).
+			Return(out, tc.devAdmErr)
+		devadm.On("NextStatuses",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			&details.DeviceAuth).
+			Return([]string{"accepted", "rejected"}, nil)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/devices/foo"+tc.query,
+			nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPostDeviceNotes(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	note := &model.Note{
+		ID:      "1",
+		AuthID:  "foo",
+		Author:  "user-1",
+		Text:    "called customer, legit",
+		Created: &now,
+	}
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input:    model.NoteReq{Text: "called customer, legit"},
+			respCode: 201,
+			respBody: ToJson(note),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: no text": {
+			input:    model.NoteReq{Text: "  "},
+			respCode: 400,
+			respBody: RestError("text must be provided"),
+		},
+		"error: text too long": {
+			input:    model.NoteReq{Text: strings.Repeat("a", model.MaxNoteLength+1)},
+			respCode: 400,
+			respBody: RestError("text is longer than 4096 characters"),
+		},
+		"error: no author": {
+			input:     model.NoteReq{Text: "called customer, legit"},
+			devAdmErr: devadm.ErrNoNoteAuthor,
+			respCode:  400,
+			respBody:  RestError(devadm.ErrNoNoteAuthor.Error()),
+		},
+		"error: not found": {
+			input:     model.NoteReq{Text: "called customer, legit"},
+			devAdmErr: store.ErrNotFound,
+			respCode:  404,
+			respBody:  RestError(store.ErrNotFound.Error()),
+		},
+		"error: generic": {
+			input:     model.NoteReq{Text: "called customer, legit"},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		da := &mdevadm.App{}
+
+		var out *model.Note
+		if tc.devAdmErr == nil {
+			out = note
+		}
+		da.On("AddNote",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.AuthID("foo"),
+			model.NoteReq{Text: "called customer, legit"}).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, da)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/devices/foo/notes",
+			tc.input)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetDeviceNotes(t *testing.T) {
+	notes := []model.Note{
+		{ID: "1", AuthID: "foo", Author: "user-1", Text: "called customer"},
+		{ID: "2", AuthID: "foo", Author: "user-2", Text: "legit"},
+	}
+
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 200,
+			respBody: ToJson(notes),
+		},
+		"error: not found": {
+			devAdmErr: store.ErrNotFound,
+			respCode:  404,
+			respBody:  RestError(store.ErrNotFound.Error()),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListNotes",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.AuthID("foo")).
+			Return(notes, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/devices/foo/notes", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPostDevicesTags(t *testing.T) {
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input: model.TagsReq{
+				IDs:    []model.AuthID{"foo", "bar"},
+				Add:    []string{"lab"},
+				Remove: []string{"rma"},
+			},
+			respCode: 200,
+			respBody: ToJson(model.TagsResult{Updated: 2}),
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: no ids": {
+			input:    model.TagsReq{Add: []string{"lab"}},
+			respCode: 400,
+			respBody: RestError("ids must be provided"),
+		},
+		"error: no tags": {
+			input:    model.TagsReq{IDs: []model.AuthID{"foo"}},
+			respCode: 400,
+			respBody: RestError("add or remove must be provided"),
+		},
+		"error: invalid tag": {
+			input: model.TagsReq{
+				IDs:    []model.AuthID{"foo"},
+				Remove: []string{"a b"},
+			},
+			respCode: 400,
+			respBody: RestError(`invalid tag "a b", only letters, digits and _.:- are allowed`),
+		},
+		"error: generic": {
+			input: model.TagsReq{
+				IDs:    []model.AuthID{"foo", "bar"},
+				Add:    []string{"lab"},
+				Remove: []string{"rma"},
+			},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		var out *model.TagsResult
+		if tc.devAdmErr == nil {
+			out = &model.TagsResult{Updated: 2}
+		}
+		devadm.On("UpdateTags",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.TagsReq{
+				IDs:    []model.AuthID{"foo", "bar"},
+				Add:    []string{"lab"},
+				Remove: []string{"rma"},
+			}).
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/devices/tags",
+			tc.input)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetIdentitySchema(t *testing.T) {
+	schema := &model.IdentitySchema{
+		Attributes: []model.AttributeSchema{
+			{Name: "mac", Required: true, MaxLength: 17},
+		},
+		AllowExtra: true,
+	}
+
+	testCases := map[string]struct {
+		schema    *model.IdentitySchema
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			schema:   schema,
+			respCode: 200,
+			respBody: ToJson(schema),
+		},
+		"error: not set": {
+			respCode: 404,
+			respBody: RestError("identity schema not set"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("GetIdentitySchema",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(tc.schema, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/identity_schema", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmPutIdentitySchema(t *testing.T) {
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			input: map[string]interface{}{
+				"attributes": []map[string]interface{}{
+					{"name": "mac", "required": true, "pattern": "[0-9a-f:]+"},
+					{"name": "sku", "max_length": 32},
+				},
+			},
+			respCode: 204,
+		},
+		"error: empty request": {
+			input:    nil,
+			respCode: 400,
+			respBody: RestError("EOF"),
+		},
+		"error: invalid pattern": {
+			input: map[string]interface{}{
+				"attributes": []map[string]interface{}{
+					{"name": "mac", "pattern": "[0-9"},
+				},
+			},
+			respCode: 400,
+			respBody: RestError(`invalid pattern of attribute "mac": error parsing regexp: missing closing ]: ` + "`[0-9`"),
+		},
+		"error: repeated attribute": {
+			input: map[string]interface{}{
+				"attributes": []map[string]interface{}{
+					{"name": "mac"},
+					{"name": "mac", "required": true},
+				},
+			},
+			respCode: 400,
+			respBody: RestError(`attribute "mac" listed more than once`),
+		},
+		"error: generic": {
+			input: map[string]interface{}{
+				"allow_extra": true,
+			},
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("SetIdentitySchema",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			mock.AnythingOfType("model.IdentitySchema")).
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("PUT",
+			"http://1.2.3.4/api/management/v1/admission/identity_schema",
+			tc.input)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmDeleteIdentitySchema(t *testing.T) {
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 204,
+		},
+		"error: not found": {
+			devAdmErr: devadm.ErrIdentitySchemaNotFound,
+			respCode:  404,
+			respBody:  RestError("identity schema not set"),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("DeleteIdentitySchema",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("DELETE",
+			"http://1.2.3.4/api/management/v1/admission/identity_schema", nil)
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmGetMetrics(t *testing.T) {
+	devadm := &mdevadm.App{}
+	apih := makeMockApiHandler(t, devadm)
+
+	req := test.MakeSimpleRequest("GET",
+		"http://1.2.3.4/api/internal/v1/admission/metrics", nil)
+	req.Header.Add(requestid.RequestIdHeader, "test")
+
+	recorded := test.RunRequest(t, apih, req)
+	recorded.CodeIs(200)
+
+	var metrics map[string]interface{}
+	err := recorded.DecodeJsonPayload(&metrics)
+	assert.NoError(t, err)
+	assert.Contains(t, metrics, "submissions_dropped")
+}
+
+func TestApiDevAdmPostDevicesBatch(t *testing.T) {
+	rows := []model.AuthSetRow{
+		{
+			Row: 1,
+			AuthSet: model.AuthSet{
+				DeviceId:   `{"sn":"0001"}`,
+				Key:        "key1",
+				Attributes: model.DeviceAuthAttributes{"sn": "0001"},
+			},
+		},
+		{
+			Row: 2,
+			AuthSet: model.AuthSet{
+				DeviceId:   `{"sn":"0002"}`,
+				Key:        "key2",
+				Attributes: model.DeviceAuthAttributes{"sn": "0002"},
+			},
+		},
+	}
+	report := &model.PreauthReport{
+		Preauthorized: 1,
+		Conflicts:     1,
+		Results: []model.PreauthResult{
+			{
+				Row:      1,
+				DeviceId: `{"sn":"0001"}`,
+				Result:   model.PreauthResultPreauthorized,
+			},
+			{
+				Row:      2,
+				DeviceId: `{"sn":"0002"}`,
+				Result:   model.PreauthResultConflict,
+				Error:    "device already exists",
+			},
+		},
+	}
+	partial := &model.PreauthReport{
+		Preauthorized: 1,
+		Skipped:       1,
+		Results: []model.PreauthResult{
+			{
+				Row:      1,
+				DeviceId: `{"sn":"0001"}`,
+				Result:   model.PreauthResultPreauthorized,
+			},
+			{
+				Row:      2,
+				DeviceId: `{"sn":"0002"}`,
+				Result:   model.PreauthResultSkipped,
+			},
+		},
+	}
+
+	testCases := map[string]struct {
+		contentType string
+		body        string
+
+		rows         interface{}
+		devAdmReport *model.PreauthReport
+		devAdmErr    error
+
+		respCode int
+		respBody string
+	}{
+		"ok, csv": {
+			contentType: "text/csv",
+			body: "device_identity,key\n" +
+				"\"{\"\"sn\"\":\"\"0001\"\"}\",key1\n" +
+				"\"{\"\"sn\"\":\"\"0002\"\"}\",key2\n",
+			rows:     rows,
+			respCode: 200,
+			respBody: ToJson(report),
+		},
+		"ok, json lines": {
+			contentType: "application/x-ndjson",
+			body: `{"device_identity": "{\"sn\":\"0001\"}", "key": "key1"}` + "\n\n" +
+				`{"device_identity": "{\"sn\":\"0002\"}", "key": "key2"}` + "\n",
+			rows:     rows,
+			respCode: 200,
+			respBody: ToJson(report),
+		},
+		"ok, invalid rows are passed on": {
+			contentType: "application/x-ndjson",
+			body: `{"device_identity": "{\"sn\":\"0001\"}", "key": "key1"}` + "\n" +
+				`{"device_identity": "{\"sn\":\"0002\"}"}` + "\n" +
+				`not json` + "\n",
+			rows: mock.MatchedBy(func(rows []model.AuthSetRow) bool {
+				return len(rows) == 3 &&
+					rows[0].Err == nil &&
+					rows[1].Err != nil && rows[1].Row == 2 &&
+					rows[2].Err != nil && rows[2].Row == 3
+			}),
+			respCode: 200,
+			respBody: ToJson(report),
+		},
+		"error: csv header": {
+			contentType: "text/csv",
+			body:        "identity,key\n",
+			respCode:    400,
+			respBody:    RestError("CSV header must name device_identity and key columns"),
+		},
+		"error: no auth sets": {
+			contentType: "application/x-ndjson",
+			body:        "\n\n",
+			respCode:    400,
+			respBody:    RestError("no auth sets"),
+		},
+		"error: content type": {
+			contentType: "application/json",
+			body:        `[]`,
+			respCode:    415,
+			respBody:    RestError("Content-Type must be one of text/csv, application/x-ndjson"),
+		},
+		"error: interrupted": {
+			contentType: "application/x-ndjson",
+			body: `{"device_identity": "{\"sn\":\"0001\"}", "key": "key1"}` + "\n" +
+				`{"device_identity": "{\"sn\":\"0002\"}", "key": "key2"}` + "\n",
+			rows:         rows,
+			devAdmReport: partial,
+			devAdmErr:    errors.New("bulk preauthorization interrupted: context deadline exceeded"),
+			respCode:     503,
+			respBody:     ToJson(partial),
+		},
+		"error: generic": {
+			contentType: "application/x-ndjson",
+			body: `{"device_identity": "{\"sn\":\"0001\"}", "key": "key1"}` + "\n" +
+				`{"device_identity": "{\"sn\":\"0002\"}", "key": "key2"}` + "\n",
+			rows:      rows,
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+		devadm.On("GetIdentitySchema",
+			mock.MatchedBy(func(c context.Context) bool { return true })).
+			Return(nil, nil)
+
+		if tc.rows != nil {
+			out := tc.devAdmReport
+			if tc.devAdmErr == nil {
+				out = report
+			}
+			devadm.On("PreauthorizeDevices",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				tc.rows, "Bearer foo").
+				Return(out, tc.devAdmErr)
+		}
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req, _ := http.NewRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/devices/batch",
+			strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		req.Header.Set("Authorization", "Bearer foo")
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		devadm.AssertExpectations(t)
+	}
+}
+
+func TestApiDevAdmGetTrash(t *testing.T) {
+	deleted := func(num int) []model.DeviceAuth {
+		now := time.Unix(1500000000, 0)
+		var res []model.DeviceAuth
+		for i := 0; i < num; i++ {
+			res = append(res, model.DeviceAuth{
+				ID:        model.AuthID(strconv.Itoa(i)),
+				DeviceId:  "bar",
+				Status:    model.DevStatusPending,
+				DeletedAt: &now,
+			})
+		}
+		return res
+	}
+
+	testCases := map[string]struct {
+		url string
+
+		skip      int
+		limit     int
+		devs      []model.DeviceAuth
+		devAdmErr error
+
+		respCode int
+		respBody string
+		hdrs     []string
+	}{
+		"ok, next page": {
+			url:      "?page=2&per_page=2",
+			skip:     2,
+			limit:    3,
+			devs:     deleted(3),
+			respCode: 200,
+			respBody: ToJson(deleted(2)),
+			hdrs: []string{
+				fmt.Sprintf(utils.LinkTmpl, "trash",
+					"page=1&per_page=2", "prev"),
+				fmt.Sprintf(utils.LinkTmpl, "trash",
+					"page=3&per_page=2", "next"),
+			},
+		},
+		"ok, last page": {
+			skip:     0,
+			limit:    21,
+			devs:     deleted(1),
+			respCode: 200,
+			respBody: ToJson(deleted(1)),
+		},
+		"error: pagination": {
+			url:      "?page=0",
+			respCode: 400,
+			respBody: RestError(utils.MsgQueryParmLimit("page")),
+		},
+		"error: generic": {
+			skip:      0,
+			limit:     21,
+			devAdmErr: errors.New("db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		devadm := &mdevadm.App{}
+
+		devadm.On("ListDeletedDeviceAuths",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			tc.skip, tc.limit).
+			Return(tc.devs, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, devadm)
+
+		req := test.MakeSimpleRequest("GET",
+			"http://1.2.3.4/api/management/v1/admission/trash"+tc.url,
+			nil)
+
+		recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+		for _, h := range tc.hdrs {
+			assert.True(t, HasHeader("Link", h, recorded), "expected header %s", h)
+		}
+	}
+}
+
+func TestApiDevAdmRestoreDevice(t *testing.T) {
+	dev := &model.DeviceAuth{
+		ID:       "foo",
+		DeviceId: "bar",
+		Status:   model.DevStatusAccepted,
+	}
+
+	testCases := map[string]struct {
+		devAdmErr error
+		respCode  int
+		respBody  string
+	}{
+		"ok": {
+			respCode: 200,
+			respBody: ToJson(dev),
+		},
+		"error: not in trash": {
+			devAdmErr: store.ErrNotFound,
+			respCode:  404,
+			respBody:  RestError(store.ErrNotFound.Error()),
+		},
+		"error: conflict": {
+			devAdmErr: devadm.AuthSetConflictError,
+			respCode:  409,
+			respBody:  RestError(devadm.AuthSetConflictError.Error()),
+		},
+		"error: quota exceeded": {
+			devAdmErr: devadm.ErrQuotaExceeded,
+			respCode:  402,
+			respBody:  RestError(devadm.ErrQuotaExceeded.Error()),
+		},
+		"error: blocklisted": {
+			devAdmErr: devadm.ErrDeviceBlocked,
+			respCode:  403,
+			respBody:  RestError(devadm.ErrDeviceBlocked.Error()),
+		},
+		"error: generic": {
+			devAdmErr: errors.New("failed to propagate"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Logf("test case: %s", name)
+		da := &mdevadm.App{}
+
+		var out *model.DeviceAuth
+		if tc.devAdmErr == nil {
+			out = dev
+		}
+		da.On("RestoreDeviceAuth",
+			mock.MatchedBy(func(c context.Context) bool { return true }),
+			model.AuthID("foo"), "Bearer foo").
+			Return(out, tc.devAdmErr)
+
+		apih := makeMockApiHandler(t, da)
+
+		req := test.MakeSimpleRequest("POST",
+			"http://1.2.3.4/api/management/v1/admission/trash/foo/restore", nil)
+		req.Header.Set("Authorization", "Bearer foo")
+
+		runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+	}
+}
+
+func TestApiDevAdmIdempotentRequest(t *testing.T) {
+	authSet := model.AuthSet{Key: "foo-key", DeviceId: makeJson(t,
+		map[string]string{
+			"mac": "00:00:00:01",
+		})}
+
+	testCases := map[string]struct {
+		key string
+
+		beginReq *model.IdempotentRequest
+		beginErr error
+
+		devAdmErr error
+		endErr    error
+
+		respCode     int
+		respBody     string
+		respReplayed bool
+
+		preauthorized bool
+		recorded      bool
+	}{
+		"no key": {
+			respCode:      201,
+			preauthorized: true,
+		},
+		"first request": {
+			key:           "key-1",
+			respCode:      201,
+			preauthorized: true,
+			recorded:      true,
+		},
+		"first request, conflict": {
+			key:           "key-1",
+			devAdmErr:     devadm.AuthSetConflictError,
+			respCode:      409,
+			respBody:      RestError("device already exists"),
+			preauthorized: true,
+			recorded:      true,
+		},
+		"first request, recording failed": {
+			key:           "key-1",
+			endErr:        errors.New("db connection failed"),
+			respCode:      201,
+			preauthorized: true,
+			recorded:      true,
+		},
+		"retry": {
+			key: "key-1",
+			beginReq: &model.IdempotentRequest{
+				Key:        "key-1",
+				StatusCode: 409,
+				Header: http.Header{
+					"Content-Type": []string{"application/json; charset=utf-8"},
+				},
+				Body: []byte(RestError("device already exists")),
+			},
+			respCode:     409,
+			respBody:     RestError("device already exists"),
+			respReplayed: true,
+		},
+		"error: key reused": {
+			key:      "key-1",
+			beginErr: devadm.ErrIdempotencyKeyReused,
+			respCode: 422,
+			respBody: RestError(devadm.ErrIdempotencyKeyReused.Error()),
+		},
+		"error: key in flight": {
+			key:      "key-1",
+			beginErr: devadm.ErrIdempotencyKeyInFlight,
+			respCode: 409,
+			respBody: RestError(devadm.ErrIdempotencyKeyInFlight.Error()),
+		},
+		"error: key too long": {
+			key:      strings.Repeat("k", model.MaxIdempotencyKeyLength+1),
+			respCode: 400,
+			respBody: RestError("Idempotency-Key is longer than 255 characters"),
+		},
+		"error: generic": {
+			key:      "key-1",
+			beginErr: errors.New("db connection failed"),
+			respCode: 500,
+			respBody: RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var hash string
+			released := false
+
+			devadm := &mdevadm.App{}
+			devadm.On("GetIdentitySchema",
+				mock.MatchedBy(func(c context.Context) bool { return true })).
+				Return(nil, nil)
+			devadm.On("PreauthorizeDevice",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				mock.AnythingOfType("model.AuthSet"),
+				"",
+			).Return(tc.devAdmErr)
+			devadm.On("BeginIdempotentRequest",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				tc.key,
+				mock.MatchedBy(func(h string) bool {
+					hash = h
+					return h != ""
+				}),
+			).Return(
+				func(_ context.Context, key, h string) *model.IdempotentRequest {
+					if tc.beginReq != nil || tc.beginErr != nil {
+						return tc.beginReq
+					}
+					// reserved for this attempt
+					return &model.IdempotentRequest{
+						Key:   key,
+						Hash:  h,
+						Lease: "lease-1",
+					}
+				},
+				tc.beginErr)
+			devadm.On("KeepIdempotentRequest",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				mock.AnythingOfType("*model.IdempotentRequest"),
+			).Return(func() { released = true })
+			devadm.On("EndIdempotentRequest",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				mock.MatchedBy(func(req *model.IdempotentRequest) bool {
+					// the key is held until the response is complete
+					return assert.True(t, released) &&
+						assert.Equal(t, tc.key, req.Key) &&
+						assert.Equal(t, hash, req.Hash) &&
+						assert.Equal(t, "lease-1", req.Lease) &&
+						assert.Equal(t, tc.respCode, req.StatusCode) &&
+						assert.Equal(t, tc.respBody, string(req.Body)) &&
+						assert.Equal(t, "application/json; charset=utf-8",
+							req.Header.Get("Content-Type"))
+				}),
+			).Return(tc.endErr)
+
+			apih := makeMockApiHandler(t, devadm)
+
+			rest.ErrorFieldName = "error"
+
+			req := test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/management/v1/admission/devices",
+				authSet)
+			if tc.key != "" {
+				req.Header.Set(HdrIdempotencyKey, tc.key)
+			}
+			recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+			if tc.respReplayed {
+				recorded.HeaderIs(HdrIdempotentReplayed, "true")
+				recorded.ContentTypeIsJson()
+			} else {
+				assert.Empty(t, recorded.Recorder.Header().Get(HdrIdempotentReplayed))
+			}
+
+			if tc.preauthorized {
+				devadm.AssertCalled(t, "PreauthorizeDevice",
+					mock.Anything, mock.Anything, mock.Anything)
+			} else {
+				devadm.AssertNotCalled(t, "PreauthorizeDevice",
+					mock.Anything, mock.Anything, mock.Anything)
+			}
+			if tc.recorded {
+				devadm.AssertCalled(t, "KeepIdempotentRequest",
+					mock.Anything, mock.Anything)
+				devadm.AssertCalled(t, "EndIdempotentRequest",
+					mock.Anything, mock.Anything)
+			} else {
+				devadm.AssertNotCalled(t, "KeepIdempotentRequest",
+					mock.Anything, mock.Anything)
+				devadm.AssertNotCalled(t, "EndIdempotentRequest",
+					mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestApiDevAdmIdempotentRequestHash(t *testing.T) {
+	hashOf := func(method, url string, body interface{}) string {
+		r := &rest.Request{Request: test.MakeSimpleRequest(method, url, body)}
+		h, err := hashRequest(r)
+		assert.NoError(t, err)
+
+		// body is still there for the handler
+		var b map[string]string
+		assert.NoError(t, r.DecodeJsonPayload(&b))
+		assert.Equal(t, body, b)
+		return h
+	}
+
+	url := "http://1.2.3.4/api/management/v1/admission/devices/foo/status"
+	accepted := map[string]string{"status": "accepted"}
+	rejected := map[string]string{"status": "rejected"}
+
+	h := hashOf("PUT", url, accepted)
+	assert.Equal(t, h, hashOf("PUT", url, accepted))
+	assert.NotEqual(t, h, hashOf("PUT", url, rejected))
+	assert.NotEqual(t, h, hashOf("POST", url, accepted))
+	assert.NotEqual(t, h, hashOf("PUT",
+		"http://1.2.3.4/api/management/v1/admission/devices/bar/status",
+		accepted))
+}
+
+func TestApiDevAdmPatchDevice(t *testing.T) {
+	serial := "0001"
+	patched := &model.DeviceAuth{
+		ID:         "foo",
+		Status:     model.DevStatusPending,
+		Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "sn": "0001"},
+		Tags:       []string{"a"},
+		Revision:   4,
+	}
+
+	testCases := map[string]struct {
+		body    string
+		ifMatch string
+
+		patch     *model.DeviceAuthPatch
+		devAdmDev *model.DeviceAuth
+		devAdmErr error
+
+		respCode int
+		respBody string
+	}{
+		"ok": {
+			body: `{"attributes": {"sn": "0001", "foo": null}, "tags": ["a"]}`,
+			patch: &model.DeviceAuthPatch{
+				Attributes: map[string]*string{"sn": &serial, "foo": nil},
+				Tags:       []string{"a"},
+				SetTags:    true,
+			},
+			devAdmDev: patched,
+			respCode:  200,
+			respBody:  ToJson(&DevAdmApiDevice{patched, []string{"accepted", "rejected"}}),
+		},
+		"ok, if-match": {
+			body:    `{"expires_ts": null}`,
+			ifMatch: `"3"`,
+			patch: &model.DeviceAuthPatch{
+				SetExpiresAt: true,
+			},
+			devAdmDev: patched,
+			respCode:  200,
+			respBody:  ToJson(&DevAdmApiDevice{patched, []string{"accepted", "rejected"}}),
+		},
+		"error: field not allowed": {
+			body:     `{"status": "accepted"}`,
+			respCode: 400,
+			respBody: RestError("failed to decode patch: status: field cannot be patched"),
+		},
+		"error: not an object": {
+			body:     `["a"]`,
+			respCode: 400,
+			respBody: RestError("failed to decode patch: patch must be a JSON object"),
+		},
+		"error: invalid tag": {
+			body:     `{"tags": ["a b"]}`,
+			respCode: 400,
+			respBody: RestError(`failed to decode patch: tags: invalid tag "a b", only letters, digits and _.:- are allowed`),
+		},
+		"error: notes removed": {
+			body:     `{"notes": null}`,
+			respCode: 400,
+			respBody: RestError("failed to decode patch: notes: notes cannot be removed"),
+		},
+		"error: bad if-match": {
+			body:     `{"tags": null}`,
+			ifMatch:  `"foo"`,
+			respCode: 412,
+			respBody: RestError(devadm.ErrRevisionMismatch.Error()),
+		},
+		"error: revision mismatch": {
+			body: `{"tags": null}`,
+			patch: &model.DeviceAuthPatch{
+				SetTags: true,
+			},
+			devAdmErr: devadm.ErrRevisionMismatch,
+			respCode:  412,
+			respBody:  RestError(devadm.ErrRevisionMismatch.Error()),
+		},
+		"error: not found": {
+			body: `{"tags": null}`,
+			patch: &model.DeviceAuthPatch{
+				SetTags: true,
+			},
+			devAdmErr: store.ErrNotFound,
+			respCode:  404,
+			respBody:  RestError(store.ErrNotFound.Error()),
+		},
+		"error: attributes removed": {
+			body:     `{"attributes": null}`,
+			respCode: 400,
+			respBody: RestError("failed to decode patch: attributes: identity attributes cannot be removed"),
+		},
+		"error: no attributes left": {
+			body: `{"attributes": {"mac": null}}`,
+			patch: &model.DeviceAuthPatch{
+				Attributes: map[string]*string{"mac": nil},
+			},
+			devAdmErr: devadm.ErrNoAttributesLeft,
+			respCode:  400,
+			respBody:  RestError(devadm.ErrNoAttributesLeft.Error()),
+		},
+		"error: schema violated": {
+			body: `{"attributes": {"mac": null}}`,
+			patch: &model.DeviceAuthPatch{
+				Attributes: map[string]*string{"mac": nil},
+			},
+			devAdmErr: utils.NewUsageError(`identity attribute "mac" is required`),
+			respCode:  400,
+			respBody:  RestError(`identity attribute "mac" is required`),
+		},
+		"error: no note author": {
+			body: `{"notes": ["legit"]}`,
+			patch: &model.DeviceAuthPatch{
+				Notes: []string{"legit"},
+			},
+			devAdmErr: devadm.ErrNoNoteAuthor,
+			respCode:  400,
+			respBody:  RestError(devadm.ErrNoNoteAuthor.Error()),
+		},
+		"error: generic": {
+			body: `{"tags": null}`,
+			patch: &model.DeviceAuthPatch{
+				SetTags: true,
+			},
+			devAdmErr: errors.New("failed to patch auth set: db connection failed"),
+			respCode:  500,
+			respBody:  RestError("internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			devadm := &mdevadm.App{}
+			if tc.patch != nil {
+				devadm.On("PatchDeviceAuth",
+					mock.MatchedBy(func(c context.Context) bool { return true }),
+					model.AuthID("foo"),
+					*tc.patch,
+				).Return(tc.devAdmDev, tc.devAdmErr)
+			}
+			devadm.On("NextStatuses",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				patched).
+				Return([]string{"accepted", "rejected"}, nil)
+
+			apih := makeMockApiHandler(t, devadm)
+
+			rest.ErrorFieldName = "error"
+
+			req := test.MakeSimpleRequest("PATCH",
+				"http://1.2.3.4/api/management/v1/admission/devices/foo",
+				nil)
+			req.Body = ioutil.NopCloser(strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+
+			recorded := runTestRequest(t, apih, req, tc.respCode, tc.respBody)
+			if tc.respCode == 200 {
+				recorded.HeaderIs("ETag", `"4"`)
+			}
+			if tc.patch == nil {
+				devadm.AssertNotCalled(t, "PatchDeviceAuth",
+					mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/devadm"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	"github.com/mendersoftware/deviceadm/utils"
+)
+
+const (
+	uriV2Devices      = "/api/management/v2/admission/devices"
+	uriV2Device       = "/api/management/v2/admission/devices/:id"
+	uriV2DeviceStatus = "/api/management/v2/admission/devices/:id/status"
+
+	cursorName = "cursor"
+)
+
+// DevAdmApiV2Item is the envelope of a single resource in v2 API responses
+type DevAdmApiV2Item struct {
+	Data interface{} `json:"data"`
+}
+
+// DevAdmApiV2List is the envelope of a list of resources in v2 API
+// responses
+type DevAdmApiV2List struct {
+	Data interface{}     `json:"data"`
+	Meta DevAdmApiV2Meta `json:"meta"`
+}
+
+type DevAdmApiV2Meta struct {
+	// number of resources matching the query, on all pages
+	Total int `json:"total"`
+	// cursor of the next page, nil on the last page
+	NextCursor *string `json:"next_cursor"`
+}
+
+// v2Routes lists routes of v2 API; v2 responses are wrapped in envelopes
+// and errors are RFC 7807 problems
+func (d *DevAdmHandlers) v2Routes() []*rest.Route {
+	return []*rest.Route{
+		rest.Get(uriV2Devices, d.GetDevicesV2Handler),
+		rest.Post(uriV2Devices, d.idempotent(d.PostDevicesV2Handler, problemWithLog)),
+
+		rest.Get(uriV2Device, d.GetDeviceV2Handler),
+		rest.Patch(uriV2Device, d.PatchDeviceV2Handler),
+		rest.Delete(uriV2Device, d.DeleteDeviceV2Handler),
+
+		rest.Put(uriV2DeviceStatus, d.idempotent(d.UpdateDeviceStatusV2Handler, problemWithLog)),
+	}
+}
+
+// encodeCursor returns an opaque cursor of the page following auth set 'id'
+func encodeCursor(id model.AuthID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeCursor(cursor string) (model.AuthID, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(id) == 0 {
+		return "", errors.New("invalid cursor")
+	}
+	return model.AuthID(id), nil
+}
+
+func (d *DevAdmHandlers) GetDevicesV2Handler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	perPage, err := utils.ParseQueryParmUInt(r, utils.PerPageName, false,
+		utils.PerPageMin, utils.PerPageMax, utils.PerPageDefault)
+	if err != nil {
+		problemWithLogCode(w, r, l, err, http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	status, err := utils.ParseQueryParmStr(r, utils.StatusName, false, utils.DevStatuses)
+	if err != nil {
+		problemWithLogCode(w, r, l, err, http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	deviceId, err := utils.ParseQueryParmStr(r, "device_id", false, nil)
+	if err != nil {
+		problemWithLogCode(w, r, l, err, http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	tags := r.URL.Query()["tag"]
+	if err := model.ValidateTags(tags); err != nil {
+		problemWithLogCode(w, r, l, err, http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	filter := store.Filter{
+		Status:   status,
+		DeviceID: model.DeviceID(deviceId),
+		Tags:     tags,
+	}
+	if cursor := r.URL.Query().Get(cursorName); cursor != "" {
+		filter.After, err = decodeCursor(cursor)
+		if err != nil {
+			problemWithLogCode(w, r, l, err, http.StatusBadRequest, ProblemInvalidRequest)
+			return
+		}
+	}
+
+	//get one extra device to see if there's a next page
+	devs, err := d.DevAdm.ListDeviceAuths(ctx, 0, int(perPage+1), filter)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	total, err := d.DevAdm.CountDeviceAuths(ctx, filter)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	meta := DevAdmApiV2Meta{Total: total}
+	if uint64(len(devs)) > perPage {
+		devs = devs[:perPage]
+		next := encodeCursor(devs[len(devs)-1].ID)
+		meta.NextCursor = &next
+	}
+
+	w.WriteJson(&DevAdmApiV2List{
+		Data: devs,
+		Meta: meta,
+	})
+}
+
+func (d *DevAdmHandlers) PostDevicesV2Handler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	defer r.Body.Close()
+	schema, err := d.DevAdm.GetIdentitySchema(ctx)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	authSet, err := model.ParseAuthSet(r.Body, schema)
+	if err != nil {
+		problemWithLogCode(w, r, l, err, http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	err = d.DevAdm.PreauthorizeDevice(ctx, *authSet, r.Header.Get("Authorization"))
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (d *DevAdmHandlers) GetDeviceV2Handler(w rest.ResponseWriter, r *rest.Request) {
+	ctx := r.Context()
+	l := log.FromContext(ctx)
+
+	details, err := utils.ParseQueryParmBool(r, "details", false, false)
+	if err != nil {
+		problemWithLogCode(w, r, l, err, http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	id := model.AuthID(r.PathParam("id"))
+
+	if details {
+		dev, err := d.DevAdm.GetDeviceAuthDetails(ctx, id)
+		if err != nil {
+			problemWithLog(w, r, l, err)
+			return
+		}
+
+		next, err := d.DevAdm.NextStatuses(ctx, &dev.DeviceAuth)
+		if err != nil {
+			problemWithLog(w, r, l, err)
+			return
+		}
+		w.Header().Set("ETag", etag(&dev.DeviceAuth))
+		w.WriteJson(&DevAdmApiV2Item{&DevAdmApiDeviceDetails{dev, next}})
+		return
+	}
+
+	dev, err := d.DevAdm.GetDeviceAuth(ctx, id)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	next, err := d.DevAdm.NextStatuses(ctx, dev)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+	w.Header().Set("ETag", etag(dev))
+	w.WriteJson(&DevAdmApiV2Item{&DevAdmApiDevice{dev, next}})
+}
+
+func (d *DevAdmHandlers) PatchDeviceV2Handler(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	ctx, err := ifMatchContext(r)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	defer r.Body.Close()
+	patch, err := model.ParseDeviceAuthPatch(r.Body)
+	if err != nil {
+		problemWithLogCode(w, r, l,
+			errors.Wrap(err, "failed to decode patch"),
+			http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	dev, err := d.DevAdm.PatchDeviceAuth(ctx, model.AuthID(r.PathParam("id")), *patch)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	next, err := d.DevAdm.NextStatuses(ctx, dev)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+	w.Header().Set("ETag", etag(dev))
+	w.WriteJson(&DevAdmApiV2Item{&DevAdmApiDevice{dev, next}})
+}
+
+func (d *DevAdmHandlers) DeleteDeviceV2Handler(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	ctx, err := ifMatchContext(r)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	err = d.DevAdm.DeleteDeviceAuthPropagate(ctx, model.AuthID(r.PathParam("id")),
+		r.Header.Get("Authorization"))
+	if err != nil && err != store.ErrNotFound {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *DevAdmHandlers) UpdateDeviceStatusV2Handler(w rest.ResponseWriter, r *rest.Request) {
+	l := log.FromContext(r.Context())
+
+	ctx, err := ifMatchContext(r)
+	if err != nil {
+		problemWithLog(w, r, l, err)
+		return
+	}
+
+	var status DevAdmApiStatus
+	err = r.DecodeJsonPayload(&status)
+	if err != nil {
+		problemWithLogCode(w, r, l,
+			errors.Wrap(err, "failed to decode status data"),
+			http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	id := model.AuthID(r.PathParam("id"))
+
+	switch status.Status {
+	case model.DevStatusAccepted:
+		err = d.DevAdm.AcceptDeviceAuth(ctx, id)
+	case model.DevStatusRejected:
+		err = d.DevAdm.RejectDeviceAuth(ctx, id)
+	case model.DevStatusSuspended:
+		err = d.DevAdm.SuspendDeviceAuth(ctx, id)
+	default:
+		problemWithLogCode(w, r, l,
+			errors.New("incorrect device status"),
+			http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	switch err {
+	case nil:
+		w.WriteJson(&DevAdmApiV2Item{&status})
+	case devadm.ErrApprovalsPending:
+		// approval was recorded, but the status did not change yet
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		problemWithLog(w, r, l, err)
+	}
+}
@@ -0,0 +1,520 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/devadm"
+	mdevadm "github.com/mendersoftware/deviceadm/devadm/mocks"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	"github.com/mendersoftware/deviceadm/utils"
+)
+
+func ProblemError(status int, code, detail string) string {
+	return ToJson(&Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: "test",
+	})
+}
+
+func TestApiDevAdmV2GetDevices(t *testing.T) {
+	devs := []model.DeviceAuth{
+		{ID: "1", DeviceId: "dev-1", Status: model.DevStatusPending},
+		{ID: "2", DeviceId: "dev-1", Status: model.DevStatusPending},
+		{ID: "3", DeviceId: "dev-2", Status: model.DevStatusPending},
+	}
+	cursor := encodeCursor("2")
+
+	testCases := map[string]struct {
+		url string
+
+		filter  store.Filter
+		limit   int
+		devs    []model.DeviceAuth
+		listErr error
+		total   int
+
+		code int
+		body string
+	}{
+		"ok": {
+			url:   "?per_page=5",
+			limit: 6,
+			devs:  devs,
+			total: 3,
+			code:  200,
+			body: ToJson(&DevAdmApiV2List{
+				Data: devs,
+				Meta: DevAdmApiV2Meta{Total: 3},
+			}),
+		},
+		"ok, next page": {
+			url:   "?per_page=2&status=pending&tag=lab",
+			limit: 3,
+			filter: store.Filter{
+				Status: model.DevStatusPending,
+				Tags:   []string{"lab"},
+			},
+			devs:  devs,
+			total: 3,
+			code:  200,
+			body: ToJson(&DevAdmApiV2List{
+				Data: devs[:2],
+				Meta: DevAdmApiV2Meta{Total: 3, NextCursor: &cursor},
+			}),
+		},
+		"ok, cursor": {
+			url:   "?per_page=2&cursor=" + cursor,
+			limit: 3,
+			filter: store.Filter{
+				After: "2",
+			},
+			devs:  devs[2:],
+			total: 3,
+			code:  200,
+			body: ToJson(&DevAdmApiV2List{
+				Data: devs[2:],
+				Meta: DevAdmApiV2Meta{Total: 3},
+			}),
+		},
+		"ok, empty": {
+			url:   "?device_id=dev-3",
+			limit: 21,
+			filter: store.Filter{
+				DeviceID: "dev-3",
+			},
+			devs: []model.DeviceAuth{},
+			code: 200,
+			body: `{"data":[],"meta":{"total":0,"next_cursor":null}}`,
+		},
+		"error: invalid cursor": {
+			url:  "?cursor=%21%21",
+			code: 400,
+			body: ProblemError(400, ProblemInvalidRequest, "invalid cursor"),
+		},
+		"error: invalid per_page": {
+			url:  "?per_page=0",
+			code: 400,
+			body: ProblemError(400, ProblemInvalidRequest,
+				"Param per_page is out of bounds"),
+		},
+		"error: invalid status": {
+			url:  "?status=foo",
+			code: 400,
+			body: ProblemError(400, ProblemInvalidRequest,
+				"Param status must be one of [pending rejected accepted preauthorized suspended]"),
+		},
+		"error: internal": {
+			url:     "",
+			limit:   21,
+			listErr: errors.New("failed to fetch devices: db connection failed"),
+			code:    500,
+			body:    ProblemError(500, ProblemInternal, "internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			devadm := &mdevadm.App{}
+			devadm.On("ListDeviceAuths",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				0, tc.limit, tc.filter).
+				Return(tc.devs, tc.listErr)
+			devadm.On("CountDeviceAuths",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				tc.filter).
+				Return(tc.total, nil)
+
+			apih := makeMockApiHandler(t, devadm)
+
+			req := test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/admission/devices"+tc.url,
+				nil)
+			recorded := runTestRequest(t, apih, req, tc.code, tc.body)
+			if tc.code != 200 {
+				recorded.HeaderIs("Content-Type", ProblemContentType)
+			}
+		})
+	}
+}
+
+func TestApiDevAdmV2GetDevice(t *testing.T) {
+	dev := &model.DeviceAuth{
+		ID:       "foo",
+		Status:   model.DevStatusPending,
+		Revision: 2,
+	}
+
+	testCases := map[string]struct {
+		id     model.AuthID
+		dev    *model.DeviceAuth
+		getErr error
+
+		code int
+		body string
+	}{
+		"ok": {
+			id:   "foo",
+			dev:  dev,
+			code: 200,
+			body: ToJson(&DevAdmApiV2Item{
+				&DevAdmApiDevice{dev, []string{"accepted", "rejected"}},
+			}),
+		},
+		"error: not found": {
+			id:     "bar",
+			getErr: store.ErrNotFound,
+			code:   404,
+			body:   ProblemError(404, ProblemNotFound, store.ErrNotFound.Error()),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			devadm := &mdevadm.App{}
+			devadm.On("GetDeviceAuth",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				tc.id).
+				Return(tc.dev, tc.getErr)
+			devadm.On("NextStatuses",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				dev).
+				Return([]string{"accepted", "rejected"}, nil)
+
+			apih := makeMockApiHandler(t, devadm)
+
+			req := test.MakeSimpleRequest("GET",
+				"http://1.2.3.4/api/management/v2/admission/devices/"+tc.id.String(),
+				nil)
+			recorded := runTestRequest(t, apih, req, tc.code, tc.body)
+			if tc.code == 200 {
+				recorded.HeaderIs("ETag", `"2"`)
+			}
+		})
+	}
+}
+
+func TestApiDevAdmV2UpdateDeviceStatus(t *testing.T) {
+	testCases := map[string]struct {
+		status    string
+		ifMatch   string
+		devAdmErr error
+
+		code int
+		body string
+	}{
+		"ok, accepted": {
+			status: model.DevStatusAccepted,
+			code:   200,
+			body:   `{"data":{"status":"accepted"}}`,
+		},
+		"ok, suspended": {
+			status: model.DevStatusSuspended,
+			code:   200,
+			body:   `{"data":{"status":"suspended"}}`,
+		},
+		"ok, approvals pending": {
+			status:    model.DevStatusAccepted,
+			devAdmErr: devadm.ErrApprovalsPending,
+			code:      202,
+		},
+		"error: bad status": {
+			status: model.DevStatusPending,
+			code:   400,
+			body:   ProblemError(400, ProblemInvalidRequest, "incorrect device status"),
+		},
+		"error: bad if-match": {
+			status:  model.DevStatusRejected,
+			ifMatch: `"abc"`,
+			code:    412,
+			body: ProblemError(412, ProblemRevisionMismatch,
+				devadm.ErrRevisionMismatch.Error()),
+		},
+		"error: transition": {
+			status:    model.DevStatusRejected,
+			devAdmErr: devadm.ErrInvalidTransition,
+			code:      409,
+			body: ProblemError(409, ProblemInvalidTransition,
+				devadm.ErrInvalidTransition.Error()),
+		},
+		"error: quota": {
+			status:    model.DevStatusAccepted,
+			devAdmErr: devadm.ErrQuotaExceeded,
+			code:      402,
+			body: ProblemError(402, ProblemQuotaExceeded,
+				devadm.ErrQuotaExceeded.Error()),
+		},
+		"error: not found": {
+			status:    model.DevStatusAccepted,
+			devAdmErr: store.ErrNotFound,
+			code:      404,
+			body:      ProblemError(404, ProblemNotFound, store.ErrNotFound.Error()),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			devadm := &mdevadm.App{}
+			for _, method := range []string{"AcceptDeviceAuth", "RejectDeviceAuth", "SuspendDeviceAuth"} {
+				devadm.On(method,
+					mock.MatchedBy(func(c context.Context) bool { return true }),
+					model.AuthID("foo")).
+					Return(tc.devAdmErr)
+			}
+
+			apih := makeMockApiHandler(t, devadm)
+
+			req := test.MakeSimpleRequest("PUT",
+				"http://1.2.3.4/api/management/v2/admission/devices/foo/status",
+				DevAdmApiStatus{tc.status})
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			runTestRequest(t, apih, req, tc.code, tc.body)
+		})
+	}
+}
+
+func TestApiDevAdmV2PostDevices(t *testing.T) {
+	authSet := model.AuthSet{Key: "foo-key", DeviceId: makeJson(t,
+		map[string]string{
+			"mac": "00:00:00:01",
+		})}
+
+	testCases := map[string]struct {
+		input     interface{}
+		devAdmErr error
+
+		code int
+		body string
+	}{
+		"ok": {
+			input: authSet,
+			code:  201,
+		},
+		"error: conflict": {
+			input:     authSet,
+			devAdmErr: devadm.AuthSetConflictError,
+			code:      409,
+			body: ProblemError(409, ProblemAuthSetExists,
+				devadm.AuthSetConflictError.Error()),
+		},
+		"error: no key": {
+			input: model.AuthSet{DeviceId: authSet.DeviceId},
+			code:  400,
+			body: ProblemError(400, ProblemInvalidRequest,
+				"key: non zero value required"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			devadm := &mdevadm.App{}
+			devadm.On("GetIdentitySchema",
+				mock.MatchedBy(func(c context.Context) bool { return true })).
+				Return(nil, nil)
+			devadm.On("PreauthorizeDevice",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				mock.AnythingOfType("model.AuthSet"),
+				"").
+				Return(tc.devAdmErr)
+
+			apih := makeMockApiHandler(t, devadm)
+
+			req := test.MakeSimpleRequest("POST",
+				"http://1.2.3.4/api/management/v2/admission/devices",
+				tc.input)
+			runTestRequest(t, apih, req, tc.code, tc.body)
+		})
+	}
+}
+
+func TestApiDevAdmV2PatchDevice(t *testing.T) {
+	dev := &model.DeviceAuth{
+		ID:       "foo",
+		Status:   model.DevStatusPending,
+		Tags:     []string{"a"},
+		Revision: 4,
+	}
+	errNoAttributes := devadm.ErrNoAttributesLeft
+
+	devadm := &mdevadm.App{}
+	devadm.On("PatchDeviceAuth",
+		mock.MatchedBy(func(c context.Context) bool { return true }),
+		model.AuthID("foo"),
+		model.DeviceAuthPatch{Tags: []string{"a"}, SetTags: true}).
+		Return(dev, nil)
+	devadm.On("PatchDeviceAuth",
+		mock.MatchedBy(func(c context.Context) bool { return true }),
+		model.AuthID("bar"),
+		model.DeviceAuthPatch{Tags: []string{"a"}, SetTags: true}).
+		Return(nil, store.ErrNotFound)
+	devadm.On("PatchDeviceAuth",
+		mock.MatchedBy(func(c context.Context) bool { return true }),
+		model.AuthID("foo"),
+		model.DeviceAuthPatch{Attributes: map[string]*string{"mac": nil}}).
+		Return(nil, errNoAttributes)
+	devadm.On("NextStatuses",
+		mock.MatchedBy(func(c context.Context) bool { return true }),
+		dev).
+		Return([]string{"accepted", "rejected"}, nil)
+
+	apih := makeMockApiHandler(t, devadm)
+
+	req := test.MakeSimpleRequest("PATCH",
+		"http://1.2.3.4/api/management/v2/admission/devices/foo",
+		map[string]interface{}{"tags": []string{"a"}})
+	recorded := runTestRequest(t, apih, req, 200,
+		ToJson(&DevAdmApiV2Item{
+			&DevAdmApiDevice{dev, []string{"accepted", "rejected"}},
+		}))
+	recorded.HeaderIs("ETag", `"4"`)
+
+	req = test.MakeSimpleRequest("PATCH",
+		"http://1.2.3.4/api/management/v2/admission/devices/bar",
+		map[string]interface{}{"tags": []string{"a"}})
+	runTestRequest(t, apih, req, 404,
+		ProblemError(404, ProblemNotFound, store.ErrNotFound.Error()))
+
+	req = test.MakeSimpleRequest("PATCH",
+		"http://1.2.3.4/api/management/v2/admission/devices/foo",
+		map[string]interface{}{"status": "accepted"})
+	runTestRequest(t, apih, req, 400,
+		ProblemError(400, ProblemInvalidRequest,
+			"failed to decode patch: status: field cannot be patched"))
+
+	req = test.MakeSimpleRequest("PATCH",
+		"http://1.2.3.4/api/management/v2/admission/devices/foo",
+		map[string]interface{}{"attributes": map[string]interface{}{"mac": nil}})
+	runTestRequest(t, apih, req, 400,
+		ProblemError(400, ProblemInvalidRequest,
+			errNoAttributes.Error()))
+}
+
+func TestApiDevAdmV2DeleteDevice(t *testing.T) {
+	testCases := map[string]struct {
+		id        model.AuthID
+		devAdmErr error
+
+		code int
+		body string
+	}{
+		"ok": {
+			id:   "foo",
+			code: 204,
+		},
+		"ok, not found": {
+			id:        "bar",
+			devAdmErr: store.ErrNotFound,
+			code:      204,
+		},
+		"error: revision mismatch": {
+			id:        "foo",
+			devAdmErr: devadm.ErrRevisionMismatch,
+			code:      412,
+			body: ProblemError(412, ProblemRevisionMismatch,
+				devadm.ErrRevisionMismatch.Error()),
+		},
+		"error: internal": {
+			id:        "foo",
+			devAdmErr: errors.New("failed to propagate"),
+			code:      500,
+			body:      ProblemError(500, ProblemInternal, "internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			devadm := &mdevadm.App{}
+			devadm.On("DeleteDeviceAuthPropagate",
+				mock.MatchedBy(func(c context.Context) bool { return true }),
+				tc.id, "").
+				Return(tc.devAdmErr)
+
+			apih := makeMockApiHandler(t, devadm)
+
+			req := test.MakeSimpleRequest("DELETE",
+				"http://1.2.3.4/api/management/v2/admission/devices/"+tc.id.String(),
+				nil)
+			runTestRequest(t, apih, req, tc.code, tc.body)
+		})
+	}
+}
+
+func TestProblemWithLog(t *testing.T) {
+	testCases := map[string]struct {
+		err error
+
+		code       int
+		body       string
+		retryAfter string
+	}{
+		"sentinel": {
+			err:  devadm.ErrApprovalNotOwned,
+			code: 403,
+			body: ProblemError(403, ProblemApprovalNotOwned,
+				devadm.ErrApprovalNotOwned.Error()),
+		},
+		"usage error": {
+			err:  utils.NewUsageError("bad identity"),
+			code: 400,
+			body: ProblemError(400, ProblemInvalidRequest, "bad identity"),
+		},
+		"rate limited": {
+			err:  &devadm.RateLimitError{RetryAfter: 1500 * time.Millisecond},
+			code: 429,
+			body: ProblemError(429, ProblemRateLimited,
+				(&devadm.RateLimitError{RetryAfter: 1500 * time.Millisecond}).Error()),
+			retryAfter: "2",
+		},
+		"internal": {
+			err:  errors.New("db connection failed"),
+			code: 500,
+			body: ProblemError(500, ProblemInternal, "internal error"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			api := rest.NewApi()
+			api.Use(&requestid.RequestIdMiddleware{})
+			api.SetApp(rest.AppSimple(func(w rest.ResponseWriter, r *rest.Request) {
+				problemWithLog(w, r, log.New(log.Ctx{}), tc.err)
+			}))
+
+			req := test.MakeSimpleRequest("GET", "http://1.2.3.4/", nil)
+			recorded := runTestRequest(t, api.MakeHandler(), req, tc.code, tc.body)
+			recorded.HeaderIs("Content-Type", ProblemContentType)
+			if tc.retryAfter != "" {
+				recorded.HeaderIs("Retry-After", tc.retryAfter)
+			}
+		})
+	}
+}
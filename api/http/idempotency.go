@@ -0,0 +1,177 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+
+	"github.com/mendersoftware/deviceadm/devadm"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/utils"
+)
+
+const (
+	HdrIdempotencyKey     = "Idempotency-Key"
+	HdrIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// response headers replayed along with the recorded response
+var idempotentHeaders = []string{"Content-Type", "Location"}
+
+// idempotencyRecorder passes the response on to the client, keeping a
+// copy of it
+type idempotencyRecorder struct {
+	rest.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *idempotencyRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotencyRecorder) WriteJson(v interface{}) error {
+	b, err := w.EncodeJson(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.(http.ResponseWriter).Write(b)
+}
+
+// hashRequest returns a digest of request method, URI and body; the body
+// is kept intact for the handler
+func hashRequest(r *rest.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// errorResponder responds to a request which failed with error 'e'
+type errorResponder func(w rest.ResponseWriter, r *rest.Request, l *log.Logger, e error)
+
+// idempotencyErrWithLog responds to idempotency errors in v1 API format
+func idempotencyErrWithLog(w rest.ResponseWriter, r *rest.Request, l *log.Logger, e error) {
+	switch {
+	case e == devadm.ErrIdempotencyKeyReused:
+		restErrWithLog(w, r, l, e, http.StatusUnprocessableEntity)
+	case e == devadm.ErrIdempotencyKeyInFlight:
+		restErrWithLog(w, r, l, e, http.StatusConflict)
+	case utils.IsUsageError(e):
+		restErrWithLog(w, r, l, e, http.StatusBadRequest)
+	default:
+		restErrWithLogInternal(w, r, l, e)
+	}
+}
+
+// idempotent makes handler 'h' honour Idempotency-Key header: the response
+// to the first request made with a key is recorded, and replayed to
+// retries of the request instead of processing it again; failures are
+// reported with 'fail'
+func (d *DevAdmHandlers) idempotent(h rest.HandlerFunc, fail errorResponder) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		key := r.Header.Get(HdrIdempotencyKey)
+		if key == "" {
+			h(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		l := log.FromContext(ctx)
+
+		if len(key) > model.MaxIdempotencyKeyLength {
+			fail(w, r, l, utils.NewUsageError(fmt.Sprintf(
+				"%s is longer than %d characters",
+				HdrIdempotencyKey, model.MaxIdempotencyKeyLength)))
+			return
+		}
+
+		hash, err := hashRequest(r)
+		if err != nil {
+			fail(w, r, l, utils.NewUsageError(
+				"failed to read request body: "+err.Error()))
+			return
+		}
+
+		req, err := d.DevAdm.BeginIdempotentRequest(ctx, key, hash)
+		if err != nil {
+			fail(w, r, l, err)
+			return
+		}
+
+		if req.Done() {
+			for _, name := range idempotentHeaders {
+				if v, ok := req.Header[name]; ok {
+					w.Header()[name] = v
+				}
+			}
+			w.Header().Set(HdrIdempotentReplayed, "true")
+			w.WriteHeader(req.StatusCode)
+			w.(http.ResponseWriter).Write(req.Body)
+			return
+		}
+
+		// hold the key for as long as the handler runs
+		release := d.DevAdm.KeepIdempotentRequest(ctx, req)
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		h(rec, r)
+		release()
+
+		req.StatusCode = rec.status
+		req.Header = http.Header{}
+		req.Body = rec.body.Bytes()
+		for _, name := range idempotentHeaders {
+			if v, ok := w.Header()[name]; ok {
+				req.Header[name] = v
+			}
+		}
+
+		// the response was sent already, a failure only means that
+		// retries are processed again
+		err = d.DevAdm.EndIdempotentRequest(ctx, req)
+		if err != nil {
+			l.Errorf("failed to record response to idempotent request: %v", err)
+		}
+	}
+}
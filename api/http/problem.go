@@ -0,0 +1,140 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mendersoftware/go-lib-micro/requestid"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/devadm"
+	"github.com/mendersoftware/deviceadm/store"
+	"github.com/mendersoftware/deviceadm/utils"
+)
+
+const (
+	ProblemContentType = "application/problem+json"
+)
+
+// Stable error codes of v2 API problems; clients may rely on them, so
+// they must never change
+const (
+	ProblemInvalidRequest       = "invalid_request"
+	ProblemInternal             = "internal_error"
+	ProblemNotFound             = "not_found"
+	ProblemRateLimited          = "rate_limited"
+	ProblemAuthSetExists        = "auth_set_exists"
+	ProblemInvalidTransition    = "invalid_transition"
+	ProblemNotPreauthorized     = "not_preauthorized"
+	ProblemDeviceBlocked        = "device_blocked"
+	ProblemQuotaExceeded        = "quota_exceeded"
+	ProblemRevisionMismatch     = "revision_mismatch"
+	ProblemNoApprover           = "no_approver"
+	ProblemApprovalNotFound     = "approval_not_found"
+	ProblemApprovalNotOwned     = "approval_not_owned"
+	ProblemNoNoteAuthor         = "no_note_author"
+	ProblemIdempotencyKeyReused = "idempotency_key_reused"
+	ProblemIdempotencyInFlight  = "idempotency_key_in_flight"
+)
+
+// Problem is an RFC 7807 problem details object, extended with a stable
+// error code; unlike the human readable detail, the code can be relied on
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type problemCode struct {
+	status int
+	code   string
+}
+
+// problemCodes maps errors returned by devadm to problems
+var problemCodes = map[error]problemCode{
+	store.ErrNotFound:                {http.StatusNotFound, ProblemNotFound},
+	devadm.ErrAuthNotFound:           {http.StatusNotFound, ProblemNotFound},
+	devadm.AuthSetConflictError:      {http.StatusConflict, ProblemAuthSetExists},
+	devadm.ErrInvalidTransition:      {http.StatusConflict, ProblemInvalidTransition},
+	devadm.ErrNotPreauthorized:       {http.StatusConflict, ProblemNotPreauthorized},
+	devadm.ErrDeviceBlocked:          {http.StatusForbidden, ProblemDeviceBlocked},
+	devadm.ErrQuotaExceeded:          {http.StatusPaymentRequired, ProblemQuotaExceeded},
+	devadm.ErrRevisionMismatch:       {http.StatusPreconditionFailed, ProblemRevisionMismatch},
+	devadm.ErrNoApprover:             {http.StatusBadRequest, ProblemNoApprover},
+	devadm.ErrApprovalNotFound:       {http.StatusNotFound, ProblemApprovalNotFound},
+	devadm.ErrApprovalNotOwned:       {http.StatusForbidden, ProblemApprovalNotOwned},
+	devadm.ErrNoNoteAuthor:           {http.StatusBadRequest, ProblemNoNoteAuthor},
+	devadm.ErrNoAttributesLeft:       {http.StatusBadRequest, ProblemInvalidRequest},
+	devadm.ErrIdempotencyKeyReused:   {http.StatusUnprocessableEntity, ProblemIdempotencyKeyReused},
+	devadm.ErrIdempotencyKeyInFlight: {http.StatusConflict, ProblemIdempotencyInFlight},
+}
+
+// problemWithLog responds with the problem error 'e' maps to, see
+// problemCodes; usage errors are invalid requests, other errors are
+// internal and their details are only logged
+func problemWithLog(w rest.ResponseWriter, r *rest.Request, l *log.Logger, e error) {
+	cause := errors.Cause(e)
+
+	if pc, ok := problemCodes[cause]; ok {
+		problemWithLogCode(w, r, l, e, pc.status, pc.code)
+		return
+	}
+
+	if utils.IsUsageError(cause) {
+		problemWithLogCode(w, r, l, e, http.StatusBadRequest, ProblemInvalidRequest)
+		return
+	}
+
+	if rerr, ok := cause.(*devadm.RateLimitError); ok {
+		// whole seconds, rounded up
+		secs := (rerr.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.Itoa(int(secs)))
+		problemWithLogCode(w, r, l, e, http.StatusTooManyRequests, ProblemRateLimited)
+		return
+	}
+
+	problemWithLogCode(w, r, l, e, http.StatusInternalServerError, ProblemInternal)
+}
+
+// problemWithLogCode responds with a problem of HTTP status 'status' and
+// error code 'code'; details of internal errors are only logged
+func problemWithLogCode(w rest.ResponseWriter, r *rest.Request, l *log.Logger, e error, status int, code string) {
+	detail := e.Error()
+	if status >= http.StatusInternalServerError {
+		detail = "internal error"
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(status)
+	err := w.WriteJson(&Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: requestid.GetReqId(r),
+	})
+	if err != nil {
+		panic(err)
+	}
+	l.F(log.Ctx{}).Error(errors.Wrap(e, detail).Error())
+}
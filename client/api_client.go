@@ -14,13 +14,49 @@
 package client
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/mendersoftware/go-lib-micro/requestid"
+	"github.com/pkg/errors"
 
 	ctx_httpheader "github.com/mendersoftware/deviceadm/context/httpheader"
 )
 
+var (
+	// client used by a zero value HttpApi, backed by
+	// http.DefaultTransport
+	defaultClient = &http.Client{}
+)
+
+// Config of the transport shared by outgoing requests
+type Config struct {
+	// maximum number of idle (keep-alive) connections, in total and to a
+	// single host
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// time after which an idle connection is closed
+	IdleConnTimeout time.Duration
+
+	// PEM file with root CAs trusted in addition to the system ones
+	CACertFile string
+	// PEM files with client certificate and key, presented to servers
+	// requesting client authentication (mutual TLS)
+	ClientCertFile string
+	ClientKeyFile  string
+	// skip verification of server certificates
+	TLSSkipVerify bool
+
+	// proxy URL, if not set proxy is taken from HTTP_PROXY/HTTPS_PROXY
+	// environment variables
+	ProxyURL string
+}
+
 func maybeSetHeader(hdrs http.Header, hdr string, val string) {
 	if val == "" {
 		return
@@ -30,7 +66,86 @@ func maybeSetHeader(hdrs http.Header, hdr string, val string) {
 }
 
 // HttpApi is an http.Client wrapper tailored to use with mender's APIs.
+// A zero value HttpApi uses http.DefaultTransport; use NewHttpApi to get one
+// with TLS and proxy settings from Config.
 type HttpApi struct {
+	client *http.Client
+}
+
+// NewHttpApi returns an HttpApi whose requests share a single connection
+// pool set up according to 'conf'
+func NewHttpApi(conf Config) (*HttpApi, error) {
+	t, err := NewTransport(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HttpApi{
+		client: &http.Client{Transport: t},
+	}, nil
+}
+
+// NewTransport builds a pooling, TLS capable transport from 'conf'
+func NewTransport(conf Config) (*http.Transport, error) {
+	tlsConf, err := newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if conf.ProxyURL != "" {
+		purl, err := url.Parse(conf.ProxyURL)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse proxy URL")
+		}
+		proxy = http.ProxyURL(purl)
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     tlsConf,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        conf.MaxIdleConns,
+		MaxIdleConnsPerHost: conf.MaxIdleConnsPerHost,
+		IdleConnTimeout:     conf.IdleConnTimeout,
+	}, nil
+}
+
+func newTLSConfig(conf Config) (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: conf.TLSSkipVerify,
+	}
+
+	if conf.CACertFile != "" {
+		pem, err := ioutil.ReadFile(conf.CACertFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA certificate")
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in %s",
+				conf.CACertFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	if conf.ClientCertFile != "" || conf.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.ClientCertFile, conf.ClientKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load client certificate")
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
 }
 
 // Do behaves similarly to http.Client.Do(), but will also automatically add
@@ -39,7 +154,10 @@ type HttpApi struct {
 // - X-Mender-RequestId - extracted with requestid.FromContext()
 // - Authorization - extracted with httpheader.FromContext()
 func (a *HttpApi) Do(r *http.Request) (*http.Response, error) {
-	client := &http.Client{}
+	client := a.client
+	if client == nil {
+		client = defaultClient
+	}
 	ctx := r.Context()
 
 	maybeSetHeader(r.Header, requestid.RequestIdHeader,
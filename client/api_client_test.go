@@ -14,9 +14,22 @@
 package client
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/stretchr/testify/assert"
@@ -54,3 +67,220 @@ func TestApiClient(t *testing.T) {
 	assert.Equal(t, "Bearer of-bad-news", inreq.Header.Get("Authorization"))
 	assert.Equal(t, "123-456", inreq.Header.Get(requestid.RequestIdHeader))
 }
+
+// writeTempPEM writes PEM block of type 'typ' to a file in 'dir'
+func writeTempPEM(t *testing.T, dir, name, typ string, der []byte) string {
+	path := filepath.Join(dir, name)
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// makeClientCert generates a self signed client certificate, returns paths
+// to certificate and key files and the parsed certificate
+func makeClientCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "deviceadm"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writeTempPEM(t, dir, "client.crt", "CERTIFICATE", der),
+		writeTempPEM(t, dir, "client.key", "EC PRIVATE KEY", keyDer),
+		cert
+}
+
+func TestApiClientMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deviceadm-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, clientCert := makeClientCert(t, dir)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := writeTempPEM(t, dir, "ca.crt", "CERTIFICATE",
+		srv.Certificate().Raw)
+
+	testCases := map[string]struct {
+		conf Config
+		err  bool
+	}{
+		"ok": {
+			conf: Config{
+				CACertFile:     caFile,
+				ClientCertFile: certFile,
+				ClientKeyFile:  keyFile,
+			},
+		},
+		"error: unknown CA": {
+			conf: Config{
+				ClientCertFile: certFile,
+				ClientKeyFile:  keyFile,
+			},
+			err: true,
+		},
+		"error: no client certificate": {
+			conf: Config{
+				CACertFile: caFile,
+			},
+			err: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewHttpApi(tc.conf)
+			assert.NoError(t, err)
+
+			r, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+			rsp, err := c.Do(r)
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusNoContent, rsp.StatusCode)
+				rsp.Body.Close()
+			}
+		})
+	}
+}
+
+func TestApiClientConnectionReuse(t *testing.T) {
+	conns := 0
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns++
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c, err := NewHttpApi(Config{
+		MaxIdleConnsPerHost: 2,
+		IdleConnTimeout:     time.Minute,
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		r, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+		rsp, err := c.Do(r)
+		assert.NoError(t, err)
+		ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+	}
+
+	assert.Equal(t, 1, conns)
+}
+
+func TestNewTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deviceadm-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	garbage := filepath.Join(dir, "garbage.pem")
+	ioutil.WriteFile(garbage, []byte("foo"), 0600)
+
+	testCases := map[string]struct {
+		conf Config
+
+		proxy string
+		err   string
+	}{
+		"ok": {
+			conf: Config{
+				MaxIdleConns: 10,
+			},
+		},
+		"ok, proxy": {
+			conf: Config{
+				ProxyURL: "http://proxy:3128",
+			},
+			proxy: "http://proxy:3128",
+		},
+		"error: missing CA file": {
+			conf: Config{
+				CACertFile: filepath.Join(dir, "missing.crt"),
+			},
+			err: "failed to read CA certificate",
+		},
+		"error: bad CA file": {
+			conf: Config{
+				CACertFile: garbage,
+			},
+			err: "no certificates found in " + garbage,
+		},
+		"error: bad client certificate": {
+			conf: Config{
+				ClientCertFile: garbage,
+				ClientKeyFile:  garbage,
+			},
+			err: "failed to load client certificate",
+		},
+		"error: bad proxy URL": {
+			conf: Config{
+				ProxyURL: ":bad url",
+			},
+			err: "failed to parse proxy URL",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tr, err := NewTransport(tc.conf)
+			if tc.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tc.err)
+				}
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.conf.MaxIdleConns, tr.MaxIdleConns)
+
+			if tc.proxy != "" {
+				r, _ := http.NewRequest(http.MethodGet, "http://devauth/", nil)
+				purl, err := tr.Proxy(r)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.proxy, purl.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package deviceauth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/utils/clock"
+)
+
+var (
+	// ErrCircuitOpen is returned without contacting devauth while the
+	// circuit breaker is open
+	ErrCircuitOpen = errors.New("devauth is unavailable, circuit breaker open")
+)
+
+// CircuitBreaker tracks consecutive failures of requests to devauth. Once
+// the failure threshold is reached, the breaker opens and requests are
+// refused until the cooldown expires; then a single trial request is let
+// through, and its outcome either closes the breaker or opens it again.
+//
+// A nil *CircuitBreaker is valid and never opens.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     clock.Clock
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration, c clock.Clock) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		clock:     c,
+	}
+}
+
+// Allow tells whether a request may be sent now
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	if b.probing || b.clock.Now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	// half-open, let a single trial request through
+	b.probing = true
+	return true
+}
+
+// Record registers the outcome of a request that was allowed to proceed
+func (b *CircuitBreaker) Record(success bool) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package deviceauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(func() time.Time { return now })
+
+	b := NewCircuitBreaker(2, time.Minute, clock)
+
+	assert.True(t, b.Allow())
+	b.Record(false)
+	assert.True(t, b.Allow())
+	b.Record(true)
+
+	// failure counter was reset by a successful request
+	assert.True(t, b.Allow())
+	b.Record(false)
+	assert.True(t, b.Allow())
+	b.Record(false)
+
+	// open
+	assert.False(t, b.Allow())
+	now = now.Add(30 * time.Second)
+	assert.False(t, b.Allow())
+
+	// half-open, only one trial request
+	now = now.Add(time.Minute)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+
+	// trial failed, open again
+	b.Record(false)
+	assert.False(t, b.Allow())
+
+	// trial succeeded, closed
+	now = now.Add(2 * time.Minute)
+	assert.True(t, b.Allow())
+	b.Record(true)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	var b *CircuitBreaker
+
+	b.Record(false)
+	assert.True(t, b.Allow())
+
+	b = NewCircuitBreaker(0, time.Minute, nil)
+	for i := 0; i < 10; i++ {
+		b.Record(false)
+	}
+	assert.True(t, b.Allow())
+}
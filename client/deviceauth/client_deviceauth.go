@@ -17,6 +17,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
+	"math/rand"
 	"net/http"
 	"strings"
 	"time"
@@ -38,6 +40,16 @@ const (
 	defaultPreauthorizeDeviceUri = "/api/management/v1/devauth/devices"
 	// default request timeout, 10s?
 	defaultDevAuthReqTimeout = time.Duration(10) * time.Second
+	// default delay before the first retry
+	defaultDevAuthRetryBackoff = time.Duration(100) * time.Millisecond
+	// default upper bound of the delay between retries
+	defaultDevAuthMaxRetryBackoff = time.Duration(2) * time.Second
+)
+
+var (
+	// ErrConflict is returned when devauth already knows the device
+	// being preauthorized
+	ErrConflict = errors.New("device already exists in devauth")
 )
 
 type Config struct {
@@ -45,11 +57,23 @@ type Config struct {
 	DevauthUrl string
 	// request timeout
 	Timeout time.Duration
+	// number of retries of idempotent requests, 0 disables retries
+	MaxRetries int
+	// delay before the first retry, doubled with each subsequent one
+	RetryBackoff time.Duration
+	// upper bound of the delay between retries
+	MaxRetryBackoff time.Duration
+	// number of consecutive failures opening the circuit breaker, 0
+	// disables the breaker
+	BreakerThreshold int
+	// time after which an open breaker lets a trial request through
+	BreakerCooldown time.Duration
 }
 
 type Client struct {
-	client client.HttpRunner
-	conf   Config
+	client  client.HttpRunner
+	conf    Config
+	breaker *CircuitBreaker
 }
 
 // devauth's status request
@@ -89,10 +113,7 @@ func (d *Client) UpdateDevice(ctx context.Context, sreq StatusReq) error {
 
 	req.Header.Set("Content-Type", "application/json")
 
-	// set request timeout and setup cancellation
-	ctx, cancel := context.WithTimeout(ctx, d.conf.Timeout)
-	defer cancel()
-	rsp, err := d.client.Do(req.WithContext(ctx))
+	rsp, err := d.do(ctx, req, true)
 	if err != nil {
 		return errors.Wrapf(err, "failed to update device status")
 	}
@@ -133,10 +154,8 @@ func (d *Client) PreauthorizeDevice(
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", authorizationHeader)
 
-	// set request timeout and setup cancellation
-	ctx, cancel := context.WithTimeout(ctx, d.conf.Timeout)
-	defer cancel()
-	rsp, err := d.client.Do(req.WithContext(ctx))
+	// creating the auth set is not idempotent, never retry
+	rsp, err := d.do(ctx, req, false)
 	if err != nil {
 		return errors.Wrapf(err, "failed to preauthorize device")
 	}
@@ -145,6 +164,8 @@ func (d *Client) PreauthorizeDevice(
 	switch rsp.StatusCode {
 	case http.StatusCreated:
 		return nil
+	case http.StatusConflict:
+		return ErrConflict
 	default:
 		return errors.Errorf("device preauthorize request failed with status %v",
 			rsp.Status)
@@ -164,10 +185,7 @@ func (d *Client) DeleteDeviceAuthSet(
 
 	req.Header.Set("Authorization", authorizationHeader)
 
-	// set request timeout and setup cancellation
-	ctx, cancel := context.WithTimeout(ctx, d.conf.Timeout)
-	defer cancel()
-	rsp, err := d.client.Do(req.WithContext(ctx))
+	rsp, err := d.do(ctx, req, true)
 	if err != nil {
 		return errors.Wrapf(err, "failed to preauthorize device")
 	}
@@ -188,12 +206,123 @@ func NewClient(c Config, client client.HttpRunner) *Client {
 	if c.Timeout == 0 {
 		c.Timeout = defaultDevAuthReqTimeout
 	}
+	if c.RetryBackoff == 0 {
+		c.RetryBackoff = defaultDevAuthRetryBackoff
+	}
+	if c.MaxRetryBackoff == 0 {
+		c.MaxRetryBackoff = defaultDevAuthMaxRetryBackoff
+	}
 	return &Client{
 		client: client,
 		conf:   c,
 	}
 }
 
+// WithCircuitBreaker makes the client fail fast while breaker 'b' is
+// open, the breaker is meant to be shared by all clients talking to
+// the same devauth instance
+func (d *Client) WithCircuitBreaker(b *CircuitBreaker) *Client {
+	d.breaker = b
+	return d
+}
+
+// do sends the request, retrying it on transport errors and 5xx responses
+// if 'retry' is set. Each attempt is bounded by the configured timeout,
+// which is released once the response body is closed.
+func (d *Client) do(ctx context.Context, req *http.Request, retry bool) (*http.Response, error) {
+	attempts := 1
+	if retry {
+		attempts += d.conf.MaxRetries
+	}
+
+	for i := 0; ; i++ {
+		if !d.breaker.Allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		rsp, err := d.doOnce(ctx, req)
+
+		transient := err != nil ||
+			rsp.StatusCode >= http.StatusInternalServerError
+		d.breaker.Record(!transient)
+
+		if !transient || i == attempts-1 {
+			return rsp, err
+		}
+
+		if rsp != nil {
+			rsp.Body.Close()
+		}
+
+		l := log.FromContext(ctx)
+		l.Warnf("devauth request %s %s failed, retrying",
+			req.Method, req.URL.Path)
+
+		if err := sleepContext(ctx, d.backoff(i)); err != nil {
+			return nil, err
+		}
+
+		// rewind the body for the next attempt
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+func (d *Client) doOnce(ctx context.Context, req *http.Request) (*http.Response, error) {
+	// set request timeout and setup cancellation
+	ctx, cancel := context.WithTimeout(ctx, d.conf.Timeout)
+	rsp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	rsp.Body = &cancelOnClose{ReadCloser: rsp.Body, cancel: cancel}
+	return rsp, nil
+}
+
+// backoff returns the delay before retry number 'attempt' (counted from
+// 0); the delay grows exponentially up to the configured maximum, half of
+// it is randomized to spread retries of concurrent requests
+func (d *Client) backoff(attempt int) time.Duration {
+	delay := d.conf.RetryBackoff << uint(attempt)
+	if delay <= 0 || delay > d.conf.MaxRetryBackoff {
+		delay = d.conf.MaxRetryBackoff
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func sleepContext(ctx context.Context, dur time.Duration) error {
+	t := time.NewTimer(dur)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// cancelOnClose releases the request context together with the body
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
 func (d *Client) buildDevAuthUpdateUrl(req StatusReq) string {
 	repl := strings.NewReplacer("{id}", req.DeviceId,
 		"{aid}", req.AuthId)
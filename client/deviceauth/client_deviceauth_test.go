@@ -21,9 +21,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/mendersoftware/deviceadm/utils"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
 	"github.com/mendersoftware/go-lib-micro/rest_utils"
 	"io/ioutil"
 	"strings"
@@ -130,6 +132,7 @@ func TestDevAuthClientReqUrl(t *testing.T) {
 		})
 
 	assert.NoError(t, err, "expected no errors")
+	assert.Equal(t, "/api/management/v1/devauth/devices/1/auth/123/status", urlPath)
 }
 
 func TestDevAuthClientReqNoHost(t *testing.T) {
@@ -246,6 +249,19 @@ func TestDevAuthClientPreauthorizeDeviceReqFailStatus(t *testing.T) {
 	assert.Error(t, err, "expected an error")
 }
 
+func TestDevAuthClientPreauthorizeDeviceReqConflict(t *testing.T) {
+	s := newMockServer(t, http.StatusConflict, nil)
+	defer s.Close()
+
+	c := NewClient(Config{
+		DevauthUrl: s.URL,
+	}, &http.Client{})
+
+	err := c.PreauthorizeDevice(context.Background(),
+		&PreAuthReq{}, "Bearer: foo-token")
+	assert.Equal(t, ErrConflict, err)
+}
+
 func TestDevAuthClientPreauthorizeDeviceReqFailParseURL(t *testing.T) {
 
 	c := NewClient(Config{
@@ -298,3 +314,198 @@ func TestDeleteDeviceAuthsetReqFailStatus(t *testing.T) {
 		&PreAuthReq{}, "Bearer: foo-token")
 	assert.Error(t, err, "expected an error")
 }
+
+// return mock http server responding with consecutive statuses from
+// 'statuses', repeating the last one, and counting received requests
+func newSequenceServer(t *testing.T, statuses []int, count *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idx := *count
+		if idx >= len(statuses) {
+			idx = len(statuses) - 1
+		}
+		*count++
+
+		// the body is rewound for each attempt
+		if r.Method == http.MethodPut {
+			var sreq StatusReq
+			err := json.NewDecoder(r.Body).Decode(&sreq)
+			assert.NoError(t, err)
+			assert.Equal(t, "accepted", sreq.Status)
+		}
+		w.WriteHeader(statuses[idx])
+	}))
+}
+
+func TestDevAuthClientRetry(t *testing.T) {
+	testCases := map[string]struct {
+		statuses   []int
+		maxRetries int
+
+		attempts int
+		err      bool
+	}{
+		"ok, no retries": {
+			statuses:   []int{http.StatusNoContent},
+			maxRetries: 3,
+			attempts:   1,
+		},
+		"ok after retries": {
+			statuses: []int{
+				http.StatusServiceUnavailable,
+				http.StatusInternalServerError,
+				http.StatusNoContent,
+			},
+			maxRetries: 3,
+			attempts:   3,
+		},
+		"retries exhausted": {
+			statuses:   []int{http.StatusInternalServerError},
+			maxRetries: 2,
+			attempts:   3,
+			err:        true,
+		},
+		"retries disabled": {
+			statuses:   []int{http.StatusInternalServerError},
+			maxRetries: 0,
+			attempts:   1,
+			err:        true,
+		},
+		"client error, no retries": {
+			statuses:   []int{http.StatusBadRequest},
+			maxRetries: 3,
+			attempts:   1,
+			err:        true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			count := 0
+			s := newSequenceServer(t, tc.statuses, &count)
+			defer s.Close()
+
+			c := NewClient(Config{
+				DevauthUrl:   s.URL,
+				MaxRetries:   tc.maxRetries,
+				RetryBackoff: time.Millisecond,
+			}, &http.Client{})
+
+			err := c.UpdateDevice(context.Background(),
+				StatusReq{
+					AuthId:   "123",
+					DeviceId: "1",
+					Status:   "accepted",
+				})
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.attempts, count)
+
+			// deletion is retried the same way
+			count = 0
+			err = c.DeleteDeviceAuthSet(context.Background(),
+				"1", "123", "Bearer: foo-token")
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.attempts, count)
+		})
+	}
+}
+
+func TestDevAuthClientPreauthorizeDeviceNoRetry(t *testing.T) {
+	count := 0
+	s := newSequenceServer(t, []int{http.StatusInternalServerError}, &count)
+	defer s.Close()
+
+	c := NewClient(Config{
+		DevauthUrl:   s.URL,
+		MaxRetries:   3,
+		RetryBackoff: time.Millisecond,
+	}, &http.Client{})
+
+	err := c.PreauthorizeDevice(context.Background(),
+		&PreAuthReq{}, "Bearer: foo-token")
+	assert.Error(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestDevAuthClientRetryCanceled(t *testing.T) {
+	count := 0
+	s := newSequenceServer(t, []int{http.StatusInternalServerError}, &count)
+	defer s.Close()
+
+	c := NewClient(Config{
+		DevauthUrl:   s.URL,
+		MaxRetries:   3,
+		RetryBackoff: time.Hour,
+	}, &http.Client{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := c.UpdateDevice(ctx,
+		StatusReq{
+			AuthId:   "123",
+			DeviceId: "1",
+			Status:   "accepted",
+		})
+	assert.EqualError(t, err, "failed to update device status: context deadline exceeded")
+	assert.Equal(t, 1, count)
+}
+
+func TestDevAuthClientBackoff(t *testing.T) {
+	c := NewClient(Config{
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: time.Second,
+	}, &http.Client{})
+
+	for i, max := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		d := c.backoff(i)
+		assert.True(t, d >= max/2 && d <= max,
+			"backoff %v for attempt %d not within [%v, %v]", d, i, max/2, max)
+	}
+}
+
+func TestDevAuthClientCircuitBreaker(t *testing.T) {
+	count := 0
+	s := newSequenceServer(t, []int{http.StatusInternalServerError}, &count)
+	defer s.Close()
+
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(time.Now())
+
+	b := NewCircuitBreaker(2, time.Minute, clock)
+	c := NewClient(Config{
+		DevauthUrl:   s.URL,
+		MaxRetries:   5,
+		RetryBackoff: time.Millisecond,
+	}, &http.Client{}).WithCircuitBreaker(b)
+
+	err := c.UpdateDevice(context.Background(),
+		StatusReq{
+			AuthId:   "123",
+			DeviceId: "1",
+			Status:   "accepted",
+		})
+	assert.Error(t, err)
+	assert.Equal(t, ErrCircuitOpen, errors.Cause(err))
+	// the breaker opened after 2 failed attempts
+	assert.Equal(t, 2, count)
+
+	err = c.PreauthorizeDevice(context.Background(),
+		&PreAuthReq{}, "Bearer: foo-token")
+	assert.Equal(t, ErrCircuitOpen, errors.Cause(err))
+	assert.Equal(t, 2, count)
+}
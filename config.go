@@ -14,6 +14,8 @@
 package main
 
 import (
+	"time"
+
 	"github.com/mendersoftware/deviceadm/config"
 )
 
@@ -38,6 +40,101 @@ const (
 
 	SettingDevAuthUrl        = "devauthurl"
 	SettingDevAuthUrlDefault = "http://mender-device-auth:8080"
+
+	SettingDevAuthTimeout        = "devauth_timeout"
+	SettingDevAuthTimeoutDefault = 10 * time.Second
+
+	SettingDevAuthMaxRetries        = "devauth_max_retries"
+	SettingDevAuthMaxRetriesDefault = 3
+
+	SettingDevAuthRetryBackoff        = "devauth_retry_backoff"
+	SettingDevAuthRetryBackoffDefault = 100 * time.Millisecond
+
+	SettingDevAuthMaxRetryBackoff        = "devauth_max_retry_backoff"
+	SettingDevAuthMaxRetryBackoffDefault = 2 * time.Second
+
+	SettingDevAuthBreakerThreshold        = "devauth_breaker_threshold"
+	SettingDevAuthBreakerThresholdDefault = 5
+
+	SettingDevAuthBreakerCooldown        = "devauth_breaker_cooldown"
+	SettingDevAuthBreakerCooldownDefault = 30 * time.Second
+
+	SettingHttpMaxIdleConns        = "http_max_idle_conns"
+	SettingHttpMaxIdleConnsDefault = 100
+
+	SettingHttpMaxIdleConnsPerHost        = "http_max_idle_conns_per_host"
+	SettingHttpMaxIdleConnsPerHostDefault = 10
+
+	SettingHttpIdleConnTimeout        = "http_idle_conn_timeout"
+	SettingHttpIdleConnTimeoutDefault = 90 * time.Second
+
+	SettingHttpProxy = "http_proxy"
+
+	SettingHttpTLSCACert     = "http_tls_ca_cert"
+	SettingHttpTLSClientCert = "http_tls_client_cert"
+	SettingHttpTLSClientKey  = "http_tls_client_key"
+
+	SettingHttpTLSSkipVerify        = "http_tls_skipverify"
+	SettingHttpTLSSkipVerifyDefault = false
+
+	SettingPropagationTargets = "propagation_targets"
+
+	SettingWebhooksMaxRetries        = "webhooks_max_retries"
+	SettingWebhooksMaxRetriesDefault = 5
+
+	SettingWebhooksRetryBackoff        = "webhooks_retry_backoff"
+	SettingWebhooksRetryBackoffDefault = 1 * time.Second
+
+	SettingWebhooksTimeout        = "webhooks_timeout"
+	SettingWebhooksTimeoutDefault = 10 * time.Second
+
+	SettingWebhooksWorkers        = "webhooks_workers"
+	SettingWebhooksWorkersDefault = 4
+
+	SettingWebhooksQueueSize        = "webhooks_queue_size"
+	SettingWebhooksQueueSizeDefault = 1000
+
+	SettingWebhooksResumeInterval        = "webhooks_resume_interval"
+	SettingWebhooksResumeIntervalDefault = 1 * time.Minute
+
+	SettingWebhooksAllowPrivate        = "webhooks_allow_private_destinations"
+	SettingWebhooksAllowPrivateDefault = false
+
+	SettingShutdownTimeout        = "shutdown_timeout"
+	SettingShutdownTimeoutDefault = 30 * time.Second
+
+	SettingEventsCollSize        = "events_coll_size"
+	SettingEventsCollSizeDefault = 10 * 1024 * 1024
+
+	SettingEventsPollInterval        = "events_poll_interval"
+	SettingEventsPollIntervalDefault = 1 * time.Second
+
+	SettingEventsGapTimeout        = "events_gap_timeout"
+	SettingEventsGapTimeoutDefault = 5 * time.Second
+
+	SettingBatchPreauthConcurrency        = "batch_preauth_concurrency"
+	SettingBatchPreauthConcurrencyDefault = 10
+
+	SettingRateLimitIdentityBurst        = "ratelimit_identity_burst"
+	SettingRateLimitIdentityBurstDefault = 0
+
+	SettingRateLimitIdentityInterval        = "ratelimit_identity_interval"
+	SettingRateLimitIdentityIntervalDefault = 1 * time.Minute
+
+	SettingRateLimitTenantBurst        = "ratelimit_tenant_burst"
+	SettingRateLimitTenantBurstDefault = 0
+
+	SettingRateLimitTenantInterval        = "ratelimit_tenant_interval"
+	SettingRateLimitTenantIntervalDefault = 1 * time.Second
+
+	SettingTrashRetention        = "trash_retention"
+	SettingTrashRetentionDefault = 30 * 24 * time.Hour
+
+	SettingIdempotencyTTL        = "idempotency_ttl"
+	SettingIdempotencyTTLDefault = 24 * time.Hour
+
+	SettingIdempotencyLease        = "idempotency_lease"
+	SettingIdempotencyLeaseDefault = 1 * time.Minute
 )
 
 var (
@@ -49,5 +146,34 @@ var (
 		{Key: SettingDevAuthUrl, Value: SettingDevAuthUrlDefault},
 		{Key: SettingDbSSL, Value: SettingDbSSLDefault},
 		{Key: SettingDbSSLSkipVerify, Value: SettingDbSSLSkipVerifyDefault},
+		{Key: SettingDevAuthTimeout, Value: SettingDevAuthTimeoutDefault},
+		{Key: SettingDevAuthMaxRetries, Value: SettingDevAuthMaxRetriesDefault},
+		{Key: SettingDevAuthRetryBackoff, Value: SettingDevAuthRetryBackoffDefault},
+		{Key: SettingDevAuthMaxRetryBackoff, Value: SettingDevAuthMaxRetryBackoffDefault},
+		{Key: SettingDevAuthBreakerThreshold, Value: SettingDevAuthBreakerThresholdDefault},
+		{Key: SettingDevAuthBreakerCooldown, Value: SettingDevAuthBreakerCooldownDefault},
+		{Key: SettingHttpMaxIdleConns, Value: SettingHttpMaxIdleConnsDefault},
+		{Key: SettingHttpMaxIdleConnsPerHost, Value: SettingHttpMaxIdleConnsPerHostDefault},
+		{Key: SettingHttpIdleConnTimeout, Value: SettingHttpIdleConnTimeoutDefault},
+		{Key: SettingHttpTLSSkipVerify, Value: SettingHttpTLSSkipVerifyDefault},
+		{Key: SettingWebhooksMaxRetries, Value: SettingWebhooksMaxRetriesDefault},
+		{Key: SettingWebhooksRetryBackoff, Value: SettingWebhooksRetryBackoffDefault},
+		{Key: SettingWebhooksTimeout, Value: SettingWebhooksTimeoutDefault},
+		{Key: SettingWebhooksWorkers, Value: SettingWebhooksWorkersDefault},
+		{Key: SettingWebhooksQueueSize, Value: SettingWebhooksQueueSizeDefault},
+		{Key: SettingWebhooksResumeInterval, Value: SettingWebhooksResumeIntervalDefault},
+		{Key: SettingWebhooksAllowPrivate, Value: SettingWebhooksAllowPrivateDefault},
+		{Key: SettingShutdownTimeout, Value: SettingShutdownTimeoutDefault},
+		{Key: SettingEventsCollSize, Value: SettingEventsCollSizeDefault},
+		{Key: SettingEventsPollInterval, Value: SettingEventsPollIntervalDefault},
+		{Key: SettingEventsGapTimeout, Value: SettingEventsGapTimeoutDefault},
+		{Key: SettingBatchPreauthConcurrency, Value: SettingBatchPreauthConcurrencyDefault},
+		{Key: SettingRateLimitIdentityBurst, Value: SettingRateLimitIdentityBurstDefault},
+		{Key: SettingRateLimitIdentityInterval, Value: SettingRateLimitIdentityIntervalDefault},
+		{Key: SettingRateLimitTenantBurst, Value: SettingRateLimitTenantBurstDefault},
+		{Key: SettingRateLimitTenantInterval, Value: SettingRateLimitTenantIntervalDefault},
+		{Key: SettingTrashRetention, Value: SettingTrashRetentionDefault},
+		{Key: SettingIdempotencyTTL, Value: SettingIdempotencyTTLDefault},
+		{Key: SettingIdempotencyLease, Value: SettingIdempotencyLeaseDefault},
 	}
 )
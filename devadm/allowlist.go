@@ -0,0 +1,113 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+var (
+	ErrAllowlistEntryNotFound = errors.New("allowlist entry not found")
+)
+
+func (d *DevAdm) ImportAllowlist(ctx context.Context, batch string, singleUse bool, tags []string, attrs []model.DeviceAuthAttributes) ([]model.AllowlistEntry, error) {
+	now := d.clock.Now()
+
+	entries := make([]model.AllowlistEntry, len(attrs))
+	for i := range attrs {
+		entries[i] = model.AllowlistEntry{
+			Batch:      batch,
+			Attributes: attrs[i],
+			Tags:       tags,
+			SingleUse:  singleUse,
+			Created:    &now,
+		}
+	}
+
+	err := d.db.InsertAllowlistEntries(ctx, entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to import allowlist")
+	}
+	return entries, nil
+}
+
+func (d *DevAdm) ListAllowlist(ctx context.Context, batch string, skip, limit int) ([]model.AllowlistEntry, error) {
+	entries, err := d.db.GetAllowlist(ctx, batch, skip, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch allowlist")
+	}
+	return entries, nil
+}
+
+func (d *DevAdm) DeleteAllowlistEntry(ctx context.Context, id string) error {
+	err := d.db.DeleteAllowlistEntry(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrAllowlistEntryNotFound
+	default:
+		return errors.Wrap(err, "failed to delete allowlist entry")
+	}
+}
+
+// matchAllowlist returns the allowlist entry admitting auth set 'dev', or
+// nil if there is none; a matching single use entry is marked as used by
+// the auth set
+func (d *DevAdm) matchAllowlist(ctx context.Context, dev *model.DeviceAuth) (*model.AllowlistEntry, error) {
+	found, err := d.db.FindAllowlistEntries(ctx, dev.Attributes, dev.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch allowlist entries")
+	}
+
+	// entries are looked up by identity attributes, tags are checked here
+	var entries []model.AllowlistEntry
+	for i := range found {
+		if model.HasTags(dev.Tags, found[i].Tags) {
+			entries = append(entries, found[i])
+		}
+	}
+
+	// prefer entries which do not have to be used up
+	for i := range entries {
+		if !entries[i].SingleUse || entries[i].UsedBy == dev.ID {
+			return &entries[i], nil
+		}
+	}
+
+	for i := range entries {
+		err := d.db.UseAllowlistEntry(ctx, entries[i].ID, dev.ID, d.clock.Now())
+		switch err {
+		case nil:
+			return &entries[i], nil
+		case store.ErrNotFound:
+			// used by another auth set in the meantime
+			continue
+		default:
+			return nil, errors.Wrap(err, "failed to use allowlist entry")
+		}
+	}
+
+	if len(entries) > 0 {
+		log.FromContext(ctx).Infof("allowlist entries matching auth set %v are used up",
+			dev.ID)
+	}
+	return nil, nil
+}
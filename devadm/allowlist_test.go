@@ -0,0 +1,253 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmSubmitDeviceAllowlist(t *testing.T) {
+	testCases := map[string]struct {
+		// tags of the stored auth set
+		tags       []string
+		entries    []model.AllowlistEntry
+		entriesErr error
+
+		// errors returned when using entries, by entry ID
+		useErrs map[string]error
+
+		targetErr error
+
+		outStatus string
+		outError  error
+	}{
+		"no match": {
+			entries:   []model.AllowlistEntry{},
+			outStatus: model.DevStatusPending,
+		},
+		"multi use entry": {
+			entries: []model.AllowlistEntry{
+				{ID: "1", SingleUse: true},
+				{ID: "2"},
+			},
+			outStatus: model.DevStatusAccepted,
+		},
+		"entry requiring tags": {
+			tags: []string{"lab", "qa-passed"},
+			entries: []model.AllowlistEntry{
+				{ID: "1", Tags: []string{"qa-passed"}},
+			},
+			outStatus: model.DevStatusAccepted,
+		},
+		"entry requiring tags, not tagged": {
+			tags: []string{"lab"},
+			entries: []model.AllowlistEntry{
+				{ID: "1", Tags: []string{"qa-passed"}},
+			},
+			outStatus: model.DevStatusPending,
+		},
+		"single use entry used by the auth set before": {
+			entries: []model.AllowlistEntry{
+				{ID: "1", SingleUse: true},
+				{ID: "2", SingleUse: true, UsedBy: "foo"},
+			},
+			outStatus: model.DevStatusAccepted,
+		},
+		"single use entry": {
+			entries: []model.AllowlistEntry{
+				{ID: "1", SingleUse: true},
+			},
+			useErrs: map[string]error{
+				"1": nil,
+			},
+			outStatus: model.DevStatusAccepted,
+		},
+		"single use entry used in the meantime": {
+			entries: []model.AllowlistEntry{
+				{ID: "1", SingleUse: true},
+				{ID: "2", SingleUse: true},
+			},
+			useErrs: map[string]error{
+				"1": store.ErrNotFound,
+				"2": nil,
+			},
+			outStatus: model.DevStatusAccepted,
+		},
+		"entries used up": {
+			entries: []model.AllowlistEntry{
+				{ID: "1", SingleUse: true},
+			},
+			useErrs: map[string]error{
+				"1": store.ErrNotFound,
+			},
+			outStatus: model.DevStatusPending,
+		},
+		"error: use": {
+			entries: []model.AllowlistEntry{
+				{ID: "1", SingleUse: true},
+			},
+			useErrs: map[string]error{
+				"1": errors.New("db connection failed"),
+			},
+			outError: errors.New("failed to use allowlist entry: db connection failed"),
+		},
+		"error: find": {
+			entriesErr: errors.New("db connection failed"),
+			outError:   errors.New("failed to fetch allowlist entries: db connection failed"),
+		},
+		"error: propagation": {
+			entries: []model.AllowlistEntry{
+				{ID: "1"},
+			},
+			targetErr: errors.New("devauth down"),
+			outStatus: model.DevStatusAccepted,
+			outError:  errors.New("failed to propagate device status update: devauth down"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			now := time.Now()
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			attrs := model.DeviceAuthAttributes{"sn": "1234"}
+
+			var put *model.DeviceAuth
+			db := &mstore.DataStore{}
+			if tc.tags != nil {
+				db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+					Return(&model.DeviceAuth{ID: "foo", Tags: tc.tags}, nil)
+			} else {
+				db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+					Return(nil, store.ErrNotFound)
+			}
+			db.On("GetBlocklist", ctx).
+				Return([]model.BlocklistEntry{}, nil)
+			db.On("FindAllowlistEntries", ctx, attrs, model.AuthID("foo")).
+				Return(tc.entries, tc.entriesErr)
+			for id, err := range tc.useErrs {
+				db.On("UseAllowlistEntry", ctx, id, model.AuthID("foo"), now).
+					Return(err)
+			}
+			if tc.outStatus == model.DevStatusAccepted {
+				db.On("GetQuota", ctx).
+					Return(&model.Quota{}, nil)
+			}
+			if tc.outStatus != "" {
+				db.On("PutDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth")).
+					Run(func(args mock.Arguments) {
+						put = args.Get(1).(*model.DeviceAuth)
+					}).
+					Return(nil)
+			}
+
+			target := &fakeTarget{name: "devauth", err: tc.targetErr}
+			d := &DevAdm{
+				db: db,
+				clientGetter: func() client.HttpRunner {
+					return FakeApiRequester{http.StatusNoContent}
+				},
+				clock: clock,
+			}
+			d.WithPropagationTarget(target, FailurePolicyRequired)
+
+			err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+				ID:         "foo",
+				DeviceId:   "bar",
+				Key:        "foo-key",
+				Attributes: attrs,
+				Status:     model.DevStatusPending,
+			})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.outStatus != "" {
+				assert.Equal(t, tc.outStatus, put.Status)
+			}
+			if tc.outStatus == model.DevStatusAccepted {
+				assert.Equal(t, []string{"status_changed:accepted"}, target.calls)
+			} else {
+				assert.Empty(t, target.calls)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDevAdmImportAllowlist(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(now)
+
+	db := &mstore.DataStore{}
+	db.On("InsertAllowlistEntries", ctx, []model.AllowlistEntry{
+		{
+			Batch:      "batch1",
+			Attributes: model.DeviceAuthAttributes{"sn": "0001"},
+			Tags:       []string{"lab"},
+			SingleUse:  true,
+			Created:    &now,
+		},
+		{
+			Batch:      "batch1",
+			Attributes: model.DeviceAuthAttributes{"sn": "0002"},
+			Tags:       []string{"lab"},
+			SingleUse:  true,
+			Created:    &now,
+		},
+	}).Return(nil).Once()
+	db.On("InsertAllowlistEntries", ctx,
+		mock.AnythingOfType("[]model.AllowlistEntry")).
+		Return(errors.New("db connection failed"))
+	db.On("DeleteAllowlistEntry", ctx, "1").
+		Return(store.ErrNotFound)
+
+	d := &DevAdm{db: db, clock: clock}
+
+	attrs := []model.DeviceAuthAttributes{
+		{"sn": "0001"},
+		{"sn": "0002"},
+	}
+	entries, err := d.ImportAllowlist(ctx, "batch1", true, []string{"lab"}, attrs)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	_, err = d.ImportAllowlist(ctx, "batch1", true, nil, attrs)
+	assert.EqualError(t, err, "failed to import allowlist: db connection failed")
+
+	assert.Equal(t, ErrAllowlistEntryNotFound, d.DeleteAllowlistEntry(ctx, "1"))
+	db.AssertExpectations(t)
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+var (
+	ErrApprovalsPending = errors.New("approval recorded, more approvals are required")
+	ErrNoApprover       = errors.New("approver identity is unknown")
+	ErrApprovalNotFound = errors.New("approval not found")
+	ErrApprovalNotOwned = errors.New("approval can be withdrawn only by its approver")
+)
+
+func (d *DevAdm) GetSettings(ctx context.Context) (*model.TenantSettings, error) {
+	settings, err := d.db.GetSettings(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch settings")
+	}
+	return settings, nil
+}
+
+// UpdateSettings replaces the tenant's settings; lowering the number of
+// required approvals takes as many approvals as are currently required,
+// the calling user's approval is recorded and ErrApprovalsPending is
+// returned until enough users requested the very same settings
+func (d *DevAdm) UpdateSettings(ctx context.Context, settings model.TenantSettings) error {
+	current, err := d.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	var approvalID model.AuthID
+	if current.RequiredApprovals > 1 &&
+		settings.RequiredApprovals < current.RequiredApprovals {
+		approvalID, err = settingsApprovalID(&settings)
+		if err != nil {
+			return err
+		}
+		err = d.approve(ctx, approvalID, current.RequiredApprovals)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.db.PutSettings(ctx, &settings)
+	if err != nil {
+		return errors.Wrap(err, "failed to update settings")
+	}
+
+	if approvalID != "" {
+		d.clearApprovals(ctx, approvalID)
+	}
+	return nil
+}
+
+// settingsApprovalID returns the ID approvals of a change to 'settings'
+// are recorded under, in place of an auth set ID
+func settingsApprovalID(settings *model.TenantSettings) (model.AuthID, error) {
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode settings")
+	}
+	sum := sha256.Sum256(b)
+	return model.AuthID("settings-" + hex.EncodeToString(sum[:])), nil
+}
+
+func (d *DevAdm) ListApprovals(ctx context.Context, id model.AuthID) ([]model.Approval, error) {
+	approvals, err := d.db.GetApprovals(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch approvals")
+	}
+	return approvals, nil
+}
+
+// WithdrawApproval removes approval 'id', which must have been given by
+// the calling user
+func (d *DevAdm) WithdrawApproval(ctx context.Context, id string) error {
+	approval, err := d.db.GetApproval(ctx, id)
+	switch err {
+	case nil:
+		break
+	case store.ErrNotFound:
+		return ErrApprovalNotFound
+	default:
+		return errors.Wrap(err, "failed to fetch approval")
+	}
+
+	idty := identity.FromContext(ctx)
+	if idty == nil || idty.Subject != approval.Approver {
+		return ErrApprovalNotOwned
+	}
+
+	err = d.db.DeleteApproval(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrApprovalNotFound
+	default:
+		return errors.Wrap(err, "failed to delete approval")
+	}
+}
+
+// approveDeviceAuth records the calling user's approval of auth set 'dev',
+// returns ErrApprovalsPending until 'required' distinct users approved it
+func (d *DevAdm) approveDeviceAuth(ctx context.Context, dev *model.DeviceAuth, required int) error {
+	// nothing to approve, the decision was already made; a suspended
+	// auth set was approved before and is resumed without a new round
+	if dev.Status == model.DevStatusAccepted ||
+		dev.Status == model.DevStatusSuspended {
+		return nil
+	}
+
+	return d.approve(ctx, dev.ID, required)
+}
+
+// approve records the calling user's approval of 'id', returns
+// ErrApprovalsPending until 'required' distinct users approved it
+func (d *DevAdm) approve(ctx context.Context, id model.AuthID, required int) error {
+	idty := identity.FromContext(ctx)
+	if idty == nil || idty.Subject == "" {
+		return ErrNoApprover
+	}
+
+	now := d.clock.Now()
+	err := d.db.InsertApproval(ctx, &model.Approval{
+		AuthID:   id,
+		Approver: idty.Subject,
+		Created:  &now,
+	})
+	// approving again does not count, but is not an error either
+	if err != nil && err != store.ErrDuplicate {
+		return errors.Wrap(err, "failed to record approval")
+	}
+
+	approvals, err := d.db.GetApprovals(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch approvals")
+	}
+
+	if len(approvals) < required {
+		return ErrApprovalsPending
+	}
+	return nil
+}
+
+// clearApprovals drops approvals of auth set, or settings change, 'id'
+// after a decision was made, so that the next one requires a fresh set of
+// approvals
+func (d *DevAdm) clearApprovals(ctx context.Context, id model.AuthID) {
+	err := d.db.DeleteApprovalsByAuthSet(ctx, id)
+	if err != nil {
+		log.FromContext(ctx).Errorf("failed to clear approvals of %v: %v",
+			id, err)
+	}
+}
@@ -0,0 +1,390 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmAcceptDeviceApprovals(t *testing.T) {
+	testCases := map[string]struct {
+		subject string
+
+		settings    *model.TenantSettings
+		settingsErr error
+
+		status string
+
+		insertErr error
+		approvals []model.Approval
+
+		accepted bool
+		outError error
+	}{
+		"single approval": {
+			subject:  "alice",
+			settings: &model.TenantSettings{RequiredApprovals: 1},
+			status:   model.DevStatusPending,
+			accepted: true,
+		},
+		"first approval": {
+			subject:  "alice",
+			settings: &model.TenantSettings{RequiredApprovals: 2},
+			status:   model.DevStatusPending,
+			approvals: []model.Approval{
+				{AuthID: "foo", Approver: "alice"},
+			},
+			outError: ErrApprovalsPending,
+		},
+		"approved again by the same user": {
+			subject:   "alice",
+			settings:  &model.TenantSettings{RequiredApprovals: 2},
+			status:    model.DevStatusPending,
+			insertErr: store.ErrDuplicate,
+			approvals: []model.Approval{
+				{AuthID: "foo", Approver: "alice"},
+			},
+			outError: ErrApprovalsPending,
+		},
+		"last approval": {
+			subject:  "bob",
+			settings: &model.TenantSettings{RequiredApprovals: 2},
+			status:   model.DevStatusRejected,
+			approvals: []model.Approval{
+				{AuthID: "foo", Approver: "alice"},
+				{AuthID: "foo", Approver: "bob"},
+			},
+			accepted: true,
+		},
+		"already accepted": {
+			subject:  "bob",
+			settings: &model.TenantSettings{RequiredApprovals: 2},
+			status:   model.DevStatusAccepted,
+			accepted: true,
+		},
+		"resumed from suspension": {
+			settings: &model.TenantSettings{RequiredApprovals: 2},
+			status:   model.DevStatusSuspended,
+			accepted: true,
+		},
+		"no approver": {
+			settings: &model.TenantSettings{RequiredApprovals: 2},
+			status:   model.DevStatusPending,
+			outError: ErrNoApprover,
+		},
+		"error: settings": {
+			subject:     "alice",
+			settingsErr: errors.New("db connection failed"),
+			outError:    errors.New("failed to fetch settings: db connection failed"),
+		},
+		"error: insert approval": {
+			subject:   "alice",
+			settings:  &model.TenantSettings{RequiredApprovals: 2},
+			status:    model.DevStatusPending,
+			insertErr: errors.New("db connection failed"),
+			outError:  errors.New("failed to record approval: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.subject != "" {
+				ctx = identity.WithContext(ctx, &identity.Identity{
+					Subject: tc.subject,
+				})
+			}
+
+			db := &mstore.DataStore{}
+			db.On("GetSettings", ctx).
+				Return(tc.settings, tc.settingsErr)
+			db.On("GetQuota", ctx).
+				Return(&model.Quota{}, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(func(ctx context.Context, id model.AuthID) *model.DeviceAuth {
+					return &model.DeviceAuth{
+						ID:       id,
+						DeviceId: "bar",
+						Status:   tc.status,
+					}
+				}, nil)
+			db.On("InsertApproval", ctx,
+				mock.MatchedBy(func(a *model.Approval) bool {
+					return a.AuthID == "foo" && a.Approver == tc.subject
+				})).
+				Return(tc.insertErr)
+			db.On("GetApprovals", ctx, model.AuthID("foo")).
+				Return(tc.approvals, nil)
+			db.On("UpdateDeviceAuth", ctx,
+				mock.MatchedBy(func(d *model.DeviceAuth) bool {
+					return d.Status == model.DevStatusAccepted
+				})).
+				Return(nil)
+			db.On("DeleteApprovalsByAuthSet", ctx, model.AuthID("foo")).
+				Return(nil)
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(time.Unix(1500000000, 0))
+
+			d := devadmWithClientForTest(db, http.StatusNoContent).(*DevAdm)
+			d.clock = clock
+
+			err := d.AcceptDeviceAuth(ctx, "foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.accepted {
+				db.AssertCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			} else {
+				db.AssertNotCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			}
+			if tc.accepted && tc.settings.RequiredApprovals > 1 {
+				db.AssertCalled(t, "DeleteApprovalsByAuthSet", ctx, model.AuthID("foo"))
+			} else {
+				db.AssertNotCalled(t, "DeleteApprovalsByAuthSet", ctx, model.AuthID("foo"))
+			}
+		})
+	}
+}
+
+func TestDevAdmUpdateSettings(t *testing.T) {
+	lowered := model.TenantSettings{RequiredApprovals: 1}
+	loweredID, err := settingsApprovalID(&lowered)
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		subject string
+
+		current     *model.TenantSettings
+		currentErr  error
+		settings    model.TenantSettings
+		approvals   []model.Approval
+		approvalErr error
+		putErr      error
+
+		approved bool
+		updated  bool
+		outError string
+	}{
+		"raised": {
+			subject:  "alice",
+			current:  &model.TenantSettings{RequiredApprovals: 2},
+			settings: model.TenantSettings{RequiredApprovals: 3},
+			updated:  true,
+		},
+		"lowered from a single approval": {
+			subject:  "alice",
+			current:  &model.TenantSettings{RequiredApprovals: 1},
+			settings: model.TenantSettings{RequiredApprovals: 0},
+			updated:  true,
+		},
+		"lowered, first approval": {
+			subject:  "alice",
+			current:  &model.TenantSettings{RequiredApprovals: 2},
+			settings: lowered,
+			approvals: []model.Approval{
+				{AuthID: loweredID, Approver: "alice"},
+			},
+			approved: true,
+			outError: ErrApprovalsPending.Error(),
+		},
+		"lowered, last approval": {
+			subject:  "bob",
+			current:  &model.TenantSettings{RequiredApprovals: 2},
+			settings: lowered,
+			approvals: []model.Approval{
+				{AuthID: loweredID, Approver: "alice"},
+				{AuthID: loweredID, Approver: "bob"},
+			},
+			approved: true,
+			updated:  true,
+		},
+		"lowered, no approver": {
+			current:  &model.TenantSettings{RequiredApprovals: 2},
+			settings: lowered,
+			outError: ErrNoApprover.Error(),
+		},
+		"error: current settings": {
+			subject:    "alice",
+			currentErr: errors.New("db connection failed"),
+			settings:   lowered,
+			outError:   "failed to fetch settings: db connection failed",
+		},
+		"error: update": {
+			subject:  "alice",
+			current:  &model.TenantSettings{},
+			settings: lowered,
+			putErr:   errors.New("db connection failed"),
+			updated:  true,
+			outError: "failed to update settings: db connection failed",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.subject != "" {
+				ctx = identity.WithContext(ctx,
+					&identity.Identity{Subject: tc.subject})
+			}
+
+			db := &mstore.DataStore{}
+			db.On("GetSettings", ctx).
+				Return(tc.current, tc.currentErr)
+			db.On("InsertApproval", ctx,
+				mock.MatchedBy(func(a *model.Approval) bool {
+					return a.AuthID == loweredID && a.Approver == tc.subject
+				})).
+				Return(nil)
+			db.On("GetApprovals", ctx, loweredID).
+				Return(tc.approvals, nil)
+			db.On("PutSettings", ctx, &tc.settings).
+				Return(tc.putErr)
+			db.On("DeleteApprovalsByAuthSet", ctx, loweredID).
+				Return(nil)
+
+			d := devadmForTest(db)
+
+			err := d.UpdateSettings(ctx, tc.settings)
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.approved {
+				db.AssertCalled(t, "InsertApproval", ctx, mock.Anything)
+			} else {
+				db.AssertNotCalled(t, "InsertApproval", ctx, mock.Anything)
+			}
+			if tc.updated {
+				db.AssertCalled(t, "PutSettings", ctx, &tc.settings)
+			} else {
+				db.AssertNotCalled(t, "PutSettings", ctx, mock.Anything)
+			}
+			if tc.approved && tc.updated {
+				db.AssertCalled(t, "DeleteApprovalsByAuthSet", ctx, loweredID)
+			} else {
+				db.AssertNotCalled(t, "DeleteApprovalsByAuthSet", ctx, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDevAdmRejectDeviceClearsApprovals(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetSettings", ctx).
+		Return(&model.TenantSettings{RequiredApprovals: 2}, nil)
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{ID: "foo", Status: model.DevStatusPending}, nil)
+	db.On("UpdateDeviceAuth", ctx,
+		mock.AnythingOfType("*model.DeviceAuth")).
+		Return(nil)
+	db.On("DeleteApprovalsByAuthSet", ctx, model.AuthID("foo")).
+		Return(errors.New("db connection failed"))
+
+	d := devadmWithClientForTest(db, http.StatusNoContent)
+
+	// failure to clear approvals does not fail the rejection
+	err := d.RejectDeviceAuth(ctx, "foo")
+	assert.NoError(t, err)
+
+	db.AssertExpectations(t)
+}
+
+func TestDevAdmWithdrawApproval(t *testing.T) {
+	testCases := map[string]struct {
+		subject string
+
+		approval    *model.Approval
+		approvalErr error
+		deleteErr   error
+
+		outError error
+	}{
+		"ok": {
+			subject:  "alice",
+			approval: &model.Approval{ID: "1", Approver: "alice"},
+		},
+		"not found": {
+			subject:     "alice",
+			approvalErr: store.ErrNotFound,
+			outError:    ErrApprovalNotFound,
+		},
+		"removed concurrently": {
+			subject:   "alice",
+			approval:  &model.Approval{ID: "1", Approver: "alice"},
+			deleteErr: store.ErrNotFound,
+			outError:  ErrApprovalNotFound,
+		},
+		"approval of other user": {
+			subject:  "bob",
+			approval: &model.Approval{ID: "1", Approver: "alice"},
+			outError: ErrApprovalNotOwned,
+		},
+		"no identity": {
+			approval: &model.Approval{ID: "1", Approver: "alice"},
+			outError: ErrApprovalNotOwned,
+		},
+		"error: db": {
+			subject:     "alice",
+			approvalErr: errors.New("db connection failed"),
+			outError:    errors.New("failed to fetch approval: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.subject != "" {
+				ctx = identity.WithContext(ctx, &identity.Identity{
+					Subject: tc.subject,
+				})
+			}
+
+			db := &mstore.DataStore{}
+			db.On("GetApproval", ctx, "1").
+				Return(tc.approval, tc.approvalErr)
+			db.On("DeleteApproval", ctx, "1").
+				Return(tc.deleteErr)
+
+			d := devadmForTest(db)
+
+			err := d.WithdrawApproval(ctx, "1")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
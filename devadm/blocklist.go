@@ -0,0 +1,108 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+var (
+	ErrBlocklistEntryNotFound = errors.New("blocklist entry not found")
+	ErrDeviceBlocked          = errors.New("device is blocklisted")
+)
+
+func (d *DevAdm) CreateBlocklistEntry(ctx context.Context, req model.BlocklistEntryReq) (*model.BlocklistEntry, error) {
+	entry := req.Entry()
+	now := d.clock.Now()
+	entry.Created = &now
+
+	err := d.db.InsertBlocklistEntry(ctx, entry)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create blocklist entry")
+	}
+	return entry, nil
+}
+
+func (d *DevAdm) ListBlocklist(ctx context.Context) ([]model.BlocklistEntry, error) {
+	entries, err := d.db.GetBlocklist(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch blocklist")
+	}
+	return entries, nil
+}
+
+func (d *DevAdm) DeleteBlocklistEntry(ctx context.Context, id string) error {
+	err := d.db.DeleteBlocklistEntry(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrBlocklistEntryNotFound
+	default:
+		return errors.Wrap(err, "failed to delete blocklist entry")
+	}
+}
+
+func (d *DevAdm) ListBlocklistHits(ctx context.Context, skip, limit int) ([]model.BlocklistHit, error) {
+	hits, err := d.db.GetBlocklistHits(ctx, skip, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch blocklist hits")
+	}
+	return hits, nil
+}
+
+// matchBlocklist returns the blocklist entry matching auth set 'dev', or
+// nil if the auth set is not blocked
+func (d *DevAdm) matchBlocklist(ctx context.Context, dev *model.DeviceAuth) (*model.BlocklistEntry, error) {
+	entries, err := d.db.GetBlocklist(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch blocklist")
+	}
+
+	for i := range entries {
+		if entries[i].Matches(dev) {
+			return &entries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// recordBlocklistHit notes that auth set 'dev' was rejected because of
+// 'entry'; failures are only logged
+func (d *DevAdm) recordBlocklistHit(ctx context.Context, entry *model.BlocklistEntry, dev *model.DeviceAuth) {
+	l := log.FromContext(ctx)
+	l.Infof("auth set %v with identity %s matches blocklist entry %s, rejecting",
+		dev.ID, dev.DeviceIdentity, entry.ID)
+
+	now := d.clock.Now()
+	err := d.db.InsertBlocklistHit(ctx, &model.BlocklistHit{
+		EntryID:        entry.ID,
+		AuthID:         dev.ID,
+		DeviceId:       dev.DeviceId,
+		DeviceIdentity: dev.DeviceIdentity,
+		Attributes:     dev.Attributes,
+		KeyFingerprint: model.KeyFingerprint(dev.Key),
+		Created:        &now,
+	})
+	if err != nil {
+		l.Errorf("failed to record blocklist hit of auth set %v with identity %s: %v",
+			dev.ID, dev.DeviceIdentity, err)
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmSubmitDeviceBlocklist(t *testing.T) {
+	testCases := map[string]struct {
+		stored       *model.DeviceAuth
+		tags         []string
+		blocklist    []model.BlocklistEntry
+		blocklistErr error
+		targetErr    error
+
+		outStatus string
+		outEntry  string
+		outError  error
+	}{
+		"not blocked": {
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", Attributes: map[string]string{"mac": "00:11"}},
+			},
+			outStatus: model.DevStatusPending,
+		},
+		"blocked by attributes": {
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", Attributes: map[string]string{"mac": "00:11"}},
+				{ID: "2", Attributes: map[string]string{"sn": "1234"}},
+			},
+			outStatus: model.DevStatusRejected,
+			outEntry:  "2",
+		},
+		"blocked by key": {
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", KeyFingerprint: model.KeyFingerprint("foo-key")},
+			},
+			outStatus: model.DevStatusRejected,
+			outEntry:  "1",
+		},
+		"blocked by tags": {
+			stored: &model.DeviceAuth{ID: "foo", Tags: []string{"lab", "rma"}},
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", Tags: []string{"rma"}},
+			},
+			outStatus: model.DevStatusRejected,
+			outEntry:  "1",
+		},
+		"not blocked, tags missing": {
+			stored: &model.DeviceAuth{ID: "foo", Tags: []string{"lab"}},
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", Attributes: map[string]string{"sn": "1234"},
+					Tags: []string{"rma"}},
+			},
+			outStatus: model.DevStatusPending,
+		},
+		"not blocked, submitted tags ignored": {
+			tags: []string{"rma"},
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", Tags: []string{"rma"}},
+			},
+			outStatus: model.DevStatusPending,
+		},
+		"blocked, propagation failed": {
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", KeyFingerprint: model.KeyFingerprint("foo-key")},
+			},
+			targetErr: errors.New("devauth down"),
+			outStatus: model.DevStatusRejected,
+			outEntry:  "1",
+			outError:  errors.New("failed to propagate device status update: devauth down"),
+		},
+		"blocklist error": {
+			blocklistErr: errors.New("db connection failed"),
+			outError:     errors.New("failed to fetch blocklist: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(time.Now())
+
+			var put *model.DeviceAuth
+			db := &mstore.DataStore{}
+			if tc.stored != nil {
+				db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+					Return(tc.stored, nil)
+			} else {
+				db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+					Return(nil, store.ErrNotFound)
+			}
+			db.On("GetBlocklist", ctx).
+				Return(tc.blocklist, tc.blocklistErr)
+			if tc.blocklistErr == nil {
+				db.On("PutDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth")).
+					Run(func(args mock.Arguments) {
+						put = args.Get(1).(*model.DeviceAuth)
+					}).
+					Return(nil)
+			}
+			if tc.blocklistErr == nil && tc.outEntry == "" {
+				db.On("FindAllowlistEntries", ctx,
+					model.DeviceAuthAttributes{"sn": "1234"},
+					model.AuthID("foo")).
+					Return([]model.AllowlistEntry{}, nil)
+			}
+			if tc.outEntry != "" {
+				db.On("InsertBlocklistHit", ctx,
+					mock.MatchedBy(func(h *model.BlocklistHit) bool {
+						return h.EntryID == tc.outEntry &&
+							h.AuthID == "foo" &&
+							h.KeyFingerprint == model.KeyFingerprint("foo-key")
+					})).
+					Return(nil)
+			}
+
+			target := &fakeTarget{name: "devauth", err: tc.targetErr}
+			d := &DevAdm{
+				db: db,
+				clientGetter: func() client.HttpRunner {
+					return FakeApiRequester{http.StatusNoContent}
+				},
+				clock: clock,
+			}
+			d.WithPropagationTarget(target, FailurePolicyRequired)
+
+			err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+				ID:         "foo",
+				DeviceId:   "bar",
+				Key:        "foo-key",
+				Attributes: map[string]string{"sn": "1234"},
+				Status:     model.DevStatusPending,
+				Tags:       tc.tags,
+			})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.outStatus != "" {
+				assert.Equal(t, tc.outStatus, put.Status)
+			}
+			if tc.outEntry != "" {
+				assert.Equal(t, []string{"status_changed:rejected"}, target.calls)
+			} else {
+				assert.Empty(t, target.calls)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDevAdmPreauthorizeDeviceBlocklist(t *testing.T) {
+	ctx := context.Background()
+
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(time.Now())
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuthsByIdentityData", ctx, "foo-id").
+		Return([]model.DeviceAuth{}, nil)
+	db.On("GetBlocklist", ctx).
+		Return([]model.BlocklistEntry{
+			{ID: "1", Attributes: map[string]string{"foo": "bar"}},
+		}, nil)
+	db.On("InsertBlocklistHit", ctx,
+		mock.MatchedBy(func(h *model.BlocklistHit) bool {
+			return h.EntryID == "1" && h.DeviceId == "" &&
+				h.DeviceIdentity == "foo-id" &&
+				h.KeyFingerprint == model.KeyFingerprint("foo-key")
+		})).
+		Return(errors.New("db connection failed"))
+
+	target := &fakeTarget{name: "devauth"}
+	d := &DevAdm{
+		db: db,
+		clientGetter: func() client.HttpRunner {
+			t.Fatal("devauth must not be called")
+			return nil
+		},
+		clock: clock,
+	}
+	d.WithPropagationTarget(target, FailurePolicyRequired)
+
+	// the device is refused before it's stored or propagated; failing to
+	// record the hit does not change that
+	err := d.PreauthorizeDevice(ctx, model.AuthSet{
+		DeviceId:   "foo-id",
+		Key:        "foo-key",
+		Attributes: map[string]string{"foo": "bar", "sn": "1234"},
+	}, "Bearer foo")
+	assert.Equal(t, ErrDeviceBlocked, err)
+	assert.Empty(t, target.calls)
+	db.AssertExpectations(t)
+	db.AssertNotCalled(t, "InsertDeviceAuth", ctx, mock.Anything)
+}
+
+func TestDevAdmBlocklist(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(now)
+
+	db := &mstore.DataStore{}
+	db.On("InsertBlocklistEntry", ctx,
+		&model.BlocklistEntry{
+			KeyFingerprint: model.KeyFingerprint("foo-key"),
+			Reason:         "stolen",
+			Created:        &now,
+		}).
+		Return(nil)
+	db.On("DeleteBlocklistEntry", ctx, "1").
+		Return(nil)
+	db.On("DeleteBlocklistEntry", ctx, "2").
+		Return(store.ErrNotFound)
+	db.On("DeleteBlocklistEntry", ctx, "3").
+		Return(errors.New("db connection failed"))
+
+	d := &DevAdm{db: db, clock: clock}
+
+	entry, err := d.CreateBlocklistEntry(ctx, model.BlocklistEntryReq{
+		Key:    "foo-key",
+		Reason: "stolen",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, model.KeyFingerprint("foo-key"), entry.KeyFingerprint)
+
+	assert.NoError(t, d.DeleteBlocklistEntry(ctx, "1"))
+	assert.Equal(t, ErrBlocklistEntryNotFound, d.DeleteBlocklistEntry(ctx, "2"))
+	assert.EqualError(t, d.DeleteBlocklistEntry(ctx, "3"),
+		"failed to delete blocklist entry: db connection failed")
+	db.AssertExpectations(t)
+}
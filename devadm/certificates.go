@@ -0,0 +1,91 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+var (
+	ErrTrustedCANotFound = errors.New("trusted CA not found")
+	ErrTrustedCAExists   = errors.New("CA certificate is already trusted")
+)
+
+func (d *DevAdm) AddTrustedCA(ctx context.Context, req model.TrustedCAReq) (*model.TrustedCA, error) {
+	ca := req.TrustedCA()
+	now := d.clock.Now()
+	ca.Created = &now
+
+	err := d.db.InsertTrustedCA(ctx, ca)
+	switch err {
+	case nil:
+		return ca, nil
+	case store.ErrDuplicate:
+		return nil, ErrTrustedCAExists
+	default:
+		return nil, errors.Wrap(err, "failed to add trusted CA")
+	}
+}
+
+func (d *DevAdm) ListTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	cas, err := d.db.GetTrustedCAs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch trusted CAs")
+	}
+	return cas, nil
+}
+
+func (d *DevAdm) DeleteTrustedCA(ctx context.Context, id string) error {
+	err := d.db.DeleteTrustedCA(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrTrustedCANotFound
+	default:
+		return errors.Wrap(err, "failed to delete trusted CA")
+	}
+}
+
+// verifyCertificate checks device certificate 'cert' presented with auth
+// set 'dev' against tenant's trusted CAs; returns nil if the certificate
+// is not valid
+func (d *DevAdm) verifyCertificate(ctx context.Context, dev *model.DeviceAuth, cert string) (*model.CertificateInfo, error) {
+	l := log.FromContext(ctx)
+
+	cas, err := d.db.GetTrustedCAs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch trusted CAs")
+	}
+	if len(cas) == 0 {
+		l.Warnf("auth set %v presented a certificate, but no CA is trusted",
+			dev.ID)
+		return nil, nil
+	}
+
+	info, err := model.VerifyDeviceCertificate(cert, dev.Key, cas,
+		d.clock.Now())
+	if err != nil {
+		l.Warnf("auth set %v presented an invalid certificate: %v",
+			dev.ID, err)
+		return nil, nil
+	}
+	return info, nil
+}
@@ -0,0 +1,328 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+// testCert is a locally generated certificate together with its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  string
+}
+
+// makeTestCert generates a certificate valid until 'notAfter', signed by
+// 'issuer' or self-signed if 'issuer' is nil
+func makeTestCert(t *testing.T, name string, serial int64, isCA bool,
+	notAfter time.Time, issuer *testCert) *testCert {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notAfter.Add(-24 * time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+	}
+	if isCA {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	parent, signer := tmpl, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent,
+		&key.PublicKey, signer)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: der,
+		})),
+	}
+}
+
+// publicKey returns PEM encoded public key of the certificate
+func (c *testCert) publicKey(t *testing.T) string {
+	der, err := x509.MarshalPKIXPublicKey(&c.key.PublicKey)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	}))
+}
+
+func TestDevAdmSubmitDeviceCertificate(t *testing.T) {
+	now := time.Now()
+	valid := now.Add(time.Hour)
+
+	root := makeTestCert(t, "root", 1, true, valid.Add(time.Hour), nil)
+	intermediate := makeTestCert(t, "intermediate", 2, true, valid, root)
+	other := makeTestCert(t, "other", 3, true, valid, nil)
+
+	device := makeTestCert(t, "device-1", 100, false, valid, root)
+	deviceViaInt := makeTestCert(t, "device-2", 101, false, valid, intermediate)
+	expired := makeTestCert(t, "device-3", 102, false, now.Add(-time.Minute), root)
+	untrusted := makeTestCert(t, "device-4", 103, false, valid, other)
+
+	trusted := []model.TrustedCA{
+		{ID: "ca-1", Certificate: root.pem},
+	}
+
+	testCases := map[string]struct {
+		cert   string
+		key    string
+		cas    []model.TrustedCA
+		casErr error
+
+		outStatus string
+		outInfo   *model.CertificateInfo
+		outError  error
+	}{
+		"ok": {
+			cert:      device.pem,
+			key:       device.publicKey(t),
+			cas:       trusted,
+			outStatus: model.DevStatusAccepted,
+			outInfo: &model.CertificateInfo{
+				Subject:   "CN=device-1",
+				Serial:    "100",
+				ExpiresAt: device.cert.NotAfter,
+				CAID:      "ca-1",
+			},
+		},
+		"ok, intermediate CA": {
+			cert:      deviceViaInt.pem + intermediate.pem,
+			key:       deviceViaInt.publicKey(t),
+			cas:       trusted,
+			outStatus: model.DevStatusAccepted,
+			outInfo: &model.CertificateInfo{
+				Subject:   "CN=device-2",
+				Serial:    "101",
+				ExpiresAt: deviceViaInt.cert.NotAfter,
+				CAID:      "ca-1",
+			},
+		},
+		"intermediate CA missing": {
+			cert:      deviceViaInt.pem,
+			key:       deviceViaInt.publicKey(t),
+			cas:       trusted,
+			outStatus: model.DevStatusPending,
+		},
+		"expired": {
+			cert:      expired.pem,
+			key:       expired.publicKey(t),
+			cas:       trusted,
+			outStatus: model.DevStatusPending,
+		},
+		"untrusted CA": {
+			cert:      untrusted.pem,
+			key:       untrusted.publicKey(t),
+			cas:       trusted,
+			outStatus: model.DevStatusPending,
+		},
+		"key mismatch": {
+			cert:      device.pem,
+			key:       untrusted.publicKey(t),
+			cas:       trusted,
+			outStatus: model.DevStatusPending,
+		},
+		"garbled certificate": {
+			cert:      "foo",
+			key:       device.publicKey(t),
+			cas:       trusted,
+			outStatus: model.DevStatusPending,
+		},
+		"no trusted CAs": {
+			cert:      device.pem,
+			key:       device.publicKey(t),
+			cas:       []model.TrustedCA{},
+			outStatus: model.DevStatusPending,
+		},
+		"error": {
+			cert:     device.pem,
+			key:      device.publicKey(t),
+			casErr:   errors.New("db connection failed"),
+			outError: errors.New("failed to fetch trusted CAs: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			var put model.DeviceAuth
+			db := &mstore.DataStore{}
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(nil, store.ErrNotFound)
+			db.On("GetBlocklist", ctx).
+				Return([]model.BlocklistEntry{}, nil)
+			db.On("GetTrustedCAs", ctx).
+				Return(tc.cas, tc.casErr)
+			if tc.outStatus == model.DevStatusPending {
+				db.On("FindAllowlistEntries", ctx,
+					mock.AnythingOfType("model.DeviceAuthAttributes"),
+					model.AuthID("foo")).
+					Return([]model.AllowlistEntry{}, nil)
+			}
+			if tc.outStatus == model.DevStatusAccepted {
+				db.On("GetQuota", ctx).
+					Return(&model.Quota{}, nil)
+			}
+			if tc.outStatus != "" {
+				db.On("PutDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth")).
+					Run(func(args mock.Arguments) {
+						put = *args.Get(1).(*model.DeviceAuth)
+					}).
+					Return(nil)
+			}
+
+			target := &fakeTarget{name: "inventory"}
+			d := &DevAdm{
+				db: db,
+				clientGetter: func() client.HttpRunner {
+					return FakeApiRequester{http.StatusNoContent}
+				},
+				clock: clock,
+			}
+			d.WithPropagationTarget(target, FailurePolicyRequired)
+
+			err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+				ID:          "foo",
+				DeviceId:    "bar",
+				Key:         tc.key,
+				Attributes:  model.DeviceAuthAttributes{"sn": "1234"},
+				Status:      model.DevStatusPending,
+				Certificate: tc.cert,
+			})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outStatus, put.Status)
+				assert.Equal(t, tc.outInfo, put.CertificateInfo)
+				assert.Empty(t, put.Certificate)
+			}
+
+			if tc.outStatus == model.DevStatusAccepted {
+				assert.Equal(t, []string{"status_changed:accepted"}, target.calls)
+			} else {
+				assert.Empty(t, target.calls)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDevAdmAddTrustedCA(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(now)
+
+	ca := makeTestCert(t, "root", 1, true, now.Add(time.Hour), nil)
+	device := makeTestCert(t, "device", 2, false, now.Add(time.Hour), ca)
+
+	req := model.TrustedCAReq{Certificate: device.pem}
+	assert.EqualError(t, req.Validate(), "not a CA certificate")
+	req = model.TrustedCAReq{Certificate: ca.pem + ca.pem}
+	assert.EqualError(t, req.Validate(), "exactly one certificate must be provided")
+
+	req = model.TrustedCAReq{Certificate: ca.pem, Description: "factory"}
+	assert.NoError(t, req.Validate())
+
+	var inserted *model.TrustedCA
+	db := &mstore.DataStore{}
+	db.On("InsertTrustedCA", ctx,
+		mock.AnythingOfType("*model.TrustedCA")).
+		Run(func(args mock.Arguments) {
+			inserted = args.Get(1).(*model.TrustedCA)
+		}).
+		Return(nil).Once()
+	db.On("InsertTrustedCA", ctx,
+		mock.AnythingOfType("*model.TrustedCA")).
+		Return(store.ErrDuplicate).Once()
+
+	d := &DevAdm{db: db, clock: clock}
+
+	out, err := d.AddTrustedCA(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, inserted, out)
+	assert.Equal(t, "CN=root", out.Subject)
+	assert.Equal(t, "factory", out.Description)
+	assert.Equal(t, ca.cert.NotAfter, out.ExpiresAt)
+	assert.Len(t, out.Fingerprint, 64)
+	assert.Equal(t, &now, out.Created)
+
+	_, err = d.AddTrustedCA(ctx, req)
+	assert.Equal(t, ErrTrustedCAExists, err)
+	db.AssertExpectations(t)
+}
+
+func TestDevAdmDeleteTrustedCA(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("DeleteTrustedCA", ctx, "1").
+		Return(nil)
+	db.On("DeleteTrustedCA", ctx, "2").
+		Return(store.ErrNotFound)
+	db.On("DeleteTrustedCA", ctx, "3").
+		Return(errors.New("db connection failed"))
+
+	d := &DevAdm{db: db}
+
+	assert.NoError(t, d.DeleteTrustedCA(ctx, "1"))
+	assert.Equal(t, ErrTrustedCANotFound, d.DeleteTrustedCA(ctx, "2"))
+	assert.EqualError(t, d.DeleteTrustedCA(ctx, "3"),
+		"failed to delete trusted CA: db connection failed")
+}
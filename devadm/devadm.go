@@ -17,6 +17,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/pkg/errors"
 
 	"github.com/mendersoftware/deviceadm/client"
@@ -43,10 +44,13 @@ func simpleApiClientGetter() client.HttpRunner {
 // this device admission service interface
 type App interface {
 	ListDeviceAuths(ctx context.Context, skip int, limit int, filter store.Filter) ([]model.DeviceAuth, error)
+	CountDeviceAuths(ctx context.Context, filter store.Filter) (int, error)
 	SubmitDeviceAuth(ctx context.Context, d model.DeviceAuth) error
 	GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error)
 	AcceptDeviceAuth(ctx context.Context, id model.AuthID) error
 	RejectDeviceAuth(ctx context.Context, id model.AuthID) error
+	SuspendDeviceAuth(ctx context.Context, id model.AuthID) error
+	NextStatuses(ctx context.Context, dev *model.DeviceAuth) ([]string, error)
 	DeleteDeviceAuth(ctx context.Context, id model.AuthID) error
 	DeleteDeviceAuthPropagate(ctx context.Context, id model.AuthID, authorizationHeader string) error
 	AcceptDevicePreAuth(ctx context.Context, id model.AuthID) error
@@ -56,24 +60,113 @@ type App interface {
 	ProvisionTenant(ctx context.Context, tenant_id string) error
 
 	PreauthorizeDevice(ctx context.Context, authSet model.AuthSet, authorizationHeader string) error
+
+	CreateWebhook(ctx context.Context, req model.WebhookReq) (*model.Webhook, error)
+	ListWebhooks(ctx context.Context) ([]model.Webhook, error)
+	GetWebhook(ctx context.Context, id string) (*model.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	ListWebhookDeliveries(ctx context.Context, id string, skip, limit int) ([]model.WebhookDelivery, error)
+
+	WatchEvents(ctx context.Context, lastID int64, fn func(ev *model.Event) error) error
+
+	GetSettings(ctx context.Context) (*model.TenantSettings, error)
+	UpdateSettings(ctx context.Context, settings model.TenantSettings) error
+	ListApprovals(ctx context.Context, id model.AuthID) ([]model.Approval, error)
+	WithdrawApproval(ctx context.Context, id string) error
+
+	CreateBlocklistEntry(ctx context.Context, req model.BlocklistEntryReq) (*model.BlocklistEntry, error)
+	ListBlocklist(ctx context.Context) ([]model.BlocklistEntry, error)
+	DeleteBlocklistEntry(ctx context.Context, id string) error
+	ListBlocklistHits(ctx context.Context, skip, limit int) ([]model.BlocklistHit, error)
+
+	ImportAllowlist(ctx context.Context, batch string, singleUse bool, tags []string, attrs []model.DeviceAuthAttributes) ([]model.AllowlistEntry, error)
+	ListAllowlist(ctx context.Context, batch string, skip, limit int) ([]model.AllowlistEntry, error)
+	DeleteAllowlistEntry(ctx context.Context, id string) error
+
+	PreauthorizeDevices(ctx context.Context, rows []model.AuthSetRow, authorizationHeader string) (*model.PreauthReport, error)
+
+	CreateEnrollmentToken(ctx context.Context, req model.EnrollmentTokenReq) (*model.EnrollmentToken, error)
+	ListEnrollmentTokens(ctx context.Context) ([]model.EnrollmentToken, error)
+	RevokeEnrollmentToken(ctx context.Context, id string) error
+
+	AddTrustedCA(ctx context.Context, req model.TrustedCAReq) (*model.TrustedCA, error)
+	ListTrustedCAs(ctx context.Context) ([]model.TrustedCA, error)
+	DeleteTrustedCA(ctx context.Context, id string) error
+
+	AddManifestKey(ctx context.Context, req model.ManifestKeyReq) (*model.ManifestKey, error)
+	ListManifestKeys(ctx context.Context) ([]model.ManifestKey, error)
+	DeleteManifestKey(ctx context.Context, id string) error
+	PreauthorizeManifest(ctx context.Context, m *model.SignedManifest, authorizationHeader string) (*model.PreauthReport, error)
+
+	SetQuota(ctx context.Context, tenantID string, quota model.Quota) error
+	GetQuotaUsage(ctx context.Context) (*model.QuotaUsage, error)
+
+	GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error)
+	SetIdentitySchema(ctx context.Context, schema model.IdentitySchema) error
+	DeleteIdentitySchema(ctx context.Context) error
+
+	TagDeviceAuth(ctx context.Context, id model.AuthID, tags []string) error
+	UntagDeviceAuth(ctx context.Context, id model.AuthID, tag string) error
+	UpdateTags(ctx context.Context, req model.TagsReq) (*model.TagsResult, error)
+
+	ListDeletedDeviceAuths(ctx context.Context, skip, limit int) ([]model.DeviceAuth, error)
+	RestoreDeviceAuth(ctx context.Context, id model.AuthID, authorizationHeader string) (*model.DeviceAuth, error)
+
+	AddNote(ctx context.Context, id model.AuthID, req model.NoteReq) (*model.Note, error)
+	ListNotes(ctx context.Context, id model.AuthID) ([]model.Note, error)
+	GetDeviceAuthDetails(ctx context.Context, id model.AuthID) (*model.DeviceAuthDetails, error)
+
+	PatchDeviceAuth(ctx context.Context, id model.AuthID, patch model.DeviceAuthPatch) (*model.DeviceAuth, error)
+
+	BeginIdempotentRequest(ctx context.Context, key, hash string) (*model.IdempotentRequest, error)
+	KeepIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) func()
+	EndIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error
 }
 
 var AuthSetConflictError = errors.New("device already exists")
 
-func NewDevAdm(d store.DataStore, authclientconf deviceauth.Config, clock clock.Clock) App {
+func NewDevAdm(d store.DataStore, authclientconf deviceauth.Config, clock clock.Clock) *DevAdm {
 	return &DevAdm{
 		db:             d,
 		authclientconf: authclientconf,
-		clientGetter:   simpleApiClientGetter,
-		clock:          clock,
+		authbreaker: deviceauth.NewCircuitBreaker(
+			authclientconf.BreakerThreshold,
+			authclientconf.BreakerCooldown,
+			clock),
+		clientGetter: simpleApiClientGetter,
+		clock:        clock,
 	}
 }
 
 type DevAdm struct {
 	db             store.DataStore
 	authclientconf deviceauth.Config
+	authbreaker    *deviceauth.CircuitBreaker
 	clientGetter   ApiClientGetter
+	hooks          []propagationHook
+	webhooks       *webhookDispatcher
+	eventlog       *eventLog
+	ratelimits     *RateLimitConfig
 	clock          clock.Clock
+
+	batchPreauthConcurrency int
+	idempotencyTTL          time.Duration
+	idempotencyLease        time.Duration
+}
+
+// WithHttpClient makes the app use client 'c' for requests to other
+// services
+func (d *DevAdm) WithHttpClient(c client.HttpRunner) *DevAdm {
+	d.clientGetter = func() client.HttpRunner {
+		return c
+	}
+	return d
+}
+
+// authClient returns a devauth client sharing the app's circuit breaker
+func (d *DevAdm) authClient() *deviceauth.Client {
+	return deviceauth.NewClient(d.authclientconf, d.clientGetter()).
+		WithCircuitBreaker(d.authbreaker)
 }
 
 func (d *DevAdm) ListDeviceAuths(ctx context.Context, skip int, limit int, filter store.Filter) ([]model.DeviceAuth, error) {
@@ -85,17 +178,175 @@ func (d *DevAdm) ListDeviceAuths(ctx context.Context, skip int, limit int, filte
 	return devs, nil
 }
 
+// CountDeviceAuths returns the number of auth sets matching 'filter',
+// regardless of filter.After
+func (d *DevAdm) CountDeviceAuths(ctx context.Context, filter store.Filter) (int, error) {
+	n, err := d.db.CountDeviceAuths(ctx, filter)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count devices")
+	}
+
+	return n, nil
+}
+
 func (d *DevAdm) SubmitDeviceAuth(ctx context.Context, dev model.DeviceAuth) error {
+	if err := d.limitSubmission(ctx, &dev); err != nil {
+		return err
+	}
+
 	now := time.Now()
 	dev.RequestTime = &now
 
-	err := d.db.PutDeviceAuth(ctx, &dev)
+	// credentials must not be passed on to notifications
+	token := dev.EnrollmentToken
+	dev.EnrollmentToken = ""
+	cert := dev.Certificate
+	dev.Certificate = ""
+
+	// tags of a known auth set take part in admission decisions, devices
+	// cannot set their own
+	prev, err := d.db.GetDeviceAuth(ctx, dev.ID)
+	switch err {
+	case nil:
+		dev.Tags = prev.Tags
+	case store.ErrNotFound:
+		dev.Tags = nil
+	default:
+		return errors.Wrap(err, "failed to fetch device")
+	}
+
+	blocked, err := d.matchBlocklist(ctx, &dev)
+	if err != nil {
+		return err
+	}
+	admitted := false
+	var usedToken *model.EnrollmentToken
+	release := func() {}
+	if blocked != nil {
+		dev.Status = model.DevStatusRejected
+	} else if prev != nil && prev.Status == model.DevStatusSuspended {
+		// devauth reports a suspended auth set as rejected, it stays
+		// suspended until resumed
+		dev.Status = model.DevStatusSuspended
+	} else if dev.Status == model.DevStatusPending {
+		admitted, usedToken, release, err = d.autoAdmit(ctx, &dev, token, cert)
+		if err != nil {
+			return err
+		}
+		if admitted {
+			dev.Status = model.DevStatusAccepted
+		}
+	}
+
+	err = d.db.PutDeviceAuth(ctx, &dev)
+	release()
 	if err != nil {
+		if usedToken != nil {
+			d.releaseEnrollmentToken(ctx, usedToken)
+		}
 		return errors.Wrap(err, "failed to put device")
 	}
+
+	if blocked != nil {
+		d.recordBlocklistHit(ctx, blocked, &dev)
+	}
+
+	// let devauth know about the automatic decision
+	if blocked != nil || admitted {
+		err = d.propagateDeviceAuthUpdate(ctx, d.allHooks(), &dev)
+		if err != nil {
+			// the device is not admitted until devauth knows, it will
+			// present the token again when retrying
+			if usedToken != nil {
+				d.releaseEnrollmentToken(ctx, usedToken)
+			}
+			return err
+		}
+	}
+
+	switch {
+	case prev == nil:
+		d.emit(ctx, model.EventAuthSetCreated, &dev)
+	case prev.Status != dev.Status:
+		d.emit(ctx, model.EventStatusChanged, &dev)
+	}
 	return nil
 }
 
+// autoAdmit checks if pending auth set 'dev' can be accepted without user's
+// decision, i.e. it presented a valid enrollment token or certificate, or
+// matches the allowlist, and accepting it does not exceed device quota;
+// the enrollment token a use was taken of is returned, so that the use can
+// be given back if admission fails, as well as the function releasing the
+// quota reservation, to be called once the auth set is stored
+func (d *DevAdm) autoAdmit(ctx context.Context, dev *model.DeviceAuth, token, cert string) (bool, *model.EnrollmentToken, func(), error) {
+	admitted, t, err := d.matchAutoAdmission(ctx, dev, token, cert)
+	if err != nil || !admitted {
+		return false, nil, func() {}, err
+	}
+
+	release, err := d.reserveNewDeviceQuota(ctx, dev.DeviceId)
+	if err == nil {
+		if t != nil {
+			t.Stamp(dev)
+		}
+		return true, t, release, nil
+	}
+
+	if t != nil {
+		d.releaseEnrollmentToken(ctx, t)
+	}
+	if err == ErrQuotaExceeded {
+		log.FromContext(ctx).Warnf("accepting auth set %v would exceed device quota, leaving it pending",
+			dev.ID)
+		return false, nil, func() {}, nil
+	}
+	return false, nil, func() {}, err
+}
+
+// matchAutoAdmission checks if pending auth set 'dev' qualifies for
+// automatic admission, see autoAdmit
+func (d *DevAdm) matchAutoAdmission(ctx context.Context, dev *model.DeviceAuth, token, cert string) (bool, *model.EnrollmentToken, error) {
+	l := log.FromContext(ctx)
+
+	if token != "" {
+		t, err := d.useEnrollmentToken(ctx, token)
+		if err != nil {
+			return false, nil, err
+		}
+		if t != nil {
+			l.Infof("auth set %v presented enrollment token %s, accepting",
+				dev.ID, t.ID)
+			return true, t, nil
+		}
+		l.Warnf("auth set %v presented an invalid enrollment token", dev.ID)
+	}
+
+	if cert != "" {
+		info, err := d.verifyCertificate(ctx, dev, cert)
+		if err != nil {
+			return false, nil, err
+		}
+		if info != nil {
+			l.Infof("auth set %v presented certificate %s issued by trusted CA %s, accepting",
+				dev.ID, info.Serial, info.CAID)
+			dev.CertificateInfo = info
+			return true, nil, nil
+		}
+	}
+
+	entry, err := d.matchAllowlist(ctx, dev)
+	if err != nil {
+		return false, nil, err
+	}
+	if entry != nil {
+		l.Infof("auth set %v matches allowlist entry %s, accepting",
+			dev.ID, entry.ID)
+		return true, nil, nil
+	}
+	return false, nil, nil
+}
+
 func (d *DevAdm) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error) {
 	dev, err := d.db.GetDeviceAuth(ctx, id)
 	if err != nil {
@@ -105,34 +356,91 @@ func (d *DevAdm) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.Dev
 }
 
 func (d *DevAdm) DeleteDeviceAuth(ctx context.Context, id model.AuthID) error {
-	err := d.db.DeleteDeviceAuth(ctx, id)
+	// deletion comes from devauth, let the other targets know
+	var dev *model.DeviceAuth
+	if d.observed() {
+		var err error
+		dev, err = d.db.GetDeviceAuth(ctx, id)
+		switch err {
+		case nil:
+			err = d.propagateDeviceAuthDeletion(ctx, d.hooks, dev, "")
+			if err != nil {
+				return err
+			}
+		case store.ErrNotFound:
+			return err
+		default:
+			return errors.Wrap(err, "failed to get device authentication set")
+		}
+	}
+
+	// devauth has deleted the auth set already, no matter what changed
+	now := d.clock.Now()
+	err := d.db.SoftDeleteDeviceAuth(ctx, id, store.AnyRevision, now)
 	switch err {
 	case nil:
-		return nil
+		break
 	case store.ErrNotFound:
 		return err
 	default:
 		return errors.Wrap(err, "failed to delete device")
 	}
+
+	if dev != nil {
+		dev.DeletedAt = &now
+		d.emit(ctx, model.EventAuthSetDeleted, dev)
+	}
+	return nil
 }
 
 func (d *DevAdm) DeleteDeviceAuthPropagate(ctx context.Context, id model.AuthID, authorizationHeader string) error {
-	err := d.propagateDeviceAuthDeletion(ctx, id, authorizationHeader)
+	devAuth, err := d.db.GetDeviceAuth(ctx, id)
+	if err != nil {
+		if err == store.ErrNotFound {
+			return err
+		} else {
+			return errors.Wrap(err, "failed to get device authentication set")
+		}
+	}
+	if devAuth == nil {
+		return errors.New("failed to get device authentication set")
+	}
+
+	err = checkRevision(ctx, devAuth)
 	if err != nil {
 		return err
 	}
 
-	err = d.db.DeleteDeviceAuth(ctx, id)
+	// delete unless someone else changed the auth set since it was
+	// fetched; this is done before propagation, so that a conflicting
+	// deletion is not announced to devauth
+	now := d.clock.Now()
+	err = d.db.SoftDeleteDeviceAuth(ctx, id, devAuth.Revision, now)
 	switch err {
 	case nil:
-		return nil
+		break
 	case store.ErrNotFound:
 		return err
+	case store.ErrModified:
+		return ErrRevisionMismatch
 	default:
 		return errors.Wrap(err, "failed to delete device authentication set")
 	}
+
+	err = d.propagateDeviceAuthDeletion(ctx, d.allHooks(), devAuth, authorizationHeader)
+	if err != nil {
+		d.rollbackDeleteDeviceAuth(ctx, devAuth)
+		return err
+	}
+
+	devAuth.DeletedAt = &now
+	d.emit(ctx, model.EventAuthSetDeleted, devAuth)
+	return nil
 }
 
+// AcceptDevicePreAuth accepts preauthorized auth set 'id' on behalf of
+// devauth; the device was already admitted there, so the tenant's
+// transition table does not apply
 func (d *DevAdm) AcceptDevicePreAuth(ctx context.Context, id model.AuthID) error {
 	dev, err := d.db.GetDeviceAuth(ctx, id)
 
@@ -149,24 +457,40 @@ func (d *DevAdm) AcceptDevicePreAuth(ctx context.Context, id model.AuthID) error
 		return ErrNotPreauthorized
 	}
 
+	release, err := d.reserveQuota(ctx, dev.ID)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	err = d.db.UpdateDeviceAuth(ctx, &model.DeviceAuth{
-		ID:     dev.ID,
-		Status: model.DevStatusAccepted,
+		ID:       dev.ID,
+		Status:   model.DevStatusAccepted,
+		Revision: dev.Revision,
 	})
-	if err != nil {
+	if err == store.ErrModified {
+		return ErrRevisionMismatch
+	} else if err != nil {
 		return errors.Wrap(err, "failed to update auth set")
 	}
+	dev.Revision++
 
+	// devauth made this decision itself, notify the other targets only
+	dev.Status = model.DevStatusAccepted
+	err = d.propagateDeviceAuthUpdate(ctx, d.hooks, dev)
+	if err != nil {
+		return err
+	}
+
+	d.emit(ctx, model.EventStatusChanged, dev)
 	return nil
 }
 
-func (d *DevAdm) propagateDeviceAuthUpdate(ctx context.Context, dev *model.DeviceAuth) error {
-	// forward device state to auth service
-	cl := deviceauth.NewClient(d.authclientconf, d.clientGetter())
-	err := cl.UpdateDevice(ctx, deviceauth.StatusReq{
-		AuthId:   dev.ID.String(),
-		DeviceId: dev.DeviceId.String(),
-		Status:   dev.Status,
+func (d *DevAdm) propagateDeviceAuthUpdate(ctx context.Context,
+	hooks []propagationHook, dev *model.DeviceAuth) error {
+	// forward device state to auth service and other targets
+	_, err := d.propagate(ctx, hooks, func(t PropagationTarget) error {
+		return t.StatusChanged(ctx, dev)
 	})
 	if err != nil {
 		if utils.IsUsageError(err) {
@@ -179,69 +503,163 @@ func (d *DevAdm) propagateDeviceAuthUpdate(ctx context.Context, dev *model.Devic
 	return nil
 }
 
-func (d *DevAdm) propagateDeviceAuthDeletion(
-	ctx context.Context, authId model.AuthID, authorizationHeader string) error {
-	devAuth, err := d.db.GetDeviceAuth(ctx, authId)
+func (d *DevAdm) propagateDeviceAuthDeletion(ctx context.Context,
+	hooks []propagationHook, devAuth *model.DeviceAuth, authorizationHeader string) error {
+	// forward device authentication set deletion to auth service and
+	// other targets
+	_, err := d.propagate(ctx, hooks, func(t PropagationTarget) error {
+		return t.Deleted(ctx, devAuth, authorizationHeader)
+	})
 	if err != nil {
-		if err == store.ErrNotFound {
+		if utils.IsUsageError(err) {
 			return err
 		} else {
-			return errors.Wrap(err, "failed to get device authentication set")
+			return errors.Wrap(err, "failed to propagate device authentication set deletion")
 		}
 	}
-	if devAuth == nil {
-		return errors.New("failed to get device authentication set")
+	return nil
+}
+
+// updateDeviceAuthStatus moves auth set 'dev', as read by checkTransition,
+// to 'status'
+func (d *DevAdm) updateDeviceAuthStatus(ctx context.Context, dev *model.DeviceAuth, status string) error {
+	prevStatus := dev.Status
+	dev.Status = status
+
+	// update only the status, unless someone else changed the auth set
+	// since the transition was checked; this is done before propagation,
+	// so that a conflicting update is not announced to devauth
+	err := d.db.UpdateDeviceAuth(ctx, &model.DeviceAuth{
+		ID:       dev.ID,
+		Status:   dev.Status,
+		Revision: dev.Revision,
+	})
+	if err == store.ErrModified {
+		return ErrRevisionMismatch
+	} else if err != nil {
+		return err
 	}
+	dev.Revision++
 
-	// forward device authentication set deletion to auth service
-	cl := deviceauth.NewClient(d.authclientconf, d.clientGetter())
-	err = cl.DeleteDeviceAuthSet(ctx, devAuth.DeviceId.String(), devAuth.ID.String(), authorizationHeader)
+	err = d.propagateDeviceAuthUpdate(ctx, d.allHooks(), dev)
 	if err != nil {
-		if utils.IsUsageError(err) {
-			return err
-		} else {
-			return errors.Wrap(err, "failed to propagate device authentication set deletion")
-		}
+		d.rollbackDeviceAuthStatus(ctx, dev, prevStatus)
+		return err
+	}
+
+	if prevStatus != status {
+		d.emit(ctx, model.EventStatusChanged, dev)
 	}
 	return nil
 }
 
-func (d *DevAdm) updateDeviceAuthStatus(ctx context.Context, id model.AuthID, status string) error {
-	dev, err := d.db.GetDeviceAuth(ctx, id)
+// AcceptDeviceAuth accepts auth set 'id'; if the tenant requires multiple
+// approvals, the calling user's approval is recorded and
+// ErrApprovalsPending is returned until enough users approved the auth set
+func (d *DevAdm) AcceptDeviceAuth(ctx context.Context, id model.AuthID) error {
+	settings, err := d.GetSettings(ctx)
 	if err != nil {
 		return err
 	}
 
-	dev.Status = status
+	dev, err := d.checkTransition(ctx, settings, id, model.DevStatusAccepted)
+	if err != nil {
+		return err
+	}
 
-	err = d.propagateDeviceAuthUpdate(ctx, dev)
+	release, err := d.reserveQuota(ctx, id)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	// update only status and attributes fields
-	err = d.db.PutDeviceAuth(ctx, &model.DeviceAuth{
-		ID:       dev.ID,
-		DeviceId: dev.DeviceId,
-		Status:   dev.Status,
-	})
+	multiApproval := settings.RequiredApprovals > 1
+	if multiApproval {
+		err = d.approveDeviceAuth(ctx, dev, settings.RequiredApprovals)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = d.updateDeviceAuthStatus(ctx, dev, model.DevStatusAccepted)
 	if err != nil {
 		return err
 	}
 
+	if multiApproval {
+		d.clearApprovals(ctx, id)
+	}
 	return nil
 }
 
-func (d *DevAdm) AcceptDeviceAuth(ctx context.Context, id model.AuthID) error {
-	return d.updateDeviceAuthStatus(ctx, id, model.DevStatusAccepted)
+func (d *DevAdm) RejectDeviceAuth(ctx context.Context, id model.AuthID) error {
+	settings, err := d.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	dev, err := d.checkTransition(ctx, settings, id, model.DevStatusRejected)
+	if err != nil {
+		return err
+	}
+
+	err = d.updateDeviceAuthStatus(ctx, dev, model.DevStatusRejected)
+	if err != nil {
+		return err
+	}
+
+	// rejection discards approvals collected so far
+	if settings.RequiredApprovals > 1 {
+		d.clearApprovals(ctx, id)
+	}
+	return nil
 }
 
-func (d *DevAdm) RejectDeviceAuth(ctx context.Context, id model.AuthID) error {
-	return d.updateDeviceAuthStatus(ctx, id, model.DevStatusRejected)
+// SuspendDeviceAuth temporarily blocks auth set 'id'; devauth
+// treats it as rejected, accepting it again resumes the device without
+// another approval round
+func (d *DevAdm) SuspendDeviceAuth(ctx context.Context, id model.AuthID) error {
+	settings, err := d.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	dev, err := d.checkTransition(ctx, settings, id, model.DevStatusSuspended)
+	if err != nil {
+		return err
+	}
+
+	return d.updateDeviceAuthStatus(ctx, dev, model.DevStatusSuspended)
 }
 
 func (d *DevAdm) DeleteDeviceData(ctx context.Context, devid model.DeviceID) error {
-	return d.db.DeleteDeviceAuthByDevice(ctx, devid)
+	// deletion comes from devauth, let the other targets know
+	var devs []model.DeviceAuth
+	if d.observed() {
+		var err error
+		devs, err = d.db.GetDeviceAuths(ctx, 0, 0, store.Filter{DeviceID: devid})
+		if err != nil {
+			return errors.Wrap(err, "failed to get device authentication sets")
+		}
+		for i := range devs {
+			err = d.propagateDeviceAuthDeletion(ctx, d.hooks, &devs[i], "")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	now := d.clock.Now()
+	err := d.db.SoftDeleteDeviceAuthByDevice(ctx, devid, now)
+	if err != nil {
+		return err
+	}
+
+	for i := range devs {
+		devs[i].DeletedAt = &now
+		d.emit(ctx, model.EventAuthSetDeleted, &devs[i])
+	}
+	return nil
 }
 
 func (d *DevAdm) ProvisionTenant(ctx context.Context, tenant_id string) error {
@@ -268,22 +686,92 @@ func (d *DevAdm) PreauthorizeDevice(ctx context.Context, authSet model.AuthSet,
 	now := d.clock.Now()
 	dev.RequestTime = &now
 
+	// blocklisted devices are refused before devauth learns about them
+	blocked, err := d.matchBlocklist(ctx, dev)
+	if err != nil {
+		return err
+	}
+	if blocked != nil {
+		d.recordBlocklistHit(ctx, blocked, dev)
+		return ErrDeviceBlocked
+	}
+
 	err = d.db.InsertDeviceAuth(ctx, dev)
 	if err != nil {
 		return err
 	}
 
-	return d.propagatePreauthorizeDevice(ctx, dev, authorizationHeader)
+	err = d.propagatePreauthorizeDevice(ctx, dev, authorizationHeader)
+	if err != nil {
+		// the auth set is unknown to devauth, drop our copy so that
+		// the request can be retried
+		d.rollbackPreauthorizeDevice(ctx, dev)
+
+		if errors.Cause(err) == deviceauth.ErrConflict {
+			return AuthSetConflictError
+		}
+		return err
+	}
+
+	return nil
+}
+
+// rollbackDeviceAuthStatus compensates for a status update that failed to
+// propagate by restoring status 'prev' of auth set 'dev', unless the auth
+// set was changed again in the meantime
+func (d *DevAdm) rollbackDeviceAuthStatus(ctx context.Context, dev *model.DeviceAuth, prev string) {
+	l := log.FromContext(ctx)
+
+	err := d.db.UpdateDeviceAuth(ctx, &model.DeviceAuth{
+		ID:       dev.ID,
+		Status:   prev,
+		Revision: dev.Revision,
+	})
+	if err != nil {
+		l.Errorf("failed to roll back status of auth set %v to %s: %v",
+			dev.ID, prev, err)
+	}
+}
+
+// rollbackDeleteDeviceAuth compensates for a deletion that failed to
+// propagate by taking auth set 'dev' out of the trash
+func (d *DevAdm) rollbackDeleteDeviceAuth(ctx context.Context, dev *model.DeviceAuth) {
+	l := log.FromContext(ctx)
+
+	err := d.db.RestoreDeviceAuth(ctx, dev.ID)
+	if err != nil {
+		l.Errorf("failed to roll back deletion of auth set %v: %v",
+			dev.ID, err)
+	}
+}
+
+// rollbackPreauthorizeDevice compensates for a preauthorization that failed
+// to propagate by removing the locally stored auth set
+func (d *DevAdm) rollbackPreauthorizeDevice(ctx context.Context, dev *model.DeviceAuth) {
+	l := log.FromContext(ctx)
+
+	err := d.db.DeleteDeviceAuth(ctx, dev.ID)
+	if err != nil && err != store.ErrNotFound {
+		l.Errorf("failed to roll back preauthorization of auth set %v: %v",
+			dev.ID, err)
+	}
 }
 
 func (d *DevAdm) propagatePreauthorizeDevice(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
-	// forward device preauthorization to auth service
-	cl := deviceauth.NewClient(d.authclientconf, d.clientGetter())
-	err := cl.PreauthorizeDevice(ctx, &deviceauth.PreAuthReq{
-		DeviceId:  string(dev.DeviceId),
-		AuthSetId: string(dev.ID),
-		IdData:    dev.DeviceIdentity,
-		PubKey:    dev.Key,
-	}, authorizationHeader)
+	// forward device preauthorization to auth service and other targets
+	done, err := d.propagate(ctx, d.allHooks(), func(t PropagationTarget) error {
+		return t.Preauthorized(ctx, dev, authorizationHeader)
+	})
+	if err != nil {
+		// undo preauthorization on targets that already accepted it
+		l := log.FromContext(ctx)
+		for i := len(done) - 1; i >= 0; i-- {
+			derr := done[i].target.Deleted(ctx, dev, authorizationHeader)
+			if derr != nil {
+				l.Errorf("failed to roll back preauthorization of auth set %v in %s: %v",
+					dev.ID, done[i].target.Name(), derr)
+			}
+		}
+	}
 	return errors.Wrap(err, "failed to propagate device status update")
 }
@@ -15,6 +15,7 @@ package devadm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -29,6 +30,7 @@ import (
 	"github.com/mendersoftware/deviceadm/model"
 	"github.com/mendersoftware/deviceadm/store"
 	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	"github.com/mendersoftware/deviceadm/utils/clock"
 	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
 	"time"
 )
@@ -54,6 +56,7 @@ func devadmWithClientForTest(d store.DataStore, clientRespStatus int) App {
 	return &DevAdm{
 		db:           d,
 		clientGetter: clientGetter,
+		clock:        clock.NewClock(),
 	}
 }
 
@@ -61,6 +64,7 @@ func devadmForTest(d store.DataStore) App {
 	return &DevAdm{
 		db:           d,
 		clientGetter: simpleApiClientGetter,
+		clock:        clock.NewClock(),
 	}
 }
 
@@ -103,10 +107,33 @@ func TestDevAdmListDevicesErr(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestDevAdmCountDevices(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("CountDeviceAuths", ctx, store.Filter{Status: model.DevStatusPending}).
+		Return(3, nil)
+	db.On("CountDeviceAuths", ctx, store.Filter{}).
+		Return(0, errors.New("db connection failed"))
+
+	d := devadmForTest(db)
+
+	n, err := d.CountDeviceAuths(ctx, store.Filter{Status: model.DevStatusPending})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	_, err = d.CountDeviceAuths(ctx, store.Filter{})
+	assert.EqualError(t, err, "failed to count devices: db connection failed")
+}
+
 func TestDevAdmSubmitDevice(t *testing.T) {
 	ctx := context.Background()
 
 	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("")).
+		Return(nil, store.ErrNotFound)
+	db.On("GetBlocklist", ctx).
+		Return([]model.BlocklistEntry{}, nil)
 	db.On("PutDeviceAuth", ctx,
 		mock.AnythingOfType("*model.DeviceAuth")).
 		Return(nil)
@@ -118,10 +145,39 @@ func TestDevAdmSubmitDevice(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDevAdmSubmitDeviceSuspended(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{ID: "foo", Status: model.DevStatusSuspended}, nil)
+	db.On("GetBlocklist", ctx).
+		Return([]model.BlocklistEntry{}, nil)
+	db.On("PutDeviceAuth", ctx,
+		mock.MatchedBy(func(d *model.DeviceAuth) bool {
+			return d.Status == model.DevStatusSuspended
+		})).
+		Return(nil)
+
+	d := devadmWithClientForTest(db, http.StatusNoContent)
+
+	err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+		ID:     "foo",
+		Status: model.DevStatusRejected,
+	})
+
+	assert.NoError(t, err)
+	db.AssertExpectations(t)
+}
+
 func TestDevAdmSubmitDeviceErr(t *testing.T) {
 	ctx := context.Background()
 
 	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("")).
+		Return(nil, store.ErrNotFound)
+	db.On("GetBlocklist", ctx).
+		Return([]model.BlocklistEntry{}, nil)
 	db.On("PutDeviceAuth", ctx,
 		mock.AnythingOfType("*model.DeviceAuth")).
 		Return(errors.New("db connection failed"))
@@ -181,11 +237,15 @@ func TestDevAdmAcceptDevice(t *testing.T) {
 	ctx := context.Background()
 
 	db := &mstore.DataStore{}
+	db.On("GetSettings", ctx).
+		Return(&model.TenantSettings{}, nil)
+	db.On("GetQuota", ctx).
+		Return(&model.Quota{}, nil)
 	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
-		Return(&model.DeviceAuth{ID: "foo"}, nil)
+		Return(&model.DeviceAuth{ID: "foo", Status: model.DevStatusPending}, nil)
 	db.On("GetDeviceAuth", ctx, model.AuthID("bar")).
 		Return(nil, store.ErrNotFound)
-	db.On("PutDeviceAuth", ctx,
+	db.On("UpdateDeviceAuth", ctx,
 		mock.AnythingOfType("*model.DeviceAuth")).
 		Return(nil)
 
@@ -226,8 +286,10 @@ func TestDevAdmDeleteDevice(t *testing.T) {
 			ctx := context.Background()
 
 			db := &mstore.DataStore{}
-			db.On("DeleteDeviceAuth", ctx,
+			db.On("SoftDeleteDeviceAuth", ctx,
 				mock.AnythingOfType("model.AuthID"),
+				store.AnyRevision,
+				mock.AnythingOfType("time.Time"),
 			).Return(tc.datastoreError)
 			i := devadmForTest(db)
 
@@ -248,11 +310,13 @@ func TestDevAdmRejectDevice(t *testing.T) {
 	ctx := context.Background()
 
 	db := &mstore.DataStore{}
+	db.On("GetSettings", ctx).
+		Return(&model.TenantSettings{}, nil)
 	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
-		Return(&model.DeviceAuth{ID: "foo"}, nil)
+		Return(&model.DeviceAuth{ID: "foo", Status: model.DevStatusPending}, nil)
 	db.On("GetDeviceAuth", ctx, model.AuthID("bar")).
 		Return(nil, store.ErrNotFound)
-	db.On("PutDeviceAuth", ctx,
+	db.On("UpdateDeviceAuth", ctx,
 		mock.AnythingOfType("*model.DeviceAuth")).
 		Return(nil)
 
@@ -267,6 +331,102 @@ func TestDevAdmRejectDevice(t *testing.T) {
 	assert.EqualError(t, err, store.ErrNotFound.Error())
 }
 
+// statusRecorder records status updates sent to devauth
+type statusRecorder struct {
+	statuses []string
+}
+
+func (r *statusRecorder) Do(req *http.Request) (*http.Response, error) {
+	var status deviceauth.StatusReq
+	if err := json.NewDecoder(req.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	r.statuses = append(r.statuses, status.Status)
+
+	w := httptest.NewRecorder()
+	w.WriteHeader(http.StatusNoContent)
+	return w.Result(), nil
+}
+
+func TestDevAdmSuspendDevice(t *testing.T) {
+	testCases := map[string]struct {
+		dev    *model.DeviceAuth
+		getErr error
+
+		devauth  []string
+		outError error
+	}{
+		"ok": {
+			dev:     &model.DeviceAuth{ID: "foo", Status: model.DevStatusAccepted},
+			devauth: []string{model.DevStatusRejected},
+		},
+		"already suspended": {
+			dev:     &model.DeviceAuth{ID: "foo", Status: model.DevStatusSuspended},
+			devauth: []string{model.DevStatusRejected},
+		},
+		"not accepted": {
+			dev:      &model.DeviceAuth{ID: "foo", Status: model.DevStatusPending},
+			outError: ErrInvalidTransition,
+		},
+		"not found": {
+			getErr:   store.ErrNotFound,
+			outError: store.ErrNotFound,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("GetSettings", ctx).
+				Return(&model.TenantSettings{}, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(func(ctx context.Context, id model.AuthID) *model.DeviceAuth {
+					if tc.dev == nil {
+						return nil
+					}
+					// handed out copies, the status is updated in place
+					dev := *tc.dev
+					return &dev
+				}, tc.getErr)
+			db.On("UpdateDeviceAuth", ctx,
+				mock.MatchedBy(func(d *model.DeviceAuth) bool {
+					return d.Status == model.DevStatusSuspended
+				})).
+				Return(nil)
+
+			devauth := &statusRecorder{}
+			target := &fakeTarget{name: "inventory"}
+			d := (&DevAdm{
+				db: db,
+				clientGetter: func() client.HttpRunner {
+					return devauth
+				},
+				clock: clock.NewClock(),
+			}).WithPropagationTarget(target, FailurePolicyBestEffort)
+
+			err := d.SuspendDeviceAuth(ctx, "foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+
+			// devauth rejects the device, other targets learn the
+			// actual status
+			assert.Equal(t, tc.devauth, devauth.statuses)
+			if tc.devauth != nil {
+				assert.Equal(t, []string{"status_changed:suspended"}, target.calls)
+				db.AssertCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			} else {
+				assert.Empty(t, target.calls)
+				db.AssertNotCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			}
+		})
+	}
+}
+
 func TestDevAdmProvisionTenant(t *testing.T) {
 	t.Parallel()
 
@@ -390,6 +550,8 @@ func TestDevAdmAcceptDevicePreAuth(t *testing.T) {
 				ctx,
 				mock.AnythingOfType("*model.DeviceAuth"),
 			).Return(tc.storeUpdateErr)
+			db.On("GetQuota", ctx).
+				Return(&model.Quota{}, nil)
 
 			d := devadmForTest(db)
 
@@ -416,9 +578,11 @@ func TestDevAdmPreauthorizeDevice(t *testing.T) {
 	testCases := map[string]struct {
 		datastoreGetError    error
 		datastoreInsertError error
+		datastoreDeleteError error
 		outError             error
 		clientStatusCode     int
 		foundAuthSets        []model.DeviceAuth
+		rollback             bool
 	}{
 		"ok": {
 			datastoreGetError:    nil,
@@ -439,10 +603,26 @@ func TestDevAdmPreauthorizeDevice(t *testing.T) {
 			outError:             errors.New("bar error"),
 		},
 		"calling devauth error": {
+			datastoreGetError:    nil,
+			datastoreInsertError: nil,
+			clientStatusCode:     500,
+			outError:             errors.New("failed to propagate device status update: device preauthorize request failed with status 500 Internal Server Error"),
+			rollback:             true,
+		},
+		"calling devauth error, rollback error": {
+			datastoreGetError:    nil,
+			datastoreInsertError: nil,
+			datastoreDeleteError: errors.New("baz error"),
+			clientStatusCode:     500,
+			outError:             errors.New("failed to propagate device status update: device preauthorize request failed with status 500 Internal Server Error"),
+			rollback:             true,
+		},
+		"devauth conflict error": {
 			datastoreGetError:    nil,
 			datastoreInsertError: nil,
 			clientStatusCode:     409,
-			outError:             errors.New("failed to propagate device status update: device preauthorize request failed with status 409 Conflict"),
+			outError:             AuthSetConflictError,
+			rollback:             true,
 		},
 		"conflict error": {
 			datastoreGetError:    nil,
@@ -470,8 +650,13 @@ func TestDevAdmPreauthorizeDevice(t *testing.T) {
 				Return(tc.foundAuthSets, tc.datastoreGetError)
 			d := &model.DeviceAuth{ID: "", DeviceId: "", DeviceIdentity: "foo-id", Key: "foo-key", Status: "preauthorized", Attributes: model.DeviceAuthAttributes(map[string]string{"foo": "bar"}), RequestTime: &exampleTime}
 			if tc.datastoreGetError == nil && len(tc.foundAuthSets) == 0 {
+				db.On("GetBlocklist", ctx).
+					Return([]model.BlocklistEntry{}, nil)
 				db.On("InsertDeviceAuth", ctx, d).Return(tc.datastoreInsertError)
 			}
+			if tc.rollback {
+				db.On("DeleteDeviceAuth", ctx, d.ID).Return(tc.datastoreDeleteError)
+			}
 			i := &DevAdm{
 				db: db,
 				clientGetter: func() client.HttpRunner {
@@ -502,6 +687,7 @@ func TestDevAdmDeleteDevicePropagate(t *testing.T) {
 		datastoreGetDeviceAuthError    error
 		clientStatusCode               int
 		storeAuth                      *model.DeviceAuth
+		rollback                       bool
 		outError                       error
 	}{
 		"ok": {
@@ -536,6 +722,16 @@ func TestDevAdmDeleteDevicePropagate(t *testing.T) {
 			clientStatusCode:               http.StatusNoContent,
 			outError:                       errors.New("failed to delete device authentication set: db connection failed"),
 		},
+		"delete device: modified concurrently": {
+			storeAuth: &model.DeviceAuth{
+				ID:       "1",
+				DeviceId: model.DeviceID("1"),
+				Revision: 2,
+			},
+			datastoreDeleteDeviceAuthError: store.ErrModified,
+			clientStatusCode:               http.StatusInternalServerError,
+			outError:                       ErrRevisionMismatch,
+		},
 		"get device: datastore error": {
 			datastoreGetDeviceAuthError: errors.New("db connection failed"),
 			outError:                    errors.New("failed to get device authentication set: db connection failed"),
@@ -549,6 +745,7 @@ func TestDevAdmDeleteDevicePropagate(t *testing.T) {
 				ID:       "1",
 				DeviceId: model.DeviceID("1"),
 			},
+			rollback: true,
 			outError: errors.New("failed to propagate device authentication set deletion: delete device authentication set request failed with status 500 Internal Server Error"),
 		},
 	}
@@ -557,18 +754,31 @@ func TestDevAdmDeleteDevicePropagate(t *testing.T) {
 		t.Run(fmt.Sprintf("test case: %s", name), func(t *testing.T) {
 			ctx := context.Background()
 
+			revision := 0
+			if tc.storeAuth != nil {
+				revision = tc.storeAuth.Revision
+			}
+
 			db := &mstore.DataStore{}
-			db.On("DeleteDeviceAuth", ctx,
+			// deleted at the revision fetched
+			db.On("SoftDeleteDeviceAuth", ctx,
 				mock.AnythingOfType("model.AuthID"),
+				revision,
+				mock.AnythingOfType("time.Time"),
 			).Return(tc.datastoreDeleteDeviceAuthError)
 			db.On("GetDeviceAuth", ctx,
 				mock.AnythingOfType("model.AuthID"),
 			).Return(tc.storeAuth, tc.datastoreGetDeviceAuthError)
+			if tc.rollback {
+				db.On("RestoreDeviceAuth", ctx, model.AuthID("1")).
+					Return(nil)
+			}
 			i := &DevAdm{
 				db: db,
 				clientGetter: func() client.HttpRunner {
 					return FakeApiRequester{tc.clientStatusCode}
 				},
+				clock: clock.NewClock(),
 			}
 
 			err := i.DeleteDeviceAuthPropagate(ctx, "foo", "bar")
@@ -580,6 +790,9 @@ func TestDevAdmDeleteDevicePropagate(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 			}
+			if tc.rollback {
+				db.AssertCalled(t, "RestoreDeviceAuth", ctx, model.AuthID("1"))
+			}
 		})
 	}
 }
@@ -0,0 +1,110 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+const (
+	// length of enrollment token secrets, in bytes
+	enrollmentTokenLen = 32
+)
+
+var (
+	ErrEnrollmentTokenNotFound = errors.New("enrollment token not found")
+	ErrEnrollmentTokenExpiry   = errors.New("expires_ts must be in the future")
+)
+
+func (d *DevAdm) CreateEnrollmentToken(ctx context.Context, req model.EnrollmentTokenReq) (*model.EnrollmentToken, error) {
+	now := d.clock.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, ErrEnrollmentTokenExpiry
+	}
+
+	secret := make([]byte, enrollmentTokenLen)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "failed to generate enrollment token")
+	}
+
+	token := &model.EnrollmentToken{
+		Token:       base64.RawURLEncoding.EncodeToString(secret),
+		Description: req.Description,
+		MaxUses:     req.MaxUses,
+		UsesLeft:    req.MaxUses,
+		ExpiresAt:   req.ExpiresAt,
+		Attributes:  req.Attributes,
+		Created:     &now,
+	}
+	token.Hash = model.HashEnrollmentToken(token.Token)
+
+	err := d.db.InsertEnrollmentToken(ctx, token)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create enrollment token")
+	}
+	return token, nil
+}
+
+func (d *DevAdm) ListEnrollmentTokens(ctx context.Context) ([]model.EnrollmentToken, error) {
+	tokens, err := d.db.GetEnrollmentTokens(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch enrollment tokens")
+	}
+	return tokens, nil
+}
+
+func (d *DevAdm) RevokeEnrollmentToken(ctx context.Context, id string) error {
+	err := d.db.RevokeEnrollmentToken(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrEnrollmentTokenNotFound
+	default:
+		return errors.Wrap(err, "failed to revoke enrollment token")
+	}
+}
+
+// useEnrollmentToken uses up one use of enrollment token 'token'; returns
+// nil if the token is not valid
+func (d *DevAdm) useEnrollmentToken(ctx context.Context, token string) (*model.EnrollmentToken, error) {
+	t, err := d.db.UseEnrollmentToken(ctx, model.HashEnrollmentToken(token),
+		d.clock.Now())
+	switch err {
+	case nil:
+		return t, nil
+	case store.ErrNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Wrap(err, "failed to use enrollment token")
+	}
+}
+
+// releaseEnrollmentToken gives back the use of token 't' taken for an
+// auth set which ended up not admitted; failures are only logged
+func (d *DevAdm) releaseEnrollmentToken(ctx context.Context, t *model.EnrollmentToken) {
+	err := d.db.ReleaseEnrollmentToken(ctx, t.ID)
+	if err != nil {
+		log.FromContext(ctx).Errorf("failed to release enrollment token %s: %v",
+			t.ID, err)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmSubmitDeviceEnrollmentToken(t *testing.T) {
+	testCases := map[string]struct {
+		token     *model.EnrollmentToken
+		tokenErr  error
+		putErr    error
+		targetErr error
+
+		outStatus     string
+		outAttributes model.DeviceAuthAttributes
+		outError      error
+		released      bool
+	}{
+		"valid token": {
+			token: &model.EnrollmentToken{
+				ID:         "1",
+				Attributes: model.DeviceAuthAttributes{"sn": "other", "site": "plant1"},
+			},
+			outStatus:     model.DevStatusAccepted,
+			outAttributes: model.DeviceAuthAttributes{"sn": "1234", "site": "plant1"},
+		},
+		"invalid token": {
+			tokenErr:      store.ErrNotFound,
+			outStatus:     model.DevStatusPending,
+			outAttributes: model.DeviceAuthAttributes{"sn": "1234"},
+		},
+		"error": {
+			tokenErr: errors.New("db connection failed"),
+			outError: errors.New("failed to use enrollment token: db connection failed"),
+		},
+		"valid token, put error": {
+			token:     &model.EnrollmentToken{ID: "1"},
+			putErr:    errors.New("db connection failed"),
+			outStatus: model.DevStatusAccepted,
+			outError:  errors.New("failed to put device: db connection failed"),
+			released:  true,
+		},
+		"valid token, propagation error": {
+			token:     &model.EnrollmentToken{ID: "1"},
+			targetErr: errors.New("connection refused"),
+			outStatus: model.DevStatusAccepted,
+			outError:  errors.New("failed to propagate device status update: connection refused"),
+			released:  true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			now := time.Now()
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			var put model.DeviceAuth
+			db := &mstore.DataStore{}
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(nil, store.ErrNotFound)
+			db.On("GetBlocklist", ctx).
+				Return([]model.BlocklistEntry{}, nil)
+			db.On("UseEnrollmentToken", ctx,
+				model.HashEnrollmentToken("secret"), now).
+				Return(tc.token, tc.tokenErr)
+			if tc.tokenErr == store.ErrNotFound {
+				db.On("FindAllowlistEntries", ctx,
+					mock.AnythingOfType("model.DeviceAuthAttributes"),
+					model.AuthID("foo")).
+					Return([]model.AllowlistEntry{}, nil)
+			}
+			if tc.outStatus == model.DevStatusAccepted {
+				db.On("GetQuota", ctx).
+					Return(&model.Quota{}, nil)
+			}
+			if tc.outStatus != "" {
+				db.On("PutDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth")).
+					Run(func(args mock.Arguments) {
+						put = *args.Get(1).(*model.DeviceAuth)
+					}).
+					Return(tc.putErr)
+			}
+			if tc.released {
+				db.On("ReleaseEnrollmentToken", ctx, "1").
+					Return(nil)
+			}
+
+			target := &fakeTarget{name: "inventory", err: tc.targetErr}
+			d := &DevAdm{
+				db: db,
+				clientGetter: func() client.HttpRunner {
+					return FakeApiRequester{http.StatusNoContent}
+				},
+				clock: clock,
+			}
+			d.WithPropagationTarget(target, FailurePolicyRequired)
+
+			err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+				ID:              "foo",
+				DeviceId:        "bar",
+				Attributes:      model.DeviceAuthAttributes{"sn": "1234"},
+				Status:          model.DevStatusPending,
+				EnrollmentToken: "secret",
+			})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outStatus, put.Status)
+				assert.Equal(t, tc.outAttributes, put.Attributes)
+				assert.Empty(t, put.EnrollmentToken)
+			}
+
+			if tc.outStatus == model.DevStatusAccepted && tc.putErr == nil {
+				assert.Equal(t, []string{"status_changed:accepted"}, target.calls)
+			} else {
+				assert.Empty(t, target.calls)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDevAdmCreateEnrollmentToken(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(now)
+
+	var inserted *model.EnrollmentToken
+	db := &mstore.DataStore{}
+	db.On("InsertEnrollmentToken", ctx,
+		mock.AnythingOfType("*model.EnrollmentToken")).
+		Run(func(args mock.Arguments) {
+			inserted = args.Get(1).(*model.EnrollmentToken)
+		}).
+		Return(nil)
+
+	d := &DevAdm{db: db, clock: clock}
+
+	past := now.Add(-time.Minute)
+	_, err := d.CreateEnrollmentToken(ctx, model.EnrollmentTokenReq{
+		MaxUses:   1,
+		ExpiresAt: &past,
+	})
+	assert.Equal(t, ErrEnrollmentTokenExpiry, err)
+
+	future := now.Add(time.Hour)
+	token, err := d.CreateEnrollmentToken(ctx, model.EnrollmentTokenReq{
+		Description: "line 1",
+		MaxUses:     5,
+		ExpiresAt:   &future,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, inserted, token)
+	assert.NotEmpty(t, token.Token)
+	assert.Equal(t, model.HashEnrollmentToken(token.Token), token.Hash)
+	assert.Equal(t, 5, token.UsesLeft)
+	assert.Equal(t, &now, token.Created)
+	db.AssertExpectations(t)
+}
+
+func TestDevAdmRevokeEnrollmentToken(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("RevokeEnrollmentToken", ctx, "1").
+		Return(nil)
+	db.On("RevokeEnrollmentToken", ctx, "2").
+		Return(store.ErrNotFound)
+	db.On("RevokeEnrollmentToken", ctx, "3").
+		Return(errors.New("db connection failed"))
+
+	d := &DevAdm{db: db}
+
+	assert.NoError(t, d.RevokeEnrollmentToken(ctx, "1"))
+	assert.Equal(t, ErrEnrollmentTokenNotFound, d.RevokeEnrollmentToken(ctx, "2"))
+	assert.EqualError(t, d.RevokeEnrollmentToken(ctx, "3"),
+		"failed to revoke enrollment token: db connection failed")
+}
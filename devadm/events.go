@@ -0,0 +1,186 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+)
+
+const (
+	defaultEventsPollInterval = time.Duration(1) * time.Second
+	defaultEventsGapTimeout   = time.Duration(5) * time.Second
+
+	// max. number of events fetched from the event log at once
+	eventsBatchSize = 100
+)
+
+var (
+	ErrEventLogDisabled = errors.New("event log is disabled")
+)
+
+// EventLogConfig controls the admission event log
+type EventLogConfig struct {
+	// how often the event log is checked for new events by streams
+	// that caught up
+	PollInterval time.Duration
+	// how long streams hold back events following a gap in sequence
+	// numbers, waiting for the missing ones
+	GapTimeout time.Duration
+}
+
+type eventLog struct {
+	conf EventLogConfig
+
+	// closed by StopEventStreams
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// WithEventLog enables recording of admission events, which can then be
+// streamed with WatchEvents
+func (d *DevAdm) WithEventLog(conf EventLogConfig) *DevAdm {
+	if conf.PollInterval == 0 {
+		conf.PollInterval = defaultEventsPollInterval
+	}
+	if conf.GapTimeout == 0 {
+		conf.GapTimeout = defaultEventsGapTimeout
+	}
+	d.eventlog = &eventLog{
+		conf: conf,
+		stop: make(chan struct{}),
+	}
+	return d
+}
+
+// StopEventStreams ends all WatchEvents calls, present and future; it's
+// meant to be called on shutdown, streams never end on their own
+func (d *DevAdm) StopEventStreams() {
+	if d.eventlog == nil {
+		return
+	}
+	d.eventlog.stopOnce.Do(func() { close(d.eventlog.stop) })
+}
+
+// observed checks if anyone beside devauth is interested in changes of
+// auth sets
+func (d *DevAdm) observed() bool {
+	return len(d.hooks) > 0 || d.eventlog != nil
+}
+
+// emit announces 'event' about auth set 'dev' to the event log and
+// webhooks; failures are logged and never returned, as the admission
+// decision has already been made
+func (d *DevAdm) emit(ctx context.Context, event string, dev *model.DeviceAuth) {
+	d.recordEvent(ctx, event, dev)
+	d.notifyWebhooks(ctx, event, dev)
+}
+
+func (d *DevAdm) recordEvent(ctx context.Context, event string, dev *model.DeviceAuth) {
+	if d.eventlog == nil {
+		return
+	}
+
+	err := d.db.InsertEvent(ctx, &model.Event{
+		Type:      event,
+		Timestamp: d.clock.Now(),
+		AuthSet:   dev,
+	})
+	if err != nil {
+		log.FromContext(ctx).Errorf("failed to record %s event of auth set %v: %v",
+			event, dev.ID, err)
+	}
+}
+
+// WatchEvents calls 'fn' for each event recorded after event 'lastID',
+// waiting for new events until 'ctx' is done, 'fn' fails or
+// StopEventStreams is called; a negative
+// 'lastID' starts with events recorded from now on. While there is nothing
+// new, 'fn' is called with a nil event every poll interval, so that the
+// caller can keep its connection alive.
+//
+// Sequence numbers are assigned before events are stored, so concurrently
+// recorded events may show up out of order. Events following a gap are
+// held back until the gap is filled, or for GapTimeout at most, in case
+// the missing events were never stored or were already dropped from the
+// log. Otherwise a stream resumed after the later event would never get
+// the earlier one.
+func (d *DevAdm) WatchEvents(ctx context.Context, lastID int64, fn func(ev *model.Event) error) error {
+	if d.eventlog == nil {
+		return ErrEventLogDisabled
+	}
+
+	if lastID < 0 {
+		var err error
+		lastID, err = d.db.GetLastEventID(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch events")
+		}
+	}
+
+	// when the gap following 'lastID' was first seen
+	var gapSince time.Time
+
+	for {
+		evs, err := d.db.GetEvents(ctx, lastID, eventsBatchSize)
+		if err != nil {
+			return errors.Wrap(err, "failed to fetch events")
+		}
+
+		held := false
+		sent := false
+		for i := range evs {
+			if evs[i].ID > lastID+1 {
+				now := d.clock.Now()
+				if gapSince.IsZero() {
+					gapSince = now
+				}
+				if now.Sub(gapSince) < d.eventlog.conf.GapTimeout {
+					held = true
+					break
+				}
+			}
+
+			if err := fn(&evs[i]); err != nil {
+				return err
+			}
+			lastID = evs[i].ID
+			gapSince = time.Time{}
+			sent = true
+		}
+
+		if !held && len(evs) == eventsBatchSize {
+			continue
+		}
+		if !sent {
+			if err := fn(nil); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.eventlog.stop:
+			return nil
+		case <-d.clock.After(d.eventlog.conf.PollInterval):
+		}
+	}
+}
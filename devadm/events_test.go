@@ -0,0 +1,459 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	"github.com/mendersoftware/deviceadm/utils/clock"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func devadmWithEventLogForTest(db store.DataStore) *DevAdm {
+	d := devadmWithClientForTest(db, http.StatusNoContent).(*DevAdm).
+		WithEventLog(EventLogConfig{
+			PollInterval: time.Millisecond,
+		})
+	d.clock = mockEventsClock(func() time.Time {
+		return time.Unix(1500000000, 0)
+	})
+	return d
+}
+
+// mockEventsClock returns a clock at time now(), with poll intervals
+// passing immediately
+func mockEventsClock(now func() time.Time) *mclock.Clock {
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(now)
+	clock.On("After", mock.AnythingOfType("time.Duration")).
+		Return(func(time.Duration) <-chan time.Time {
+			fired := make(chan time.Time, 1)
+			fired <- now()
+			return fired
+		})
+	return clock
+}
+
+func matchEvent(typ string, id model.AuthID, status string) interface{} {
+	return mock.MatchedBy(func(ev *model.Event) bool {
+		return ev.Type == typ &&
+			ev.AuthSet.ID == id &&
+			ev.AuthSet.Status == status &&
+			ev.Timestamp.Equal(time.Unix(1500000000, 0))
+	})
+}
+
+func TestDevAdmRecordEvents(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetSettings", ctx).
+		Return(&model.TenantSettings{}, nil)
+	db.On("GetQuota", ctx).
+		Return(&model.Quota{}, nil)
+	db.On("GetBlocklist", ctx).
+		Return([]model.BlocklistEntry{}, nil)
+	db.On("FindAllowlistEntries", ctx,
+		mock.AnythingOfType("model.DeviceAuthAttributes"),
+		model.AuthID("new")).
+		Return([]model.AllowlistEntry{}, nil)
+	db.On("GetDeviceAuth", ctx, model.AuthID("new")).
+		Return(nil, store.ErrNotFound)
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(func(ctx context.Context, id model.AuthID) *model.DeviceAuth {
+			return &model.DeviceAuth{
+				ID:       id,
+				DeviceId: "bar",
+				Status:   model.DevStatusPending,
+			}
+		}, nil)
+	db.On("PutDeviceAuth", ctx,
+		mock.AnythingOfType("*model.DeviceAuth")).
+		Return(nil)
+	db.On("UpdateDeviceAuth", ctx,
+		mock.AnythingOfType("*model.DeviceAuth")).
+		Return(nil)
+	db.On("SoftDeleteDeviceAuth", ctx, model.AuthID("foo"),
+		store.AnyRevision, mock.AnythingOfType("time.Time")).
+		Return(nil)
+	db.On("InsertEvent", ctx,
+		matchEvent(model.EventAuthSetCreated, "new", model.DevStatusPending)).
+		Return(nil).Once()
+	db.On("InsertEvent", ctx,
+		matchEvent(model.EventStatusChanged, "foo", model.DevStatusAccepted)).
+		Return(errors.New("db connection failed")).Once()
+	db.On("InsertEvent", ctx,
+		matchEvent(model.EventAuthSetDeleted, "foo", model.DevStatusPending)).
+		Return(nil).Once()
+
+	d := devadmWithEventLogForTest(db)
+
+	err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+		ID:     "new",
+		Status: model.DevStatusPending,
+	})
+	assert.NoError(t, err)
+
+	// failure to record an event does not fail the operation
+	err = d.AcceptDeviceAuth(ctx, "foo")
+	assert.NoError(t, err)
+
+	err = d.DeleteDeviceAuth(ctx, "foo")
+	assert.NoError(t, err)
+
+	db.AssertExpectations(t)
+}
+
+func TestDevAdmWatchEvents(t *testing.T) {
+	events := func(ids ...int64) []model.Event {
+		evs := []model.Event{}
+		for _, id := range ids {
+			evs = append(evs, model.Event{
+				ID:   id,
+				Type: model.EventStatusChanged,
+			})
+		}
+		return evs
+	}
+
+	testCases := map[string]struct {
+		lastID int64
+
+		lastEventID    int64
+		lastEventIDErr error
+
+		batches [][]model.Event
+		err     error
+		fnErr   error
+
+		received   []int64
+		keepalives int
+		outError   string
+	}{
+		"from now on": {
+			lastID:      -1,
+			lastEventID: 5,
+			batches: [][]model.Event{
+				events(6, 7),
+				events(),
+				events(8),
+			},
+			received:   []int64{6, 7, 8},
+			keepalives: 2,
+		},
+		"resume": {
+			lastID: 2,
+			batches: [][]model.Event{
+				events(3),
+			},
+			received:   []int64{3},
+			keepalives: 1,
+		},
+		"error: last event ID": {
+			lastID:         -1,
+			lastEventIDErr: errors.New("db connection failed"),
+			outError:       "failed to fetch events: db connection failed",
+		},
+		"error: events": {
+			lastID:   2,
+			err:      errors.New("db connection failed"),
+			outError: "failed to fetch events: db connection failed",
+		},
+		"error: callback": {
+			lastID: 2,
+			batches: [][]model.Event{
+				events(3, 4),
+			},
+			fnErr:    errors.New("broken pipe"),
+			received: []int64{3},
+			outError: "broken pipe",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			db := &mstore.DataStore{}
+			db.On("GetLastEventID", ctx).
+				Return(tc.lastEventID, tc.lastEventIDErr)
+
+			after := tc.lastID
+			if after < 0 {
+				after = tc.lastEventID
+			}
+			for _, b := range tc.batches {
+				db.On("GetEvents", ctx, after, eventsBatchSize).
+					Return(b, nil).Once()
+				if len(b) > 0 {
+					after = b[len(b)-1].ID
+				}
+			}
+			// nothing more to stream
+			db.On("GetEvents", ctx, after, eventsBatchSize).
+				Run(func(args mock.Arguments) {
+					cancel()
+				}).
+				Return(events(), tc.err)
+
+			d := devadmWithEventLogForTest(db)
+
+			var received []int64
+			keepalives := 0
+			err := d.WatchEvents(ctx, tc.lastID, func(ev *model.Event) error {
+				if ev == nil {
+					keepalives++
+					return nil
+				}
+				received = append(received, ev.ID)
+				return tc.fnErr
+			})
+
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.received, received)
+			// one more may be sent after the last fetch, racing with
+			// the cancellation
+			assert.True(t, keepalives >= tc.keepalives,
+				"%d keepalives, expected at least %d", keepalives, tc.keepalives)
+		})
+	}
+}
+
+func TestDevAdmStopEventStreams(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetEvents", ctx, int64(2), eventsBatchSize).
+		Return([]model.Event{}, nil)
+
+	d := devadmWithEventLogForTest(db)
+	// poll interval never passes
+	clock := &mclock.Clock{}
+	clock.On("After", mock.AnythingOfType("time.Duration")).
+		Return(func(time.Duration) <-chan time.Time {
+			return make(chan time.Time)
+		})
+	d.clock = clock
+
+	watching := make(chan error)
+	go func() {
+		watching <- d.WatchEvents(ctx, 2,
+			func(ev *model.Event) error { return nil })
+	}()
+
+	d.StopEventStreams()
+	select {
+	case err := <-watching:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchEvents did not return")
+	}
+
+	// stopping again is harmless
+	d.StopEventStreams()
+}
+
+func TestDevAdmWatchEventsDisabled(t *testing.T) {
+	d := devadmWithClientForTest(&mstore.DataStore{}, http.StatusNoContent)
+
+	err := d.WatchEvents(context.Background(), 0,
+		func(ev *model.Event) error { return nil })
+	assert.Equal(t, ErrEventLogDisabled, err)
+}
+
+func TestDevAdmWatchEventsGap(t *testing.T) {
+	events := func(ids ...int64) []model.Event {
+		evs := []model.Event{}
+		for _, id := range ids {
+			evs = append(evs, model.Event{ID: id})
+		}
+		return evs
+	}
+
+	testCases := map[string]struct {
+		batches [][]model.Event
+		// time passed since the previous fetch
+		elapsed []time.Duration
+
+		received []int64
+	}{
+		"gap filled": {
+			batches: [][]model.Event{
+				events(4, 5),
+				events(3, 4, 5),
+			},
+			elapsed:  []time.Duration{0, time.Second},
+			received: []int64{3, 4, 5},
+		},
+		"gap timed out": {
+			batches: [][]model.Event{
+				events(4, 5),
+				events(4, 5),
+				events(4, 5),
+			},
+			elapsed:  []time.Duration{0, 4 * time.Second, time.Second},
+			received: []int64{4, 5},
+		},
+		"events before gap": {
+			batches: [][]model.Event{
+				events(3, 5),
+				events(5),
+				events(4, 5),
+			},
+			elapsed:  []time.Duration{0, time.Second, time.Second},
+			received: []int64{3, 4, 5},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			now := time.Unix(1500000000, 0)
+			fetches := 0
+
+			db := &mstore.DataStore{}
+			db.On("GetEvents", ctx, mock.AnythingOfType("int64"), eventsBatchSize).
+				Return(func(ctx context.Context, after int64, limit int) []model.Event {
+					if fetches == len(tc.batches) {
+						cancel()
+						return events()
+					}
+					now = now.Add(tc.elapsed[fetches])
+					fetches++
+
+					evs := events()
+					for _, ev := range tc.batches[fetches-1] {
+						if ev.ID > after {
+							evs = append(evs, ev)
+						}
+					}
+					return evs
+				}, nil)
+
+			d := devadmWithEventLogForTest(db)
+			d.clock = mockEventsClock(func() time.Time { return now })
+			d.eventlog.conf.GapTimeout = 5 * time.Second
+
+			var received []int64
+			err := d.WatchEvents(ctx, 2, func(ev *model.Event) error {
+				if ev != nil {
+					received = append(received, ev.ID)
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.received, received)
+		})
+	}
+}
+
+func TestDevAdmWatchEventsConcurrentInserts(t *testing.T) {
+	const count = 20
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// events are stored in reverse order of their sequence numbers,
+	// mimicking concurrent inserts completing out of order
+	var mtx sync.Mutex
+	var seq int64
+	stored := []model.Event{}
+
+	db := &mstore.DataStore{}
+	db.On("InsertEvent", ctx, mock.AnythingOfType("*model.Event")).
+		Run(func(args mock.Arguments) {
+			ev := args.Get(1).(*model.Event)
+
+			mtx.Lock()
+			seq++
+			ev.ID = seq
+			mtx.Unlock()
+
+			time.Sleep(time.Duration(count-ev.ID) * time.Millisecond)
+
+			mtx.Lock()
+			stored = append(stored, *ev)
+			mtx.Unlock()
+		}).
+		Return(nil)
+	db.On("GetEvents", ctx, mock.AnythingOfType("int64"), eventsBatchSize).
+		Return(func(ctx context.Context, after int64, limit int) []model.Event {
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			evs := []model.Event{}
+			for _, ev := range stored {
+				if ev.ID > after {
+					evs = append(evs, ev)
+				}
+			}
+			sort.Slice(evs, func(i, j int) bool { return evs[i].ID < evs[j].ID })
+			if len(evs) > limit {
+				evs = evs[:limit]
+			}
+			return evs
+		}, nil)
+
+	d := devadmWithEventLogForTest(db)
+	d.clock = clock.NewClock()
+	d.eventlog.conf.GapTimeout = time.Minute
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.recordEvent(ctx, model.EventStatusChanged, &model.DeviceAuth{ID: "foo"})
+		}()
+	}
+
+	var received []int64
+	err := d.WatchEvents(ctx, 0, func(ev *model.Event) error {
+		if ev == nil {
+			return nil
+		}
+		received = append(received, ev.ID)
+		if len(received) == count {
+			cancel()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	wg.Wait()
+
+	expected := []int64{}
+	for i := int64(1); i <= count; i++ {
+		expected = append(expected, i)
+	}
+	assert.Equal(t, expected, received)
+}
@@ -0,0 +1,216 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+const (
+	DefaultIdempotencyTTL   = 24 * time.Hour
+	DefaultIdempotencyLease = 1 * time.Minute
+)
+
+var (
+	ErrIdempotencyKeyReused   = errors.New("idempotency key was used for a different request")
+	ErrIdempotencyKeyInFlight = errors.New("request with this idempotency key is in progress")
+)
+
+// WithIdempotencyTTL sets the time for which responses to requests made
+// with an idempotency key are kept
+func (d *DevAdm) WithIdempotencyTTL(ttl time.Duration) *DevAdm {
+	d.idempotencyTTL = ttl
+	return d
+}
+
+// WithIdempotencyLease sets the time for which a request made with an
+// idempotency key holds the key while it is processed; a retry made after
+// that time takes the key over
+func (d *DevAdm) WithIdempotencyLease(lease time.Duration) *DevAdm {
+	d.idempotencyLease = lease
+	return d
+}
+
+// newLeaseToken returns a random token identifying the holder of a lease,
+// e.g. an attempt to process a request made with an idempotency key
+func newLeaseToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (d *DevAdm) getIdempotencyLease() time.Duration {
+	if d.idempotencyLease == 0 {
+		return DefaultIdempotencyLease
+	}
+	return d.idempotencyLease
+}
+
+// BeginIdempotentRequest reserves idempotency key 'key' for a request of
+// hash 'hash'; if the request was already made, the recorded request is
+// returned so that its response can be replayed, otherwise the reserved
+// request is returned, the caller processes it while holding the key with
+// KeepIdempotentRequest and finishes with EndIdempotentRequest
+//
+// The key is only leased until EndIdempotentRequest records the response,
+// so that a request abandoned in the middle does not block the key for the
+// whole TTL; once the lease runs out, the next retry takes the key over.
+// Each attempt holds the key with its own lease token, an attempt which
+// lost the key can neither record its response nor release the key.
+func (d *DevAdm) BeginIdempotentRequest(ctx context.Context, key, hash string) (*model.IdempotentRequest, error) {
+	token, err := newLeaseToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate lease token")
+	}
+
+	lease := d.getIdempotencyLease()
+	now := d.clock.Now()
+
+	reserved := &model.IdempotentRequest{
+		Key:     key,
+		Hash:    hash,
+		Lease:   token,
+		Expires: now.Add(lease),
+	}
+
+	err = d.db.InsertIdempotentRequest(ctx, reserved)
+	switch err {
+	case nil:
+		return reserved, nil
+	case store.ErrDuplicate:
+		break
+	default:
+		return nil, errors.Wrap(err, "failed to reserve idempotency key")
+	}
+
+	req, err := d.db.GetIdempotentRequest(ctx, key)
+	switch err {
+	case nil:
+		break
+	case store.ErrNotFound:
+		// expired just now, the retry will succeed
+		return nil, ErrIdempotencyKeyInFlight
+	default:
+		return nil, errors.Wrap(err, "failed to fetch idempotency key")
+	}
+
+	if req.Hash != hash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if req.Done() {
+		return req, nil
+	}
+	if now.Before(req.Expires) {
+		return nil, ErrIdempotencyKeyInFlight
+	}
+
+	// the request holding the key was abandoned
+	err = d.db.TakeOverIdempotentRequest(ctx, req, reserved.Lease, reserved.Expires)
+	switch err {
+	case nil:
+		return reserved, nil
+	case store.ErrModified:
+		// another retry was faster
+		return nil, ErrIdempotencyKeyInFlight
+	default:
+		return nil, errors.Wrap(err, "failed to take over idempotency key")
+	}
+}
+
+// KeepIdempotentRequest renews the lease on request 'req' reserved with
+// BeginIdempotentRequest until the returned function is called, so that a
+// request processed for longer than the lease is not taken over by retries
+func (d *DevAdm) KeepIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) func() {
+	l := log.FromContext(ctx)
+	lease := d.getIdempotencyLease()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-d.clock.After(lease / 2):
+			}
+
+			err := d.db.RenewIdempotentRequest(ctx, req, d.clock.Now().Add(lease))
+			switch err {
+			case nil:
+				break
+			case store.ErrNotFound:
+				l.Warnf("idempotency key %q was taken over while the request was processed", req.Key)
+				return
+			default:
+				// try again, the lease is not over yet
+				l.Errorf("failed to renew idempotency key %q: %v", req.Key, err)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// EndIdempotentRequest records the response to request 'req' reserved with
+// BeginIdempotentRequest; server errors are not recorded, the key is
+// released instead so that the request can be retried
+func (d *DevAdm) EndIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error {
+	l := log.FromContext(ctx)
+
+	if req.StatusCode >= http.StatusInternalServerError {
+		err := d.db.DeleteIdempotentRequest(ctx, req.Key, req.Lease)
+		if err != nil && err != store.ErrNotFound {
+			return errors.Wrap(err, "failed to release idempotency key")
+		}
+		return nil
+	}
+
+	ttl := d.idempotencyTTL
+	if ttl == 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	req.Expires = d.clock.Now().Add(ttl)
+
+	err := d.db.UpdateIdempotentRequest(ctx, req)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		// expired or taken over while the request was processed, retries
+		// are going to be processed again
+		l.Warnf("idempotency key %q was lost before the response was recorded", req.Key)
+		return nil
+	default:
+		return errors.Wrap(err, "failed to record response")
+	}
+}
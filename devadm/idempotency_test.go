@@ -0,0 +1,357 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmBeginIdempotentRequest(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	done := &model.IdempotentRequest{
+		Key:        "key",
+		Hash:       "hash",
+		StatusCode: http.StatusCreated,
+		Body:       []byte(`{"id":"1"}`),
+	}
+
+	abandoned := &model.IdempotentRequest{
+		Key:     "key",
+		Hash:    "hash",
+		Expires: now,
+	}
+
+	testCases := map[string]struct {
+		lease time.Duration
+
+		insertErr   error
+		stored      *model.IdempotentRequest
+		getErr      error
+		takeOverErr error
+
+		outReq *model.IdempotentRequest
+		outErr string
+	}{
+		"first request": {},
+		"first request, custom lease": {
+			lease: 10 * time.Second,
+		},
+		"retry": {
+			insertErr: store.ErrDuplicate,
+			stored:    done,
+			outReq:    done,
+		},
+		"retry, different request": {
+			insertErr: store.ErrDuplicate,
+			stored: &model.IdempotentRequest{
+				Key:        "key",
+				Hash:       "other",
+				StatusCode: http.StatusCreated,
+			},
+			outErr: ErrIdempotencyKeyReused.Error(),
+		},
+		"retry, first request in progress": {
+			insertErr: store.ErrDuplicate,
+			stored: &model.IdempotentRequest{
+				Key:     "key",
+				Hash:    "hash",
+				Expires: now.Add(time.Second),
+			},
+			outErr: ErrIdempotencyKeyInFlight.Error(),
+		},
+		"retry, first request abandoned": {
+			insertErr: store.ErrDuplicate,
+			stored:    abandoned,
+		},
+		"retry, first request abandoned, taken over by another retry": {
+			insertErr:   store.ErrDuplicate,
+			stored:      abandoned,
+			takeOverErr: store.ErrModified,
+			outErr:      ErrIdempotencyKeyInFlight.Error(),
+		},
+		"retry, key expired": {
+			insertErr: store.ErrDuplicate,
+			getErr:    store.ErrNotFound,
+			outErr:    ErrIdempotencyKeyInFlight.Error(),
+		},
+		"error, insert": {
+			insertErr: errors.New("db connection failed"),
+			outErr:    "failed to reserve idempotency key: db connection failed",
+		},
+		"error, fetch": {
+			insertErr: store.ErrDuplicate,
+			getErr:    errors.New("db connection failed"),
+			outErr:    "failed to fetch idempotency key: db connection failed",
+		},
+		"error, take over": {
+			insertErr:   store.ErrDuplicate,
+			stored:      abandoned,
+			takeOverErr: errors.New("db connection failed"),
+			outErr:      "failed to take over idempotency key: db connection failed",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			lease := tc.lease
+			if lease == 0 {
+				lease = DefaultIdempotencyLease
+			}
+
+			var inserted, takenOver string
+
+			db := &mstore.DataStore{}
+			db.On("InsertIdempotentRequest", ctx,
+				mock.MatchedBy(func(req *model.IdempotentRequest) bool {
+					inserted = req.Lease
+					return req.Key == "key" &&
+						req.Hash == "hash" &&
+						len(req.Lease) == 32 &&
+						req.Expires.Equal(now.Add(lease))
+				})).
+				Return(tc.insertErr)
+			db.On("GetIdempotentRequest", ctx, "key").
+				Return(tc.stored, tc.getErr)
+			db.On("TakeOverIdempotentRequest", ctx, abandoned,
+				mock.MatchedBy(func(lease string) bool {
+					takenOver = lease
+					return true
+				}),
+				now.Add(lease)).
+				Return(tc.takeOverErr)
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			d := devadmForTest(db).(*DevAdm)
+			d.clock = clock
+			if tc.lease != 0 {
+				d.WithIdempotencyLease(tc.lease)
+			}
+
+			req, err := d.BeginIdempotentRequest(ctx, "key", "hash")
+			switch {
+			case tc.outErr != "":
+				assert.EqualError(t, err, tc.outErr)
+				assert.Nil(t, req)
+			case tc.outReq != nil:
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outReq, req)
+			default:
+				// reserved for this attempt, with the lease token
+				// stored last
+				assert.NoError(t, err)
+				lease := inserted
+				if tc.stored == abandoned {
+					lease = takenOver
+				}
+				assert.Equal(t, &model.IdempotentRequest{
+					Key:     "key",
+					Hash:    "hash",
+					Lease:   lease,
+					Expires: now.Add(d.getIdempotencyLease()),
+				}, req)
+			}
+
+			if tc.insertErr != store.ErrDuplicate {
+				db.AssertNotCalled(t, "GetIdempotentRequest", ctx, "key")
+			}
+			if tc.stored != abandoned {
+				db.AssertNotCalled(t, "TakeOverIdempotentRequest",
+					ctx, abandoned, mock.Anything, now.Add(lease))
+			}
+		})
+	}
+}
+
+func TestDevAdmEndIdempotentRequest(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	testCases := map[string]struct {
+		status int
+		ttl    time.Duration
+
+		updateErr error
+		deleteErr error
+
+		outErr string
+	}{
+		"success": {
+			status: http.StatusCreated,
+		},
+		"success, custom ttl": {
+			status: http.StatusCreated,
+			ttl:    time.Hour,
+		},
+		"client error is recorded": {
+			status: http.StatusConflict,
+		},
+		"key expired meanwhile": {
+			status:    http.StatusCreated,
+			updateErr: store.ErrNotFound,
+		},
+		"server error releases key": {
+			status: http.StatusInternalServerError,
+		},
+		"server error, key expired meanwhile": {
+			status:    http.StatusInternalServerError,
+			deleteErr: store.ErrNotFound,
+		},
+		"error, update": {
+			status:    http.StatusCreated,
+			updateErr: errors.New("db connection failed"),
+			outErr:    "failed to record response: db connection failed",
+		},
+		"error, delete": {
+			status:    http.StatusBadGateway,
+			deleteErr: errors.New("db connection failed"),
+			outErr:    "failed to release idempotency key: db connection failed",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			req := &model.IdempotentRequest{
+				Key:        "key",
+				Hash:       "hash",
+				Lease:      "lease-1",
+				StatusCode: tc.status,
+				Body:       []byte(`{}`),
+			}
+
+			ttl := tc.ttl
+			if ttl == 0 {
+				ttl = DefaultIdempotencyTTL
+			}
+
+			db := &mstore.DataStore{}
+			db.On("UpdateIdempotentRequest", ctx,
+				&model.IdempotentRequest{
+					Key:        "key",
+					Hash:       "hash",
+					Lease:      "lease-1",
+					StatusCode: tc.status,
+					Body:       []byte(`{}`),
+					Expires:    now.Add(ttl),
+				}).
+				Return(tc.updateErr)
+			db.On("DeleteIdempotentRequest", ctx, "key", "lease-1").
+				Return(tc.deleteErr)
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			d := devadmForTest(db).(*DevAdm)
+			d.clock = clock
+			if tc.ttl != 0 {
+				d.WithIdempotencyTTL(tc.ttl)
+			}
+
+			err := d.EndIdempotentRequest(ctx, req)
+			if tc.outErr != "" {
+				assert.EqualError(t, err, tc.outErr)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.status >= http.StatusInternalServerError {
+				db.AssertCalled(t, "DeleteIdempotentRequest", ctx, "key", "lease-1")
+				db.AssertNotCalled(t, "UpdateIdempotentRequest", ctx, req)
+			} else {
+				db.AssertCalled(t, "UpdateIdempotentRequest", ctx, req)
+				db.AssertNotCalled(t, "DeleteIdempotentRequest", ctx, "key", "lease-1")
+			}
+		})
+	}
+}
+
+func TestDevAdmKeepIdempotentRequest(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	testCases := map[string]struct {
+		renewErr error
+
+		ticks   int
+		renewed int
+	}{
+		"renewed while processed": {
+			ticks:   2,
+			renewed: 2,
+		},
+		"released before renewal": {},
+		"renewal failed, tried again": {
+			renewErr: errors.New("db connection failed"),
+			ticks:    2,
+			renewed:  2,
+		},
+		"taken over": {
+			renewErr: store.ErrNotFound,
+			ticks:    1,
+			renewed:  1,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			req := &model.IdempotentRequest{
+				Key:   "key",
+				Hash:  "hash",
+				Lease: "lease-1",
+			}
+
+			db := &mstore.DataStore{}
+			db.On("RenewIdempotentRequest", ctx, req,
+				now.Add(DefaultIdempotencyLease)).
+				Return(tc.renewErr)
+
+			ticks := make(chan time.Time)
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+			clock.On("After", DefaultIdempotencyLease/2).
+				Return(func(time.Duration) <-chan time.Time {
+					return ticks
+				})
+
+			d := devadmForTest(db).(*DevAdm)
+			d.clock = clock
+
+			release := d.KeepIdempotentRequest(ctx, req)
+			for i := 0; i < tc.ticks; i++ {
+				ticks <- now
+			}
+			release()
+
+			db.AssertNumberOfCalls(t, "RenewIdempotentRequest", tc.renewed)
+		})
+	}
+}
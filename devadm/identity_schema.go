@@ -0,0 +1,59 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+var (
+	ErrIdentitySchemaNotFound = errors.New("identity schema not set")
+)
+
+// GetIdentitySchema returns tenant's identity schema, or nil if the tenant
+// did not set one
+func (d *DevAdm) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	schema, err := d.db.GetIdentitySchema(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch identity schema")
+	}
+	return schema, nil
+}
+
+// SetIdentitySchema sets tenant's identity schema; auth sets stored
+// already are not checked against it
+func (d *DevAdm) SetIdentitySchema(ctx context.Context, schema model.IdentitySchema) error {
+	err := d.db.PutIdentitySchema(ctx, &schema)
+	if err != nil {
+		return errors.Wrap(err, "failed to update identity schema")
+	}
+	return nil
+}
+
+func (d *DevAdm) DeleteIdentitySchema(ctx context.Context) error {
+	err := d.db.DeleteIdentitySchema(ctx)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrIdentitySchemaNotFound
+	default:
+		return errors.Wrap(err, "failed to delete identity schema")
+	}
+}
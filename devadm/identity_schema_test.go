@@ -0,0 +1,102 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+)
+
+func TestDevAdmGetIdentitySchema(t *testing.T) {
+	testCases := map[string]struct {
+		schema *model.IdentitySchema
+		dbErr  error
+
+		outError error
+	}{
+		"ok": {
+			schema: &model.IdentitySchema{
+				Attributes: []model.AttributeSchema{{Name: "mac"}},
+			},
+		},
+		"ok, not set": {},
+		"error": {
+			dbErr:    errors.New("db connection failed"),
+			outError: errors.New("failed to fetch identity schema: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("GetIdentitySchema", ctx).Return(tc.schema, tc.dbErr)
+
+			d := devadmForTest(db)
+
+			schema, err := d.GetIdentitySchema(ctx)
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Nil(t, schema)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.schema, schema)
+			}
+		})
+	}
+}
+
+func TestDevAdmDeleteIdentitySchema(t *testing.T) {
+	testCases := map[string]struct {
+		dbErr error
+
+		outError error
+	}{
+		"ok": {},
+		"error: not set": {
+			dbErr:    store.ErrNotFound,
+			outError: ErrIdentitySchemaNotFound,
+		},
+		"error": {
+			dbErr:    errors.New("db connection failed"),
+			outError: errors.New("failed to delete identity schema: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("DeleteIdentitySchema", ctx).Return(tc.dbErr)
+
+			d := devadmForTest(db)
+
+			err := d.DeleteIdentitySchema(ctx)
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+var (
+	ErrManifestKeyNotFound = errors.New("manifest key not found")
+	ErrManifestKeyExists   = errors.New("manifest key is already registered")
+	ErrManifestSignature   = errors.New("manifest signature does not match any registered key")
+	ErrManifestReplayed    = errors.New("manifest was already processed")
+)
+
+func (d *DevAdm) AddManifestKey(ctx context.Context, req model.ManifestKeyReq) (*model.ManifestKey, error) {
+	key := req.Key()
+	now := d.clock.Now()
+	key.Created = &now
+
+	err := d.db.InsertManifestKey(ctx, key)
+	switch err {
+	case nil:
+		return key, nil
+	case store.ErrDuplicate:
+		return nil, ErrManifestKeyExists
+	default:
+		return nil, errors.Wrap(err, "failed to add manifest key")
+	}
+}
+
+func (d *DevAdm) ListManifestKeys(ctx context.Context) ([]model.ManifestKey, error) {
+	keys, err := d.db.GetManifestKeys(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch manifest keys")
+	}
+	return keys, nil
+}
+
+func (d *DevAdm) DeleteManifestKey(ctx context.Context, id string) error {
+	err := d.db.DeleteManifestKey(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrManifestKeyNotFound
+	default:
+		return errors.Wrap(err, "failed to delete manifest key")
+	}
+}
+
+// PreauthorizeManifest verifies signed manufacturing manifest 'm' and
+// preauthorizes its auth sets; a manifest is processed only once, even if
+// preauthorizing some of its auth sets failed
+func (d *DevAdm) PreauthorizeManifest(ctx context.Context, m *model.SignedManifest, authorizationHeader string) (*model.PreauthReport, error) {
+	keys, err := d.db.GetManifestKeys(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch manifest keys")
+	}
+
+	key := m.Verify(keys)
+	if key == nil {
+		return nil, ErrManifestSignature
+	}
+
+	// record the manifest first, so that concurrent uploads of the same
+	// manifest are rejected too
+	now := d.clock.Now()
+	err = d.db.InsertManifestRecord(ctx, &model.ManifestRecord{
+		ID:      m.Decoded.ID,
+		KeyID:   key.ID,
+		Devices: len(m.Rows),
+		Created: &now,
+	})
+	switch err {
+	case nil:
+	case store.ErrDuplicate:
+		return nil, ErrManifestReplayed
+	default:
+		return nil, errors.Wrap(err, "failed to record manifest")
+	}
+
+	log.FromContext(ctx).Infof("preauthorizing %d auth sets of manifest %s signed with key %s",
+		len(m.Rows), m.Decoded.ID, key.ID)
+
+	return d.PreauthorizeDevices(ctx, m.Rows, authorizationHeader)
+}
@@ -0,0 +1,242 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+// makeManifestKey generates an Ed25519 key pair, the public key is PEM
+// encoded
+func makeManifestKey(t *testing.T) (string, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.NoError(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	})), priv
+}
+
+// signManifest signs manifest 'm' with 'key' and parses the upload
+func signManifest(t *testing.T, m interface{}, key ed25519.PrivateKey) *model.SignedManifest {
+	doc, err := json.Marshal(m)
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(map[string]string{
+		"manifest":  base64.StdEncoding.EncodeToString(doc),
+		"signature": base64.StdEncoding.EncodeToString(ed25519.Sign(key, doc)),
+	})
+	assert.NoError(t, err)
+
+	signed, err := model.ParseSignedManifest(bytes.NewReader(body), nil)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestDevAdmPreauthorizeManifest(t *testing.T) {
+	otherKey, _ := makeManifestKey(t)
+	factoryKey, factoryPriv := makeManifestKey(t)
+	_, unknownPriv := makeManifestKey(t)
+
+	keys := []model.ManifestKey{
+		{ID: "key-1", PublicKey: otherKey},
+		{ID: "key-2", PublicKey: factoryKey},
+	}
+
+	manifest := map[string]interface{}{
+		"id": "manifest-1",
+		"devices": []map[string]string{
+			{"device_identity": `{"sn":"0001"}`, "key": "key-0001"},
+			{"device_identity": `{"sn":"0002"}`},
+		},
+	}
+
+	testCases := map[string]struct {
+		signer    ed25519.PrivateKey
+		keysErr   error
+		recordErr error
+
+		outReport *model.PreauthReport
+		outError  error
+	}{
+		"ok": {
+			signer: factoryPriv,
+			outReport: &model.PreauthReport{
+				Preauthorized: 1,
+				Invalid:       1,
+				Results: []model.PreauthResult{
+					{
+						Row:      1,
+						DeviceId: `{"sn":"0001"}`,
+						Result:   model.PreauthResultPreauthorized,
+					},
+					{
+						Row:      2,
+						DeviceId: `{"sn":"0002"}`,
+						Result:   model.PreauthResultInvalid,
+						Error:    "key: non zero value required",
+					},
+				},
+			},
+		},
+		"error: unknown key": {
+			signer:   unknownPriv,
+			outError: ErrManifestSignature,
+		},
+		"error: replayed": {
+			signer:    factoryPriv,
+			recordErr: store.ErrDuplicate,
+			outError:  ErrManifestReplayed,
+		},
+		"error: keys": {
+			signer:   factoryPriv,
+			keysErr:  errors.New("db connection failed"),
+			outError: errors.New("failed to fetch manifest keys: db connection failed"),
+		},
+		"error: record": {
+			signer:    factoryPriv,
+			recordErr: errors.New("db connection failed"),
+			outError:  errors.New("failed to record manifest: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			now := time.Now()
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			db := &mstore.DataStore{}
+			db.On("GetManifestKeys", ctx).
+				Return(keys, tc.keysErr)
+			if tc.keysErr == nil && tc.outError != ErrManifestSignature {
+				db.On("InsertManifestRecord", ctx, &model.ManifestRecord{
+					ID:      "manifest-1",
+					KeyID:   "key-2",
+					Devices: 2,
+					Created: &now,
+				}).Return(tc.recordErr)
+			}
+			if tc.outReport != nil {
+				db.On("GetDeviceAuthsByIdentityData", ctx, `{"sn":"0001"}`).
+					Return([]model.DeviceAuth{}, nil)
+				db.On("GetBlocklist", ctx).
+					Return([]model.BlocklistEntry{}, nil)
+				db.On("InsertDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth")).
+					Return(nil)
+			}
+
+			d := &DevAdm{
+				db: db,
+				clientGetter: func() client.HttpRunner {
+					return FakeApiRequester{http.StatusCreated}
+				},
+				clock: clock,
+			}
+
+			report, err := d.PreauthorizeManifest(ctx,
+				signManifest(t, manifest, tc.signer), "Bearer foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outReport, report)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDevAdmAddManifestKey(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now()
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(now)
+
+	pub, _ := makeManifestKey(t)
+
+	req := model.ManifestKeyReq{PublicKey: "foo"}
+	assert.EqualError(t, req.Validate(), "no PEM encoded public key found")
+
+	req = model.ManifestKeyReq{PublicKey: pub, Description: "factory 1"}
+	assert.NoError(t, req.Validate())
+
+	db := &mstore.DataStore{}
+	db.On("InsertManifestKey", ctx,
+		mock.AnythingOfType("*model.ManifestKey")).
+		Return(nil).Once()
+	db.On("InsertManifestKey", ctx,
+		mock.AnythingOfType("*model.ManifestKey")).
+		Return(store.ErrDuplicate).Once()
+
+	d := &DevAdm{db: db, clock: clock}
+
+	key, err := d.AddManifestKey(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, pub, key.PublicKey)
+	assert.Equal(t, model.KeyFingerprint(pub), key.Fingerprint)
+	assert.Equal(t, "factory 1", key.Description)
+	assert.Equal(t, &now, key.Created)
+
+	_, err = d.AddManifestKey(ctx, req)
+	assert.Equal(t, ErrManifestKeyExists, err)
+	db.AssertExpectations(t)
+}
+
+func TestDevAdmDeleteManifestKey(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("DeleteManifestKey", ctx, "1").
+		Return(nil)
+	db.On("DeleteManifestKey", ctx, "2").
+		Return(store.ErrNotFound)
+	db.On("DeleteManifestKey", ctx, "3").
+		Return(errors.New("db connection failed"))
+
+	d := &DevAdm{db: db}
+
+	assert.NoError(t, d.DeleteManifestKey(ctx, "1"))
+	assert.Equal(t, ErrManifestKeyNotFound, d.DeleteManifestKey(ctx, "2"))
+	assert.EqualError(t, d.DeleteManifestKey(ctx, "3"),
+		"failed to delete manifest key: db connection failed")
+}
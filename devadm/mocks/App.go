@@ -52,6 +52,216 @@ func (_m *App) AcceptDevicePreAuth(ctx context.Context, id model.AuthID) error {
 	return r0
 }
 
+// AddManifestKey provides a mock function with given fields: ctx, req
+func (_m *App) AddManifestKey(ctx context.Context, req model.ManifestKeyReq) (*model.ManifestKey, error) {
+	ret := _m.Called(ctx, req)
+
+	var r0 *model.ManifestKey
+	if rf, ok := ret.Get(0).(func(context.Context, model.ManifestKeyReq) *model.ManifestKey); ok {
+		r0 = rf(ctx, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.ManifestKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.ManifestKeyReq) error); ok {
+		r1 = rf(ctx, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddNote provides a mock function with given fields: ctx, id, req
+func (_m *App) AddNote(ctx context.Context, id model.AuthID, req model.NoteReq) (*model.Note, error) {
+	ret := _m.Called(ctx, id, req)
+
+	var r0 *model.Note
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID, model.NoteReq) *model.Note); ok {
+		r0 = rf(ctx, id, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Note)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID, model.NoteReq) error); ok {
+		r1 = rf(ctx, id, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// AddTrustedCA provides a mock function with given fields: ctx, req
+func (_m *App) AddTrustedCA(ctx context.Context, req model.TrustedCAReq) (*model.TrustedCA, error) {
+	ret := _m.Called(ctx, req)
+
+	var r0 *model.TrustedCA
+	if rf, ok := ret.Get(0).(func(context.Context, model.TrustedCAReq) *model.TrustedCA); ok {
+		r0 = rf(ctx, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TrustedCA)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.TrustedCAReq) error); ok {
+		r1 = rf(ctx, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BeginIdempotentRequest provides a mock function with given fields: ctx, key, hash
+func (_m *App) BeginIdempotentRequest(ctx context.Context, key string, hash string) (*model.IdempotentRequest, error) {
+	ret := _m.Called(ctx, key, hash)
+
+	var r0 *model.IdempotentRequest
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *model.IdempotentRequest); ok {
+		r0 = rf(ctx, key, hash)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IdempotentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, key, hash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountDeviceAuths provides a mock function with given fields: ctx, filter
+func (_m *App) CountDeviceAuths(ctx context.Context, filter store.Filter) (int, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, store.Filter) int); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, store.Filter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateBlocklistEntry provides a mock function with given fields: ctx, req
+func (_m *App) CreateBlocklistEntry(ctx context.Context, req model.BlocklistEntryReq) (*model.BlocklistEntry, error) {
+	ret := _m.Called(ctx, req)
+
+	var r0 *model.BlocklistEntry
+	if rf, ok := ret.Get(0).(func(context.Context, model.BlocklistEntryReq) *model.BlocklistEntry); ok {
+		r0 = rf(ctx, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.BlocklistEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.BlocklistEntryReq) error); ok {
+		r1 = rf(ctx, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateEnrollmentToken provides a mock function with given fields: ctx, req
+func (_m *App) CreateEnrollmentToken(ctx context.Context, req model.EnrollmentTokenReq) (*model.EnrollmentToken, error) {
+	ret := _m.Called(ctx, req)
+
+	var r0 *model.EnrollmentToken
+	if rf, ok := ret.Get(0).(func(context.Context, model.EnrollmentTokenReq) *model.EnrollmentToken); ok {
+		r0 = rf(ctx, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.EnrollmentToken)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.EnrollmentTokenReq) error); ok {
+		r1 = rf(ctx, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateWebhook provides a mock function with given fields: ctx, req
+func (_m *App) CreateWebhook(ctx context.Context, req model.WebhookReq) (*model.Webhook, error) {
+	ret := _m.Called(ctx, req)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context, model.WebhookReq) *model.Webhook); ok {
+		r0 = rf(ctx, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.WebhookReq) error); ok {
+		r1 = rf(ctx, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteAllowlistEntry provides a mock function with given fields: ctx, id
+func (_m *App) DeleteAllowlistEntry(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteBlocklistEntry provides a mock function with given fields: ctx, id
+func (_m *App) DeleteBlocklistEntry(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteDeviceAuth provides a mock function with given fields: ctx, id
 func (_m *App) DeleteDeviceAuth(ctx context.Context, id model.AuthID) error {
 	ret := _m.Called(ctx, id)
@@ -94,6 +304,76 @@ func (_m *App) DeleteDeviceData(ctx context.Context, id model.DeviceID) error {
 	return r0
 }
 
+// DeleteIdentitySchema provides a mock function with given fields: ctx
+func (_m *App) DeleteIdentitySchema(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteManifestKey provides a mock function with given fields: ctx, id
+func (_m *App) DeleteManifestKey(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteTrustedCA provides a mock function with given fields: ctx, id
+func (_m *App) DeleteTrustedCA(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, id
+func (_m *App) DeleteWebhook(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// EndIdempotentRequest provides a mock function with given fields: ctx, req
+func (_m *App) EndIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error {
+	ret := _m.Called(ctx, req)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IdempotentRequest) error); ok {
+		r0 = rf(ctx, req)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // GetDeviceAuth provides a mock function with given fields: ctx, id
 func (_m *App) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error) {
 	ret := _m.Called(ctx, id)
@@ -117,22 +397,22 @@ func (_m *App) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.Devic
 	return r0, r1
 }
 
-// ListDeviceAuths provides a mock function with given fields: ctx, skip, limit, filter
-func (_m *App) ListDeviceAuths(ctx context.Context, skip int, limit int, filter store.Filter) ([]model.DeviceAuth, error) {
-	ret := _m.Called(ctx, skip, limit, filter)
+// GetDeviceAuthDetails provides a mock function with given fields: ctx, id
+func (_m *App) GetDeviceAuthDetails(ctx context.Context, id model.AuthID) (*model.DeviceAuthDetails, error) {
+	ret := _m.Called(ctx, id)
 
-	var r0 []model.DeviceAuth
-	if rf, ok := ret.Get(0).(func(context.Context, int, int, store.Filter) []model.DeviceAuth); ok {
-		r0 = rf(ctx, skip, limit, filter)
+	var r0 *model.DeviceAuthDetails
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) *model.DeviceAuthDetails); ok {
+		r0 = rf(ctx, id)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]model.DeviceAuth)
+			r0 = ret.Get(0).(*model.DeviceAuthDetails)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, int, int, store.Filter) error); ok {
-		r1 = rf(ctx, skip, limit, filter)
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
+		r1 = rf(ctx, id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -140,55 +420,726 @@ func (_m *App) ListDeviceAuths(ctx context.Context, skip int, limit int, filter
 	return r0, r1
 }
 
-// PreauthorizeDevice provides a mock function with given fields: ctx, authSet, authorizationHeader
-func (_m *App) PreauthorizeDevice(ctx context.Context, authSet model.AuthSet, authorizationHeader string) error {
-	ret := _m.Called(ctx, authSet, authorizationHeader)
+// GetIdentitySchema provides a mock function with given fields: ctx
+func (_m *App) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	ret := _m.Called(ctx)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.AuthSet, string) error); ok {
-		r0 = rf(ctx, authSet, authorizationHeader)
+	var r0 *model.IdentitySchema
+	if rf, ok := ret.Get(0).(func(context.Context) *model.IdentitySchema); ok {
+		r0 = rf(ctx)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IdentitySchema)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// ProvisionTenant provides a mock function with given fields: ctx, tenant_id
-func (_m *App) ProvisionTenant(ctx context.Context, tenant_id string) error {
-	ret := _m.Called(ctx, tenant_id)
+// GetQuotaUsage provides a mock function with given fields: ctx
+func (_m *App) GetQuotaUsage(ctx context.Context) (*model.QuotaUsage, error) {
+	ret := _m.Called(ctx)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, tenant_id)
+	var r0 *model.QuotaUsage
+	if rf, ok := ret.Get(0).(func(context.Context) *model.QuotaUsage); ok {
+		r0 = rf(ctx)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.QuotaUsage)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// RejectDeviceAuth provides a mock function with given fields: ctx, id
-func (_m *App) RejectDeviceAuth(ctx context.Context, id model.AuthID) error {
-	ret := _m.Called(ctx, id)
+// GetSettings provides a mock function with given fields: ctx
+func (_m *App) GetSettings(ctx context.Context) (*model.TenantSettings, error) {
+	ret := _m.Called(ctx)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) error); ok {
-		r0 = rf(ctx, id)
+	var r0 *model.TenantSettings
+	if rf, ok := ret.Get(0).(func(context.Context) *model.TenantSettings); ok {
+		r0 = rf(ctx)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TenantSettings)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// SubmitDeviceAuth provides a mock function with given fields: ctx, d
-func (_m *App) SubmitDeviceAuth(ctx context.Context, d model.DeviceAuth) error {
-	ret := _m.Called(ctx, d)
+// GetWebhook provides a mock function with given fields: ctx, id
+func (_m *App) GetWebhook(ctx context.Context, id string) (*model.Webhook, error) {
+	ret := _m.Called(ctx, id)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.DeviceAuth) error); ok {
-		r0 = rf(ctx, d)
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Webhook); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImportAllowlist provides a mock function with given fields: ctx, batch, singleUse, tags, attrs
+func (_m *App) ImportAllowlist(ctx context.Context, batch string, singleUse bool, tags []string, attrs []model.DeviceAuthAttributes) ([]model.AllowlistEntry, error) {
+	ret := _m.Called(ctx, batch, singleUse, tags, attrs)
+
+	var r0 []model.AllowlistEntry
+	if rf, ok := ret.Get(0).(func(context.Context, string, bool, []string, []model.DeviceAuthAttributes) []model.AllowlistEntry); ok {
+		r0 = rf(ctx, batch, singleUse, tags, attrs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AllowlistEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, bool, []string, []model.DeviceAuthAttributes) error); ok {
+		r1 = rf(ctx, batch, singleUse, tags, attrs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// KeepIdempotentRequest provides a mock function with given fields: ctx, req
+func (_m *App) KeepIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) func() {
+	ret := _m.Called(ctx, req)
+
+	var r0 func()
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IdempotentRequest) func()); ok {
+		r0 = rf(ctx, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(func())
+		}
+	}
+
+	return r0
+}
+
+// ListAllowlist provides a mock function with given fields: ctx, batch, skip, limit
+func (_m *App) ListAllowlist(ctx context.Context, batch string, skip int, limit int) ([]model.AllowlistEntry, error) {
+	ret := _m.Called(ctx, batch, skip, limit)
+
+	var r0 []model.AllowlistEntry
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int) []model.AllowlistEntry); ok {
+		r0 = rf(ctx, batch, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.AllowlistEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, int) error); ok {
+		r1 = rf(ctx, batch, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListApprovals provides a mock function with given fields: ctx, id
+func (_m *App) ListApprovals(ctx context.Context, id model.AuthID) ([]model.Approval, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 []model.Approval
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) []model.Approval); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Approval)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListBlocklist provides a mock function with given fields: ctx
+func (_m *App) ListBlocklist(ctx context.Context) ([]model.BlocklistEntry, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.BlocklistEntry
+	if rf, ok := ret.Get(0).(func(context.Context) []model.BlocklistEntry); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.BlocklistEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListBlocklistHits provides a mock function with given fields: ctx, skip, limit
+func (_m *App) ListBlocklistHits(ctx context.Context, skip int, limit int) ([]model.BlocklistHit, error) {
+	ret := _m.Called(ctx, skip, limit)
+
+	var r0 []model.BlocklistHit
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) []model.BlocklistHit); ok {
+		r0 = rf(ctx, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.BlocklistHit)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListDeletedDeviceAuths provides a mock function with given fields: ctx, skip, limit
+func (_m *App) ListDeletedDeviceAuths(ctx context.Context, skip int, limit int) ([]model.DeviceAuth, error) {
+	ret := _m.Called(ctx, skip, limit)
+
+	var r0 []model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) []model.DeviceAuth); ok {
+		r0 = rf(ctx, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListDeviceAuths provides a mock function with given fields: ctx, skip, limit, filter
+func (_m *App) ListDeviceAuths(ctx context.Context, skip int, limit int, filter store.Filter) ([]model.DeviceAuth, error) {
+	ret := _m.Called(ctx, skip, limit, filter)
+
+	var r0 []model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, store.Filter) []model.DeviceAuth); ok {
+		r0 = rf(ctx, skip, limit, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int, store.Filter) error); ok {
+		r1 = rf(ctx, skip, limit, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListEnrollmentTokens provides a mock function with given fields: ctx
+func (_m *App) ListEnrollmentTokens(ctx context.Context) ([]model.EnrollmentToken, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.EnrollmentToken
+	if rf, ok := ret.Get(0).(func(context.Context) []model.EnrollmentToken); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.EnrollmentToken)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListManifestKeys provides a mock function with given fields: ctx
+func (_m *App) ListManifestKeys(ctx context.Context) ([]model.ManifestKey, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.ManifestKey
+	if rf, ok := ret.Get(0).(func(context.Context) []model.ManifestKey); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ManifestKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListNotes provides a mock function with given fields: ctx, id
+func (_m *App) ListNotes(ctx context.Context, id model.AuthID) ([]model.Note, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 []model.Note
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) []model.Note); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Note)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListTrustedCAs provides a mock function with given fields: ctx
+func (_m *App) ListTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.TrustedCA
+	if rf, ok := ret.Get(0).(func(context.Context) []model.TrustedCA); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.TrustedCA)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListWebhookDeliveries provides a mock function with given fields: ctx, id, skip, limit
+func (_m *App) ListWebhookDeliveries(ctx context.Context, id string, skip int, limit int) ([]model.WebhookDelivery, error) {
+	ret := _m.Called(ctx, id, skip, limit)
+
+	var r0 []model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int) []model.WebhookDelivery); ok {
+		r0 = rf(ctx, id, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, int) error); ok {
+		r1 = rf(ctx, id, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListWebhooks provides a mock function with given fields: ctx
+func (_m *App) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context) []model.Webhook); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// NextStatuses provides a mock function with given fields: ctx, dev
+func (_m *App) NextStatuses(ctx context.Context, dev *model.DeviceAuth) ([]string, error) {
+	ret := _m.Called(ctx, dev)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) []string); ok {
+		r0 = rf(ctx, dev)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.DeviceAuth) error); ok {
+		r1 = rf(ctx, dev)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PatchDeviceAuth provides a mock function with given fields: ctx, id, patch
+func (_m *App) PatchDeviceAuth(ctx context.Context, id model.AuthID, patch model.DeviceAuthPatch) (*model.DeviceAuth, error) {
+	ret := _m.Called(ctx, id, patch)
+
+	var r0 *model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID, model.DeviceAuthPatch) *model.DeviceAuth); ok {
+		r0 = rf(ctx, id, patch)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID, model.DeviceAuthPatch) error); ok {
+		r1 = rf(ctx, id, patch)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PreauthorizeDevice provides a mock function with given fields: ctx, authSet, authorizationHeader
+func (_m *App) PreauthorizeDevice(ctx context.Context, authSet model.AuthSet, authorizationHeader string) error {
+	ret := _m.Called(ctx, authSet, authorizationHeader)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthSet, string) error); ok {
+		r0 = rf(ctx, authSet, authorizationHeader)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PreauthorizeDevices provides a mock function with given fields: ctx, rows, authorizationHeader
+func (_m *App) PreauthorizeDevices(ctx context.Context, rows []model.AuthSetRow, authorizationHeader string) (*model.PreauthReport, error) {
+	ret := _m.Called(ctx, rows, authorizationHeader)
+
+	var r0 *model.PreauthReport
+	if rf, ok := ret.Get(0).(func(context.Context, []model.AuthSetRow, string) *model.PreauthReport); ok {
+		r0 = rf(ctx, rows, authorizationHeader)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.PreauthReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []model.AuthSetRow, string) error); ok {
+		r1 = rf(ctx, rows, authorizationHeader)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PreauthorizeManifest provides a mock function with given fields: ctx, m, authorizationHeader
+func (_m *App) PreauthorizeManifest(ctx context.Context, m *model.SignedManifest, authorizationHeader string) (*model.PreauthReport, error) {
+	ret := _m.Called(ctx, m, authorizationHeader)
+
+	var r0 *model.PreauthReport
+	if rf, ok := ret.Get(0).(func(context.Context, *model.SignedManifest, string) *model.PreauthReport); ok {
+		r0 = rf(ctx, m, authorizationHeader)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.PreauthReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *model.SignedManifest, string) error); ok {
+		r1 = rf(ctx, m, authorizationHeader)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ProvisionTenant provides a mock function with given fields: ctx, tenant_id
+func (_m *App) ProvisionTenant(ctx context.Context, tenant_id string) error {
+	ret := _m.Called(ctx, tenant_id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, tenant_id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RejectDeviceAuth provides a mock function with given fields: ctx, id
+func (_m *App) RejectDeviceAuth(ctx context.Context, id model.AuthID) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreDeviceAuth provides a mock function with given fields: ctx, id, authorizationHeader
+func (_m *App) RestoreDeviceAuth(ctx context.Context, id model.AuthID, authorizationHeader string) (*model.DeviceAuth, error) {
+	ret := _m.Called(ctx, id, authorizationHeader)
+
+	var r0 *model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID, string) *model.DeviceAuth); ok {
+		r0 = rf(ctx, id, authorizationHeader)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID, string) error); ok {
+		r1 = rf(ctx, id, authorizationHeader)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeEnrollmentToken provides a mock function with given fields: ctx, id
+func (_m *App) RevokeEnrollmentToken(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetIdentitySchema provides a mock function with given fields: ctx, schema
+func (_m *App) SetIdentitySchema(ctx context.Context, schema model.IdentitySchema) error {
+	ret := _m.Called(ctx, schema)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.IdentitySchema) error); ok {
+		r0 = rf(ctx, schema)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetQuota provides a mock function with given fields: ctx, tenantID, quota
+func (_m *App) SetQuota(ctx context.Context, tenantID string, quota model.Quota) error {
+	ret := _m.Called(ctx, tenantID, quota)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Quota) error); ok {
+		r0 = rf(ctx, tenantID, quota)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SubmitDeviceAuth provides a mock function with given fields: ctx, d
+func (_m *App) SubmitDeviceAuth(ctx context.Context, d model.DeviceAuth) error {
+	ret := _m.Called(ctx, d)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeviceAuth) error); ok {
+		r0 = rf(ctx, d)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SuspendDeviceAuth provides a mock function with given fields: ctx, id
+func (_m *App) SuspendDeviceAuth(ctx context.Context, id model.AuthID) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TagDeviceAuth provides a mock function with given fields: ctx, id, tags
+func (_m *App) TagDeviceAuth(ctx context.Context, id model.AuthID, tags []string) error {
+	ret := _m.Called(ctx, id, tags)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID, []string) error); ok {
+		r0 = rf(ctx, id, tags)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UntagDeviceAuth provides a mock function with given fields: ctx, id, tag
+func (_m *App) UntagDeviceAuth(ctx context.Context, id model.AuthID, tag string) error {
+	ret := _m.Called(ctx, id, tag)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID, string) error); ok {
+		r0 = rf(ctx, id, tag)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateSettings provides a mock function with given fields: ctx, settings
+func (_m *App) UpdateSettings(ctx context.Context, settings model.TenantSettings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.TenantSettings) error); ok {
+		r0 = rf(ctx, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateTags provides a mock function with given fields: ctx, req
+func (_m *App) UpdateTags(ctx context.Context, req model.TagsReq) (*model.TagsResult, error) {
+	ret := _m.Called(ctx, req)
+
+	var r0 *model.TagsResult
+	if rf, ok := ret.Get(0).(func(context.Context, model.TagsReq) *model.TagsResult); ok {
+		r0 = rf(ctx, req)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TagsResult)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.TagsReq) error); ok {
+		r1 = rf(ctx, req)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// WatchEvents provides a mock function with given fields: ctx, lastID, fn
+func (_m *App) WatchEvents(ctx context.Context, lastID int64, fn func(ev *model.Event) error) error {
+	ret := _m.Called(ctx, lastID, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, func(ev *model.Event) error) error); ok {
+		r0 = rf(ctx, lastID, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WithdrawApproval provides a mock function with given fields: ctx, id
+func (_m *App) WithdrawApproval(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -0,0 +1,84 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+)
+
+var (
+	ErrNoNoteAuthor = errors.New("note author identity is unknown")
+)
+
+// AddNote attaches the calling user's note to auth set 'id'; returns
+// store.ErrNotFound if there is no such auth set
+func (d *DevAdm) AddNote(ctx context.Context, id model.AuthID, req model.NoteReq) (*model.Note, error) {
+	idty := identity.FromContext(ctx)
+	if idty == nil || idty.Subject == "" {
+		return nil, ErrNoNoteAuthor
+	}
+
+	if _, err := d.db.GetDeviceAuth(ctx, id); err != nil {
+		return nil, err
+	}
+
+	now := d.clock.Now()
+	note := &model.Note{
+		AuthID:  id,
+		Author:  idty.Subject,
+		Text:    req.Text,
+		Created: &now,
+	}
+	if err := d.db.InsertNote(ctx, note); err != nil {
+		return nil, errors.Wrap(err, "failed to store note")
+	}
+	return note, nil
+}
+
+// ListNotes returns notes of auth set 'id', oldest first; returns
+// store.ErrNotFound if there is no such auth set
+func (d *DevAdm) ListNotes(ctx context.Context, id model.AuthID) ([]model.Note, error) {
+	if _, err := d.db.GetDeviceAuth(ctx, id); err != nil {
+		return nil, err
+	}
+
+	notes, err := d.db.GetNotes(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch notes")
+	}
+	return notes, nil
+}
+
+// GetDeviceAuthDetails returns auth set 'id' along with its notes
+func (d *DevAdm) GetDeviceAuthDetails(ctx context.Context, id model.AuthID) (*model.DeviceAuthDetails, error) {
+	dev, err := d.db.GetDeviceAuth(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := d.db.GetNotes(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch notes")
+	}
+
+	return &model.DeviceAuthDetails{
+		DeviceAuth: *dev,
+		Notes:      notes,
+	}, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmAddNote(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+
+	testCases := map[string]struct {
+		subject   string
+		getErr    error
+		insertErr error
+
+		outNote  *model.Note
+		outError error
+	}{
+		"ok": {
+			subject: "user-1",
+			outNote: &model.Note{
+				ID:      "note-1",
+				AuthID:  "foo",
+				Author:  "user-1",
+				Text:    "called customer, legit",
+				Created: &now,
+			},
+		},
+		"error: no author": {
+			outError: ErrNoNoteAuthor,
+		},
+		"error: auth set not found": {
+			subject:  "user-1",
+			getErr:   store.ErrNotFound,
+			outError: store.ErrNotFound,
+		},
+		"error: db": {
+			subject:   "user-1",
+			insertErr: errors.New("db connection failed"),
+			outError:  errors.New("failed to store note: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.subject != "" {
+				ctx = identity.WithContext(ctx, &identity.Identity{
+					Subject: tc.subject,
+				})
+			}
+
+			db := &mstore.DataStore{}
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(&model.DeviceAuth{ID: "foo"}, tc.getErr)
+			db.On("InsertNote", ctx,
+				mock.MatchedBy(func(n *model.Note) bool {
+					return n.AuthID == "foo" && n.Author == tc.subject &&
+						n.Text == "called customer, legit" &&
+						n.Created.Equal(now)
+				})).
+				Run(func(args mock.Arguments) {
+					args.Get(1).(*model.Note).ID = "note-1"
+				}).
+				Return(tc.insertErr)
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			d := devadmForTest(db).(*DevAdm)
+			d.clock = clock
+
+			note, err := d.AddNote(ctx, "foo",
+				model.NoteReq{Text: "called customer, legit"})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Nil(t, note)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outNote, note)
+			}
+		})
+	}
+}
+
+func TestDevAdmListNotes(t *testing.T) {
+	notes := []model.Note{
+		{ID: "1", AuthID: "foo", Author: "user-1", Text: "called customer"},
+		{ID: "2", AuthID: "foo", Author: "user-2", Text: "legit"},
+	}
+
+	testCases := map[string]struct {
+		getErr   error
+		notesErr error
+
+		outError error
+	}{
+		"ok": {},
+		"error: auth set not found": {
+			getErr:   store.ErrNotFound,
+			outError: store.ErrNotFound,
+		},
+		"error: db": {
+			notesErr: errors.New("db connection failed"),
+			outError: errors.New("failed to fetch notes: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(&model.DeviceAuth{ID: "foo"}, tc.getErr)
+			db.On("GetNotes", ctx, model.AuthID("foo")).
+				Return(notes, tc.notesErr)
+
+			d := devadmForTest(db)
+
+			out, err := d.ListNotes(ctx, "foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Nil(t, out)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, notes, out)
+			}
+
+			out2, err := d.GetDeviceAuthDetails(ctx, "foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Nil(t, out2)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, &model.DeviceAuthDetails{
+					DeviceAuth: model.DeviceAuth{ID: "foo"},
+					Notes:      notes,
+				}, out2)
+			}
+		})
+	}
+}
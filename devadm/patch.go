@@ -0,0 +1,98 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	"github.com/mendersoftware/deviceadm/utils"
+)
+
+var (
+	ErrNoAttributesLeft = errors.New("at least one identity attribute is required")
+)
+
+// PatchDeviceAuth merges 'patch' into auth set 'id' and returns the
+// patched auth set; patched attributes must not be empty and must satisfy
+// the tenant's identity schema, a schema violation is returned as a usage
+// error. Returns
+// store.ErrNotFound if there is no such auth set.
+func (d *DevAdm) PatchDeviceAuth(ctx context.Context, id model.AuthID, patch model.DeviceAuthPatch) (*model.DeviceAuth, error) {
+	var author string
+	if len(patch.Notes) != 0 {
+		idty := identity.FromContext(ctx)
+		if idty == nil || idty.Subject == "" {
+			return nil, ErrNoNoteAuthor
+		}
+		author = idty.Subject
+	}
+
+	dev, err := d.db.GetDeviceAuth(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRevision(ctx, dev); err != nil {
+		return nil, err
+	}
+
+	patch.Apply(dev)
+
+	if len(patch.Attributes) != 0 {
+		if len(dev.Attributes) == 0 {
+			return nil, ErrNoAttributesLeft
+		}
+
+		schema, err := d.GetIdentitySchema(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if schema != nil {
+			if err := schema.Check(dev.Attributes); err != nil {
+				return nil, utils.NewUsageError(err.Error())
+			}
+		}
+	}
+
+	err = d.db.PatchDeviceAuth(ctx, dev)
+	switch err {
+	case nil:
+		dev.Revision++
+	case store.ErrNotFound:
+		return nil, err
+	case store.ErrModified:
+		return nil, ErrRevisionMismatch
+	default:
+		return nil, errors.Wrap(err, "failed to patch auth set")
+	}
+
+	for _, text := range patch.Notes {
+		now := d.clock.Now()
+		err := d.db.InsertNote(ctx, &model.Note{
+			AuthID:  id,
+			Author:  author,
+			Text:    text,
+			Created: &now,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to store note")
+		}
+	}
+
+	return dev, nil
+}
@@ -0,0 +1,315 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	"github.com/mendersoftware/deviceadm/utils"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmPatchDeviceAuth(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	expires := time.Unix(1600000000, 0)
+
+	serial := "0001"
+	schema := &model.IdentitySchema{
+		Attributes: []model.AttributeSchema{
+			{Name: "mac", Required: true},
+			{Name: "sn"},
+		},
+	}
+
+	testCases := map[string]struct {
+		subject  string
+		revision int
+		patch    model.DeviceAuthPatch
+
+		getErr    error
+		schema    *model.IdentitySchema
+		patched   *model.DeviceAuth
+		patchErr  error
+		insertErr error
+
+		outDev   *model.DeviceAuth
+		outError string
+	}{
+		"ok, attributes merged": {
+			patch: model.DeviceAuthPatch{
+				Attributes: map[string]*string{
+					"sn":  &serial,
+					"foo": nil,
+				},
+			},
+			schema: &model.IdentitySchema{AllowExtra: true},
+			patched: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "sn": "0001"},
+				Tags:       []string{"a"},
+				Revision:   3,
+			},
+			outDev: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "sn": "0001"},
+				Tags:       []string{"a"},
+				Revision:   4,
+			},
+		},
+		"ok, tags and expiry replaced": {
+			patch: model.DeviceAuthPatch{
+				Tags:         []string{"b", "c", "b"},
+				SetTags:      true,
+				ExpiresAt:    &expires,
+				SetExpiresAt: true,
+			},
+			patched: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Tags:       []string{"b", "c"},
+				ExpiresAt:  &expires,
+				Revision:   3,
+			},
+			outDev: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Tags:       []string{"b", "c"},
+				ExpiresAt:  &expires,
+				Revision:   4,
+			},
+		},
+		"ok, notes added": {
+			subject: "user-1",
+			patch: model.DeviceAuthPatch{
+				Tags:    nil,
+				SetTags: true,
+				Notes:   []string{"first", "second"},
+			},
+			patched: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Revision:   3,
+			},
+			outDev: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Revision:   4,
+			},
+		},
+		"ok, revision matches": {
+			revision: 3,
+			patch: model.DeviceAuthPatch{
+				SetExpiresAt: true,
+			},
+			patched: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Tags:       []string{"a"},
+				Revision:   3,
+			},
+			outDev: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Tags:       []string{"a"},
+				Revision:   4,
+			},
+		},
+		"error: attributes violate schema": {
+			patch: model.DeviceAuthPatch{
+				Attributes: map[string]*string{"mac": nil},
+			},
+			schema:   schema,
+			outError: `identity attribute "mac" is required`,
+		},
+		"error: no attributes left": {
+			patch: model.DeviceAuthPatch{
+				Attributes: map[string]*string{"mac": nil, "foo": nil},
+			},
+			outError: ErrNoAttributesLeft.Error(),
+		},
+		"error: notes without author": {
+			patch: model.DeviceAuthPatch{
+				Notes: []string{"first"},
+			},
+			outError: ErrNoNoteAuthor.Error(),
+		},
+		"error: not found": {
+			patch: model.DeviceAuthPatch{
+				SetTags: true,
+			},
+			getErr:   store.ErrNotFound,
+			outError: store.ErrNotFound.Error(),
+		},
+		"error: revision mismatch": {
+			revision: 2,
+			patch: model.DeviceAuthPatch{
+				SetTags: true,
+			},
+			outError: ErrRevisionMismatch.Error(),
+		},
+		"error: modified concurrently": {
+			patch: model.DeviceAuthPatch{
+				SetTags: true,
+			},
+			patched: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Revision:   3,
+			},
+			patchErr: store.ErrModified,
+			outError: ErrRevisionMismatch.Error(),
+		},
+		"error: db": {
+			patch: model.DeviceAuthPatch{
+				SetTags: true,
+			},
+			patched: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Revision:   3,
+			},
+			patchErr: errors.New("db connection failed"),
+			outError: "failed to patch auth set: db connection failed",
+		},
+		"error: note not stored": {
+			subject: "user-1",
+			patch: model.DeviceAuthPatch{
+				Notes: []string{"first"},
+			},
+			patched: &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Tags:       []string{"a"},
+				Revision:   3,
+			},
+			insertErr: errors.New("db connection failed"),
+			outError:  "failed to store note: db connection failed",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.subject != "" {
+				ctx = identity.WithContext(ctx, &identity.Identity{
+					Subject: tc.subject,
+				})
+			}
+			if tc.revision != 0 {
+				ctx = WithRevision(ctx, tc.revision)
+			}
+
+			dev := &model.DeviceAuth{
+				ID:         "1",
+				Status:     model.DevStatusPending,
+				Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "foo": "bar"},
+				Tags:       []string{"a"},
+				Revision:   3,
+			}
+
+			db := &mstore.DataStore{}
+			db.On("GetDeviceAuth", ctx, model.AuthID("1")).
+				Return(dev, tc.getErr)
+			db.On("GetIdentitySchema", ctx).
+				Return(tc.schema, nil)
+			// copy the auth set as stored, it's updated afterwards
+			var patched *model.DeviceAuth
+			db.On("PatchDeviceAuth", ctx,
+				mock.MatchedBy(func(d *model.DeviceAuth) bool {
+					stored := *d
+					patched = &stored
+					return true
+				})).
+				Return(tc.patchErr)
+			db.On("InsertNote", ctx, mock.AnythingOfType("*model.Note")).
+				Return(tc.insertErr)
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			d := devadmForTest(db).(*DevAdm)
+			d.clock = clock
+
+			out, err := d.PatchDeviceAuth(ctx, "1", tc.patch)
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+				assert.Nil(t, out)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outDev, out)
+			}
+
+			assert.Equal(t, tc.patched, patched)
+
+			if tc.outError == "" {
+				db.AssertNumberOfCalls(t, "InsertNote", len(tc.patch.Notes))
+				for _, text := range tc.patch.Notes {
+					db.AssertCalled(t, "InsertNote", ctx, &model.Note{
+						AuthID:  "1",
+						Author:  tc.subject,
+						Text:    text,
+						Created: &now,
+					})
+				}
+			}
+		})
+	}
+}
+
+func TestDevAdmPatchDeviceAuthSchemaViolation(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("1")).
+		Return(&model.DeviceAuth{
+			ID:         "1",
+			Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01"},
+		}, nil)
+	db.On("GetIdentitySchema", ctx).
+		Return(&model.IdentitySchema{
+			Attributes: []model.AttributeSchema{
+				{Name: "mac", Required: true},
+			},
+		}, nil)
+
+	d := devadmForTest(db)
+
+	serial := "0001"
+	_, err := d.PatchDeviceAuth(ctx, "1", model.DeviceAuthPatch{
+		Attributes: map[string]*string{"sn": &serial},
+	})
+	assert.EqualError(t, err, `identity attribute "sn" is not allowed`)
+	assert.True(t, utils.IsUsageError(err))
+}
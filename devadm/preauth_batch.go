@@ -0,0 +1,143 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/utils"
+)
+
+const (
+	DefaultBatchPreauthConcurrency = 10
+)
+
+// WithBatchPreauthConcurrency sets the number of auth sets of a bulk
+// preauthorization which are propagated concurrently
+func (d *DevAdm) WithBatchPreauthConcurrency(n int) *DevAdm {
+	d.batchPreauthConcurrency = n
+	return d
+}
+
+// PreauthorizeDevices preauthorizes a batch of auth sets; a failure to
+// preauthorize one of them does not affect the others, outcomes of all
+// rows are reported. If 'ctx' is cancelled meanwhile, the report of rows
+// processed so far is returned along with the error, rows which were not
+// processed are reported as skipped.
+func (d *DevAdm) PreauthorizeDevices(ctx context.Context, rows []model.AuthSetRow, authorizationHeader string) (*model.PreauthReport, error) {
+	results := make([]model.PreauthResult, len(rows))
+
+	// rows to be preauthorized; an identity repeated in the upload is a
+	// conflict, preauthorizing it concurrently would be racy
+	var todo []int
+	seen := map[string]int{}
+	for i, row := range rows {
+		results[i] = model.PreauthResult{
+			Row:      row.Row,
+			DeviceId: row.AuthSet.DeviceId,
+		}
+
+		if row.Err != nil {
+			results[i].Result = model.PreauthResultInvalid
+			results[i].Error = row.Err.Error()
+			continue
+		}
+
+		if first, ok := seen[row.AuthSet.DeviceId]; ok {
+			results[i].Result = model.PreauthResultConflict
+			results[i].Error = fmt.Sprintf("device identity repeated, see row %d", first)
+			continue
+		}
+		seen[row.AuthSet.DeviceId] = row.Row
+
+		// until a worker picks it up
+		results[i].Result = model.PreauthResultSkipped
+
+		todo = append(todo, i)
+	}
+
+	workers := d.batchPreauthConcurrency
+	if workers <= 0 {
+		workers = DefaultBatchPreauthConcurrency
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				d.preauthorizeRow(ctx, &rows[idx], authorizationHeader, &results[idx])
+			}
+		}()
+	}
+
+feed:
+	for _, idx := range todo {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- idx:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := &model.PreauthReport{
+		Results: make([]model.PreauthResult, 0, len(results)),
+	}
+	for _, res := range results {
+		report.Add(res)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return report, errors.Wrap(err, "bulk preauthorization interrupted")
+	}
+	return report, nil
+}
+
+func (d *DevAdm) preauthorizeRow(ctx context.Context, row *model.AuthSetRow, authorizationHeader string, res *model.PreauthResult) {
+	err := d.PreauthorizeDevice(ctx, row.AuthSet, authorizationHeader)
+	switch {
+	case err == nil:
+		res.Result = model.PreauthResultPreauthorized
+	case ctx.Err() != nil:
+		res.Result = model.PreauthResultFailed
+		res.Error = "interrupted"
+	case err == AuthSetConflictError:
+		res.Result = model.PreauthResultConflict
+		res.Error = err.Error()
+	case err == ErrDeviceBlocked:
+		res.Result = model.PreauthResultFailed
+		res.Error = err.Error()
+	case utils.IsUsageError(errors.Cause(err)):
+		res.Result = model.PreauthResultFailed
+		res.Error = err.Error()
+	default:
+		log.FromContext(ctx).Errorf("failed to preauthorize row %d: %v",
+			row.Row, err)
+		res.Result = model.PreauthResultFailed
+		res.Error = "internal error"
+	}
+}
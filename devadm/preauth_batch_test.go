@@ -0,0 +1,158 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmPreauthorizeDevices(t *testing.T) {
+	ctx := context.Background()
+
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(time.Now())
+
+	authSet := func(id string) model.AuthSet {
+		return model.AuthSet{
+			DeviceId:   `{"sn":"` + id + `"}`,
+			Key:        "key-" + id,
+			Attributes: model.DeviceAuthAttributes{"sn": id},
+		}
+	}
+	withIdentity := func(id string) interface{} {
+		return mock.MatchedBy(func(dev *model.DeviceAuth) bool {
+			return dev.DeviceIdentity == `{"sn":"`+id+`"}`
+		})
+	}
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuthsByIdentityData", ctx, authSet("a").DeviceId).
+		Return([]model.DeviceAuth{}, nil)
+	db.On("GetDeviceAuthsByIdentityData", ctx, authSet("b").DeviceId).
+		Return([]model.DeviceAuth{{ID: "b"}}, nil)
+	db.On("GetDeviceAuthsByIdentityData", ctx, authSet("c").DeviceId).
+		Return([]model.DeviceAuth{}, nil)
+	db.On("GetDeviceAuthsByIdentityData", ctx, authSet("d").DeviceId).
+		Return([]model.DeviceAuth{}, nil)
+	db.On("GetBlocklist", ctx).
+		Return([]model.BlocklistEntry{
+			{ID: "1", Attributes: map[string]string{"sn": "d"}},
+		}, nil)
+	db.On("InsertBlocklistHit", ctx,
+		mock.AnythingOfType("*model.BlocklistHit")).
+		Return(nil)
+	db.On("InsertDeviceAuth", ctx, withIdentity("a")).
+		Return(nil)
+	db.On("InsertDeviceAuth", ctx, withIdentity("c")).
+		Return(errors.New("db connection failed"))
+
+	d := &DevAdm{
+		db: db,
+		clientGetter: func() client.HttpRunner {
+			return FakeApiRequester{http.StatusCreated}
+		},
+		clock: clock,
+	}
+	d.WithBatchPreauthConcurrency(2)
+
+	rows := []model.AuthSetRow{
+		{Row: 1, AuthSet: authSet("a")},
+		{Row: 2, AuthSet: model.AuthSet{DeviceId: "foo"},
+			Err: errors.New("key: non zero value required")},
+		{Row: 3, AuthSet: authSet("b")},
+		{Row: 4, AuthSet: authSet("a")},
+		{Row: 5, AuthSet: authSet("c")},
+		{Row: 6, AuthSet: authSet("d")},
+	}
+
+	report, err := d.PreauthorizeDevices(ctx, rows, "Bearer foo")
+	assert.NoError(t, err)
+	assert.Equal(t, &model.PreauthReport{
+		Preauthorized: 1,
+		Conflicts:     2,
+		Invalid:       1,
+		Failed:        2,
+		Results: []model.PreauthResult{
+			{
+				Row:      1,
+				DeviceId: authSet("a").DeviceId,
+				Result:   model.PreauthResultPreauthorized,
+			},
+			{
+				Row:      2,
+				DeviceId: "foo",
+				Result:   model.PreauthResultInvalid,
+				Error:    "key: non zero value required",
+			},
+			{
+				Row:      3,
+				DeviceId: authSet("b").DeviceId,
+				Result:   model.PreauthResultConflict,
+				Error:    AuthSetConflictError.Error(),
+			},
+			{
+				Row:      4,
+				DeviceId: authSet("a").DeviceId,
+				Result:   model.PreauthResultConflict,
+				Error:    "device identity repeated, see row 1",
+			},
+			{
+				Row:      5,
+				DeviceId: authSet("c").DeviceId,
+				Result:   model.PreauthResultFailed,
+				Error:    "internal error",
+			},
+			{
+				Row:      6,
+				DeviceId: authSet("d").DeviceId,
+				Result:   model.PreauthResultFailed,
+				Error:    ErrDeviceBlocked.Error(),
+			},
+		},
+	}, report)
+	db.AssertExpectations(t)
+}
+
+func TestDevAdmPreauthorizeDevicesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	d := &DevAdm{db: &mstore.DataStore{}}
+
+	report, err := d.PreauthorizeDevices(ctx, []model.AuthSetRow{
+		{Row: 1, AuthSet: model.AuthSet{DeviceId: `{"sn":"a"}`}},
+		{Row: 2, Err: errors.New("missing key")},
+	}, "Bearer foo")
+	assert.EqualError(t, err, "bulk preauthorization interrupted: context canceled")
+	assert.Equal(t, &model.PreauthReport{
+		Invalid: 1,
+		Skipped: 1,
+		Results: []model.PreauthResult{
+			{Row: 1, DeviceId: `{"sn":"a"}`, Result: model.PreauthResultSkipped},
+			{Row: 2, Result: model.PreauthResultInvalid, Error: "missing key"},
+		},
+	}, report)
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/client/deviceauth"
+	"github.com/mendersoftware/deviceadm/model"
+)
+
+const (
+	// failure to propagate to the target fails the whole operation
+	FailurePolicyRequired = "required"
+	// failure to propagate to the target is logged and ignored
+	FailurePolicyBestEffort = "best-effort"
+)
+
+const (
+	// events sent to HTTP propagation targets
+	PropagationEventStatusChanged = "status_changed"
+	PropagationEventDeleted       = "deleted"
+	PropagationEventPreauthorized = "preauthorized"
+	PropagationEventRestored      = "restored"
+
+	defaultHttpTargetTimeout = time.Duration(10) * time.Second
+)
+
+// PropagationTarget is a downstream service learning about admission
+// decisions made by devadm
+type PropagationTarget interface {
+	// Name identifies the target in logs and errors
+	Name() string
+	// StatusChanged is called after auth set was accepted or rejected
+	StatusChanged(ctx context.Context, dev *model.DeviceAuth) error
+	// Deleted is called when an auth set is removed
+	Deleted(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error
+	// Preauthorized is called when a preauthorized auth set is created
+	Preauthorized(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error
+	// Restored is called when a deleted auth set is taken out of the trash
+	Restored(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error
+}
+
+type propagationHook struct {
+	target PropagationTarget
+	policy string
+}
+
+// WithPropagationTarget adds 't' to targets notified about admission
+// decisions, with 'policy' being one of FailurePolicyRequired or
+// FailurePolicyBestEffort. Devauth is always notified first, other
+// targets are notified in the order they were added.
+func (d *DevAdm) WithPropagationTarget(t PropagationTarget, policy string) *DevAdm {
+	d.hooks = append(d.hooks, propagationHook{
+		target: t,
+		policy: policy,
+	})
+	return d
+}
+
+// allHooks returns devauth followed by configured propagation targets
+func (d *DevAdm) allHooks() []propagationHook {
+	return append([]propagationHook{
+		{
+			target: &deviceAuthTarget{d: d},
+			policy: FailurePolicyRequired,
+		},
+	}, d.hooks...)
+}
+
+// propagate calls 'op' for each of 'hooks', stopping at the first
+// failure of a required target; returns the hooks that succeeded
+func (d *DevAdm) propagate(ctx context.Context, hooks []propagationHook,
+	op func(t PropagationTarget) error) ([]propagationHook, error) {
+
+	l := log.FromContext(ctx)
+
+	done := make([]propagationHook, 0, len(hooks))
+	for _, h := range hooks {
+		err := op(h.target)
+		if err == nil {
+			done = append(done, h)
+			continue
+		}
+
+		if h.policy == FailurePolicyRequired {
+			return done, err
+		}
+		l.Warnf("failed to propagate to %s: %v", h.target.Name(), err)
+	}
+	return done, nil
+}
+
+// deviceAuthTarget forwards admission decisions to devauth
+type deviceAuthTarget struct {
+	d *DevAdm
+}
+
+func (t *deviceAuthTarget) Name() string {
+	return "devauth"
+}
+
+func (t *deviceAuthTarget) StatusChanged(ctx context.Context, dev *model.DeviceAuth) error {
+	status := dev.Status
+	// devauth has no notion of suspension, the device must not
+	// authenticate while suspended
+	if status == model.DevStatusSuspended {
+		status = model.DevStatusRejected
+	}
+	return t.d.authClient().UpdateDevice(ctx, deviceauth.StatusReq{
+		AuthId:   dev.ID.String(),
+		DeviceId: dev.DeviceId.String(),
+		Status:   status,
+	})
+}
+
+func (t *deviceAuthTarget) Deleted(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	return t.d.authClient().DeleteDeviceAuthSet(ctx,
+		dev.DeviceId.String(), dev.ID.String(), authorizationHeader)
+}
+
+func (t *deviceAuthTarget) Preauthorized(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	return t.d.authClient().PreauthorizeDevice(ctx, &deviceauth.PreAuthReq{
+		DeviceId:  string(dev.DeviceId),
+		AuthSetId: string(dev.ID),
+		IdData:    dev.DeviceIdentity,
+		PubKey:    dev.Key,
+	}, authorizationHeader)
+}
+
+// Restored recreates the auth set in devauth, which cannot undo a
+// deletion; the auth set is preauthorized first, and then brought back to
+// its status
+func (t *deviceAuthTarget) Restored(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	err := t.Preauthorized(ctx, dev, authorizationHeader)
+	if err != nil || dev.Status == model.DevStatusPreauthorized {
+		return err
+	}
+	return t.StatusChanged(ctx, dev)
+}
+
+// HttpTargetConfig describes a generic HTTP propagation target
+type HttpTargetConfig struct {
+	// name of the target used in logs
+	Name string `mapstructure:"name"`
+	// URL events are POSTed to
+	URL string `mapstructure:"url"`
+	// FailurePolicyRequired or FailurePolicyBestEffort
+	Policy string `mapstructure:"policy"`
+	// request timeout
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func (c HttpTargetConfig) Validate() error {
+	if c.Name == "" {
+		return errors.New("propagation target name must be provided")
+	}
+	if c.URL == "" {
+		return errors.Errorf("propagation target %s: url must be provided",
+			c.Name)
+	}
+	if c.Policy != FailurePolicyRequired && c.Policy != FailurePolicyBestEffort {
+		return errors.Errorf("propagation target %s: policy must be one of %s, %s",
+			c.Name, FailurePolicyRequired, FailurePolicyBestEffort)
+	}
+	return nil
+}
+
+// HttpTargetEvent is the payload POSTed to generic HTTP targets
+type HttpTargetEvent struct {
+	Event    string            `json:"event"`
+	TenantID string            `json:"tenant_id,omitempty"`
+	AuthSet  *model.DeviceAuth `json:"auth_set"`
+}
+
+// HttpTarget notifies a generic HTTP endpoint about admission decisions,
+// any 2xx response is considered a success
+type HttpTarget struct {
+	conf   HttpTargetConfig
+	client client.HttpRunner
+}
+
+func NewHttpTarget(conf HttpTargetConfig, c client.HttpRunner) *HttpTarget {
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultHttpTargetTimeout
+	}
+	return &HttpTarget{
+		conf:   conf,
+		client: c,
+	}
+}
+
+func (t *HttpTarget) Name() string {
+	return t.conf.Name
+}
+
+func (t *HttpTarget) StatusChanged(ctx context.Context, dev *model.DeviceAuth) error {
+	return t.send(ctx, PropagationEventStatusChanged, dev)
+}
+
+func (t *HttpTarget) Deleted(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	return t.send(ctx, PropagationEventDeleted, dev)
+}
+
+func (t *HttpTarget) Preauthorized(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	return t.send(ctx, PropagationEventPreauthorized, dev)
+}
+
+func (t *HttpTarget) Restored(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	return t.send(ctx, PropagationEventRestored, dev)
+}
+
+func (t *HttpTarget) send(ctx context.Context, event string, dev *model.DeviceAuth) error {
+	ev := HttpTargetEvent{
+		Event:   event,
+		AuthSet: dev,
+	}
+	if id := identity.FromContext(ctx); id != nil {
+		ev.TenantID = id.Tenant
+	}
+
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return errors.Wrapf(err, "%s: failed to prepare request", t.conf.Name)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "%s: failed to prepare request", t.conf.Name)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(ctx, t.conf.Timeout)
+	defer cancel()
+
+	rsp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "%s: request failed", t.conf.Name)
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return errors.Errorf("%s: request failed with status %v",
+			t.conf.Name, rsp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+// fakeTarget records calls and fails them with 'err'
+type fakeTarget struct {
+	name  string
+	err   error
+	calls []string
+}
+
+func (f *fakeTarget) Name() string {
+	return f.name
+}
+
+func (f *fakeTarget) StatusChanged(ctx context.Context, dev *model.DeviceAuth) error {
+	f.calls = append(f.calls, PropagationEventStatusChanged+":"+dev.Status)
+	return f.err
+}
+
+func (f *fakeTarget) Deleted(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	f.calls = append(f.calls, PropagationEventDeleted)
+	return f.err
+}
+
+func (f *fakeTarget) Preauthorized(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	f.calls = append(f.calls, PropagationEventPreauthorized)
+	return f.err
+}
+
+func (f *fakeTarget) Restored(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	f.calls = append(f.calls, PropagationEventRestored+":"+dev.Status)
+	return f.err
+}
+
+func TestDevAdmPropagateStatusChange(t *testing.T) {
+	testCases := map[string]struct {
+		requiredErr   error
+		bestEffortErr error
+
+		outError error
+	}{
+		"ok": {},
+		"best effort target failed": {
+			bestEffortErr: errors.New("inventory down"),
+		},
+		"required target failed": {
+			requiredErr: errors.New("provisioning down"),
+			outError:    errors.New("failed to propagate device status update: provisioning down"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("GetSettings", ctx).
+				Return(&model.TenantSettings{}, nil)
+			db.On("GetQuota", ctx).
+				Return(&model.Quota{}, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(&model.DeviceAuth{ID: "foo", DeviceId: "bar", Status: model.DevStatusPending}, nil)
+			db.On("UpdateDeviceAuth", ctx, &model.DeviceAuth{
+				ID:     "foo",
+				Status: model.DevStatusAccepted,
+			}).Return(nil).Once()
+			if tc.outError != nil {
+				// the status is stored before propagation, failure to
+				// propagate restores the previous one
+				db.On("UpdateDeviceAuth", ctx, &model.DeviceAuth{
+					ID:       "foo",
+					Status:   model.DevStatusPending,
+					Revision: 1,
+				}).Return(nil).Once()
+			}
+
+			bestEffort := &fakeTarget{name: "inventory", err: tc.bestEffortErr}
+			required := &fakeTarget{name: "provisioning", err: tc.requiredErr}
+			last := &fakeTarget{name: "last"}
+
+			d := devadmWithClientForTest(db, http.StatusNoContent).(*DevAdm).
+				WithPropagationTarget(bestEffort, FailurePolicyBestEffort).
+				WithPropagationTarget(required, FailurePolicyRequired).
+				WithPropagationTarget(last, FailurePolicyBestEffort)
+
+			err := d.AcceptDeviceAuth(ctx, "foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Empty(t, last.calls)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"status_changed:accepted"}, last.calls)
+			}
+			assert.Equal(t, []string{"status_changed:accepted"}, bestEffort.calls)
+			assert.Equal(t, []string{"status_changed:accepted"}, required.calls)
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDevAdmPropagateAcceptPreauth(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{
+			ID:     "foo",
+			Status: model.DevStatusPreauthorized,
+		}, nil)
+	db.On("UpdateDeviceAuth", ctx,
+		mock.AnythingOfType("*model.DeviceAuth")).
+		Return(nil)
+	db.On("GetQuota", ctx).
+		Return(&model.Quota{}, nil)
+
+	target := &fakeTarget{name: "inventory"}
+
+	// devauth must not be called, the request would fail
+	d := devadmWithClientForTest(db, http.StatusInternalServerError).(*DevAdm).
+		WithPropagationTarget(target, FailurePolicyRequired)
+
+	err := d.AcceptDevicePreAuth(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"status_changed:accepted"}, target.calls)
+}
+
+func TestDevAdmPropagateDeleteInternal(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{ID: "foo", DeviceId: "bar"}, nil)
+	db.On("SoftDeleteDeviceAuth", ctx, model.AuthID("foo"),
+		store.AnyRevision, mock.AnythingOfType("time.Time")).
+		Return(nil)
+	db.On("GetDeviceAuths", ctx, 0, 0,
+		mock.AnythingOfType("store.Filter")).
+		Return([]model.DeviceAuth{{ID: "1"}, {ID: "2"}}, nil)
+	db.On("SoftDeleteDeviceAuthByDevice", ctx, model.DeviceID("bar"),
+		mock.AnythingOfType("time.Time")).
+		Return(nil)
+
+	target := &fakeTarget{name: "inventory"}
+
+	d := devadmWithClientForTest(db, http.StatusInternalServerError).(*DevAdm).
+		WithPropagationTarget(target, FailurePolicyRequired)
+
+	err := d.DeleteDeviceAuth(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deleted"}, target.calls)
+
+	err = d.DeleteDeviceData(ctx, "bar")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deleted", "deleted", "deleted"}, target.calls)
+	db.AssertExpectations(t)
+}
+
+func TestDevAdmPropagatePreauthorizeRollback(t *testing.T) {
+	ctx := context.Background()
+
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(time.Now())
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuthsByIdentityData", ctx, "foo-id").
+		Return([]model.DeviceAuth{}, nil)
+	db.On("GetBlocklist", ctx).
+		Return([]model.BlocklistEntry{}, nil)
+	db.On("InsertDeviceAuth", ctx,
+		mock.AnythingOfType("*model.DeviceAuth")).
+		Return(nil)
+	db.On("DeleteDeviceAuth", ctx, mock.AnythingOfType("model.AuthID")).
+		Return(nil)
+
+	first := &fakeTarget{name: "inventory"}
+	failing := &fakeTarget{name: "provisioning", err: errors.New("provisioning down")}
+
+	d := &DevAdm{
+		db: db,
+		clientGetter: func() client.HttpRunner {
+			return FakeApiRequester{http.StatusCreated}
+		},
+		clock: clock,
+	}
+	d.WithPropagationTarget(first, FailurePolicyRequired).
+		WithPropagationTarget(failing, FailurePolicyRequired)
+
+	err := d.PreauthorizeDevice(ctx, model.AuthSet{
+		DeviceId:   "foo-id",
+		Key:        "foo-key",
+		Attributes: map[string]string{"foo": "bar"},
+	}, "Bearer foo")
+	assert.EqualError(t, err, "failed to propagate device status update: provisioning down")
+
+	// preauthorization was undone on the target that accepted it
+	assert.Equal(t, []string{"preauthorized", "deleted"}, first.calls)
+	assert.Equal(t, []string{"preauthorized"}, failing.calls)
+	db.AssertExpectations(t)
+}
+
+func TestHttpTarget(t *testing.T) {
+	testCases := map[string]struct {
+		status int
+
+		outError string
+	}{
+		"ok": {
+			status: http.StatusOK,
+		},
+		"ok, no content": {
+			status: http.StatusNoContent,
+		},
+		"error": {
+			status:   http.StatusBadGateway,
+			outError: "inventory: request failed with status 502 Bad Gateway",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var ev HttpTargetEvent
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "/hook", r.URL.Path)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				err := json.NewDecoder(r.Body).Decode(&ev)
+				assert.NoError(t, err)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			target := NewHttpTarget(HttpTargetConfig{
+				Name: "inventory",
+				URL:  srv.URL + "/hook",
+			}, &client.HttpApi{})
+
+			ctx := identity.WithContext(context.Background(),
+				&identity.Identity{Tenant: "tenant1"})
+			dev := &model.DeviceAuth{
+				ID:       "foo",
+				DeviceId: "bar",
+				Status:   model.DevStatusRejected,
+			}
+
+			for event, call := range map[string]func() error{
+				PropagationEventStatusChanged: func() error {
+					return target.StatusChanged(ctx, dev)
+				},
+				PropagationEventDeleted: func() error {
+					return target.Deleted(ctx, dev, "")
+				},
+				PropagationEventPreauthorized: func() error {
+					return target.Preauthorized(ctx, dev, "")
+				},
+			} {
+				err := call()
+				if tc.outError != "" {
+					assert.EqualError(t, err, tc.outError)
+				} else {
+					assert.NoError(t, err)
+				}
+				assert.Equal(t, event, ev.Event)
+				assert.Equal(t, "tenant1", ev.TenantID)
+				assert.Equal(t, dev, ev.AuthSet)
+			}
+		})
+	}
+}
+
+func TestHttpTargetConfigValidate(t *testing.T) {
+	assert.NoError(t, HttpTargetConfig{
+		Name:   "inventory",
+		URL:    "http://inventory",
+		Policy: FailurePolicyBestEffort,
+	}.Validate())
+	assert.EqualError(t, HttpTargetConfig{
+		URL:    "http://inventory",
+		Policy: FailurePolicyBestEffort,
+	}.Validate(), "propagation target name must be provided")
+	assert.EqualError(t, HttpTargetConfig{
+		Name:   "inventory",
+		Policy: FailurePolicyBestEffort,
+	}.Validate(), "propagation target inventory: url must be provided")
+	assert.EqualError(t, HttpTargetConfig{
+		Name: "inventory",
+		URL:  "http://inventory",
+	}.Validate(), "propagation target inventory: policy must be one of required, best-effort")
+}
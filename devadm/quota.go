@@ -0,0 +1,183 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("device quota exceeded")
+	ErrQuotaLocked   = errors.New("timed out waiting for other device admissions")
+)
+
+const (
+	// how long an admission may hold the quota lock at most
+	quotaLockTTL = 30 * time.Second
+	// how often, and how many times, a held quota lock is tried again
+	quotaLockRetryInterval = 100 * time.Millisecond
+	quotaLockAttempts      = 50
+)
+
+// SetQuota sets device quota of tenant 'tenantID', on behalf of the
+// tenant service
+func (d *DevAdm) SetQuota(ctx context.Context, tenantID string, quota model.Quota) error {
+	ctx = identity.WithContext(ctx, &identity.Identity{Tenant: tenantID})
+
+	err := d.db.PutQuota(ctx, &quota)
+	if err != nil {
+		return errors.Wrap(err, "failed to update quota")
+	}
+	return nil
+}
+
+func (d *DevAdm) GetQuotaUsage(ctx context.Context) (*model.QuotaUsage, error) {
+	quota, err := d.db.GetQuota(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch quota")
+	}
+
+	accepted, err := d.db.CountAcceptedDevices(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count accepted devices")
+	}
+
+	return &model.QuotaUsage{
+		Accepted: accepted,
+		Allowed:  quota.MaxDevices,
+	}, nil
+}
+
+// reserveQuota returns ErrQuotaExceeded if accepting auth set 'id' would
+// exceed tenant's device quota; devices already having an accepted auth
+// set are not counted twice. Otherwise the quota stays reserved, i.e.
+// other admissions wait, until the returned release function is called,
+// which the caller does once the auth set is stored as accepted.
+func (d *DevAdm) reserveQuota(ctx context.Context, id model.AuthID) (func(), error) {
+	quota, err := d.db.GetQuota(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch quota")
+	}
+	if quota.MaxDevices == 0 {
+		return func() {}, nil
+	}
+
+	dev, err := d.db.GetDeviceAuth(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.reserveDeviceQuota(ctx, dev.DeviceId, quota)
+}
+
+// reserveNewDeviceQuota is reserveQuota for an auth set of device 'devid',
+// which may not be stored yet
+func (d *DevAdm) reserveNewDeviceQuota(ctx context.Context, devid model.DeviceID) (func(), error) {
+	quota, err := d.db.GetQuota(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch quota")
+	}
+	if quota.MaxDevices == 0 {
+		return func() {}, nil
+	}
+
+	return d.reserveDeviceQuota(ctx, devid, quota)
+}
+
+// reserveDeviceQuota takes the tenant's quota lock and checks, under the
+// lock, that device 'devid' having an accepted auth set does not exceed
+// 'quota'; counting and accepting are not atomic, so without the lock
+// concurrent admissions could all fit in the last free slot
+func (d *DevAdm) reserveDeviceQuota(ctx context.Context, devid model.DeviceID, quota *model.Quota) (func(), error) {
+	release, err := d.lockQuota(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.checkDeviceQuota(ctx, devid, quota)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// lockQuota takes the tenant's quota lock, waiting for other holders to
+// release it; the lock expires after quotaLockTTL in case its holder
+// never does
+func (d *DevAdm) lockQuota(ctx context.Context) (func(), error) {
+	lock, err := newLeaseToken()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate quota lock")
+	}
+
+	for attempt := 1; ; attempt++ {
+		now := d.clock.Now()
+		err = d.db.LockQuota(ctx, lock, now.Add(quotaLockTTL), now)
+		if err == nil {
+			break
+		}
+		if err != store.ErrModified {
+			return nil, err
+		}
+		if attempt == quotaLockAttempts {
+			return nil, ErrQuotaLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-d.clock.After(quotaLockRetryInterval):
+		}
+	}
+
+	return func() {
+		err := d.db.UnlockQuota(ctx, lock)
+		if err != nil {
+			log.FromContext(ctx).Errorf("failed to release quota lock: %v", err)
+		}
+	}, nil
+}
+
+// checkDeviceQuota returns ErrQuotaExceeded if device 'devid' having an
+// accepted auth set would exceed 'quota'
+func (d *DevAdm) checkDeviceQuota(ctx context.Context, devid model.DeviceID, quota *model.Quota) error {
+	accepted, err := d.db.GetDeviceAuths(ctx, 0, 1, store.Filter{
+		DeviceID: devid,
+		Status:   model.DevStatusAccepted,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch accepted auth sets")
+	}
+	if len(accepted) > 0 {
+		return nil
+	}
+
+	count, err := d.db.CountAcceptedDevices(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to count accepted devices")
+	}
+	if count >= quota.MaxDevices {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+// mockQuotaLock lets quota lock be taken and released, recording both in
+// 'calls'
+func mockQuotaLock(db *mstore.DataStore, calls *[]string) {
+	db.On("LockQuota", mock.Anything, mock.AnythingOfType("string"),
+		mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) {
+			*calls = append(*calls, "lock")
+		}).
+		Return(nil)
+	db.On("UnlockQuota", mock.Anything, mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) {
+			*calls = append(*calls, "unlock")
+		}).
+		Return(nil)
+}
+
+func TestDevAdmAcceptDeviceQuota(t *testing.T) {
+	testCases := map[string]struct {
+		quota    *model.Quota
+		quotaErr error
+		accepted []model.DeviceAuth
+		count    int
+
+		outCalls []string
+		outError error
+	}{
+		"ok, no limit": {
+			quota:    &model.Quota{},
+			outCalls: []string{"update"},
+		},
+		"ok, below limit": {
+			quota:    &model.Quota{MaxDevices: 10},
+			count:    9,
+			outCalls: []string{"lock", "update", "unlock"},
+		},
+		"ok, device already accepted": {
+			quota:    &model.Quota{MaxDevices: 10},
+			accepted: []model.DeviceAuth{{ID: "other", DeviceId: "bar"}},
+			count:    10,
+			outCalls: []string{"lock", "update", "unlock"},
+		},
+		"error: limit reached": {
+			quota:    &model.Quota{MaxDevices: 10},
+			count:    10,
+			outCalls: []string{"lock", "unlock"},
+			outError: ErrQuotaExceeded,
+		},
+		"error: quota": {
+			quotaErr: errors.New("db connection failed"),
+			outError: errors.New("failed to fetch quota: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var calls []string
+			db := &mstore.DataStore{}
+			mockQuotaLock(db, &calls)
+			db.On("GetSettings", ctx).
+				Return(&model.TenantSettings{}, nil)
+			db.On("GetQuota", ctx).
+				Return(tc.quota, tc.quotaErr)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(&model.DeviceAuth{ID: "foo", DeviceId: "bar", Status: model.DevStatusPending}, nil)
+			db.On("GetDeviceAuths", ctx, 0, 1, store.Filter{
+				DeviceID: "bar",
+				Status:   model.DevStatusAccepted,
+			}).Return(tc.accepted, nil)
+			db.On("CountAcceptedDevices", ctx).
+				Return(tc.count, nil)
+			db.On("UpdateDeviceAuth", ctx,
+				mock.AnythingOfType("*model.DeviceAuth")).
+				Run(func(args mock.Arguments) {
+					calls = append(calls, "update")
+				}).
+				Return(nil)
+
+			target := &fakeTarget{name: "inventory"}
+			d := devadmWithClientForTest(db, http.StatusNoContent).(*DevAdm).
+				WithPropagationTarget(target, FailurePolicyRequired)
+
+			err := d.AcceptDeviceAuth(ctx, "foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Empty(t, target.calls)
+				db.AssertNotCalled(t, "UpdateDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth"))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"status_changed:accepted"}, target.calls)
+			}
+			// the quota stays locked until the auth set is accepted
+			assert.Equal(t, tc.outCalls, calls)
+		})
+	}
+}
+
+func TestDevAdmSubmitDeviceQuota(t *testing.T) {
+	testCases := map[string]struct {
+		token    string
+		quota    *model.Quota
+		quotaErr error
+		count    int
+
+		outStatus string
+		outError  error
+	}{
+		"allowlist, below limit": {
+			quota:     &model.Quota{MaxDevices: 10},
+			count:     9,
+			outStatus: model.DevStatusAccepted,
+		},
+		"allowlist, limit reached": {
+			quota:     &model.Quota{MaxDevices: 10},
+			count:     10,
+			outStatus: model.DevStatusPending,
+		},
+		"enrollment token, below limit": {
+			token:     "secret",
+			quota:     &model.Quota{MaxDevices: 10},
+			count:     9,
+			outStatus: model.DevStatusAccepted,
+		},
+		"enrollment token, limit reached": {
+			token:     "secret",
+			quota:     &model.Quota{MaxDevices: 10},
+			count:     10,
+			outStatus: model.DevStatusPending,
+		},
+		"error: quota": {
+			token:    "secret",
+			quotaErr: errors.New("db connection failed"),
+			outError: errors.New("failed to fetch quota: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var put *model.DeviceAuth
+			var calls []string
+			db := &mstore.DataStore{}
+			mockQuotaLock(db, &calls)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(nil, store.ErrNotFound)
+			db.On("GetBlocklist", ctx).
+				Return([]model.BlocklistEntry{}, nil)
+			if tc.token != "" {
+				db.On("UseEnrollmentToken", ctx,
+					model.HashEnrollmentToken(tc.token),
+					mock.AnythingOfType("time.Time")).
+					Return(&model.EnrollmentToken{
+						ID:         "1",
+						Attributes: model.DeviceAuthAttributes{"site": "plant1"},
+					}, nil)
+			} else {
+				db.On("FindAllowlistEntries", ctx,
+					mock.AnythingOfType("model.DeviceAuthAttributes"),
+					model.AuthID("foo")).
+					Return([]model.AllowlistEntry{{ID: "1"}}, nil)
+			}
+			db.On("GetQuota", ctx).
+				Return(tc.quota, tc.quotaErr)
+			db.On("GetDeviceAuths", ctx, 0, 1, store.Filter{
+				DeviceID: "bar",
+				Status:   model.DevStatusAccepted,
+			}).Return([]model.DeviceAuth{}, nil)
+			db.On("CountAcceptedDevices", ctx).
+				Return(tc.count, nil)
+			if tc.token != "" && tc.outStatus != model.DevStatusAccepted {
+				// the device is not admitted, the token use is given back
+				db.On("ReleaseEnrollmentToken", ctx, "1").
+					Return(nil)
+			}
+			db.On("PutDeviceAuth", ctx,
+				mock.AnythingOfType("*model.DeviceAuth")).
+				Run(func(args mock.Arguments) {
+					put = args.Get(1).(*model.DeviceAuth)
+					calls = append(calls, "put")
+				}).
+				Return(nil)
+
+			target := &fakeTarget{name: "devauth"}
+			d := devadmWithClientForTest(db, http.StatusNoContent).(*DevAdm).
+				WithPropagationTarget(target, FailurePolicyRequired)
+
+			err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+				ID:              "foo",
+				DeviceId:        "bar",
+				Attributes:      model.DeviceAuthAttributes{"sn": "1234"},
+				Status:          model.DevStatusPending,
+				EnrollmentToken: tc.token,
+			})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				db.AssertNotCalled(t, "PutDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth"))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outStatus, put.Status)
+				db.AssertExpectations(t)
+			}
+			switch tc.outStatus {
+			case model.DevStatusAccepted:
+				assert.Equal(t, []string{"lock", "put", "unlock"}, calls)
+			case model.DevStatusPending:
+				assert.Equal(t, []string{"lock", "unlock", "put"}, calls)
+			}
+
+			if tc.outStatus == model.DevStatusAccepted {
+				assert.Equal(t, []string{"status_changed:accepted"}, target.calls)
+			} else {
+				assert.Empty(t, target.calls)
+			}
+			if tc.outStatus == model.DevStatusPending {
+				// token attributes are only stamped on admitted auth sets
+				assert.NotContains(t, put.Attributes, "site")
+			}
+		})
+	}
+}
+
+func TestDevAdmAcceptDevicePreAuthQuota(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{
+			ID:       "foo",
+			DeviceId: "bar",
+			Status:   model.DevStatusPreauthorized,
+		}, nil)
+	db.On("GetQuota", ctx).
+		Return(&model.Quota{MaxDevices: 1}, nil)
+	db.On("GetDeviceAuths", ctx, 0, 1, store.Filter{
+		DeviceID: "bar",
+		Status:   model.DevStatusAccepted,
+	}).Return([]model.DeviceAuth{}, nil)
+	db.On("CountAcceptedDevices", ctx).
+		Return(1, nil)
+	var calls []string
+	mockQuotaLock(db, &calls)
+
+	d := devadmForTest(db)
+
+	err := d.AcceptDevicePreAuth(ctx, "foo")
+	assert.Equal(t, ErrQuotaExceeded, err)
+	db.AssertNotCalled(t, "UpdateDeviceAuth", ctx,
+		mock.AnythingOfType("*model.DeviceAuth"))
+	assert.Equal(t, []string{"lock", "unlock"}, calls)
+}
+
+func TestDevAdmQuotaLockHeld(t *testing.T) {
+	testCases := map[string]struct {
+		busy int
+
+		outError error
+	}{
+		"ok, released meanwhile": {
+			busy: 2,
+		},
+		"error: never released": {
+			busy:     quotaLockAttempts,
+			outError: ErrQuotaLocked,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			now := time.Now()
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+			after := make(chan time.Time, quotaLockAttempts)
+			for i := 0; i < quotaLockAttempts; i++ {
+				after <- now
+			}
+			clock.On("After", quotaLockRetryInterval).
+				Return((<-chan time.Time)(after))
+
+			attempts := 0
+			db := &mstore.DataStore{}
+			db.On("LockQuota", ctx, mock.AnythingOfType("string"),
+				now.Add(quotaLockTTL), now).
+				Return(func(ctx context.Context, lock string, expires, now time.Time) error {
+					attempts++
+					if attempts <= tc.busy {
+						return store.ErrModified
+					}
+					return nil
+				})
+			db.On("UnlockQuota", ctx, mock.AnythingOfType("string")).
+				Return(nil)
+			db.On("GetDeviceAuths", ctx, 0, 1, store.Filter{
+				DeviceID: "bar",
+				Status:   model.DevStatusAccepted,
+			}).Return([]model.DeviceAuth{}, nil)
+			db.On("CountAcceptedDevices", ctx).
+				Return(1, nil)
+
+			d := &DevAdm{db: db, clock: clock}
+
+			release, err := d.reserveDeviceQuota(ctx, "bar", &model.Quota{MaxDevices: 10})
+			if tc.outError != nil {
+				assert.Equal(t, tc.outError, err)
+				assert.Equal(t, quotaLockAttempts, attempts)
+				db.AssertNotCalled(t, "CountAcceptedDevices", ctx)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.busy+1, attempts)
+				db.AssertNotCalled(t, "UnlockQuota", ctx,
+					mock.AnythingOfType("string"))
+				release()
+				db.AssertCalled(t, "UnlockQuota", ctx,
+					mock.AnythingOfType("string"))
+			}
+		})
+	}
+}
+
+func TestDevAdmGetQuotaUsage(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetQuota", ctx).
+		Return(&model.Quota{MaxDevices: 10}, nil)
+	db.On("CountAcceptedDevices", ctx).
+		Return(4, nil)
+
+	d := devadmForTest(db)
+
+	usage, err := d.GetQuotaUsage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.QuotaUsage{Accepted: 4, Allowed: 10}, usage)
+}
+
+func TestDevAdmSetQuota(t *testing.T) {
+	db := &mstore.DataStore{}
+	db.On("PutQuota",
+		mock.MatchedBy(func(ctx context.Context) bool {
+			idty := identity.FromContext(ctx)
+			return idty != nil && idty.Tenant == "tenant-1"
+		}),
+		&model.Quota{MaxDevices: 10}).
+		Return(nil)
+
+	d := devadmForTest(db)
+
+	err := d.SetQuota(context.Background(), "tenant-1", model.Quota{MaxDevices: 10})
+	assert.NoError(t, err)
+	db.AssertExpectations(t)
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+)
+
+const (
+	// names of rate limits, as used in bucket keys and metrics
+	rateLimitIdentity = "identity"
+	rateLimitTenant   = "tenant"
+)
+
+// SubmissionsDropped counts auth set submissions rejected due to rate
+// limits, by name of the exceeded limit
+var SubmissionsDropped = expvar.NewMap("submissions_dropped")
+
+// RateLimitConfig limits the rate of auth set submissions; limits are
+// enforced across all service instances
+type RateLimitConfig struct {
+	// submissions of a single device identity
+	Identity model.RateLimit
+	// all submissions of a tenant
+	Tenant model.RateLimit
+}
+
+// RateLimitError is returned for submissions exceeding a rate limit
+type RateLimitError struct {
+	// time after which the submission can be retried
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %v", e.RetryAfter)
+}
+
+// WithRateLimits enables rate limiting of auth set submissions
+func (d *DevAdm) WithRateLimits(conf RateLimitConfig) *DevAdm {
+	d.ratelimits = &conf
+	return d
+}
+
+// limitSubmission takes a token from rate limit buckets of the auth set's
+// identity and of the tenant; returns RateLimitError if either is empty
+func (d *DevAdm) limitSubmission(ctx context.Context, dev *model.DeviceAuth) error {
+	if d.ratelimits == nil {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(dev.DeviceIdentity))
+	limits := []struct {
+		name  string
+		key   string
+		limit model.RateLimit
+	}{
+		// identity goes first, so that a single flooding device does
+		// not drain the tenant's bucket
+		{rateLimitIdentity, rateLimitIdentity + ":" + hex.EncodeToString(sum[:]),
+			d.ratelimits.Identity},
+		{rateLimitTenant, rateLimitTenant, d.ratelimits.Tenant},
+	}
+
+	for _, l := range limits {
+		if !l.limit.Enabled() {
+			continue
+		}
+		wait, err := d.db.TakeRateLimitToken(ctx, l.key, l.limit, d.clock.Now())
+		if err != nil {
+			return errors.Wrap(err, "failed to check rate limit")
+		}
+		if wait > 0 {
+			SubmissionsDropped.Add(l.name, 1)
+			return &RateLimitError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+func TestDevAdmSubmitDeviceRateLimit(t *testing.T) {
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	identityLimit := model.RateLimit{Burst: 3, Interval: time.Minute}
+	tenantLimit := model.RateLimit{Burst: 100, Interval: time.Second}
+
+	// sha256 of device identity `{"mac":"00:00:00:01"}`
+	identityKey := "identity:" +
+		"0b7aa44e731e95111ae59a73ad1bf654a22e7557287cce7c764aefbf0b1f8d00"
+
+	testCases := map[string]struct {
+		conf *RateLimitConfig
+
+		identityWait time.Duration
+		identityErr  error
+		tenantWait   time.Duration
+
+		dropped  string
+		outError error
+	}{
+		"ok, no limits": {},
+		"ok, limits disabled": {
+			conf: &RateLimitConfig{},
+		},
+		"ok, below limits": {
+			conf: &RateLimitConfig{
+				Identity: identityLimit,
+				Tenant:   tenantLimit,
+			},
+		},
+		"error: identity limit": {
+			conf: &RateLimitConfig{
+				Identity: identityLimit,
+				Tenant:   tenantLimit,
+			},
+			identityWait: 20 * time.Second,
+			dropped:      "identity",
+			outError:     &RateLimitError{RetryAfter: 20 * time.Second},
+		},
+		"error: tenant limit": {
+			conf: &RateLimitConfig{
+				Identity: identityLimit,
+				Tenant:   tenantLimit,
+			},
+			tenantWait: 300 * time.Millisecond,
+			dropped:    "tenant",
+			outError:   &RateLimitError{RetryAfter: 300 * time.Millisecond},
+		},
+		"error: store": {
+			conf: &RateLimitConfig{
+				Identity: identityLimit,
+			},
+			identityErr: errors.New("db connection failed"),
+			outError:    errors.New("failed to check rate limit: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			dev := model.DeviceAuth{
+				ID:             "foo",
+				DeviceId:       "bar",
+				DeviceIdentity: `{"mac":"00:00:00:01"}`,
+				Status:         model.DevStatusPending,
+			}
+
+			db := &mstore.DataStore{}
+			db.On("TakeRateLimitToken", ctx,
+				mock.MatchedBy(func(key string) bool {
+					return key != "tenant"
+				}),
+				identityLimit, now).
+				Return(tc.identityWait, tc.identityErr)
+			db.On("TakeRateLimitToken", ctx, "tenant", tenantLimit, now).
+				Return(tc.tenantWait, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(nil, store.ErrNotFound)
+			db.On("GetBlocklist", ctx).
+				Return([]model.BlocklistEntry{}, nil)
+			db.On("FindAllowlistEntries", ctx,
+				mock.AnythingOfType("model.DeviceAuthAttributes"),
+				model.AuthID("foo")).
+				Return([]model.AllowlistEntry{}, nil)
+			db.On("PutDeviceAuth", ctx,
+				mock.AnythingOfType("*model.DeviceAuth")).
+				Return(nil)
+
+			clock := &mclock.Clock{}
+			clock.On("Now").Return(now)
+
+			d := &DevAdm{db: db, clock: clock}
+			if tc.conf != nil {
+				d.WithRateLimits(*tc.conf)
+			}
+
+			var dropped int64
+			if tc.dropped != "" {
+				dropped = counterValue(tc.dropped)
+			}
+
+			err := d.SubmitDeviceAuth(ctx, dev)
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				if tc.dropped != "" {
+					assert.Equal(t, tc.outError, err)
+					assert.Equal(t, dropped+1, counterValue(tc.dropped))
+				}
+				db.AssertNotCalled(t, "PutDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth"))
+			} else {
+				assert.NoError(t, err)
+				db.AssertCalled(t, "PutDeviceAuth", ctx,
+					mock.AnythingOfType("*model.DeviceAuth"))
+			}
+
+			if tc.conf != nil && tc.conf.Identity.Enabled() {
+				db.AssertCalled(t, "TakeRateLimitToken", ctx,
+					identityKey, identityLimit, now)
+			}
+			if tc.identityWait > 0 {
+				db.AssertNotCalled(t, "TakeRateLimitToken", ctx,
+					"tenant", tenantLimit, now)
+			}
+		})
+	}
+}
+
+func counterValue(name string) int64 {
+	if v := SubmissionsDropped.Get(name); v != nil {
+		return v.(*expvar.Int).Value()
+	}
+	return 0
+}
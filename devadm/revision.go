@@ -0,0 +1,44 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+)
+
+var (
+	ErrRevisionMismatch = errors.New("auth set was modified, revision does not match")
+)
+
+type revisionContextKey struct{}
+
+// WithRevision returns a context under which changes of an auth set are
+// made only if the auth set is still at revision 'rev'
+func WithRevision(ctx context.Context, rev int) context.Context {
+	return context.WithValue(ctx, revisionContextKey{}, rev)
+}
+
+// checkRevision returns ErrRevisionMismatch if 'ctx' requires a revision
+// other than the one of auth set 'dev'
+func checkRevision(ctx context.Context, dev *model.DeviceAuth) error {
+	rev, ok := ctx.Value(revisionContextKey{}).(int)
+	if ok && rev != dev.Revision {
+		return ErrRevisionMismatch
+	}
+	return nil
+}
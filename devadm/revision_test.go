@@ -0,0 +1,159 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+)
+
+func TestDevAdmAcceptDeviceRevision(t *testing.T) {
+	testCases := map[string]struct {
+		ctx       context.Context
+		updateErr error
+
+		updated  bool
+		outError error
+	}{
+		"ok": {
+			ctx:     context.Background(),
+			updated: true,
+		},
+		"ok: revision": {
+			ctx:     WithRevision(context.Background(), 3),
+			updated: true,
+		},
+		"error: revision mismatch": {
+			ctx:      WithRevision(context.Background(), 2),
+			outError: ErrRevisionMismatch,
+		},
+		"error: modified concurrently": {
+			ctx:       context.Background(),
+			updateErr: store.ErrModified,
+			updated:   true,
+			outError:  ErrRevisionMismatch,
+		},
+		"error: generic": {
+			ctx:       context.Background(),
+			updateErr: errors.New("db connection failed"),
+			updated:   true,
+			outError:  errors.New("db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := tc.ctx
+
+			db := &mstore.DataStore{}
+			db.On("GetSettings", ctx).
+				Return(&model.TenantSettings{}, nil)
+			db.On("GetQuota", ctx).
+				Return(&model.Quota{}, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(&model.DeviceAuth{
+					ID:       "foo",
+					DeviceId: "bar",
+					Status:   model.DevStatusPending,
+					Revision: 3,
+				}, nil)
+			// the update is based on the fetched revision
+			db.On("UpdateDeviceAuth", ctx,
+				&model.DeviceAuth{
+					ID:       "foo",
+					Status:   model.DevStatusAccepted,
+					Revision: 3,
+				}).
+				Return(tc.updateErr)
+
+			// a failed update must not reach deviceauth at all
+			clientStatus := http.StatusNoContent
+			if tc.updateErr != nil {
+				clientStatus = http.StatusInternalServerError
+			}
+			d := devadmWithClientForTest(db, clientStatus)
+
+			err := d.AcceptDeviceAuth(ctx, "foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			if tc.updated {
+				db.AssertCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			} else {
+				db.AssertNotCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDevAdmRejectDeviceCheckedRevision(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetSettings", ctx).
+		Return(&model.TenantSettings{}, nil)
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{
+			ID:       "foo",
+			DeviceId: "bar",
+			Status:   model.DevStatusPending,
+			Revision: 3,
+		}, nil).Once()
+	// changed after the transition was checked
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{
+			ID:       "foo",
+			DeviceId: "bar",
+			Status:   model.DevStatusAccepted,
+			Revision: 4,
+		}, nil)
+	db.On("UpdateDeviceAuth", ctx,
+		&model.DeviceAuth{
+			ID:       "foo",
+			Status:   model.DevStatusRejected,
+			Revision: 3,
+		}).
+		Return(store.ErrModified)
+
+	d := devadmWithClientForTest(db, http.StatusNoContent)
+
+	err := d.RejectDeviceAuth(ctx, "foo")
+	assert.EqualError(t, err, ErrRevisionMismatch.Error())
+	db.AssertNumberOfCalls(t, "GetDeviceAuth", 1)
+}
+
+func TestDevAdmDeleteDevicePropagateRevision(t *testing.T) {
+	ctx := WithRevision(context.Background(), 2)
+
+	db := &mstore.DataStore{}
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{ID: "foo", DeviceId: "bar", Revision: 3}, nil)
+
+	d := devadmWithClientForTest(db, http.StatusNoContent)
+
+	err := d.DeleteDeviceAuthPropagate(ctx, "foo", "Bearer foo")
+	assert.EqualError(t, err, ErrRevisionMismatch.Error())
+	db.AssertNotCalled(t, "SoftDeleteDeviceAuth", ctx, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+// TagDeviceAuth adds tags to auth set 'id'; returns store.ErrNotFound if
+// there is no such auth set
+func (d *DevAdm) TagDeviceAuth(ctx context.Context, id model.AuthID, tags []string) error {
+	n, err := d.db.AddDeviceAuthTags(ctx, []model.AuthID{id}, tags)
+	if err != nil {
+		return errors.Wrap(err, "failed to add tags")
+	}
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// UntagDeviceAuth removes tag 'tag' from auth set 'id'; returns
+// store.ErrNotFound if there is no such auth set
+func (d *DevAdm) UntagDeviceAuth(ctx context.Context, id model.AuthID, tag string) error {
+	n, err := d.db.RemoveDeviceAuthTags(ctx, []model.AuthID{id}, []string{tag})
+	if err != nil {
+		return errors.Wrap(err, "failed to remove tags")
+	}
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// UpdateTags adds and removes tags of multiple auth sets; unknown auth
+// sets are skipped
+func (d *DevAdm) UpdateTags(ctx context.Context, req model.TagsReq) (*model.TagsResult, error) {
+	res := &model.TagsResult{}
+
+	if len(req.Add) != 0 {
+		n, err := d.db.AddDeviceAuthTags(ctx, req.IDs, req.Add)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to add tags")
+		}
+		res.Updated = n
+	}
+
+	if len(req.Remove) != 0 {
+		n, err := d.db.RemoveDeviceAuthTags(ctx, req.IDs, req.Remove)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to remove tags")
+		}
+		res.Updated = n
+	}
+
+	return res, nil
+}
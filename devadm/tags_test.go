@@ -0,0 +1,175 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+)
+
+func TestDevAdmTagDeviceAuth(t *testing.T) {
+	testCases := map[string]struct {
+		matched int
+		dbErr   error
+
+		outError error
+	}{
+		"ok": {
+			matched: 1,
+		},
+		"error: not found": {
+			outError: store.ErrNotFound,
+		},
+		"error: db": {
+			dbErr:    errors.New("db connection failed"),
+			outError: errors.New("failed to add tags: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("AddDeviceAuthTags", ctx,
+				[]model.AuthID{"foo"}, []string{"lab", "rma"}).
+				Return(tc.matched, tc.dbErr)
+
+			d := devadmForTest(db)
+
+			err := d.TagDeviceAuth(ctx, "foo", []string{"lab", "rma"})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAdmUntagDeviceAuth(t *testing.T) {
+	testCases := map[string]struct {
+		matched int
+		dbErr   error
+
+		outError error
+	}{
+		"ok": {
+			matched: 1,
+		},
+		"error: not found": {
+			outError: store.ErrNotFound,
+		},
+		"error: db": {
+			dbErr:    errors.New("db connection failed"),
+			outError: errors.New("failed to remove tags: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("RemoveDeviceAuthTags", ctx,
+				[]model.AuthID{"foo"}, []string{"rma"}).
+				Return(tc.matched, tc.dbErr)
+
+			d := devadmForTest(db)
+
+			err := d.UntagDeviceAuth(ctx, "foo", "rma")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDevAdmUpdateTags(t *testing.T) {
+	ids := []model.AuthID{"foo", "bar", "baz"}
+
+	testCases := map[string]struct {
+		req       model.TagsReq
+		addErr    error
+		removeErr error
+
+		outResult *model.TagsResult
+		outError  error
+	}{
+		"ok, add": {
+			req:       model.TagsReq{IDs: ids, Add: []string{"lab"}},
+			outResult: &model.TagsResult{Updated: 2},
+		},
+		"ok, remove": {
+			req:       model.TagsReq{IDs: ids, Remove: []string{"rma"}},
+			outResult: &model.TagsResult{Updated: 2},
+		},
+		"ok, add and remove": {
+			req: model.TagsReq{IDs: ids, Add: []string{"lab"},
+				Remove: []string{"rma"}},
+			outResult: &model.TagsResult{Updated: 2},
+		},
+		"error: add": {
+			req: model.TagsReq{IDs: ids, Add: []string{"lab"},
+				Remove: []string{"rma"}},
+			addErr:   errors.New("db connection failed"),
+			outError: errors.New("failed to add tags: db connection failed"),
+		},
+		"error: remove": {
+			req: model.TagsReq{IDs: ids, Add: []string{"lab"},
+				Remove: []string{"rma"}},
+			removeErr: errors.New("db connection failed"),
+			outError:  errors.New("failed to remove tags: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("AddDeviceAuthTags", ctx, ids, []string{"lab"}).
+				Return(2, tc.addErr)
+			db.On("RemoveDeviceAuthTags", ctx, ids, []string{"rma"}).
+				Return(2, tc.removeErr)
+
+			d := devadmForTest(db)
+
+			res, err := d.UpdateTags(ctx, tc.req)
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Nil(t, res)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.outResult, res)
+			}
+
+			if len(tc.req.Add) == 0 {
+				db.AssertNotCalled(t, "AddDeviceAuthTags", ctx, ids, []string{"lab"})
+			}
+			if len(tc.req.Remove) == 0 {
+				db.AssertNotCalled(t, "RemoveDeviceAuthTags", ctx, ids, []string{"rma"})
+			}
+		})
+	}
+}
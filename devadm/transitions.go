@@ -0,0 +1,57 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+)
+
+var (
+	ErrInvalidTransition = errors.New("status transition is not allowed")
+)
+
+// checkTransition returns ErrInvalidTransition if the tenant's transition
+// table does not allow moving auth set 'id' to 'status'; the auth set is
+// returned as checked, so that the update applies to this very revision
+func (d *DevAdm) checkTransition(ctx context.Context, settings *model.TenantSettings,
+	id model.AuthID, status string) (*model.DeviceAuth, error) {
+	dev, err := d.db.GetDeviceAuth(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// fail early, before any approval is recorded
+	err = checkRevision(ctx, dev)
+	if err != nil {
+		return nil, err
+	}
+
+	if !settings.GetTransitions().Allowed(dev.Status, status) {
+		return nil, ErrInvalidTransition
+	}
+	return dev, nil
+}
+
+// NextStatuses returns statuses auth set 'dev' may be moved to by users
+func (d *DevAdm) NextStatuses(ctx context.Context, dev *model.DeviceAuth) ([]string, error) {
+	settings, err := d.GetSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return settings.GetTransitions().Next(dev.Status), nil
+}
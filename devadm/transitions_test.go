@@ -0,0 +1,185 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/model"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+)
+
+func TestDevAdmStatusTransitions(t *testing.T) {
+	// re-submission is required to accept a rejected device again
+	strict := model.Transitions{
+		model.DevStatusPending:  {model.DevStatusAccepted, model.DevStatusRejected},
+		model.DevStatusAccepted: {model.DevStatusRejected},
+	}
+
+	testCases := map[string]struct {
+		transitions model.Transitions
+		from        string
+		to          string
+
+		outError error
+	}{
+		"default: accept pending": {
+			from: model.DevStatusPending,
+			to:   model.DevStatusAccepted,
+		},
+		"default: accept rejected": {
+			from: model.DevStatusRejected,
+			to:   model.DevStatusAccepted,
+		},
+		"default: suspend pending": {
+			from:     model.DevStatusPending,
+			to:       model.DevStatusSuspended,
+			outError: ErrInvalidTransition,
+		},
+		"custom: reject accepted": {
+			transitions: strict,
+			from:        model.DevStatusAccepted,
+			to:          model.DevStatusRejected,
+		},
+		"custom: accept rejected": {
+			transitions: strict,
+			from:        model.DevStatusRejected,
+			to:          model.DevStatusAccepted,
+			outError:    ErrInvalidTransition,
+		},
+		"custom: suspend accepted": {
+			transitions: strict,
+			from:        model.DevStatusAccepted,
+			to:          model.DevStatusSuspended,
+			outError:    ErrInvalidTransition,
+		},
+		"custom: suspend pending": {
+			// stored before only accepted auth sets could be suspended
+			transitions: model.Transitions{
+				model.DevStatusPending: {model.DevStatusSuspended},
+			},
+			from:     model.DevStatusPending,
+			to:       model.DevStatusSuspended,
+			outError: ErrInvalidTransition,
+		},
+		"custom: same status": {
+			transitions: strict,
+			from:        model.DevStatusRejected,
+			to:          model.DevStatusRejected,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("GetSettings", ctx).
+				Return(&model.TenantSettings{Transitions: tc.transitions}, nil)
+			db.On("GetQuota", ctx).
+				Return(&model.Quota{}, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(&model.DeviceAuth{ID: "foo", DeviceId: "bar", Status: tc.from}, nil)
+			db.On("UpdateDeviceAuth", ctx,
+				mock.AnythingOfType("*model.DeviceAuth")).
+				Return(nil)
+
+			d := devadmWithClientForTest(db, http.StatusNoContent)
+
+			var err error
+			switch tc.to {
+			case model.DevStatusAccepted:
+				err = d.AcceptDeviceAuth(ctx, "foo")
+			case model.DevStatusRejected:
+				err = d.RejectDeviceAuth(ctx, "foo")
+			case model.DevStatusSuspended:
+				err = d.SuspendDeviceAuth(ctx, "foo")
+			}
+
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				db.AssertNotCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			} else {
+				assert.NoError(t, err)
+				db.AssertCalled(t, "UpdateDeviceAuth", ctx, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestDevAdmNextStatuses(t *testing.T) {
+	testCases := map[string]struct {
+		settings    *model.TenantSettings
+		settingsErr error
+		status      string
+
+		next     []string
+		outError error
+	}{
+		"default": {
+			settings: &model.TenantSettings{},
+			status:   model.DevStatusAccepted,
+			next:     []string{model.DevStatusRejected, model.DevStatusSuspended},
+		},
+		"custom": {
+			settings: &model.TenantSettings{
+				Transitions: model.Transitions{
+					model.DevStatusAccepted: {model.DevStatusRejected},
+				},
+			},
+			status: model.DevStatusAccepted,
+			next:   []string{model.DevStatusRejected},
+		},
+		"custom: final status": {
+			settings: &model.TenantSettings{
+				Transitions: model.Transitions{
+					model.DevStatusAccepted: {model.DevStatusRejected},
+				},
+			},
+			status: model.DevStatusRejected,
+			next:   []string{},
+		},
+		"error: settings": {
+			settingsErr: errors.New("db connection failed"),
+			status:      model.DevStatusAccepted,
+			outError:    errors.New("failed to fetch settings: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			db := &mstore.DataStore{}
+			db.On("GetSettings", ctx).
+				Return(tc.settings, tc.settingsErr)
+
+			d := devadmForTest(db)
+
+			next, err := d.NextStatuses(ctx, &model.DeviceAuth{ID: "foo", Status: tc.status})
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.next, next)
+			}
+		})
+	}
+}
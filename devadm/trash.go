@@ -0,0 +1,128 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/client/deviceauth"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	"github.com/mendersoftware/deviceadm/utils"
+)
+
+func (d *DevAdm) ListDeletedDeviceAuths(ctx context.Context, skip, limit int) ([]model.DeviceAuth, error) {
+	devs, err := d.db.GetDeletedDeviceAuths(ctx, skip, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch deleted devices")
+	}
+	return devs, nil
+}
+
+// RestoreDeviceAuth takes deleted auth set 'id' out of the trash and
+// recreates it in devauth and other targets; returns store.ErrNotFound if
+// the auth set is not in the trash, AuthSetConflictError if an auth set
+// with the same identity data exists meanwhile, and ErrDeviceBlocked if
+// the auth set matches the blocklist
+func (d *DevAdm) RestoreDeviceAuth(ctx context.Context, id model.AuthID, authorizationHeader string) (*model.DeviceAuth, error) {
+	dev, err := d.db.GetDeletedDeviceAuth(ctx, id)
+	switch err {
+	case nil:
+		break
+	case store.ErrNotFound:
+		return nil, err
+	default:
+		return nil, errors.Wrap(err, "failed to fetch deleted device")
+	}
+
+	// same rule as for preauthorization, the identity must not be taken
+	others, err := d.db.GetDeviceAuthsByIdentityData(ctx, dev.DeviceIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if len(others) > 0 {
+		return nil, AuthSetConflictError
+	}
+
+	blocked, err := d.matchBlocklist(ctx, dev)
+	if err != nil {
+		return nil, err
+	}
+	if blocked != nil {
+		d.recordBlocklistHit(ctx, blocked, dev)
+		return nil, ErrDeviceBlocked
+	}
+
+	if dev.Status == model.DevStatusAccepted {
+		release, err := d.reserveNewDeviceQuota(ctx, dev.DeviceId)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	dev.DeletedAt = nil
+
+	err = d.propagateDeviceAuthRestore(ctx, dev, authorizationHeader)
+	if err != nil {
+		if errors.Cause(err) == deviceauth.ErrConflict {
+			return nil, AuthSetConflictError
+		}
+		return nil, err
+	}
+
+	err = d.db.RestoreDeviceAuth(ctx, id)
+	if err != nil {
+		// the auth set stays in the trash, targets must forget it again
+		d.rollbackDeviceAuthRestore(ctx, d.allHooks(), dev, authorizationHeader)
+		if err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to restore device")
+	}
+
+	d.emit(ctx, model.EventAuthSetRestored, dev)
+	return dev, nil
+}
+
+func (d *DevAdm) propagateDeviceAuthRestore(ctx context.Context, dev *model.DeviceAuth, authorizationHeader string) error {
+	done, err := d.propagate(ctx, d.allHooks(), func(t PropagationTarget) error {
+		return t.Restored(ctx, dev, authorizationHeader)
+	})
+	if err != nil {
+		// the auth set stays in the trash, delete it again on targets
+		// that already restored it
+		d.rollbackDeviceAuthRestore(ctx, done, dev, authorizationHeader)
+		if utils.IsUsageError(err) {
+			return err
+		}
+	}
+	return errors.Wrap(err, "failed to propagate device authentication set restore")
+}
+
+// rollbackDeviceAuthRestore deletes restored auth set 'dev' again on the
+// targets of 'hooks', in reverse order; failures are only logged
+func (d *DevAdm) rollbackDeviceAuthRestore(ctx context.Context, hooks []propagationHook, dev *model.DeviceAuth, authorizationHeader string) {
+	l := log.FromContext(ctx)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i].target.Deleted(ctx, dev, authorizationHeader)
+		if err != nil {
+			l.Errorf("failed to roll back restore of auth set %v in %s: %v",
+				dev.ID, hooks[i].target.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	"github.com/mendersoftware/deviceadm/utils/clock"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+// devauthRecorder answers devauth requests with a status depending on
+// the request method, and records them
+type devauthRecorder struct {
+	status map[string]int
+	calls  []string
+}
+
+func (r *devauthRecorder) Do(req *http.Request) (*http.Response, error) {
+	r.calls = append(r.calls, req.Method)
+
+	w := httptest.NewRecorder()
+	w.WriteHeader(r.status[req.Method])
+	return w.Result(), nil
+}
+
+func TestDevAdmListDeletedDeviceAuths(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1500000000, 0)
+
+	devs := []model.DeviceAuth{
+		{ID: "1", DeviceId: "bar", DeletedAt: &now},
+	}
+
+	db := &mstore.DataStore{}
+	db.On("GetDeletedDeviceAuths", ctx, 0, 20).
+		Return(devs, nil)
+	db.On("GetDeletedDeviceAuths", ctx, 20, 20).
+		Return(nil, errors.New("db connection failed"))
+
+	d := devadmForTest(db)
+
+	out, err := d.ListDeletedDeviceAuths(ctx, 0, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, devs, out)
+
+	out, err = d.ListDeletedDeviceAuths(ctx, 20, 20)
+	assert.EqualError(t, err, "failed to fetch deleted devices: db connection failed")
+	assert.Nil(t, out)
+}
+
+func TestDevAdmRestoreDeviceAuth(t *testing.T) {
+	deletedAt := time.Unix(1500000000, 0)
+
+	testCases := map[string]struct {
+		status     string
+		getErr     error
+		others     []model.DeviceAuth
+		blocklist  []model.BlocklistEntry
+		quota      int
+		accepted   int
+		devauth    map[string]int
+		targetErr  error
+		restoreErr error
+
+		devauthCalls []string
+		targetCalls  []string
+		locks        []string
+		outError     error
+	}{
+		"ok, accepted": {
+			status: model.DevStatusAccepted,
+			devauth: map[string]int{
+				http.MethodPost: http.StatusCreated,
+				http.MethodPut:  http.StatusNoContent,
+			},
+			devauthCalls: []string{http.MethodPost, http.MethodPut},
+			targetCalls:  []string{"restored:accepted"},
+		},
+		"ok, preauthorized": {
+			status: model.DevStatusPreauthorized,
+			devauth: map[string]int{
+				http.MethodPost: http.StatusCreated,
+			},
+			devauthCalls: []string{http.MethodPost},
+			targetCalls:  []string{"restored:preauthorized"},
+		},
+		"ok, blocklist does not match": {
+			status: model.DevStatusPending,
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", Attributes: map[string]string{"sn": "other"}},
+			},
+			devauth: map[string]int{
+				http.MethodPost: http.StatusCreated,
+				http.MethodPut:  http.StatusNoContent,
+			},
+			devauthCalls: []string{http.MethodPost, http.MethodPut},
+			targetCalls:  []string{"restored:pending"},
+		},
+		"ok, within quota": {
+			status:   model.DevStatusAccepted,
+			quota:    2,
+			accepted: 1,
+			devauth: map[string]int{
+				http.MethodPost: http.StatusCreated,
+				http.MethodPut:  http.StatusNoContent,
+			},
+			devauthCalls: []string{http.MethodPost, http.MethodPut},
+			targetCalls:  []string{"restored:accepted"},
+			locks:        []string{"lock", "restore", "unlock"},
+		},
+		"error: not in trash": {
+			getErr:   store.ErrNotFound,
+			outError: store.ErrNotFound,
+		},
+		"error: db": {
+			getErr:   errors.New("db connection failed"),
+			outError: errors.New("failed to fetch deleted device: db connection failed"),
+		},
+		"error: resubmitted meanwhile": {
+			status:   model.DevStatusPending,
+			others:   []model.DeviceAuth{{ID: "2", Key: "foo-key"}},
+			outError: AuthSetConflictError,
+		},
+		"error: identity taken with another key": {
+			status:   model.DevStatusPending,
+			others:   []model.DeviceAuth{{ID: "2", Key: "other-key"}},
+			outError: AuthSetConflictError,
+		},
+		"error: blocklisted": {
+			status: model.DevStatusAccepted,
+			blocklist: []model.BlocklistEntry{
+				{ID: "1", Attributes: map[string]string{"sn": "1234"}},
+			},
+			outError: ErrDeviceBlocked,
+		},
+		"error: quota exceeded": {
+			status:   model.DevStatusAccepted,
+			quota:    1,
+			accepted: 1,
+			locks:    []string{"lock", "unlock"},
+			outError: ErrQuotaExceeded,
+		},
+		"error: conflict in devauth": {
+			status: model.DevStatusRejected,
+			devauth: map[string]int{
+				http.MethodPost: http.StatusConflict,
+			},
+			devauthCalls: []string{http.MethodPost},
+			outError:     AuthSetConflictError,
+		},
+		"error: target failed": {
+			status: model.DevStatusRejected,
+			devauth: map[string]int{
+				http.MethodPost:   http.StatusCreated,
+				http.MethodPut:    http.StatusNoContent,
+				http.MethodDelete: http.StatusNoContent,
+			},
+			targetErr:    errors.New("provisioning down"),
+			devauthCalls: []string{http.MethodPost, http.MethodPut, http.MethodDelete},
+			targetCalls:  []string{"restored:rejected"},
+			outError:     errors.New("failed to propagate device authentication set restore: provisioning down"),
+		},
+		"error: restore failed": {
+			status: model.DevStatusPreauthorized,
+			devauth: map[string]int{
+				http.MethodPost:   http.StatusCreated,
+				http.MethodDelete: http.StatusNoContent,
+			},
+			restoreErr:   errors.New("db connection failed"),
+			devauthCalls: []string{http.MethodPost, http.MethodDelete},
+			targetCalls:  []string{"restored:preauthorized", PropagationEventDeleted},
+			outError:     errors.New("failed to restore device: db connection failed"),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			var dev *model.DeviceAuth
+			if tc.getErr == nil {
+				dev = &model.DeviceAuth{
+					ID:             "foo",
+					DeviceId:       "bar",
+					DeviceIdentity: "foo-id",
+					Key:            "foo-key",
+					Attributes:     model.DeviceAuthAttributes{"sn": "1234"},
+					Status:         tc.status,
+					DeletedAt:      &deletedAt,
+				}
+			}
+
+			var locks []string
+			db := &mstore.DataStore{}
+			db.On("GetDeletedDeviceAuth", ctx, model.AuthID("foo")).
+				Return(dev, tc.getErr)
+			db.On("GetDeviceAuthsByIdentityData", ctx, "foo-id").
+				Return(tc.others, nil)
+			db.On("GetBlocklist", ctx).
+				Return(tc.blocklist, nil)
+			db.On("InsertBlocklistHit", ctx,
+				mock.MatchedBy(func(h *model.BlocklistHit) bool {
+					return h.EntryID == "1" && h.AuthID == "foo" &&
+						h.DeviceIdentity == "foo-id"
+				})).
+				Return(nil)
+			db.On("GetQuota", ctx).
+				Return(&model.Quota{MaxDevices: tc.quota}, nil)
+			db.On("GetDeviceAuths", ctx, 0, 1,
+				store.Filter{DeviceID: "bar", Status: model.DevStatusAccepted}).
+				Return([]model.DeviceAuth{}, nil)
+			db.On("CountAcceptedDevices", ctx).
+				Return(tc.accepted, nil)
+			db.On("RestoreDeviceAuth", ctx, model.AuthID("foo")).
+				Run(func(args mock.Arguments) {
+					locks = append(locks, "restore")
+				}).
+				Return(tc.restoreErr)
+			mockQuotaLock(db, &locks)
+
+			devauth := &devauthRecorder{status: tc.devauth}
+			target := &fakeTarget{name: "provisioning", err: tc.targetErr}
+
+			d := &DevAdm{
+				db: db,
+				clientGetter: func() client.HttpRunner {
+					return devauth
+				},
+				clock: clock.NewClock(),
+			}
+			d.WithPropagationTarget(target, FailurePolicyRequired)
+
+			out, err := d.RestoreDeviceAuth(ctx, "foo", "Bearer foo")
+			if tc.outError != nil {
+				assert.EqualError(t, err, tc.outError.Error())
+				assert.Nil(t, out)
+			} else {
+				assert.NoError(t, err)
+				if assert.NotNil(t, out) {
+					assert.Equal(t, model.AuthID("foo"), out.ID)
+					assert.Nil(t, out.DeletedAt)
+				}
+			}
+			assert.Equal(t, tc.devauthCalls, devauth.calls)
+			assert.Equal(t, tc.targetCalls, target.calls)
+			if tc.locks != nil {
+				assert.Equal(t, tc.locks, locks)
+			}
+
+			if tc.outError == nil || tc.restoreErr != nil {
+				db.AssertCalled(t, "RestoreDeviceAuth", ctx, model.AuthID("foo"))
+			} else {
+				db.AssertNotCalled(t, "RestoreDeviceAuth", ctx, model.AuthID("foo"))
+			}
+			if tc.outError == ErrDeviceBlocked {
+				db.AssertCalled(t, "InsertBlocklistHit", ctx,
+					mock.AnythingOfType("*model.BlocklistHit"))
+			}
+		})
+	}
+}
+
+func TestDevAdmDeleteDeviceAuthMovesToTrash(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1500000000, 0)
+
+	db := &mstore.DataStore{}
+	db.On("SoftDeleteDeviceAuth", ctx, model.AuthID("foo"), store.AnyRevision, now).
+		Return(nil)
+	db.On("SoftDeleteDeviceAuthByDevice", ctx, model.DeviceID("bar"), now).
+		Return(nil)
+
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(now)
+
+	d := devadmForTest(db).(*DevAdm)
+	d.clock = clock
+
+	err := d.DeleteDeviceAuth(ctx, "foo")
+	assert.NoError(t, err)
+
+	err = d.DeleteDeviceData(ctx, "bar")
+	assert.NoError(t, err)
+
+	db.AssertExpectations(t)
+	db.AssertNotCalled(t, "DeleteDeviceAuth", ctx, mock.Anything)
+	db.AssertNotCalled(t, "DeleteDeviceAuthByDevice", ctx, mock.Anything)
+}
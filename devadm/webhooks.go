@@ -0,0 +1,696 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/pkg/errors"
+
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+)
+
+const (
+	// headers added to webhook requests
+	WebhookSignatureHeader = "X-Deviceadm-Signature"
+	WebhookEventHeader     = "X-Deviceadm-Event"
+	WebhookDeliveryHeader  = "X-Deviceadm-Delivery"
+
+	defaultWebhookTimeout        = time.Duration(10) * time.Second
+	defaultWebhookWorkers        = 4
+	defaultWebhookQueueSize      = 1000
+	defaultWebhookResumeInterval = time.Duration(1) * time.Minute
+)
+
+var (
+	ErrWebhookNotFound    = errors.New("webhook not found")
+	ErrWebhookDestination = errors.New("webhook destination address is not allowed")
+)
+
+// address ranges webhook requests are not sent to, unless
+// WebhookConfig.AllowPrivateDestinations is set; loopback, link-local and
+// unspecified addresses are refused too
+var webhookPrivateNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// WebhookConfig controls delivery of webhook requests
+type WebhookConfig struct {
+	// number of retries after the first failed attempt
+	MaxRetries int
+	// delay before the first retry, doubled on each consecutive retry
+	RetryBackoff time.Duration
+	// timeout of a single request
+	Timeout time.Duration
+	// number of deliveries made concurrently
+	Workers int
+	// max. number of deliveries waiting for a worker; deliveries that
+	// don't fit are left pending until ResumeWebhookDeliveries
+	QueueSize int
+	// how often ResumeWebhookDeliveries looks for pending deliveries;
+	// also the time a queued delivery is claimed for
+	ResumeInterval time.Duration
+	// deliver to loopback, private and link-local addresses too
+	AllowPrivateDestinations bool
+}
+
+// webhookJob is a delivery waiting for a worker
+type webhookJob struct {
+	ctx      context.Context
+	hook     model.Webhook
+	delivery *model.WebhookDelivery
+}
+
+type webhookDispatcher struct {
+	conf  WebhookConfig
+	queue chan webhookJob
+
+	// plain client, webhooks are called without the client certificate
+	// and proxy used for other services
+	client *http.Client
+
+	// guards closing of the queue
+	mtx     sync.RWMutex
+	stopped bool
+
+	// closed on stop, before the queue, so that enqueue waiting for room
+	// in the queue gives up and lets go of the lock
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// parent of delivery contexts, canceled to abort deliveries in
+	// progress
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// tracks workers
+	wg sync.WaitGroup
+}
+
+// WithWebhooks enables delivery of admission events to webhooks
+// registered by tenants, and starts the workers making the deliveries
+func (d *DevAdm) WithWebhooks(conf WebhookConfig) *DevAdm {
+	if conf.Timeout == 0 {
+		conf.Timeout = defaultWebhookTimeout
+	}
+	if conf.Workers <= 0 {
+		conf.Workers = defaultWebhookWorkers
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultWebhookQueueSize
+	}
+	if conf.ResumeInterval == 0 {
+		conf.ResumeInterval = defaultWebhookResumeInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &webhookDispatcher{
+		conf:   conf,
+		queue:  make(chan webhookJob, conf.QueueSize),
+		client: newWebhookClient(conf),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < conf.Workers; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for job := range w.queue {
+				d.deliverWebhook(job.ctx, &job.hook, job.delivery)
+			}
+		}()
+	}
+
+	d.webhooks = w
+	return d
+}
+
+// newWebhookClient returns the client webhook requests are made with; the
+// destination is checked on connecting, so that names resolving to
+// internal addresses, or redirects to them, are refused as well
+func newWebhookClient(conf WebhookConfig) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: conf.Timeout,
+	}
+	if !conf.AllowPrivateDestinations {
+		dialer.Control = checkWebhookDestination
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: conf.Timeout,
+			MaxIdleConnsPerHost: conf.Workers,
+		},
+	}
+}
+
+// checkWebhookDestination refuses connections to addresses internal to the
+// deployment, see webhookPrivateNets
+func checkWebhookDestination(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrWebhookDestination
+	}
+
+	if ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() {
+		return ErrWebhookDestination
+	}
+	for _, n := range webhookPrivateNets {
+		if n.Contains(ip) {
+			return ErrWebhookDestination
+		}
+	}
+	return nil
+}
+
+// StopWebhooks stops accepting deliveries and waits until the queued ones
+// are made; if 'ctx' is done first, deliveries in progress are aborted and
+// left pending, for ResumeWebhookDeliveries to pick them up
+func (d *DevAdm) StopWebhooks(ctx context.Context) error {
+	if d.webhooks == nil {
+		return nil
+	}
+	w := d.webhooks
+
+	w.stopOnce.Do(func() { close(w.stop) })
+
+	w.mtx.Lock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.queue)
+	}
+	w.mtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// enqueue passes 'job' to the workers; unless 'wait' is set, it gives up
+// right away if the queue is full, otherwise when 'ctx' is done or
+// deliveries are stopped
+func (w *webhookDispatcher) enqueue(ctx context.Context, job webhookJob, wait bool) bool {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+
+	if w.stopped {
+		return false
+	}
+
+	if !wait {
+		select {
+		case w.queue <- job:
+			return true
+		default:
+			return false
+		}
+	}
+
+	select {
+	case w.queue <- job:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-w.stop:
+		return false
+	}
+}
+
+// ResumeWebhookDeliveries queues pending deliveries of all tenants, i.e.
+// ones that did not fit in the queue or were interrupted by a shutdown,
+// every ResumeInterval until 'ctx' is done or deliveries are stopped; it's
+// meant to be started on startup
+//
+// Deliveries are claimed before they are queued, and again before each
+// attempt, so that instances resuming deliveries side by side don't make
+// the same one; receivers must be prepared to get a delivery more than
+// once anyway (see WebhookDeliveryHeader).
+func (d *DevAdm) ResumeWebhookDeliveries(ctx context.Context) error {
+	if d.webhooks == nil {
+		return nil
+	}
+
+	l := log.FromContext(ctx)
+	for {
+		err := d.resumeAllWebhookDeliveries(ctx)
+		if err != nil && ctx.Err() == nil {
+			l.Errorf("failed to resume webhook deliveries: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.webhooks.stop:
+			return nil
+		case <-d.clock.After(d.webhooks.conf.ResumeInterval):
+		}
+	}
+}
+
+// resumeAllWebhookDeliveries queues pending deliveries of all tenants once
+func (d *DevAdm) resumeAllWebhookDeliveries(ctx context.Context) error {
+	tenants, err := d.db.GetTenants(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch tenants")
+	}
+
+	for _, tenant := range tenants {
+		tctx := ctx
+		if tenant != "" {
+			tctx = identity.WithContext(ctx, &identity.Identity{
+				Tenant: tenant,
+			})
+		}
+
+		if err := d.resumeWebhookDeliveries(tctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DevAdm) resumeWebhookDeliveries(ctx context.Context) error {
+	l := log.FromContext(ctx)
+
+	deliveries, err := d.db.GetPendingWebhookDeliveries(ctx, d.clock.Now())
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch webhook deliveries")
+	}
+
+	hooks := map[string]*model.Webhook{}
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		hook, ok := hooks[delivery.WebhookID]
+		if !ok {
+			hook, err = d.db.GetWebhook(ctx, delivery.WebhookID)
+			if err != nil && err != store.ErrNotFound {
+				return errors.Wrap(err, "failed to fetch webhook")
+			}
+			hooks[delivery.WebhookID] = hook
+		}
+
+		reason := ""
+		switch {
+		case hook == nil:
+			reason = "webhook was removed"
+		case len(delivery.Payload) == 0:
+			reason = "payload was not recorded"
+		}
+
+		if reason != "" {
+			now := d.clock.Now()
+			delivery.Status = model.WebhookDeliveryFailed
+			delivery.Error = reason
+			delivery.Updated = &now
+			if err := d.db.UpdateWebhookDelivery(ctx, delivery); err != nil {
+				l.Errorf("failed to update delivery %s to webhook %s: %v",
+					delivery.ID, delivery.WebhookID, err)
+			}
+			continue
+		}
+
+		claimed, err := d.claimWebhookDelivery(ctx, delivery, "",
+			d.webhooks.conf.ResumeInterval)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		job := webhookJob{
+			ctx:      detachContext(d.webhooks.ctx, ctx),
+			hook:     *hook,
+			delivery: delivery,
+		}
+		if !d.webhooks.enqueue(ctx, job, true) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.New("webhook deliveries are stopped")
+		}
+	}
+	return nil
+}
+
+func (d *DevAdm) CreateWebhook(ctx context.Context, req model.WebhookReq) (*model.Webhook, error) {
+	now := d.clock.Now()
+	hook := &model.Webhook{
+		URL:     req.URL,
+		Events:  req.Events,
+		Secret:  req.Secret,
+		Created: &now,
+	}
+
+	err := d.db.InsertWebhook(ctx, hook)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create webhook")
+	}
+	return hook, nil
+}
+
+func (d *DevAdm) ListWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	hooks, err := d.db.GetWebhooks(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch webhooks")
+	}
+	return hooks, nil
+}
+
+func (d *DevAdm) GetWebhook(ctx context.Context, id string) (*model.Webhook, error) {
+	hook, err := d.db.GetWebhook(ctx, id)
+	switch err {
+	case nil:
+		return hook, nil
+	case store.ErrNotFound:
+		return nil, ErrWebhookNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to fetch webhook")
+	}
+}
+
+func (d *DevAdm) DeleteWebhook(ctx context.Context, id string) error {
+	err := d.db.DeleteWebhook(ctx, id)
+	switch err {
+	case nil:
+		return nil
+	case store.ErrNotFound:
+		return ErrWebhookNotFound
+	default:
+		return errors.Wrap(err, "failed to delete webhook")
+	}
+}
+
+func (d *DevAdm) ListWebhookDeliveries(ctx context.Context, id string, skip, limit int) ([]model.WebhookDelivery, error) {
+	if _, err := d.GetWebhook(ctx, id); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := d.db.GetWebhookDeliveries(ctx, id, skip, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+// notifyWebhooks queues delivery of 'event' about auth set 'dev' to all
+// subscribed webhooks; failures are logged and never returned, as the
+// admission decision has already been made
+func (d *DevAdm) notifyWebhooks(ctx context.Context, event string, dev *model.DeviceAuth) {
+	if d.webhooks == nil {
+		return
+	}
+
+	l := log.FromContext(ctx)
+
+	hooks, err := d.db.GetWebhooks(ctx)
+	if err != nil {
+		l.Errorf("failed to fetch webhooks: %v", err)
+		return
+	}
+
+	for i := range hooks {
+		hook := hooks[i]
+		if !hook.Subscribed(event) {
+			continue
+		}
+
+		claim, err := newLeaseToken()
+		if err != nil {
+			l.Errorf("failed to prepare delivery to webhook %s: %v",
+				hook.ID, err)
+			continue
+		}
+
+		// claimed right away, the delivery is queued below
+		now := d.clock.Now()
+		claimExpires := now.Add(d.webhooks.conf.ResumeInterval)
+		delivery := &model.WebhookDelivery{
+			WebhookID:    hook.ID,
+			Event:        event,
+			AuthID:       dev.ID,
+			Status:       model.WebhookDeliveryPending,
+			Created:      &now,
+			Claim:        claim,
+			ClaimExpires: &claimExpires,
+		}
+		if err := d.db.InsertWebhookDelivery(ctx, delivery); err != nil {
+			l.Errorf("failed to record delivery to webhook %s: %v",
+				hook.ID, err)
+			continue
+		}
+
+		payload := model.WebhookPayload{
+			ID:        delivery.ID,
+			Event:     event,
+			Timestamp: now,
+			AuthSet:   dev,
+		}
+		if id := identity.FromContext(ctx); id != nil {
+			payload.TenantID = id.Tenant
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			l.Errorf("failed to prepare payload for webhook %s: %v",
+				hook.ID, err)
+			continue
+		}
+
+		delivery.Payload = body
+		if err := d.db.UpdateWebhookDelivery(ctx, delivery); err != nil {
+			l.Errorf("failed to record payload of delivery %s to webhook %s: %v",
+				delivery.ID, hook.ID, err)
+			continue
+		}
+
+		job := webhookJob{
+			ctx:      detachContext(d.webhooks.ctx, ctx),
+			hook:     hook,
+			delivery: delivery,
+		}
+		if !d.webhooks.enqueue(ctx, job, false) {
+			l.Warnf("webhook delivery queue is full or stopped, delivery %s to webhook %s left pending",
+				delivery.ID, hook.ID)
+		}
+	}
+}
+
+// detachContext returns a child of 'parent' carrying identity and logger of
+// 'ctx', which is not canceled when the request that created 'ctx' completes
+func detachContext(parent, ctx context.Context) context.Context {
+	dctx := log.WithContext(parent, log.FromContext(ctx))
+	if id := identity.FromContext(ctx); id != nil {
+		dctx = identity.WithContext(dctx, id)
+	}
+	return dctx
+}
+
+// claimWebhookDelivery claims 'delivery' for token 'claim', or a new token
+// if empty, for 'ttl'; returns false if another token holds it
+func (d *DevAdm) claimWebhookDelivery(ctx context.Context,
+	delivery *model.WebhookDelivery, claim string, ttl time.Duration) (bool, error) {
+
+	if claim == "" {
+		var err error
+		claim, err = newLeaseToken()
+		if err != nil {
+			return false, errors.Wrap(err, "failed to generate claim token")
+		}
+	}
+
+	now := d.clock.Now()
+	err := d.db.ClaimWebhookDelivery(ctx, delivery, claim, now.Add(ttl), now)
+	switch err {
+	case nil:
+		return true, nil
+	case store.ErrModified:
+		return false, nil
+	default:
+		return false, errors.Wrap(err, "failed to claim webhook delivery")
+	}
+}
+
+// deliverWebhook sends the payload of 'delivery' to 'hook', retrying failed
+// requests with exponential backoff and recording the outcome in the
+// delivery log; if 'ctx' is done, the delivery is left pending
+func (d *DevAdm) deliverWebhook(ctx context.Context, hook *model.Webhook,
+	delivery *model.WebhookDelivery) {
+
+	l := log.FromContext(ctx)
+	conf := d.webhooks.conf
+
+	backoff := conf.RetryBackoff
+	for {
+		if ctx.Err() != nil {
+			l.Warnf("delivery %s to webhook %s interrupted, left pending",
+				delivery.ID, hook.ID)
+			return
+		}
+
+		// hold the claim through the attempt and the wait for the next
+		claimed, err := d.claimWebhookDelivery(ctx, delivery, delivery.Claim,
+			conf.Timeout+backoff)
+		if err != nil {
+			l.Errorf("delivery %s to webhook %s left pending: %v",
+				delivery.ID, hook.ID, err)
+			return
+		}
+		if !claimed {
+			l.Warnf("delivery %s to webhook %s was claimed by another instance",
+				delivery.ID, hook.ID)
+			return
+		}
+
+		code, err := d.sendWebhook(ctx, hook, delivery)
+		if ctx.Err() != nil {
+			continue
+		}
+
+		now := d.clock.Now()
+		delivery.Attempts++
+		delivery.StatusCode = code
+		delivery.Updated = &now
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		retry := err != nil && retryableWebhookStatus(code) &&
+			delivery.Attempts <= conf.MaxRetries
+
+		switch {
+		case err == nil:
+			delivery.Status = model.WebhookDeliveryDelivered
+		case !retry:
+			delivery.Status = model.WebhookDeliveryFailed
+		}
+
+		if uerr := d.db.UpdateWebhookDelivery(ctx, delivery); uerr != nil {
+			l.Errorf("failed to update delivery %s to webhook %s: %v",
+				delivery.ID, hook.ID, uerr)
+		}
+
+		if !retry {
+			if err != nil {
+				l.Warnf("delivery %s to webhook %s failed: %v",
+					delivery.ID, hook.ID, err)
+			}
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-d.clock.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// sendWebhook makes a single delivery attempt, returns the response
+// status code (0 if no response was received)
+func (d *DevAdm) sendWebhook(ctx context.Context, hook *model.Webhook,
+	delivery *model.WebhookDelivery) (int, error) {
+
+	body := delivery.Payload
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to prepare request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(hook.Secret, body))
+
+	ctx, cancel := context.WithTimeout(ctx, d.webhooks.conf.Timeout)
+	defer cancel()
+
+	rsp, err := d.webhooks.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, errors.Wrap(err, "request failed")
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return rsp.StatusCode, errors.Errorf("request failed with status %v",
+			rsp.Status)
+	}
+	return rsp.StatusCode, nil
+}
+
+// retryableWebhookStatus checks if a request that ended with status
+// 'code' is worth retrying; 0 stands for no response
+func retryableWebhookStatus(code int) bool {
+	return code == 0 ||
+		code == http.StatusTooManyRequests ||
+		code >= http.StatusInternalServerError
+}
+
+// SignWebhookPayload computes the value of signature header for 'body',
+// receivers should compute the same value using the webhook secret and
+// compare it with the header
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,779 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package devadm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mendersoftware/go-lib-micro/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/mendersoftware/deviceadm/client"
+	"github.com/mendersoftware/deviceadm/client/deviceauth"
+	"github.com/mendersoftware/deviceadm/model"
+	"github.com/mendersoftware/deviceadm/store"
+	mstore "github.com/mendersoftware/deviceadm/store/mocks"
+	mclock "github.com/mendersoftware/deviceadm/utils/clock/mocks"
+)
+
+// webhookReceiver is a local endpoint answering with 'statuses' in turn
+type webhookReceiver struct {
+	sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.Lock()
+	defer rcv.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	rcv.bodies = append(rcv.bodies, body)
+	rcv.headers = append(rcv.headers, r.Header)
+
+	status := http.StatusOK
+	if len(rcv.statuses) > 0 {
+		status = rcv.statuses[0]
+		rcv.statuses = rcv.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func devadmWithWebhooksForTest(db store.DataStore, maxRetries int) *DevAdm {
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(time.Unix(1500000000, 0))
+	clock.On("After", mock.AnythingOfType("time.Duration")).
+		Return(func(time.Duration) <-chan time.Time {
+			fired := make(chan time.Time, 1)
+			fired <- time.Unix(1500000000, 0)
+			return fired
+		})
+
+	d := NewDevAdm(db, deviceauth.Config{}, clock).
+		WithHttpClient(&client.HttpApi{}).
+		WithWebhooks(WebhookConfig{
+			MaxRetries:   maxRetries,
+			RetryBackoff: time.Millisecond,
+			// receivers listen on localhost
+			AllowPrivateDestinations: true,
+		})
+	return d
+}
+
+// mockWebhookClaims makes claims of webhook deliveries end with 'err'
+func mockWebhookClaims(db *mstore.DataStore, err error) {
+	db.On("ClaimWebhookDelivery",
+		mock.Anything,
+		mock.AnythingOfType("*model.WebhookDelivery"),
+		mock.AnythingOfType("string"),
+		mock.AnythingOfType("time.Time"),
+		mock.AnythingOfType("time.Time")).
+		Return(err)
+}
+
+func TestDevAdmWebhookDelivery(t *testing.T) {
+	testCases := map[string]struct {
+		statuses   []int
+		maxRetries int
+
+		attempts   int
+		status     string
+		statusCode int
+	}{
+		"delivered": {
+			attempts:   1,
+			status:     model.WebhookDeliveryDelivered,
+			statusCode: http.StatusOK,
+		},
+		"delivered after retries": {
+			statuses: []int{
+				http.StatusInternalServerError,
+				http.StatusTooManyRequests,
+			},
+			maxRetries: 3,
+			attempts:   3,
+			status:     model.WebhookDeliveryDelivered,
+			statusCode: http.StatusOK,
+		},
+		"retries exhausted": {
+			statuses: []int{
+				http.StatusBadGateway,
+				http.StatusBadGateway,
+				http.StatusBadGateway,
+			},
+			maxRetries: 2,
+			attempts:   3,
+			status:     model.WebhookDeliveryFailed,
+			statusCode: http.StatusBadGateway,
+		},
+		"client error not retried": {
+			statuses:   []int{http.StatusBadRequest},
+			maxRetries: 3,
+			attempts:   1,
+			status:     model.WebhookDeliveryFailed,
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := identity.WithContext(context.Background(),
+				&identity.Identity{Tenant: "tenant1"})
+
+			rcv := &webhookReceiver{statuses: tc.statuses}
+			srv := httptest.NewServer(rcv)
+			defer srv.Close()
+
+			hook := model.Webhook{
+				ID:     "hook1",
+				URL:    srv.URL,
+				Events: []string{model.WebhookEventAuthSetCreated},
+				Secret: "secret",
+			}
+			ignored := model.Webhook{
+				ID:     "hook2",
+				URL:    srv.URL,
+				Events: []string{model.WebhookEventStatusChanged},
+				Secret: "secret",
+			}
+
+			var delivery model.WebhookDelivery
+			var claim string
+			db := &mstore.DataStore{}
+			db.On("GetBlocklist", ctx).
+				Return([]model.BlocklistEntry{}, nil)
+			db.On("FindAllowlistEntries", ctx,
+				mock.AnythingOfType("model.DeviceAuthAttributes"),
+				model.AuthID("foo")).
+				Return([]model.AllowlistEntry{}, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(nil, store.ErrNotFound)
+			db.On("PutDeviceAuth", ctx,
+				mock.AnythingOfType("*model.DeviceAuth")).
+				Return(nil)
+			db.On("GetWebhooks", ctx).
+				Return([]model.Webhook{hook, ignored}, nil)
+			db.On("InsertWebhookDelivery", ctx,
+				mock.AnythingOfType("*model.WebhookDelivery")).
+				Run(func(args mock.Arguments) {
+					dl := args.Get(1).(*model.WebhookDelivery)
+					dl.ID = "delivery1"
+					claim = dl.Claim
+				}).
+				Return(nil)
+			db.On("UpdateWebhookDelivery",
+				mock.MatchedBy(func(c context.Context) bool {
+					id := identity.FromContext(c)
+					return id != nil && id.Tenant == "tenant1"
+				}),
+				mock.AnythingOfType("*model.WebhookDelivery")).
+				Run(func(args mock.Arguments) {
+					delivery = *args.Get(1).(*model.WebhookDelivery)
+				}).
+				Return(nil)
+
+			mockWebhookClaims(db, nil)
+
+			d := devadmWithWebhooksForTest(db, tc.maxRetries)
+
+			err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+				ID:       "foo",
+				DeviceId: "bar",
+				Status:   model.DevStatusPending,
+			})
+			assert.NoError(t, err)
+
+			assert.NoError(t, d.StopWebhooks(context.Background()))
+
+			assert.Equal(t, tc.attempts, delivery.Attempts)
+			assert.Equal(t, tc.status, delivery.Status)
+			assert.Equal(t, tc.statusCode, delivery.StatusCode)
+			assert.Equal(t, model.AuthID("foo"), delivery.AuthID)
+			assert.Equal(t, "hook1", delivery.WebhookID)
+			// payload is recorded before the first attempt
+			db.AssertNumberOfCalls(t, "UpdateWebhookDelivery", tc.attempts+1)
+			// the claim made on creation is renewed before each attempt
+			db.AssertNumberOfCalls(t, "ClaimWebhookDelivery", tc.attempts)
+			assert.NotEmpty(t, claim)
+			for _, call := range db.Calls {
+				if call.Method == "ClaimWebhookDelivery" {
+					assert.Equal(t, claim, call.Arguments.String(2))
+				}
+			}
+
+			clock := d.clock.(*mclock.Clock)
+			clock.AssertNumberOfCalls(t, "After", tc.attempts-1)
+			for i := 1; i < tc.attempts; i++ {
+				clock.AssertCalled(t, "After", time.Duration(1<<uint(i-1))*time.Millisecond)
+			}
+
+			if assert.Len(t, rcv.bodies, tc.attempts) {
+				for i, body := range rcv.bodies {
+					hdr := rcv.headers[i]
+					assert.Equal(t, SignWebhookPayload("secret", body),
+						hdr.Get(WebhookSignatureHeader))
+					assert.Equal(t, model.WebhookEventAuthSetCreated,
+						hdr.Get(WebhookEventHeader))
+					assert.Equal(t, "delivery1", hdr.Get(WebhookDeliveryHeader))
+
+					var payload model.WebhookPayload
+					assert.NoError(t, json.Unmarshal(body, &payload))
+					assert.Equal(t, "delivery1", payload.ID)
+					assert.Equal(t, "tenant1", payload.TenantID)
+					assert.Equal(t, model.WebhookEventAuthSetCreated, payload.Event)
+					if assert.NotNil(t, payload.AuthSet) {
+						assert.Equal(t, model.AuthID("foo"), payload.AuthSet.ID)
+						assert.Equal(t, model.DevStatusPending, payload.AuthSet.Status)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDevAdmSubmitDeviceWebhookEvents(t *testing.T) {
+	testCases := map[string]struct {
+		prev    *model.DeviceAuth
+		prevErr error
+
+		event    string
+		outError string
+	}{
+		"new auth set": {
+			prevErr: store.ErrNotFound,
+			event:   model.WebhookEventAuthSetCreated,
+		},
+		"resubmitted rejected auth set": {
+			prev:  &model.DeviceAuth{ID: "foo", Status: model.DevStatusRejected},
+			event: model.WebhookEventStatusChanged,
+		},
+		"resubmitted pending auth set": {
+			prev: &model.DeviceAuth{ID: "foo", Status: model.DevStatusPending},
+		},
+		"db error": {
+			prevErr:  errors.New("db connection failed"),
+			outError: "failed to fetch device: db connection failed",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			rcv := &webhookReceiver{}
+			srv := httptest.NewServer(rcv)
+			defer srv.Close()
+
+			db := &mstore.DataStore{}
+			db.On("GetBlocklist", ctx).
+				Return([]model.BlocklistEntry{}, nil)
+			db.On("FindAllowlistEntries", ctx,
+				mock.AnythingOfType("model.DeviceAuthAttributes"),
+				model.AuthID("foo")).
+				Return([]model.AllowlistEntry{}, nil)
+			db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+				Return(tc.prev, tc.prevErr)
+			db.On("PutDeviceAuth", ctx,
+				mock.AnythingOfType("*model.DeviceAuth")).
+				Return(nil)
+			db.On("GetWebhooks", ctx).
+				Return([]model.Webhook{
+					{
+						ID:     "hook1",
+						URL:    srv.URL,
+						Events: model.WebhookEvents,
+						Secret: "secret",
+					},
+				}, nil)
+			db.On("InsertWebhookDelivery", ctx,
+				mock.AnythingOfType("*model.WebhookDelivery")).
+				Return(nil)
+			db.On("UpdateWebhookDelivery",
+				mock.Anything,
+				mock.AnythingOfType("*model.WebhookDelivery")).
+				Return(nil)
+
+			mockWebhookClaims(db, nil)
+
+			d := devadmWithWebhooksForTest(db, 0)
+
+			err := d.SubmitDeviceAuth(ctx, model.DeviceAuth{
+				ID:     "foo",
+				Status: model.DevStatusPending,
+			})
+			assert.NoError(t, d.StopWebhooks(context.Background()))
+
+			if tc.outError != "" {
+				assert.EqualError(t, err, tc.outError)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			if tc.event == "" {
+				assert.Len(t, rcv.headers, 0)
+			} else if assert.Len(t, rcv.headers, 1) {
+				assert.Equal(t, tc.event, rcv.headers[0].Get(WebhookEventHeader))
+			}
+		})
+	}
+}
+
+func TestDevAdmStatusChangeWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	devauth := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer devauth.Close()
+
+	db := &mstore.DataStore{}
+	db.On("GetSettings", ctx).
+		Return(&model.TenantSettings{}, nil)
+	db.On("GetDeviceAuth", ctx, model.AuthID("foo")).
+		Return(&model.DeviceAuth{
+			ID:       "foo",
+			DeviceId: "bar",
+			Status:   model.DevStatusPending,
+		}, nil)
+	db.On("UpdateDeviceAuth", ctx,
+		mock.AnythingOfType("*model.DeviceAuth")).
+		Return(nil)
+	db.On("GetWebhooks", ctx).
+		Return([]model.Webhook{
+			{
+				ID:     "hook1",
+				URL:    srv.URL,
+				Events: []string{model.WebhookEventStatusChanged},
+				Secret: "secret",
+			},
+		}, nil)
+	db.On("InsertWebhookDelivery", ctx,
+		mock.AnythingOfType("*model.WebhookDelivery")).
+		Return(nil)
+	db.On("UpdateWebhookDelivery",
+		mock.Anything,
+		mock.AnythingOfType("*model.WebhookDelivery")).
+		Return(nil)
+	mockWebhookClaims(db, nil)
+
+	d := devadmWithWebhooksForTest(db, 0)
+	d.authclientconf.DevauthUrl = devauth.URL
+
+	err := d.RejectDeviceAuth(ctx, "foo")
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.StopWebhooks(context.Background()))
+
+	if assert.Len(t, rcv.bodies, 1) {
+		var payload model.WebhookPayload
+		assert.NoError(t, json.Unmarshal(rcv.bodies[0], &payload))
+		assert.Equal(t, model.WebhookEventStatusChanged, payload.Event)
+		assert.Equal(t, model.DevStatusRejected, payload.AuthSet.Status)
+	}
+}
+
+func TestDevAdmStopWebhooks(t *testing.T) {
+	ctx := context.Background()
+
+	rcv := &webhookReceiver{statuses: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	hook := model.Webhook{
+		ID:     "hook1",
+		URL:    srv.URL,
+		Events: model.WebhookEvents,
+		Secret: "secret",
+	}
+
+	var delivery model.WebhookDelivery
+	db := &mstore.DataStore{}
+	db.On("GetWebhooks", ctx).
+		Return([]model.Webhook{hook}, nil)
+	db.On("InsertWebhookDelivery", ctx,
+		mock.AnythingOfType("*model.WebhookDelivery")).
+		Return(nil)
+	db.On("UpdateWebhookDelivery",
+		mock.Anything,
+		mock.AnythingOfType("*model.WebhookDelivery")).
+		Run(func(args mock.Arguments) {
+			delivery = *args.Get(1).(*model.WebhookDelivery)
+		}).
+		Return(nil)
+	mockWebhookClaims(db, nil)
+
+	// backoff before the retry never ends
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(time.Unix(1500000000, 0))
+	clock.On("After", time.Minute).
+		Return(func(time.Duration) <-chan time.Time {
+			return make(chan time.Time)
+		})
+
+	d := NewDevAdm(db, deviceauth.Config{}, clock).
+		WithHttpClient(&client.HttpApi{}).
+		WithWebhooks(WebhookConfig{
+			MaxRetries:               3,
+			RetryBackoff:             time.Minute,
+			AllowPrivateDestinations: true,
+		})
+
+	d.notifyWebhooks(ctx, model.WebhookEventAuthSetCreated,
+		&model.DeviceAuth{ID: "foo"})
+
+	sctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, d.StopWebhooks(sctx))
+
+	// interrupted delivery is left for ResumeWebhookDeliveries
+	assert.Len(t, rcv.bodies, 1)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, model.WebhookDeliveryPending, delivery.Status)
+	assert.NotEmpty(t, delivery.Payload)
+
+	// no deliveries are accepted once stopped
+	d.notifyWebhooks(ctx, model.WebhookEventAuthSetCreated,
+		&model.DeviceAuth{ID: "foo"})
+	assert.Len(t, rcv.bodies, 1)
+	assert.NoError(t, d.StopWebhooks(ctx))
+}
+
+func TestCheckWebhookDestination(t *testing.T) {
+	testCases := map[string]bool{
+		"93.184.216.34:443":      true,
+		"[2606:2800:220::1]:443": true,
+		"127.0.0.1:80":           false,
+		"[::1]:80":               false,
+		"0.0.0.0:80":             false,
+		"10.1.2.3:80":            false,
+		"172.16.0.1:80":          false,
+		"172.32.0.1:80":          true,
+		"192.168.1.1:80":         false,
+		"100.64.0.1:80":          false,
+		"169.254.169.254:80":     false,
+		"[fe80::1]:80":           false,
+		"[fd00::1]:80":           false,
+		"[::ffff:127.0.0.1]:80":  false,
+	}
+
+	for addr, allowed := range testCases {
+		t.Run(addr, func(t *testing.T) {
+			err := checkWebhookDestination("tcp", addr, nil)
+			if allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, ErrWebhookDestination, err)
+			}
+		})
+	}
+}
+
+func TestDevAdmWebhookPrivateDestination(t *testing.T) {
+	ctx := context.Background()
+
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	d := NewDevAdm(&mstore.DataStore{}, deviceauth.Config{}, nil).
+		WithWebhooks(WebhookConfig{})
+
+	code, err := d.sendWebhook(ctx, &model.Webhook{
+		ID:     "hook1",
+		URL:    srv.URL,
+		Secret: "secret",
+	}, &model.WebhookDelivery{ID: "delivery1"})
+	assert.Equal(t, 0, code)
+	assert.Contains(t, err.Error(), ErrWebhookDestination.Error())
+	assert.Len(t, rcv.bodies, 0)
+	assert.NoError(t, d.StopWebhooks(ctx))
+}
+
+func TestWebhookDispatcherEnqueue(t *testing.T) {
+	w := &webhookDispatcher{
+		queue: make(chan webhookJob, 1),
+		stop:  make(chan struct{}),
+	}
+
+	assert.True(t, w.enqueue(context.Background(), webhookJob{}, false))
+	// queue is full
+	assert.False(t, w.enqueue(context.Background(), webhookJob{}, false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, w.enqueue(ctx, webhookJob{}, true))
+
+	<-w.queue
+	w.stopped = true
+	assert.False(t, w.enqueue(context.Background(), webhookJob{}, false))
+}
+
+func TestDevAdmStopWebhooksWhileResuming(t *testing.T) {
+	ctx := context.Background()
+
+	// no workers take the jobs off the queue
+	d := devadmForTest(&mstore.DataStore{}).(*DevAdm)
+	d.webhooks = &webhookDispatcher{
+		queue: make(chan webhookJob, 1),
+		stop:  make(chan struct{}),
+	}
+	w := d.webhooks
+
+	assert.True(t, w.enqueue(ctx, webhookJob{}, true))
+
+	waiting := make(chan bool)
+	go func() {
+		waiting <- w.enqueue(ctx, webhookJob{}, true)
+	}()
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- d.StopWebhooks(ctx)
+	}()
+
+	select {
+	case ok := <-waiting:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("enqueue is still waiting for room in the queue")
+	}
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopWebhooks did not return")
+	}
+}
+
+func TestDevAdmResumeWebhookDeliveries(t *testing.T) {
+	ctx := context.Background()
+	tctx := identity.WithContext(ctx, &identity.Identity{Tenant: "tenant1"})
+
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	db := &mstore.DataStore{}
+	db.On("GetTenants", ctx).
+		Return([]string{"", "tenant1"}, nil)
+	db.On("GetPendingWebhookDeliveries", ctx, time.Unix(1500000000, 0)).
+		Return([]model.WebhookDelivery{}, nil)
+	db.On("GetPendingWebhookDeliveries", tctx, time.Unix(1500000000, 0)).
+		Return([]model.WebhookDelivery{
+			{
+				ID:        "delivery1",
+				WebhookID: "hook1",
+				Status:    model.WebhookDeliveryPending,
+				Attempts:  1,
+				Payload:   []byte(`{"id":"delivery1"}`),
+			},
+			{
+				ID:        "delivery2",
+				WebhookID: "hook2",
+				Status:    model.WebhookDeliveryPending,
+				Payload:   []byte(`{"id":"delivery2"}`),
+			},
+			{
+				ID:        "delivery3",
+				WebhookID: "hook1",
+				Status:    model.WebhookDeliveryPending,
+			},
+			{
+				ID:        "delivery4",
+				WebhookID: "hook1",
+				Status:    model.WebhookDeliveryPending,
+				Payload:   []byte(`{"id":"delivery4"}`),
+			},
+		}, nil)
+	db.On("GetWebhook", tctx, "hook1").
+		Return(&model.Webhook{
+			ID:     "hook1",
+			URL:    srv.URL,
+			Events: model.WebhookEvents,
+			Secret: "secret",
+		}, nil).Once()
+	db.On("GetWebhook", tctx, "hook2").
+		Return(nil, store.ErrNotFound).Once()
+
+	var mtx sync.Mutex
+	deliveries := map[string]model.WebhookDelivery{}
+	db.On("UpdateWebhookDelivery",
+		mock.MatchedBy(func(c context.Context) bool {
+			id := identity.FromContext(c)
+			return id != nil && id.Tenant == "tenant1"
+		}),
+		mock.AnythingOfType("*model.WebhookDelivery")).
+		Run(func(args mock.Arguments) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			dl := args.Get(1).(*model.WebhookDelivery)
+			deliveries[dl.ID] = *dl
+		}).
+		Return(nil)
+	// delivery4 was claimed by another instance meanwhile
+	db.On("ClaimWebhookDelivery", tctx,
+		mock.MatchedBy(func(dl *model.WebhookDelivery) bool {
+			return dl.ID == "delivery4"
+		}),
+		mock.AnythingOfType("string"),
+		time.Unix(1500000000, 0).Add(defaultWebhookResumeInterval),
+		time.Unix(1500000000, 0)).
+		Return(store.ErrModified)
+	mockWebhookClaims(db, nil)
+
+	d := devadmWithWebhooksForTest(db, 0)
+
+	assert.NoError(t, d.resumeAllWebhookDeliveries(ctx))
+	assert.NoError(t, d.StopWebhooks(ctx))
+
+	if assert.Len(t, rcv.bodies, 1) {
+		assert.Equal(t, `{"id":"delivery1"}`, string(rcv.bodies[0]))
+		assert.Equal(t, "delivery1", rcv.headers[0].Get(WebhookDeliveryHeader))
+	}
+
+	assert.Equal(t, model.WebhookDeliveryDelivered, deliveries["delivery1"].Status)
+	assert.Equal(t, 2, deliveries["delivery1"].Attempts)
+	assert.Equal(t, model.WebhookDeliveryFailed, deliveries["delivery2"].Status)
+	assert.Equal(t, "webhook was removed", deliveries["delivery2"].Error)
+	assert.Equal(t, model.WebhookDeliveryFailed, deliveries["delivery3"].Status)
+	assert.Equal(t, "payload was not recorded", deliveries["delivery3"].Error)
+	assert.NotContains(t, deliveries, "delivery4")
+}
+
+func TestDevAdmResumeWebhookDeliveriesPeriodically(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("GetTenants", ctx).
+		Return([]string{""}, nil)
+	db.On("GetPendingWebhookDeliveries", ctx, time.Unix(1500000000, 0)).
+		Return([]model.WebhookDelivery{}, nil)
+
+	ticks := make(chan time.Time)
+	clock := &mclock.Clock{}
+	clock.On("Now").Return(time.Unix(1500000000, 0))
+	clock.On("After", time.Hour).
+		Return(func(time.Duration) <-chan time.Time {
+			return ticks
+		})
+
+	d := NewDevAdm(db, deviceauth.Config{}, clock).
+		WithWebhooks(WebhookConfig{
+			ResumeInterval: time.Hour,
+		})
+
+	resuming := make(chan error)
+	go func() {
+		resuming <- d.ResumeWebhookDeliveries(ctx)
+	}()
+
+	ticks <- time.Unix(1500000000, 0)
+	ticks <- time.Unix(1500000000, 0)
+	assert.NoError(t, d.StopWebhooks(ctx))
+
+	select {
+	case err := <-resuming:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ResumeWebhookDeliveries did not return")
+	}
+	db.AssertNumberOfCalls(t, "GetTenants", 3)
+}
+
+func TestDevAdmWebhookDeliveryClaimedElsewhere(t *testing.T) {
+	ctx := context.Background()
+
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	db := &mstore.DataStore{}
+	mockWebhookClaims(db, store.ErrModified)
+
+	d := devadmWithWebhooksForTest(db, 0)
+	d.deliverWebhook(ctx, &model.Webhook{
+		ID:     "hook1",
+		URL:    srv.URL,
+		Secret: "secret",
+	}, &model.WebhookDelivery{
+		ID:      "delivery1",
+		Claim:   "claim-1",
+		Payload: []byte(`{"id":"delivery1"}`),
+	})
+	assert.NoError(t, d.StopWebhooks(ctx))
+
+	assert.Len(t, rcv.bodies, 0)
+	db.AssertNotCalled(t, "UpdateWebhookDelivery", mock.Anything, mock.Anything)
+}
+
+func TestDevAdmWebhooks(t *testing.T) {
+	ctx := context.Background()
+
+	db := &mstore.DataStore{}
+	db.On("InsertWebhook", ctx,
+		mock.AnythingOfType("*model.Webhook")).
+		Return(nil)
+	db.On("GetWebhook", ctx, "hook1").
+		Return(&model.Webhook{ID: "hook1"}, nil)
+	db.On("GetWebhook", ctx, "missing").
+		Return(nil, store.ErrNotFound)
+	db.On("GetWebhook", ctx, "broken").
+		Return(nil, errors.New("db connection failed"))
+	db.On("DeleteWebhook", ctx, "missing").
+		Return(store.ErrNotFound)
+	db.On("GetWebhookDeliveries", ctx, "hook1", 0, 10).
+		Return([]model.WebhookDelivery{{ID: "delivery1"}}, nil)
+
+	d := devadmWithWebhooksForTest(db, 0)
+
+	hook, err := d.CreateWebhook(ctx, model.WebhookReq{
+		URL:    "http://example.com",
+		Events: []string{model.WebhookEventStatusChanged},
+		Secret: "secret",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", hook.Secret)
+	assert.Equal(t, time.Unix(1500000000, 0), *hook.Created)
+
+	_, err = d.GetWebhook(ctx, "missing")
+	assert.Equal(t, ErrWebhookNotFound, err)
+
+	_, err = d.GetWebhook(ctx, "broken")
+	assert.EqualError(t, err, "failed to fetch webhook: db connection failed")
+
+	err = d.DeleteWebhook(ctx, "missing")
+	assert.Equal(t, ErrWebhookNotFound, err)
+
+	_, err = d.ListWebhookDeliveries(ctx, "missing", 0, 10)
+	assert.Equal(t, ErrWebhookNotFound, err)
+
+	deliveries, err := d.ListWebhookDeliveries(ctx, "hook1", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+}
@@ -17,6 +17,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/mendersoftware/go-lib-micro/log"
 	"github.com/urfave/cli"
@@ -79,6 +80,18 @@ func doMain(args []string) {
 
 			Action: cmdMigrate,
 		},
+		{
+			Name:  "purge",
+			Usage: "Remove auth sets kept in the trash longer than trash_retention",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "tenant",
+					Usage: "Takes ID of specific tenant to purge.",
+				},
+			},
+
+			Action: cmdPurge,
+		},
 	}
 
 	app.Action = cmdServer
@@ -111,6 +124,8 @@ func makeDataStoreConfig() mongo.DataStoreMongoConfig {
 
 		Username: config.Config.GetString(SettingDbUsername),
 		Password: config.Config.GetString(SettingDbPassword),
+
+		EventsCollSize: config.Config.GetInt(SettingEventsCollSize),
 	}
 
 }
@@ -199,3 +214,35 @@ func cmdMigrate(args *cli.Context) error {
 
 	return nil
 }
+
+func cmdPurge(args *cli.Context) error {
+	tenantId := args.String("tenant")
+
+	l := log.New(log.Ctx{})
+
+	db, err := newDataStoreMongo()
+
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("failed to connect to db: %v", err),
+			3)
+	}
+
+	before := time.Now().Add(-config.Config.GetDuration(SettingTrashRetention))
+
+	ctx := context.Background()
+
+	if tenantId != "" {
+		l.Printf("purging tenant %v", tenantId)
+		err = db.PurgeTenant(ctx, before, tenantId)
+	} else {
+		err = db.Purge(ctx, before)
+	}
+	if err != nil {
+		return cli.NewExitError(
+			fmt.Sprintf("failed to purge deleted auth sets: %v", err),
+			3)
+	}
+
+	return nil
+}
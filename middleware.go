@@ -16,7 +16,9 @@ package main
 import (
 	"context"
 	"fmt"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/mendersoftware/go-lib-micro/accesslog"
@@ -27,7 +29,9 @@ import (
 	"github.com/mendersoftware/go-lib-micro/requestid"
 	"github.com/mendersoftware/go-lib-micro/requestlog"
 
+	api_http "github.com/mendersoftware/deviceadm/api/http"
 	ctx_httpheader "github.com/mendersoftware/deviceadm/context/httpheader"
+	"github.com/mendersoftware/deviceadm/utils"
 )
 
 const (
@@ -86,6 +90,7 @@ var (
 				http.MethodGet,
 				http.MethodPost,
 				http.MethodPut,
+				http.MethodPatch,
 				http.MethodDelete,
 				http.MethodOptions,
 			},
@@ -100,19 +105,27 @@ var (
 				"Accept-Encoding",
 				"Access-Control-Request-Headers",
 				"Header-Access-Control-Request",
+				"If-Match",
+				"Idempotency-Key",
 			},
 
 			// Headers that can be exposed to JS
 			AccessControlExposeHeaders: []string{
 				"Location",
 				"Link",
+				"ETag",
+				"Idempotent-Replayed",
 			},
 		},
 
 		// verifies the request Content-Type header
 		// The expected Content-Type is 'application/json'
-		// if the content is non-null
-		&rest.ContentTypeCheckerMiddleware{},
+		// if the content is non-null, some resources accept
+		// other types too
+		&ContentTypeCheckerMiddleware{
+			Extra:         api_http.ExtraContentTypes,
+			ExtraByMethod: api_http.ExtraMethodContentTypes,
+		},
 		&requestid.RequestIdMiddleware{},
 		&mctx.UpdateContextMiddleware{
 			Updates: []mctx.UpdateContextFunc{
@@ -155,6 +168,37 @@ func SetupMiddleware(api *rest.Api, mwtype string) error {
 	return nil
 }
 
+// ContentTypeCheckerMiddleware verifies the request Content-Type header
+// like rest.ContentTypeCheckerMiddleware, but accepts additional media
+// types on selected URL paths
+type ContentTypeCheckerMiddleware struct {
+	// media types accepted besides 'application/json', by URL path
+	Extra map[string][]string
+	// media types accepted besides 'application/json', by request method
+	ExtraByMethod map[string][]string
+}
+
+func (mw *ContentTypeCheckerMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	checkJson := (&rest.ContentTypeCheckerMiddleware{}).MiddlewareFunc(handler)
+
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		mediatype, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		charset, ok := params["charset"]
+		if !ok {
+			charset = "UTF-8"
+		}
+
+		if strings.ToUpper(charset) == "UTF-8" &&
+			(utils.ContainsString(mediatype, mw.Extra[r.URL.Path]) ||
+				utils.ContainsString(mediatype, mw.ExtraByMethod[r.Method])) {
+			handler(w, r)
+			return
+		}
+
+		checkJson(w, r)
+	}
+}
+
 func preserveHeaders(ctx context.Context, r *rest.Request) context.Context {
 	return ctx_httpheader.WithContext(ctx, r.Header, "Authorization")
 }
@@ -14,9 +14,13 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSetupMiddleware(t *testing.T) {
@@ -41,3 +45,80 @@ func TestSetupMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestContentTypeCheckerMiddleware(t *testing.T) {
+	testCases := map[string]struct {
+		method      string
+		path        string
+		contentType string
+
+		code int
+	}{
+		"json": {
+			path:        "/json",
+			contentType: "application/json",
+			code:        http.StatusOK,
+		},
+		"csv": {
+			path:        "/csv",
+			contentType: "text/csv; charset=utf-8",
+			code:        http.StatusOK,
+		},
+		"csv, json accepted too": {
+			path:        "/csv",
+			contentType: "application/json",
+			code:        http.StatusOK,
+		},
+		"csv, bad charset": {
+			path:        "/csv",
+			contentType: "text/csv; charset=latin1",
+			code:        http.StatusUnsupportedMediaType,
+		},
+		"csv, not accepted": {
+			path:        "/json",
+			contentType: "text/csv",
+			code:        http.StatusUnsupportedMediaType,
+		},
+		"merge patch": {
+			method:      http.MethodPatch,
+			path:        "/json",
+			contentType: "application/merge-patch+json",
+			code:        http.StatusOK,
+		},
+		"merge patch, not accepted": {
+			path:        "/json",
+			contentType: "application/merge-patch+json",
+			code:        http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			api := rest.NewApi()
+			api.Use(&ContentTypeCheckerMiddleware{
+				Extra: map[string][]string{
+					"/csv": {"text/csv"},
+				},
+				ExtraByMethod: map[string][]string{
+					http.MethodPatch: {"application/merge-patch+json"},
+				},
+			})
+			api.SetApp(rest.AppSimple(func(w rest.ResponseWriter, r *rest.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodPost
+			}
+
+			req := httptest.NewRequest(method, "http://localhost"+tc.path,
+				strings.NewReader("sn\n0001\n"))
+			req.Header.Set("Content-Type", tc.contentType)
+
+			recorder := httptest.NewRecorder()
+			api.MakeHandler().ServeHTTP(recorder, req)
+			assert.Equal(t, tc.code, recorder.Code)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maximum number of entries in a single allowlist upload
+	MaxAllowlistUpload = 10000
+)
+
+// AllowlistEntry describes a device expected to be admitted, e.g. one of
+// a manufacturing batch; an auth set matches the entry if it has all of
+// entry's identity attributes and tags
+type AllowlistEntry struct {
+	ID string `json:"id" bson:"id"`
+
+	// manufacturing batch the entry was imported with
+	Batch string `json:"batch,omitempty" bson:"batch,omitempty"`
+
+	// identity attributes, all of them must match
+	Attributes DeviceAuthAttributes `json:"attributes" bson:"attributes"`
+
+	// tags, all of them must be assigned to the auth set
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+
+	// single use entries admit one auth set only
+	SingleUse bool `json:"single_use" bson:"single_use"`
+
+	// auth set admitted with a single use entry
+	UsedBy AuthID     `json:"used_by,omitempty" bson:"used_by,omitempty"`
+	UsedAt *time.Time `json:"used_ts,omitempty" bson:"used_ts,omitempty"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+// Matches checks if auth set 'dev' is admitted by the entry
+func (e *AllowlistEntry) Matches(dev *DeviceAuth) bool {
+	if len(e.Attributes) == 0 {
+		return false
+	}
+	for k, v := range e.Attributes {
+		if dev.Attributes[k] != v {
+			return false
+		}
+	}
+	return HasTags(dev.Tags, e.Tags)
+}
+
+// ParseAllowlistJSON parses an allowlist upload given as a JSON array of
+// identity attribute objects
+func ParseAllowlistJSON(source io.Reader) ([]DeviceAuthAttributes, error) {
+	jd := json.NewDecoder(source)
+
+	var attrs []DeviceAuthAttributes
+	if err := jd.Decode(&attrs); err != nil {
+		return nil, err
+	}
+
+	for i, a := range attrs {
+		if len(a) == 0 {
+			return nil, errors.Errorf("entry %d: no attributes", i+1)
+		}
+		for k, v := range a {
+			if k == "" || v == "" {
+				return nil, errors.Errorf("entry %d: attribute names and values must not be empty", i+1)
+			}
+		}
+	}
+
+	return attrs, validateAllowlistUpload(attrs)
+}
+
+// ParseAllowlistCSV parses an allowlist upload given as CSV; the header
+// row names identity attributes, every other row describes an entry.
+// Empty cells are skipped.
+func ParseAllowlistCSV(source io.Reader) ([]DeviceAuthAttributes, error) {
+	cr := csv.NewReader(source)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV header row is missing")
+	} else if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, errors.Errorf("CSV header: column %d has no name", i+1)
+		}
+		if seen[name] {
+			return nil, errors.Errorf("CSV header: duplicate column %s", name)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+
+	var attrs []DeviceAuthAttributes
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		a := DeviceAuthAttributes{}
+		for i, v := range record {
+			if v = strings.TrimSpace(v); v != "" {
+				a[header[i]] = v
+			}
+		}
+		if len(a) == 0 {
+			return nil, errors.Errorf("CSV row %d: no attributes", len(attrs)+1)
+		}
+		attrs = append(attrs, a)
+	}
+
+	return attrs, validateAllowlistUpload(attrs)
+}
+
+func validateAllowlistUpload(attrs []DeviceAuthAttributes) error {
+	if len(attrs) == 0 {
+		return errors.New("no allowlist entries")
+	}
+	if len(attrs) > MaxAllowlistUpload {
+		return errors.Errorf("too many allowlist entries, at most %d are allowed",
+			MaxAllowlistUpload)
+	}
+	return nil
+}
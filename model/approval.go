@@ -0,0 +1,31 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"time"
+)
+
+// Approval is a user's vote for accepting an auth set, recorded when
+// tenant requires multiple approvals
+type Approval struct {
+	ID string `json:"id" bson:"id"`
+
+	AuthID AuthID `json:"auth_id" bson:"auth_id"`
+
+	// subject of the approving user's identity
+	Approver string `json:"approver" bson:"approver"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
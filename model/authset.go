@@ -28,7 +28,9 @@ type AuthSet struct {
 	Attributes DeviceAuthAttributes `json:"-"`
 }
 
-func ParseAuthSet(source io.Reader) (*AuthSet, error) {
+// ParseAuthSet parses an auth set, checking its identity attributes
+// against tenant's identity schema 'schema' if one is set
+func ParseAuthSet(source io.Reader, schema *IdentitySchema) (*AuthSet, error) {
 	jd := json.NewDecoder(source)
 
 	var req AuthSet
@@ -37,20 +39,34 @@ func ParseAuthSet(source io.Reader) (*AuthSet, error) {
 		return nil, err
 	}
 
-	if err := req.Validate(); err != nil {
+	if err := req.parse(schema); err != nil {
 		return nil, err
 	}
 
-	err := json.Unmarshal([]byte(req.DeviceId), &(req.Attributes))
+	return &req, nil
+}
+
+// parse validates the auth set and decodes its identity attributes,
+// which must conform to 'schema' unless it is nil
+func (r *AuthSet) parse(schema *IdentitySchema) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	err := json.Unmarshal([]byte(r.DeviceId), &(r.Attributes))
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to decode attributes data")
+		return errors.Wrap(err, "failed to decode attributes data")
 	}
 
-	if len(req.Attributes) == 0 {
-		return nil, errors.New("no attributes provided")
+	if len(r.Attributes) == 0 {
+		return errors.New("no attributes provided")
 	}
 
-	return &req, nil
+	if schema != nil {
+		return schema.Check(r.Attributes)
+	}
+
+	return nil
 }
 
 func (r *AuthSet) Validate() error {
@@ -0,0 +1,178 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maximum number of auth sets in a single bulk preauthorization
+	MaxAuthSetBatch = 10000
+
+	// outcomes of preauthorizing a single auth set of a batch
+	PreauthResultPreauthorized = "preauthorized"
+	PreauthResultConflict      = "conflict"
+	PreauthResultInvalid       = "invalid"
+	PreauthResultFailed        = "failed"
+	PreauthResultSkipped       = "skipped"
+)
+
+// AuthSetRow is an auth set of a bulk preauthorization upload; rows are
+// numbered from 1, Err is set if the row failed to parse
+type AuthSetRow struct {
+	Row     int
+	AuthSet AuthSet
+	Err     error
+}
+
+// PreauthResult is the outcome of preauthorizing a single row of a batch
+type PreauthResult struct {
+	Row      int    `json:"row"`
+	DeviceId string `json:"device_identity,omitempty"`
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PreauthReport summarizes a bulk preauthorization
+type PreauthReport struct {
+	Preauthorized int             `json:"preauthorized"`
+	Conflicts     int             `json:"conflicts"`
+	Invalid       int             `json:"invalid"`
+	Failed        int             `json:"failed"`
+	Skipped       int             `json:"skipped"`
+	Results       []PreauthResult `json:"results"`
+}
+
+// Add records result 'res' in the report
+func (r *PreauthReport) Add(res PreauthResult) {
+	switch res.Result {
+	case PreauthResultPreauthorized:
+		r.Preauthorized++
+	case PreauthResultConflict:
+		r.Conflicts++
+	case PreauthResultInvalid:
+		r.Invalid++
+	case PreauthResultSkipped:
+		r.Skipped++
+	default:
+		r.Failed++
+	}
+	r.Results = append(r.Results, res)
+}
+
+// ParseAuthSetsCSV parses a bulk preauthorization upload given as CSV;
+// the header row must name 'device_identity' and 'key' columns, other
+// columns are ignored; auth sets not conforming to identity schema
+// 'schema', if set, are reported as invalid rows
+func ParseAuthSetsCSV(source io.Reader, schema *IdentitySchema) ([]AuthSetRow, error) {
+	cr := csv.NewReader(source)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV header row is missing")
+	} else if err != nil {
+		return nil, err
+	}
+
+	idCol, keyCol := -1, -1
+	for i, name := range header {
+		switch strings.TrimSpace(name) {
+		case "device_identity":
+			idCol = i
+		case "key":
+			keyCol = i
+		}
+	}
+	if idCol < 0 || keyCol < 0 {
+		return nil, errors.New("CSV header must name device_identity and key columns")
+	}
+
+	var rows []AuthSetRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		row := AuthSetRow{Row: len(rows) + 1}
+		if idCol < len(record) {
+			row.AuthSet.DeviceId = record[idCol]
+		}
+		if keyCol < len(record) {
+			row.AuthSet.Key = record[keyCol]
+		}
+		row.Err = row.AuthSet.parse(schema)
+
+		rows = append(rows, row)
+		if len(rows) > MaxAuthSetBatch {
+			return nil, errTooManyAuthSets
+		}
+	}
+
+	return rows, validateAuthSetBatch(rows)
+}
+
+// ParseAuthSetsNDJSON parses a bulk preauthorization upload given as JSON
+// Lines, each line holding an auth set as accepted by ParseAuthSet; empty
+// lines are skipped
+func ParseAuthSetsNDJSON(source io.Reader, schema *IdentitySchema) ([]AuthSetRow, error) {
+	scanner := bufio.NewScanner(source)
+	// keys and identities can be long, allow for big lines
+	scanner.Buffer(nil, 1024*1024)
+
+	var rows []AuthSetRow
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		row := AuthSetRow{Row: len(rows) + 1}
+		row.Err = json.Unmarshal(line, &row.AuthSet)
+		if row.Err == nil {
+			row.Err = row.AuthSet.parse(schema)
+		}
+
+		rows = append(rows, row)
+		if len(rows) > MaxAuthSetBatch {
+			return nil, errTooManyAuthSets
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read JSON Lines")
+	}
+
+	return rows, validateAuthSetBatch(rows)
+}
+
+var errTooManyAuthSets = errors.Errorf(
+	"too many auth sets, at most %d are allowed", MaxAuthSetBatch)
+
+func validateAuthSetBatch(rows []AuthSetRow) error {
+	if len(rows) == 0 {
+		return errors.New("no auth sets")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DeviceAuthPatch is a JSON Merge Patch (RFC 7396) of an auth set, limited
+// to the fields operators are allowed to correct. Attributes are merged,
+// an attribute set to null is removed, but an auth set cannot be left
+// without any. Tags and expiry are replaced as a whole, null removes them.
+// Notes are never modified nor removed, texts given are added as new notes
+// of the calling user.
+//
+// Corrected attributes last until the device submits the auth set again,
+// attributes decoded from a submission replace the stored ones as a whole.
+type DeviceAuthPatch struct {
+	Attributes map[string]*string
+
+	Tags    []string
+	SetTags bool
+
+	ExpiresAt    *time.Time
+	SetExpiresAt bool
+
+	Notes []string
+}
+
+func ParseDeviceAuthPatch(source io.Reader) (*DeviceAuthPatch, error) {
+	jd := json.NewDecoder(source)
+
+	var raw json.RawMessage
+	if err := jd.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return nil, errors.New("patch must be a JSON object")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+
+	// go through the fields in a stable order, for stable errors
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var p DeviceAuthPatch
+	for _, name := range names {
+		raw := fields[name]
+		null := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+
+		var err error
+		switch name {
+		case "attributes":
+			if null {
+				err = errors.New("identity attributes cannot be removed")
+			} else {
+				err = json.Unmarshal(raw, &p.Attributes)
+			}
+		case "tags":
+			p.SetTags = true
+			if !null {
+				err = json.Unmarshal(raw, &p.Tags)
+			}
+		case "expires_ts":
+			p.SetExpiresAt = true
+			if !null {
+				err = json.Unmarshal(raw, &p.ExpiresAt)
+			}
+		case "notes":
+			if null {
+				err = errors.New("notes cannot be removed")
+			} else {
+				err = json.Unmarshal(raw, &p.Notes)
+			}
+		default:
+			err = errors.New("field cannot be patched")
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", name)
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (p *DeviceAuthPatch) Validate() error {
+	for name := range p.Attributes {
+		if name == "" {
+			return errors.New("attributes: attribute name must not be empty")
+		}
+	}
+	if err := ValidateTags(p.Tags); err != nil {
+		return errors.Wrap(err, "tags")
+	}
+	for _, text := range p.Notes {
+		if err := (&NoteReq{Text: text}).Validate(); err != nil {
+			return errors.Wrap(err, "notes")
+		}
+	}
+	return nil
+}
+
+// Apply merges the patch into auth set 'dev'; notes are not a part of the
+// auth set and are left to the caller
+func (p *DeviceAuthPatch) Apply(dev *DeviceAuth) {
+	for name, value := range p.Attributes {
+		if value == nil {
+			delete(dev.Attributes, name)
+			continue
+		}
+		if dev.Attributes == nil {
+			dev.Attributes = DeviceAuthAttributes{}
+		}
+		dev.Attributes[name] = *value
+	}
+
+	if p.SetTags {
+		dev.Tags = nil
+		for _, t := range p.Tags {
+			if !HasTags(dev.Tags, []string{t}) {
+				dev.Tags = append(dev.Tags, t)
+			}
+		}
+	}
+
+	if p.SetExpiresAt {
+		dev.ExpiresAt = p.ExpiresAt
+	}
+}
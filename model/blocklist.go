@@ -0,0 +1,146 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BlocklistEntry describes devices which must not be admitted; an auth
+// set matches the entry if its key has given fingerprint, or if it has
+// all of entry's identity attributes and tags
+type BlocklistEntry struct {
+	ID string `json:"id" bson:"id"`
+
+	// identity attributes, all of them must match
+	Attributes DeviceAuthAttributes `json:"attributes,omitempty" bson:"attributes,omitempty"`
+
+	// tags, all of them must be assigned to the auth set
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+
+	// fingerprint of the device key, see KeyFingerprint()
+	KeyFingerprint string `json:"key_fingerprint,omitempty" bson:"key_fingerprint,omitempty"`
+
+	// free form description, e.g. why the device was blocked
+	Reason string `json:"reason,omitempty" bson:"reason,omitempty"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+// Matches checks if auth set 'dev' is blocked by the entry
+func (e *BlocklistEntry) Matches(dev *DeviceAuth) bool {
+	if e.KeyFingerprint != "" && dev.Key != "" &&
+		e.KeyFingerprint == KeyFingerprint(dev.Key) {
+		return true
+	}
+
+	if len(e.Attributes) == 0 && len(e.Tags) == 0 {
+		return false
+	}
+	for k, v := range e.Attributes {
+		if dev.Attributes[k] != v {
+			return false
+		}
+	}
+	return HasTags(dev.Tags, e.Tags)
+}
+
+// BlocklistEntryReq is the blocklist entry creation request, the
+// fingerprint can be given directly or computed from device's key
+type BlocklistEntryReq struct {
+	Attributes     DeviceAuthAttributes `json:"attributes"`
+	Tags           []string             `json:"tags"`
+	KeyFingerprint string               `json:"key_fingerprint"`
+	Key            string               `json:"key"`
+	Reason         string               `json:"reason"`
+}
+
+func ParseBlocklistEntryReq(source io.Reader) (*BlocklistEntryReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req BlocklistEntryReq
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *BlocklistEntryReq) Validate() error {
+	if r.Key != "" && r.KeyFingerprint != "" {
+		return errors.New("only one of key, key_fingerprint can be provided")
+	}
+	if len(r.Attributes) == 0 && len(r.Tags) == 0 &&
+		r.Key == "" && r.KeyFingerprint == "" {
+		return errors.New("attributes, tags, key or key_fingerprint must be provided")
+	}
+	return ValidateTags(r.Tags)
+}
+
+// Entry returns blocklist entry described by the request
+func (r *BlocklistEntryReq) Entry() *BlocklistEntry {
+	e := &BlocklistEntry{
+		Attributes:     r.Attributes,
+		Tags:           r.Tags,
+		KeyFingerprint: strings.ToLower(r.KeyFingerprint),
+		Reason:         r.Reason,
+	}
+	if r.Key != "" {
+		e.KeyFingerprint = KeyFingerprint(r.Key)
+	}
+	return e
+}
+
+// BlocklistHit records an auth set rejected because of a blocklist entry
+type BlocklistHit struct {
+	ID string `json:"id" bson:"id"`
+
+	// matching blocklist entry
+	EntryID string `json:"entry_id" bson:"entry_id"`
+
+	// empty for refused preauthorizations, which are traced by
+	// their identity data
+	AuthID         AuthID               `json:"auth_id" bson:"auth_id"`
+	DeviceId       DeviceID             `json:"device_id" bson:"device_id"`
+	DeviceIdentity string               `json:"device_identity" bson:"device_identity,omitempty"`
+	Attributes     DeviceAuthAttributes `json:"attributes" bson:"attributes,omitempty"`
+	KeyFingerprint string               `json:"key_fingerprint" bson:"key_fingerprint"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+// KeyFingerprint returns hex encoded SHA256 of a public key; for PEM
+// encoded keys the digest covers the DER data only, so that formatting
+// of the PEM text does not matter
+func KeyFingerprint(key string) string {
+	data := []byte(strings.TrimSpace(key))
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
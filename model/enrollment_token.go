@@ -0,0 +1,117 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// EnrollmentToken admits devices presenting it at submission; the token
+// secret is returned upon creation only, afterwards just its digest is
+// known
+type EnrollmentToken struct {
+	ID string `json:"id" bson:"id"`
+
+	// token secret, set only when the token is created
+	Token string `json:"token,omitempty" bson:"-"`
+
+	// digest of the secret, see HashEnrollmentToken()
+	Hash string `json:"-" bson:"hash"`
+
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+
+	// number of auth sets the token can admit, and how many are left
+	MaxUses  int `json:"max_uses" bson:"max_uses"`
+	UsesLeft int `json:"uses_left" bson:"uses_left"`
+
+	ExpiresAt *time.Time `json:"expires_ts,omitempty" bson:"expires_ts,omitempty"`
+
+	// attributes stamped on admitted auth sets
+	Attributes DeviceAuthAttributes `json:"attributes,omitempty" bson:"attributes,omitempty"`
+
+	Revoked bool `json:"revoked" bson:"revoked"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+// Stamp adds token's attributes to auth set 'dev', without overriding the
+// ones the device reported
+func (t *EnrollmentToken) Stamp(dev *DeviceAuth) {
+	if len(t.Attributes) == 0 {
+		return
+	}
+	if dev.Attributes == nil {
+		dev.Attributes = DeviceAuthAttributes{}
+	}
+	for k, v := range t.Attributes {
+		if _, ok := dev.Attributes[k]; !ok {
+			dev.Attributes[k] = v
+		}
+	}
+}
+
+// EnrollmentTokenReq is the enrollment token creation request
+type EnrollmentTokenReq struct {
+	Description string `json:"description"`
+
+	// defaults to 1, i.e. a one-time token
+	MaxUses int `json:"max_uses"`
+
+	ExpiresAt *time.Time `json:"expires_ts"`
+
+	Attributes DeviceAuthAttributes `json:"attributes"`
+}
+
+func ParseEnrollmentTokenReq(source io.Reader) (*EnrollmentTokenReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req EnrollmentTokenReq
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+
+	return &req, nil
+}
+
+func (r *EnrollmentTokenReq) Validate() error {
+	if r.MaxUses < 0 {
+		return errors.New("max_uses must not be negative")
+	}
+	for k, v := range r.Attributes {
+		if k == "" || v == "" {
+			return errors.New("attribute names and values must not be empty")
+		}
+	}
+	return nil
+}
+
+// HashEnrollmentToken returns hex encoded SHA256 of token secret 'token'
+func HashEnrollmentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"time"
+)
+
+const (
+	// a new auth set was submitted
+	EventAuthSetCreated = "auth_set_created"
+	// status of an auth set changed
+	EventStatusChanged = "status_changed"
+	// an auth set was removed
+	EventAuthSetDeleted = "auth_set_deleted"
+	// a removed auth set was taken out of the trash
+	EventAuthSetRestored = "auth_set_restored"
+)
+
+// Event is an entry of the admission event log
+type Event struct {
+	// sequence number, increasing within a tenant
+	ID int64 `json:"id" bson:"id"`
+
+	// one of Event* types
+	Type string `json:"type" bson:"type"`
+
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+
+	// state of the auth set after the change
+	AuthSet *DeviceAuth `json:"auth_set" bson:"auth_set"`
+}
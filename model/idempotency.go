@@ -0,0 +1,48 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	MaxIdempotencyKeyLength = 255
+)
+
+// IdempotentRequest is a request made with an idempotency key, along with
+// the response replayed when the request is retried
+type IdempotentRequest struct {
+	Key string `bson:"_id"`
+
+	// hash of the request, a key cannot be reused for another request
+	Hash string `bson:"hash"`
+
+	// token of the attempt holding the key while the request is processed
+	Lease string `bson:"lease"`
+
+	// response, StatusCode is 0 while the request is being processed
+	StatusCode int         `bson:"status_code"`
+	Header     http.Header `bson:"header,omitempty"`
+	Body       []byte      `bson:"body,omitempty"`
+
+	// time after which the key is forgotten
+	Expires time.Time `bson:"expires_ts"`
+}
+
+// Done checks if the response to the request was recorded
+func (r *IdempotentRequest) Done() bool {
+	return r.StatusCode != 0
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// IdentitySchema constrains identity attributes of a tenant's devices;
+// attributes not listed are only allowed if AllowExtra is set
+type IdentitySchema struct {
+	Attributes []AttributeSchema `json:"attributes" bson:"attributes"`
+	AllowExtra bool              `json:"allow_extra" bson:"allow_extra"`
+
+	// compiled value patterns, by attribute name
+	patterns map[string]*regexp.Regexp
+}
+
+// AttributeSchema constrains a single identity attribute; Pattern is a
+// regular expression the whole value must match, zero MaxLength means no
+// limit
+type AttributeSchema struct {
+	Name      string `json:"name" bson:"name"`
+	Required  bool   `json:"required" bson:"required"`
+	Pattern   string `json:"pattern,omitempty" bson:"pattern,omitempty"`
+	MaxLength int    `json:"max_length,omitempty" bson:"max_length,omitempty"`
+}
+
+func ParseIdentitySchema(source io.Reader) (*IdentitySchema, error) {
+	jd := json.NewDecoder(source)
+
+	var s IdentitySchema
+	if err := jd.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *IdentitySchema) Validate() error {
+	names := map[string]bool{}
+	for _, a := range s.Attributes {
+		if a.Name == "" {
+			return errors.New("attribute name must be provided")
+		}
+		if names[a.Name] {
+			return errors.Errorf("attribute %q listed more than once", a.Name)
+		}
+		names[a.Name] = true
+
+		if a.MaxLength < 0 {
+			return errors.Errorf("max_length of attribute %q must not be negative",
+				a.Name)
+		}
+	}
+
+	return s.compile()
+}
+
+func (s *IdentitySchema) compile() error {
+	s.patterns = map[string]*regexp.Regexp{}
+	for _, a := range s.Attributes {
+		if a.Pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(a.Pattern); err != nil {
+			return errors.Wrapf(err, "invalid pattern of attribute %q", a.Name)
+		}
+		// the pattern must match whole value
+		s.patterns[a.Name] = regexp.MustCompile("^(?:" + a.Pattern + ")$")
+	}
+	return nil
+}
+
+// Check returns an error describing the first violation of the schema
+// by identity attributes 'attrs'
+func (s *IdentitySchema) Check(attrs DeviceAuthAttributes) error {
+	if s.patterns == nil {
+		if err := s.compile(); err != nil {
+			return err
+		}
+	}
+
+	known := map[string]bool{}
+	for _, a := range s.Attributes {
+		known[a.Name] = true
+
+		value, ok := attrs[a.Name]
+		if !ok {
+			if a.Required {
+				return errors.Errorf("identity attribute %q is required", a.Name)
+			}
+			continue
+		}
+		if a.MaxLength > 0 && utf8.RuneCountInString(value) > a.MaxLength {
+			return errors.Errorf("identity attribute %q is longer than %d characters",
+				a.Name, a.MaxLength)
+		}
+		if re := s.patterns[a.Name]; re != nil && !re.MatchString(value) {
+			return errors.Errorf("identity attribute %q does not match pattern %q",
+				a.Name, a.Pattern)
+		}
+	}
+
+	if s.AllowExtra {
+		return nil
+	}
+
+	// report extra attributes in a stable order
+	var extra []string
+	for name := range attrs {
+		if !known[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return errors.Errorf("identity attribute %q is not allowed", extra[0])
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ManifestKey is an Ed25519 public key registered by the tenant for
+// verifying manufacturing manifests
+type ManifestKey struct {
+	ID string `json:"id" bson:"id"`
+
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+
+	// PEM encoded Ed25519 public key
+	PublicKey string `json:"public_key" bson:"public_key"`
+
+	// fingerprint of the key, see KeyFingerprint()
+	Fingerprint string `json:"fingerprint" bson:"fingerprint"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+// ManifestKeyReq is the manifest key registration request
+type ManifestKeyReq struct {
+	PublicKey   string `json:"public_key"`
+	Description string `json:"description"`
+}
+
+func ParseManifestKeyReq(source io.Reader) (*ManifestKeyReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req ManifestKeyReq
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *ManifestKeyReq) Validate() error {
+	if r.PublicKey == "" {
+		return errors.New("public_key must be provided")
+	}
+	_, err := parseEd25519PublicKey(r.PublicKey)
+	return err
+}
+
+// Key returns manifest key described by the request
+func (r *ManifestKeyReq) Key() *ManifestKey {
+	return &ManifestKey{
+		Description: r.Description,
+		PublicKey:   strings.TrimSpace(r.PublicKey) + "\n",
+		Fingerprint: KeyFingerprint(r.PublicKey),
+	}
+}
+
+func parseEd25519PublicKey(data string) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key")
+	}
+
+	edkey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+	return edkey, nil
+}
+
+// Manifest is a manufacturing manifest, listing auth sets to be
+// preauthorized; manifest IDs are unique per tenant
+type Manifest struct {
+	ID      string    `json:"id"`
+	Devices []AuthSet `json:"devices"`
+}
+
+// SignedManifest is an uploaded manifest, Signature is Ed25519 signature
+// of the manifest document; both are base64 encoded
+type SignedManifest struct {
+	Manifest  string `json:"manifest"`
+	Signature string `json:"signature"`
+
+	doc []byte
+	sig []byte
+
+	// decoded manifest and its auth sets, numbered from 1
+	Decoded *Manifest    `json:"-"`
+	Rows    []AuthSetRow `json:"-"`
+}
+
+// ParseSignedManifest parses a signed manifest; auth sets not conforming
+// to identity schema 'schema', if set, are reported as invalid rows
+func ParseSignedManifest(source io.Reader, schema *IdentitySchema) (*SignedManifest, error) {
+	jd := json.NewDecoder(source)
+
+	var m SignedManifest
+	if err := jd.Decode(&m); err != nil {
+		return nil, err
+	}
+
+	if err := m.parse(schema); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// parse decodes the manifest document; auth sets failing to parse are
+// reported in their rows, not as an error
+func (m *SignedManifest) parse(schema *IdentitySchema) error {
+	var err error
+
+	if m.Manifest == "" || m.Signature == "" {
+		return errors.New("manifest and signature must be provided")
+	}
+
+	m.doc, err = base64.StdEncoding.DecodeString(m.Manifest)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode manifest")
+	}
+	m.sig, err = base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode signature")
+	}
+
+	m.Decoded = &Manifest{}
+	if err := json.Unmarshal(m.doc, m.Decoded); err != nil {
+		return errors.Wrap(err, "failed to decode manifest")
+	}
+	if m.Decoded.ID == "" {
+		return errors.New("manifest id must be provided")
+	}
+	if len(m.Decoded.Devices) == 0 {
+		return errors.New("no auth sets")
+	}
+	if len(m.Decoded.Devices) > MaxAuthSetBatch {
+		return errTooManyAuthSets
+	}
+
+	m.Rows = make([]AuthSetRow, len(m.Decoded.Devices))
+	for i, dev := range m.Decoded.Devices {
+		m.Rows[i] = AuthSetRow{Row: i + 1, AuthSet: dev}
+		m.Rows[i].Err = m.Rows[i].AuthSet.parse(schema)
+	}
+	return nil
+}
+
+// Verify returns the key among 'keys' the manifest is signed with, or
+// nil if none of them verifies the signature
+func (m *SignedManifest) Verify(keys []ManifestKey) *ManifestKey {
+	for i := range keys {
+		pub, err := parseEd25519PublicKey(keys[i].PublicKey)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(pub, m.doc, m.sig) {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// ManifestRecord records a processed manifest, so that it cannot be
+// replayed
+type ManifestRecord struct {
+	// manifest ID
+	ID string `json:"id" bson:"id"`
+
+	// key the manifest was signed with
+	KeyID string `json:"key_id" bson:"key_id"`
+
+	Devices int `json:"devices" bson:"devices"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
@@ -28,6 +28,7 @@ const (
 	DevStatusRejected      = "rejected"
 	DevStatusPending       = "pending"
 	DevStatusPreauthorized = "preauthorized"
+	DevStatusSuspended     = "suspended"
 )
 
 // Device authentication data set wrapper
@@ -52,6 +53,33 @@ type DeviceAuth struct {
 
 	//admission request reception time
 	RequestTime *time.Time `json:"request_time" bson:"request_time,omitempty"`
+
+	//enrollment token presented at submission, never stored
+	EnrollmentToken string `json:"token,omitempty" bson:"-"`
+
+	//PEM encoded device certificate, optionally followed by intermediate
+	//CA certificates, presented at submission, never stored
+	Certificate string `json:"certificate,omitempty" bson:"-"`
+
+	//device certificate the auth set was admitted with
+	CertificateInfo *CertificateInfo `json:"certificate_info,omitempty" bson:"certificate_info,omitempty"`
+
+	//labels assigned by users, see ValidateTags()
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+
+	//set if identity attributes violate the tenant's identity schema
+	SchemaViolation bool `json:"schema_violation,omitempty" bson:"schema_violation,omitempty"`
+
+	//time set by users after which the auth set is considered stale,
+	//informational only
+	ExpiresAt *time.Time `json:"expires_ts,omitempty" bson:"expires_ts,omitempty"`
+
+	//time the auth set was moved to the trash, nil for live auth sets
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+
+	//incremented on every change, exposed as ETag; 0 for auth sets
+	//stored before revisions were introduced
+	Revision int `json:"-" bson:"revision,omitempty"`
 }
 
 func (did DeviceID) String() string {
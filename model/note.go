@@ -0,0 +1,76 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const MaxNoteLength = 4096
+
+// Note is an operator's comment on an auth set
+type Note struct {
+	ID string `json:"id" bson:"id"`
+
+	AuthID AuthID `json:"auth_id" bson:"auth_id"`
+
+	// subject of the writing user's identity
+	Author string `json:"author" bson:"author"`
+
+	Text string `json:"text" bson:"text"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+type NoteReq struct {
+	Text string `json:"text"`
+}
+
+func ParseNoteReq(source io.Reader) (*NoteReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req NoteReq
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *NoteReq) Validate() error {
+	if strings.TrimSpace(r.Text) == "" {
+		return errors.New("text must be provided")
+	}
+	if len(r.Text) > MaxNoteLength {
+		return errors.Errorf("text is longer than %d characters", MaxNoteLength)
+	}
+	return nil
+}
+
+// DeviceAuthDetails is the detailed view of an auth set, including
+// operators' notes
+type DeviceAuthDetails struct {
+	DeviceAuth
+
+	Notes []Note `json:"notes"`
+}
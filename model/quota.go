@@ -0,0 +1,56 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Quota limits the number of distinct devices a tenant can have accepted,
+// as given by the tenant's plan; zero MaxDevices means no limit
+type Quota struct {
+	MaxDevices int `json:"max_devices" bson:"max_devices"`
+}
+
+func ParseQuota(source io.Reader) (*Quota, error) {
+	jd := json.NewDecoder(source)
+
+	var q Quota
+	if err := jd.Decode(&q); err != nil {
+		return nil, err
+	}
+
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &q, nil
+}
+
+func (q *Quota) Validate() error {
+	if q.MaxDevices < 0 {
+		return errors.New("max_devices must not be negative")
+	}
+	return nil
+}
+
+// QuotaUsage reports the number of accepted devices against the quota;
+// zero Allowed means no limit
+type QuotaUsage struct {
+	Accepted int `json:"accepted"`
+	Allowed  int `json:"allowed"`
+}
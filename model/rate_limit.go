@@ -0,0 +1,76 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"math"
+	"time"
+)
+
+// RateLimit configures a token bucket: up to Burst requests are admitted
+// at once, after which the bucket refills with one token per Interval;
+// zero Burst means no limit
+type RateLimit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+func (l RateLimit) Enabled() bool {
+	return l.Burst > 0 && l.Interval > 0
+}
+
+// TokenBucket is the stored state of a rate limit; Rev changes with
+// every update, so that concurrent updates can be detected
+type TokenBucket struct {
+	Key     string    `bson:"_id"`
+	Tokens  float64   `bson:"tokens"`
+	Updated time.Time `bson:"updated_ts"`
+	Rev     int64     `bson:"rev"`
+
+	// time at which the bucket is full again, and can be forgotten
+	Expires time.Time `bson:"expires_ts"`
+}
+
+// NewTokenBucket returns a full bucket of limit 'limit'
+func NewTokenBucket(key string, limit RateLimit, now time.Time) *TokenBucket {
+	return &TokenBucket{
+		Key:     key,
+		Tokens:  float64(limit.Burst),
+		Updated: now,
+		Expires: now,
+	}
+}
+
+// Take refills the bucket up to time 'now' and takes a token from it;
+// if there is none, the bucket is left intact and the time until a token
+// is available is returned
+func (b *TokenBucket) Take(limit RateLimit, now time.Time) (bool, time.Duration) {
+	tokens := b.Tokens
+	if elapsed := now.Sub(b.Updated); elapsed > 0 {
+		tokens += float64(elapsed) / float64(limit.Interval)
+	}
+	tokens = math.Min(tokens, float64(limit.Burst))
+
+	if tokens < 1 {
+		wait := time.Duration((1 - tokens) * float64(limit.Interval))
+		return false, wait
+	}
+
+	b.Tokens = tokens - 1
+	b.Updated = now
+	b.Rev++
+	b.Expires = now.Add(
+		time.Duration((float64(limit.Burst) - b.Tokens) * float64(limit.Interval)))
+	return true, 0
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// upper limit of required approvals, to avoid locking tenants out
+	MaxRequiredApprovals = 10
+)
+
+// TenantSettings are admission settings configurable by tenants
+type TenantSettings struct {
+	// number of distinct users which must accept an auth set before it
+	// is admitted; 0 and 1 both mean a single user decides
+	RequiredApprovals int `json:"required_approvals" bson:"required_approvals"`
+
+	// status transitions users may make, DefaultTransitions if not set;
+	// stored even if empty so that updates can restore the default
+	Transitions Transitions `json:"transitions,omitempty" bson:"transitions"`
+}
+
+// GetTransitions returns the transition table effective for the tenant
+func (s *TenantSettings) GetTransitions() Transitions {
+	if s.Transitions == nil {
+		return DefaultTransitions
+	}
+	return s.Transitions
+}
+
+func ParseTenantSettings(source io.Reader) (*TenantSettings, error) {
+	jd := json.NewDecoder(source)
+
+	var s TenantSettings
+	if err := jd.Decode(&s); err != nil {
+		return nil, err
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+func (s *TenantSettings) Validate() error {
+	if s.RequiredApprovals < 0 || s.RequiredApprovals > MaxRequiredApprovals {
+		return errors.Errorf("required_approvals must be between 0 and %d",
+			MaxRequiredApprovals)
+	}
+	return s.Transitions.Validate()
+}
@@ -0,0 +1,123 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	MaxTagLength = 64
+
+	// maximum number of auth sets tagged in a single request
+	MaxTagsBatch = 1000
+)
+
+var tagRegexp = regexp.MustCompile(`^[\w.:-]+$`)
+
+// ValidateTags checks that tags consist of letters, digits and any of
+// '_', '.', ':', '-' only, and are not too long
+func ValidateTags(tags []string) error {
+	for _, t := range tags {
+		if !tagRegexp.MatchString(t) {
+			return errors.Errorf("invalid tag %q, only letters, digits and _.:- are allowed", t)
+		}
+		if len(t) > MaxTagLength {
+			return errors.Errorf("tag %q is longer than %d characters", t, MaxTagLength)
+		}
+	}
+	return nil
+}
+
+// HasTags checks if all of 'want' tags are among 'tags'
+func HasTags(tags, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, t := range tags {
+			if t == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func ParseTags(source io.Reader) ([]string, error) {
+	jd := json.NewDecoder(source)
+
+	var tags []string
+	if err := jd.Decode(&tags); err != nil {
+		return nil, err
+	}
+
+	if len(tags) == 0 {
+		return nil, errors.New("no tags")
+	}
+	if err := ValidateTags(tags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// TagsReq adds and removes tags of multiple auth sets
+type TagsReq struct {
+	IDs    []AuthID `json:"ids"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+func ParseTagsReq(source io.Reader) (*TagsReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req TagsReq
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *TagsReq) Validate() error {
+	if len(r.IDs) == 0 {
+		return errors.New("ids must be provided")
+	}
+	if len(r.IDs) > MaxTagsBatch {
+		return errors.Errorf("too many auth sets, at most %d are allowed", MaxTagsBatch)
+	}
+	if len(r.Add) == 0 && len(r.Remove) == 0 {
+		return errors.New("add or remove must be provided")
+	}
+	if err := ValidateTags(r.Add); err != nil {
+		return err
+	}
+	return ValidateTags(r.Remove)
+}
+
+// TagsResult reports the number of auth sets a tags request applied to
+type TagsResult struct {
+	Updated int `json:"updated"`
+}
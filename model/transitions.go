@@ -0,0 +1,114 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Transitions maps the status of an auth set to statuses users may move it
+// to; staying in the same status is always allowed
+type Transitions map[string][]string
+
+// DefaultTransitions apply to tenants which did not configure their own
+var DefaultTransitions = Transitions{
+	DevStatusPending:       {DevStatusAccepted, DevStatusRejected},
+	DevStatusPreauthorized: {DevStatusAccepted, DevStatusRejected},
+	DevStatusAccepted:      {DevStatusRejected, DevStatusSuspended},
+	DevStatusRejected:      {DevStatusAccepted},
+	DevStatusSuspended:     {DevStatusAccepted, DevStatusRejected},
+}
+
+var (
+	// statuses auth sets can be in
+	transitionSources = []string{
+		DevStatusPending,
+		DevStatusPreauthorized,
+		DevStatusAccepted,
+		DevStatusRejected,
+		DevStatusSuspended,
+	}
+	// statuses users can set
+	transitionTargets = []string{
+		DevStatusAccepted,
+		DevStatusRejected,
+		DevStatusSuspended,
+	}
+)
+
+// suspendable checks if an auth set in status 'from' may be suspended; a
+// suspended auth set is accepted again without an approval round, so only
+// auth sets which were accepted can be suspended
+func suspendable(from string) bool {
+	return from == DevStatusAccepted
+}
+
+// Allowed checks if an auth set may move from status 'from' to 'to'
+func (t Transitions) Allowed(from, to string) bool {
+	if from == to {
+		return true
+	}
+	// settings stored before the rule was enforced may break it
+	if to == DevStatusSuspended && !suspendable(from) {
+		return false
+	}
+	for _, s := range t[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns statuses an auth set in status 'from' may move to
+func (t Transitions) Next(from string) []string {
+	next := []string{}
+	for _, s := range t[from] {
+		if s != from {
+			next = append(next, s)
+		}
+	}
+	sort.Strings(next)
+	return next
+}
+
+func (t Transitions) Validate() error {
+	for from, to := range t {
+		if !hasStatus(transitionSources, from) {
+			return errors.Errorf("unknown status %q in transitions", from)
+		}
+		for _, s := range to {
+			if !hasStatus(transitionTargets, s) {
+				return errors.Errorf("transition from %q to %q is not supported, "+
+					"only accepted, rejected and suspended can be set", from, s)
+			}
+			if s == DevStatusSuspended && !suspendable(from) {
+				return errors.Errorf("transition from %q to %q is not supported, "+
+					"only accepted auth sets can be suspended", from, s)
+			}
+		}
+	}
+	return nil
+}
+
+func hasStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TrustedCA is a CA certificate uploaded by the tenant; devices presenting
+// a certificate issued by a trusted CA are admitted automatically
+type TrustedCA struct {
+	ID string `json:"id" bson:"id"`
+
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+
+	// PEM encoded CA certificate
+	Certificate string `json:"certificate" bson:"certificate"`
+
+	Subject string `json:"subject" bson:"subject"`
+
+	// hex encoded SHA256 of the DER encoded certificate
+	Fingerprint string `json:"fingerprint" bson:"fingerprint"`
+
+	ExpiresAt time.Time `json:"expires_ts" bson:"expires_ts"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+// TrustedCAReq is the trusted CA upload request
+type TrustedCAReq struct {
+	Certificate string `json:"certificate"`
+	Description string `json:"description"`
+
+	cert *x509.Certificate
+}
+
+func ParseTrustedCAReq(source io.Reader) (*TrustedCAReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req TrustedCAReq
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *TrustedCAReq) Validate() error {
+	if r.Certificate == "" {
+		return errors.New("certificate must be provided")
+	}
+
+	certs, err := ParseCertificatesPEM(r.Certificate)
+	if err != nil {
+		return err
+	}
+	if len(certs) != 1 {
+		return errors.New("exactly one certificate must be provided")
+	}
+	if !certs[0].BasicConstraintsValid || !certs[0].IsCA {
+		return errors.New("not a CA certificate")
+	}
+
+	r.cert = certs[0]
+	return nil
+}
+
+// TrustedCA returns trusted CA described by the request, the request must
+// be validated first
+func (r *TrustedCAReq) TrustedCA() *TrustedCA {
+	return &TrustedCA{
+		Description: r.Description,
+		Certificate: strings.TrimSpace(r.Certificate) + "\n",
+		Subject:     r.cert.Subject.String(),
+		Fingerprint: certFingerprint(r.cert),
+		ExpiresAt:   r.cert.NotAfter,
+	}
+}
+
+// CertificateInfo describes the device certificate an auth set was
+// admitted with
+type CertificateInfo struct {
+	Subject   string    `json:"subject" bson:"subject"`
+	Serial    string    `json:"serial" bson:"serial"`
+	ExpiresAt time.Time `json:"expires_ts" bson:"expires_ts"`
+
+	// trusted CA the certificate chains to
+	CAID string `json:"ca_id" bson:"ca_id"`
+}
+
+// ParseCertificatesPEM parses a sequence of PEM encoded certificates
+func ParseCertificatesPEM(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse certificate")
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return certs, nil
+}
+
+// VerifyDeviceCertificate checks that the device certificate in 'chain'
+// (possibly followed by intermediate certificates) was issued by one of
+// 'cas', is valid at 'now' and certifies public key 'key'
+func VerifyDeviceCertificate(chain string, key string, cas []TrustedCA, now time.Time) (*CertificateInfo, error) {
+	certs, err := ParseCertificatesPEM(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         x509.NewCertPool(),
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+
+	ids := make(map[string]string, len(cas))
+	for _, ca := range cas {
+		cacerts, err := ParseCertificatesPEM(ca.Certificate)
+		if err != nil {
+			return nil, errors.Wrapf(err, "trusted CA %s", ca.ID)
+		}
+		opts.Roots.AddCert(cacerts[0])
+		ids[certFingerprint(cacerts[0])] = ca.ID
+	}
+
+	cert := certs[0]
+	chains, err := cert.Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode certificate public key")
+	}
+	sum := sha256.Sum256(der)
+	if hex.EncodeToString(sum[:]) != KeyFingerprint(key) {
+		return nil, errors.New("certificate public key does not match device key")
+	}
+
+	root := chains[0][len(chains[0])-1]
+	return &CertificateInfo{
+		Subject:   cert.Subject.String(),
+		Serial:    cert.SerialNumber.String(),
+		ExpiresAt: cert.NotAfter,
+		CAID:      ids[certFingerprint(root)],
+	}, nil
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
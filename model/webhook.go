@@ -0,0 +1,160 @@
+// Copyright 2018 Northern.tech AS
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/pkg/errors"
+)
+
+const (
+	// a new auth set was submitted and awaits a decision
+	WebhookEventAuthSetCreated = EventAuthSetCreated
+	// status of an auth set changed
+	WebhookEventStatusChanged = EventStatusChanged
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+var WebhookEvents = []string{
+	WebhookEventAuthSetCreated,
+	WebhookEventStatusChanged,
+}
+
+// Webhook is a tenant's subscription to admission events
+type Webhook struct {
+	ID string `json:"id" bson:"id"`
+
+	// URL events are POSTed to
+	URL string `json:"url" bson:"url"`
+
+	// subscribed event types
+	Events []string `json:"events" bson:"events"`
+
+	// HMAC-SHA256 key used for signing payloads, never returned
+	Secret string `json:"-" bson:"secret"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+}
+
+// Subscribed checks if the webhook wants to be notified about 'event'
+func (w *Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookReq is the webhook creation request
+type WebhookReq struct {
+	URL    string   `json:"url" valid:"required"`
+	Events []string `json:"events" valid:"required"`
+	Secret string   `json:"secret" valid:"required"`
+}
+
+func ParseWebhookReq(source io.Reader) (*WebhookReq, error) {
+	jd := json.NewDecoder(source)
+
+	var req WebhookReq
+	if err := jd.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+func (r *WebhookReq) Validate() error {
+	if _, err := govalidator.ValidateStruct(*r); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return errors.Wrap(err, "invalid url")
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+
+	for _, e := range r.Events {
+		known := false
+		for _, k := range WebhookEvents {
+			if e == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return errors.Errorf("unsupported event: %s", e)
+		}
+	}
+
+	return nil
+}
+
+// WebhookPayload is the JSON document POSTed to webhook URLs
+type WebhookPayload struct {
+	// delivery ID, same across retries
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	Timestamp time.Time   `json:"timestamp"`
+	TenantID  string      `json:"tenant_id,omitempty"`
+	AuthSet   *DeviceAuth `json:"auth_set"`
+}
+
+// WebhookDelivery records an attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	ID        string `json:"id" bson:"id"`
+	WebhookID string `json:"webhook_id" bson:"webhook_id"`
+	Event     string `json:"event" bson:"event"`
+	AuthID    AuthID `json:"auth_id" bson:"auth_id"`
+
+	// one of WebhookDelivery* statuses
+	Status string `json:"status" bson:"status"`
+
+	// number of requests made so far
+	Attempts int `json:"attempts" bson:"attempts"`
+
+	// HTTP status code of the last response, 0 if none was received
+	StatusCode int `json:"status_code,omitempty" bson:"status_code,omitempty"`
+
+	// error of the last attempt
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+
+	Created *time.Time `json:"created_ts" bson:"created_ts,omitempty"`
+	Updated *time.Time `json:"updated_ts,omitempty" bson:"updated_ts,omitempty"`
+
+	// request body, kept so that pending deliveries can be resumed
+	Payload []byte `json:"-" bson:"payload,omitempty"`
+
+	// token of the worker about to make the delivery, and the time it's
+	// held until; other instances leave a claimed delivery alone
+	Claim        string     `json:"-" bson:"claim,omitempty"`
+	ClaimExpires *time.Time `json:"-" bson:"claim_expires_ts,omitempty"`
+}
@@ -14,16 +14,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/mendersoftware/go-lib-micro/log"
+	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 
 	api_http "github.com/mendersoftware/deviceadm/api/http"
+	"github.com/mendersoftware/deviceadm/client"
 	"github.com/mendersoftware/deviceadm/client/deviceauth"
 	"github.com/mendersoftware/deviceadm/config"
 	"github.com/mendersoftware/deviceadm/devadm"
+	"github.com/mendersoftware/deviceadm/model"
 	"github.com/mendersoftware/deviceadm/utils/clock"
 )
 
@@ -42,6 +50,60 @@ func SetupAPI(stacktype string) (*rest.Api, error) {
 	return api, nil
 }
 
+func makeHttpClientConfig(c config.Reader) client.Config {
+	return client.Config{
+		MaxIdleConns:        c.GetInt(SettingHttpMaxIdleConns),
+		MaxIdleConnsPerHost: c.GetInt(SettingHttpMaxIdleConnsPerHost),
+		IdleConnTimeout:     c.GetDuration(SettingHttpIdleConnTimeout),
+
+		CACertFile:     c.GetString(SettingHttpTLSCACert),
+		ClientCertFile: c.GetString(SettingHttpTLSClientCert),
+		ClientKeyFile:  c.GetString(SettingHttpTLSClientKey),
+		TLSSkipVerify:  c.GetBool(SettingHttpTLSSkipVerify),
+
+		ProxyURL: c.GetString(SettingHttpProxy),
+	}
+}
+
+// makePropagationTargets reads the list of HTTP propagation targets, given
+// either as a list in the config file or as a JSON string, e.g. in an
+// environment variable
+func makePropagationTargets(c config.Reader) ([]devadm.HttpTargetConfig, error) {
+	raw := c.Get(SettingPropagationTargets)
+
+	if str, ok := raw.(string); ok {
+		if str == "" {
+			return nil, nil
+		}
+
+		var targets []map[string]interface{}
+		if err := json.Unmarshal([]byte(str), &targets); err != nil {
+			return nil, errors.Wrap(err, "failed to parse propagation targets")
+		}
+		raw = targets
+	}
+
+	var targets []devadm.HttpTargetConfig
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+		Result:     &targets,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := dec.Decode(raw); err != nil {
+		return nil, errors.Wrap(err, "failed to parse propagation targets")
+	}
+
+	for _, t := range targets {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return targets, nil
+}
+
 func RunServer(c config.Reader) error {
 
 	l := log.New(log.Ctx{})
@@ -51,16 +113,64 @@ func RunServer(c config.Reader) error {
 		return errors.Wrap(err, "database connection failed")
 	}
 
-	devadm := devadm.NewDevAdm(d, deviceauth.Config{
-		DevauthUrl: c.GetString(SettingDevAuthUrl),
-	}, clock.NewClock())
+	httpClient, err := client.NewHttpApi(makeHttpClientConfig(c))
+	if err != nil {
+		return errors.Wrap(err, "HTTP client setup failed")
+	}
+
+	app := devadm.NewDevAdm(d, deviceauth.Config{
+		DevauthUrl:       c.GetString(SettingDevAuthUrl),
+		Timeout:          c.GetDuration(SettingDevAuthTimeout),
+		MaxRetries:       c.GetInt(SettingDevAuthMaxRetries),
+		RetryBackoff:     c.GetDuration(SettingDevAuthRetryBackoff),
+		MaxRetryBackoff:  c.GetDuration(SettingDevAuthMaxRetryBackoff),
+		BreakerThreshold: c.GetInt(SettingDevAuthBreakerThreshold),
+		BreakerCooldown:  c.GetDuration(SettingDevAuthBreakerCooldown),
+	}, clock.NewClock()).
+		WithHttpClient(httpClient).
+		WithWebhooks(devadm.WebhookConfig{
+			MaxRetries:     c.GetInt(SettingWebhooksMaxRetries),
+			RetryBackoff:   c.GetDuration(SettingWebhooksRetryBackoff),
+			Timeout:        c.GetDuration(SettingWebhooksTimeout),
+			Workers:        c.GetInt(SettingWebhooksWorkers),
+			QueueSize:      c.GetInt(SettingWebhooksQueueSize),
+			ResumeInterval: c.GetDuration(SettingWebhooksResumeInterval),
+
+			AllowPrivateDestinations: c.GetBool(SettingWebhooksAllowPrivate),
+		}).
+		WithEventLog(devadm.EventLogConfig{
+			PollInterval: c.GetDuration(SettingEventsPollInterval),
+			GapTimeout:   c.GetDuration(SettingEventsGapTimeout),
+		}).
+		WithBatchPreauthConcurrency(c.GetInt(SettingBatchPreauthConcurrency)).
+		WithRateLimits(devadm.RateLimitConfig{
+			Identity: model.RateLimit{
+				Burst:    c.GetInt(SettingRateLimitIdentityBurst),
+				Interval: c.GetDuration(SettingRateLimitIdentityInterval),
+			},
+			Tenant: model.RateLimit{
+				Burst:    c.GetInt(SettingRateLimitTenantBurst),
+				Interval: c.GetDuration(SettingRateLimitTenantInterval),
+			},
+		}).
+		WithIdempotencyTTL(c.GetDuration(SettingIdempotencyTTL)).
+		WithIdempotencyLease(c.GetDuration(SettingIdempotencyLease))
+
+	targets, err := makePropagationTargets(c)
+	if err != nil {
+		return errors.Wrap(err, "propagation targets setup failed")
+	}
+	for _, t := range targets {
+		app.WithPropagationTarget(
+			devadm.NewHttpTarget(t, httpClient), t.Policy)
+	}
 
 	api, err := SetupAPI(c.GetString(SettingMiddleware))
 	if err != nil {
 		return errors.Wrap(err, "API setup failed")
 	}
 
-	devadmapi := api_http.NewDevAdmApiHandlers(devadm)
+	devadmapi := api_http.NewDevAdmApiHandlers(app)
 
 	apph, err := devadmapi.GetApp()
 	if err != nil {
@@ -68,8 +178,57 @@ func RunServer(c config.Reader) error {
 	}
 	api.SetApp(apph)
 
+	// canceled on shutdown, so that resuming does not hold it up
+	resumeCtx, stopResume := context.WithCancel(context.Background())
+	defer stopResume()
+	go func() {
+		err := app.ResumeWebhookDeliveries(resumeCtx)
+		if err != nil && resumeCtx.Err() == nil {
+			l.Errorf("failed to resume webhook deliveries: %v", err)
+		}
+	}()
+
 	addr := c.GetString(SettingListen)
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: api.MakeHandler(),
+	}
+	// event streams never end on their own, and would hold up Shutdown
+	srv.RegisterOnShutdown(app.StopEventStreams)
+
+	stopped := make(chan error, 1)
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+		sig := <-sigs
+
+		l.Printf("received %v, shutting down", sig)
+		stopResume()
+
+		// requests and webhook deliveries are drained side by side,
+		// each within its own deadline
+		timeout := c.GetDuration(SettingShutdownTimeout)
+		webhooksStopped := make(chan struct{})
+		go func() {
+			defer close(webhooksStopped)
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := app.StopWebhooks(ctx); err != nil {
+				l.Errorf("webhook deliveries left pending: %v", err)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err := srv.Shutdown(ctx)
+		<-webhooksStopped
+		stopped <- err
+	}()
+
 	l.Printf("listening on %s", addr)
 
-	return http.ListenAndServe(addr, api.MakeHandler())
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-stopped
 }
@@ -15,8 +15,12 @@ package main
 
 import (
 	"testing"
+	"time"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/mendersoftware/deviceadm/devadm"
 )
 
 func TestSetupApi(t *testing.T) {
@@ -29,3 +33,67 @@ func TestSetupApi(t *testing.T) {
 	assert.NotNil(t, api)
 	assert.Nil(t, err)
 }
+
+func TestMakePropagationTargets(t *testing.T) {
+	testCases := map[string]struct {
+		value interface{}
+
+		targets []devadm.HttpTargetConfig
+		err     bool
+	}{
+		"not set": {},
+		"list": {
+			value: []interface{}{
+				map[interface{}]interface{}{
+					"name":    "inventory",
+					"url":     "http://inventory",
+					"policy":  "best-effort",
+					"timeout": "5s",
+				},
+			},
+			targets: []devadm.HttpTargetConfig{
+				{
+					Name:    "inventory",
+					URL:     "http://inventory",
+					Policy:  devadm.FailurePolicyBestEffort,
+					Timeout: 5 * time.Second,
+				},
+			},
+		},
+		"json string": {
+			value: `[{"name": "inventory", "url": "http://inventory", "policy": "required"}]`,
+			targets: []devadm.HttpTargetConfig{
+				{
+					Name:   "inventory",
+					URL:    "http://inventory",
+					Policy: devadm.FailurePolicyRequired,
+				},
+			},
+		},
+		"error: bad json": {
+			value: `[{"name"`,
+			err:   true,
+		},
+		"error: invalid target": {
+			value: `[{"name": "inventory", "policy": "required"}]`,
+			err:   true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := viper.New()
+			if tc.value != nil {
+				c.Set(SettingPropagationTargets, tc.value)
+			}
+
+			targets, err := makePropagationTargets(c)
+			if tc.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.targets, targets)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ package store
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/mendersoftware/deviceadm/model"
 )
@@ -24,11 +25,24 @@ import (
 var (
 	// object not found
 	ErrNotFound = errors.New("not found")
+	// object violates a uniqueness constraint
+	ErrDuplicate = errors.New("duplicate")
+	// object was modified since it was read
+	ErrModified = errors.New("modified concurrently")
+)
+
+const (
+	// AnyRevision makes a conditional update of an auth set
+	// unconditional
+	AnyRevision = -1
 )
 
 type DataStore interface {
 	GetDeviceAuths(ctx context.Context, skip, limit int, filter Filter) ([]model.DeviceAuth, error)
 
+	// count auth sets matching `filter`, disregarding filter.After
+	CountDeviceAuths(ctx context.Context, filter Filter) (int, error)
+
 	// find a device auth set with given `id`, returns the device auth set
 	// or nil, if auth set was not found, error is set to ErrDevNotFound
 	GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error)
@@ -51,12 +65,247 @@ type DataStore interface {
 	DeleteDeviceAuthByDevice(ctx context.Context, id model.DeviceID) error
 
 	// UpdateDeviceAuth updates the auth set (strict update, no upserts).
+	// The update is applied only if the stored auth set is still at the
+	// revision of `dev`, ErrModified is returned otherwise; auth sets
+	// stored before revisions were introduced are at revision 0. Every
+	// change of an auth set bumps its revision.
 	UpdateDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error
 
+	// PatchDeviceAuth stores attributes, tags and expiry of auth set
+	// `dev` exactly as given, empty ones are removed; revision is
+	// checked as in UpdateDeviceAuth
+	PatchDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error
+
+	// add tags to the auth sets of given IDs; returns the number of
+	// auth sets found
+	AddDeviceAuthTags(ctx context.Context, ids []model.AuthID, tags []string) (int, error)
+
+	// remove tags from the auth sets of given IDs; returns the number of
+	// auth sets found
+	RemoveDeviceAuthTags(ctx context.Context, ids []model.AuthID, tags []string) (int, error)
+
+	// move auth set to the trash, marking it deleted at `ts`; auth sets in
+	// the trash are excluded from all other queries until restored. The
+	// auth set must still be at `revision` (or AnyRevision), ErrModified
+	// is returned otherwise.
+	SoftDeleteDeviceAuth(ctx context.Context, id model.AuthID, revision int, ts time.Time) error
+
+	// move all auth sets of given device to the trash
+	SoftDeleteDeviceAuthByDevice(ctx context.Context, id model.DeviceID, ts time.Time) error
+
+	// list auth sets in the trash, most recently deleted first
+	GetDeletedDeviceAuths(ctx context.Context, skip, limit int) ([]model.DeviceAuth, error)
+
+	GetDeletedDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error)
+
+	// take auth set out of the trash; returns ErrNotFound if it is not
+	// in the trash
+	RestoreDeviceAuth(ctx context.Context, id model.AuthID) error
+
+	// remove auth sets deleted before `before` for good, along with their
+	// notes; returns the number of removed auth sets
+	PurgeDeletedDeviceAuths(ctx context.Context, before time.Time) (int, error)
+
 	MigrateTenant(ctx context.Context, version string, tenant string) error
 	WithAutomigrate() DataStore
 
 	InsertDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error
 
 	GetDeviceAuthsByIdentityData(ctx context.Context, idata string) ([]model.DeviceAuth, error)
+
+	// insert a new webhook, a new ID is assigned to `hook`
+	InsertWebhook(ctx context.Context, hook *model.Webhook) error
+
+	GetWebhooks(ctx context.Context) ([]model.Webhook, error)
+
+	// find a webhook with given `id`, returns ErrNotFound if webhook was
+	// not found
+	GetWebhook(ctx context.Context, id string) (*model.Webhook, error)
+
+	// remove a webhook together with its delivery log
+	DeleteWebhook(ctx context.Context, id string) error
+
+	// insert a new delivery record, a new ID is assigned to `delivery`
+	InsertWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+
+	// update status of a delivery record
+	UpdateWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error
+
+	// list deliveries of a webhook, most recent first
+	GetWebhookDeliveries(ctx context.Context, webhookID string, skip, limit int) ([]model.WebhookDelivery, error)
+
+	// get deliveries waiting to be made and not claimed as of 'now',
+	// oldest first
+	GetPendingWebhookDeliveries(ctx context.Context, now time.Time) ([]model.WebhookDelivery, error)
+
+	// claim a pending delivery for 'claim' until 'expires', provided it is
+	// not claimed by another token as of 'now'; returns ErrModified if it
+	// is, or is no longer pending
+	ClaimWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, claim string, expires, now time.Time) error
+
+	// list tenants, "" for the default tenant
+	GetTenants(ctx context.Context) ([]string, error)
+
+	// append an event to the event log, `ev` is assigned the next
+	// sequence number
+	InsertEvent(ctx context.Context, ev *model.Event) error
+
+	// list events with sequence numbers greater than `after`, oldest
+	// first
+	GetEvents(ctx context.Context, after int64, limit int) ([]model.Event, error)
+
+	// get sequence number of the most recent event, 0 if there were no
+	// events
+	GetLastEventID(ctx context.Context) (int64, error)
+
+	// get tenant settings, defaults are returned if settings were never
+	// stored
+	GetSettings(ctx context.Context) (*model.TenantSettings, error)
+
+	PutSettings(ctx context.Context, settings *model.TenantSettings) error
+
+	// insert a new approval, a new ID is assigned to `approval`; returns
+	// ErrDuplicate if the approver already approved the auth set
+	InsertApproval(ctx context.Context, approval *model.Approval) error
+
+	// list approvals of given auth set, or all approvals if `authID` is
+	// empty
+	GetApprovals(ctx context.Context, authID model.AuthID) ([]model.Approval, error)
+
+	GetApproval(ctx context.Context, id string) (*model.Approval, error)
+
+	DeleteApproval(ctx context.Context, id string) error
+
+	// remove all approvals of given auth set
+	DeleteApprovalsByAuthSet(ctx context.Context, authID model.AuthID) error
+
+	// insert a new note, a new ID is assigned to `note`
+	InsertNote(ctx context.Context, note *model.Note) error
+
+	// list notes of given auth set, oldest first
+	GetNotes(ctx context.Context, authID model.AuthID) ([]model.Note, error)
+
+	// insert a new blocklist entry, a new ID is assigned to `entry`
+	InsertBlocklistEntry(ctx context.Context, entry *model.BlocklistEntry) error
+
+	GetBlocklist(ctx context.Context) ([]model.BlocklistEntry, error)
+
+	DeleteBlocklistEntry(ctx context.Context, id string) error
+
+	// record an auth set rejected by the blocklist, a new ID is assigned
+	// to `hit`
+	InsertBlocklistHit(ctx context.Context, hit *model.BlocklistHit) error
+
+	// list blocklist hits, most recent first
+	GetBlocklistHits(ctx context.Context, skip, limit int) ([]model.BlocklistHit, error)
+
+	// insert allowlist entries, new IDs are assigned to `entries`
+	InsertAllowlistEntries(ctx context.Context, entries []model.AllowlistEntry) error
+
+	// list allowlist entries of given batch, or all entries if `batch`
+	// is empty
+	GetAllowlist(ctx context.Context, batch string, skip, limit int) ([]model.AllowlistEntry, error)
+
+	DeleteAllowlistEntry(ctx context.Context, id string) error
+
+	// find allowlist entries matching identity attributes `attrs` that
+	// can still admit auth set `authID`, i.e. entries that are not single
+	// use, unused or already used by `authID`
+	FindAllowlistEntries(ctx context.Context, attrs model.DeviceAuthAttributes, authID model.AuthID) ([]model.AllowlistEntry, error)
+
+	// mark a single use allowlist entry as used by auth set `authID`;
+	// returns ErrNotFound if the entry is gone or used by another auth set
+	UseAllowlistEntry(ctx context.Context, id string, authID model.AuthID, ts time.Time) error
+
+	// insert a new enrollment token, a new ID is assigned to `token`
+	InsertEnrollmentToken(ctx context.Context, token *model.EnrollmentToken) error
+
+	GetEnrollmentTokens(ctx context.Context) ([]model.EnrollmentToken, error)
+
+	RevokeEnrollmentToken(ctx context.Context, id string) error
+
+	// atomically use up one use of the enrollment token with given
+	// digest; returns ErrNotFound unless the token exists, is not
+	// revoked, expired or used up
+	UseEnrollmentToken(ctx context.Context, hash string, now time.Time) (*model.EnrollmentToken, error)
+
+	// give back a use of enrollment token `id` taken by
+	// UseEnrollmentToken; returns ErrNotFound if the token is gone
+	ReleaseEnrollmentToken(ctx context.Context, id string) error
+
+	// insert a new trusted CA, a new ID is assigned to `ca`; returns
+	// ErrDuplicate if the certificate is already trusted
+	InsertTrustedCA(ctx context.Context, ca *model.TrustedCA) error
+
+	GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error)
+
+	DeleteTrustedCA(ctx context.Context, id string) error
+
+	// insert a new manifest key, a new ID is assigned to `key`; returns
+	// ErrDuplicate if the key is already registered
+	InsertManifestKey(ctx context.Context, key *model.ManifestKey) error
+
+	GetManifestKeys(ctx context.Context) ([]model.ManifestKey, error)
+
+	DeleteManifestKey(ctx context.Context, id string) error
+
+	// record a processed manifest; returns ErrDuplicate if a manifest
+	// with the same ID was already processed
+	InsertManifestRecord(ctx context.Context, rec *model.ManifestRecord) error
+
+	// get device quota of the tenant, a zero quota is returned if none
+	// was set
+	GetQuota(ctx context.Context) (*model.Quota, error)
+
+	PutQuota(ctx context.Context, quota *model.Quota) error
+
+	// count distinct devices having an accepted auth set
+	CountAcceptedDevices(ctx context.Context) (int, error)
+
+	// take the lock serializing admissions against the device quota for
+	// 'lock' until 'expires', provided no other lock is held as of 'now';
+	// returns ErrModified if one is
+	LockQuota(ctx context.Context, lock string, expires, now time.Time) error
+
+	// release quota lock 'lock'; releasing a lock that expired and was
+	// taken by someone else is a no-op
+	UnlockQuota(ctx context.Context, lock string) error
+
+	// get identity schema of the tenant, nil is returned if none was set
+	GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error)
+
+	PutIdentitySchema(ctx context.Context, schema *model.IdentitySchema) error
+
+	DeleteIdentitySchema(ctx context.Context) error
+
+	// take a token from rate limit bucket 'key', shared by all service
+	// instances; returns zero if a token was taken, or the time until
+	// one is available
+	TakeRateLimitToken(ctx context.Context, key string, limit model.RateLimit, now time.Time) (time.Duration, error)
+
+	// insert a request made with an idempotency key; returns ErrDuplicate
+	// if the key is already known
+	InsertIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error
+
+	// find a request by idempotency key, returns ErrNotFound if the key
+	// is not known or expired
+	GetIdempotentRequest(ctx context.Context, key string) (*model.IdempotentRequest, error)
+
+	// hand a request still in progress over to attempt 'lease' until
+	// 'expires', provided it was not recorded, renewed or taken over since
+	// 'req' was fetched; returns ErrModified otherwise
+	TakeOverIdempotentRequest(ctx context.Context, req *model.IdempotentRequest, lease string, expires time.Time) error
+
+	// move the expiry of a request in progress to 'expires', provided it
+	// is still held by attempt 'req.Lease'; returns ErrNotFound otherwise
+	RenewIdempotentRequest(ctx context.Context, req *model.IdempotentRequest, expires time.Time) error
+
+	// record the response to a request made with an idempotency key,
+	// provided it is still held by attempt 'req.Lease'; returns
+	// ErrNotFound otherwise
+	UpdateIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error
+
+	// forget an idempotency key, provided it is still held by attempt
+	// 'lease'; returns ErrNotFound otherwise
+	DeleteIdempotentRequest(ctx context.Context, key, lease string) error
 }
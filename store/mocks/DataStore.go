@@ -14,6 +14,7 @@
 package mocks
 
 import context "context"
+import time "time"
 import mock "github.com/stretchr/testify/mock"
 import model "github.com/mendersoftware/deviceadm/model"
 import store "github.com/mendersoftware/deviceadm/store"
@@ -23,6 +24,139 @@ type DataStore struct {
 	mock.Mock
 }
 
+// AddDeviceAuthTags provides a mock function with given fields: ctx, ids, tags
+func (_m *DataStore) AddDeviceAuthTags(ctx context.Context, ids []model.AuthID, tags []string) (int, error) {
+	ret := _m.Called(ctx, ids, tags)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, []model.AuthID, []string) int); ok {
+		r0 = rf(ctx, ids, tags)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []model.AuthID, []string) error); ok {
+		r1 = rf(ctx, ids, tags)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ClaimWebhookDelivery provides a mock function with given fields: ctx, delivery, claim, expires, now
+func (_m *DataStore) ClaimWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, claim string, expires time.Time, now time.Time) error {
+	ret := _m.Called(ctx, delivery, claim, expires, now)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.WebhookDelivery, string, time.Time, time.Time) error); ok {
+		r0 = rf(ctx, delivery, claim, expires, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CountAcceptedDevices provides a mock function with given fields: ctx
+func (_m *DataStore) CountAcceptedDevices(ctx context.Context) (int, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context) int); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountDeviceAuths provides a mock function with given fields: ctx, filter
+func (_m *DataStore) CountDeviceAuths(ctx context.Context, filter store.Filter) (int, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, store.Filter) int); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, store.Filter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteAllowlistEntry provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteAllowlistEntry(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteApproval provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteApproval(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteApprovalsByAuthSet provides a mock function with given fields: ctx, authID
+func (_m *DataStore) DeleteApprovalsByAuthSet(ctx context.Context, authID model.AuthID) error {
+	ret := _m.Called(ctx, authID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) error); ok {
+		r0 = rf(ctx, authID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteBlocklistEntry provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteBlocklistEntry(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteDeviceAuth provides a mock function with given fields: ctx, id
 func (_m *DataStore) DeleteDeviceAuth(ctx context.Context, id model.AuthID) error {
 	ret := _m.Called(ctx, id)
@@ -51,22 +185,92 @@ func (_m *DataStore) DeleteDeviceAuthByDevice(ctx context.Context, id model.Devi
 	return r0
 }
 
-// GetDeviceAuth provides a mock function with given fields: ctx, id
-func (_m *DataStore) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error) {
+// DeleteIdempotentRequest provides a mock function with given fields: ctx, key, lease
+func (_m *DataStore) DeleteIdempotentRequest(ctx context.Context, key string, lease string) error {
+	ret := _m.Called(ctx, key, lease)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, key, lease)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteIdentitySchema provides a mock function with given fields: ctx
+func (_m *DataStore) DeleteIdentitySchema(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteManifestKey provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteManifestKey(ctx context.Context, id string) error {
 	ret := _m.Called(ctx, id)
 
-	var r0 *model.DeviceAuth
-	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) *model.DeviceAuth); ok {
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteTrustedCA provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteTrustedCA(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteWebhook provides a mock function with given fields: ctx, id
+func (_m *DataStore) DeleteWebhook(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
 		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// FindAllowlistEntries provides a mock function with given fields: ctx, attrs, authID
+func (_m *DataStore) FindAllowlistEntries(ctx context.Context, attrs model.DeviceAuthAttributes, authID model.AuthID) ([]model.AllowlistEntry, error) {
+	ret := _m.Called(ctx, attrs, authID)
+
+	var r0 []model.AllowlistEntry
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeviceAuthAttributes, model.AuthID) []model.AllowlistEntry); ok {
+		r0 = rf(ctx, attrs, authID)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*model.DeviceAuth)
+			r0 = ret.Get(0).([]model.AllowlistEntry)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
-		r1 = rf(ctx, id)
+	if rf, ok := ret.Get(1).(func(context.Context, model.DeviceAuthAttributes, model.AuthID) error); ok {
+		r1 = rf(ctx, attrs, authID)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -74,22 +278,22 @@ func (_m *DataStore) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model
 	return r0, r1
 }
 
-// GetDeviceAuths provides a mock function with given fields: ctx, skip, limit, filter
-func (_m *DataStore) GetDeviceAuths(ctx context.Context, skip int, limit int, filter store.Filter) ([]model.DeviceAuth, error) {
-	ret := _m.Called(ctx, skip, limit, filter)
+// GetAllowlist provides a mock function with given fields: ctx, batch, skip, limit
+func (_m *DataStore) GetAllowlist(ctx context.Context, batch string, skip int, limit int) ([]model.AllowlistEntry, error) {
+	ret := _m.Called(ctx, batch, skip, limit)
 
-	var r0 []model.DeviceAuth
-	if rf, ok := ret.Get(0).(func(context.Context, int, int, store.Filter) []model.DeviceAuth); ok {
-		r0 = rf(ctx, skip, limit, filter)
+	var r0 []model.AllowlistEntry
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int) []model.AllowlistEntry); ok {
+		r0 = rf(ctx, batch, skip, limit)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]model.DeviceAuth)
+			r0 = ret.Get(0).([]model.AllowlistEntry)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, int, int, store.Filter) error); ok {
-		r1 = rf(ctx, skip, limit, filter)
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, int) error); ok {
+		r1 = rf(ctx, batch, skip, limit)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -97,22 +301,22 @@ func (_m *DataStore) GetDeviceAuths(ctx context.Context, skip int, limit int, fi
 	return r0, r1
 }
 
-// GetDeviceAuthsByIdentityData provides a mock function with given fields: ctx, idata
-func (_m *DataStore) GetDeviceAuthsByIdentityData(ctx context.Context, idata string) ([]model.DeviceAuth, error) {
-	ret := _m.Called(ctx, idata)
+// GetApproval provides a mock function with given fields: ctx, id
+func (_m *DataStore) GetApproval(ctx context.Context, id string) (*model.Approval, error) {
+	ret := _m.Called(ctx, id)
 
-	var r0 []model.DeviceAuth
-	if rf, ok := ret.Get(0).(func(context.Context, string) []model.DeviceAuth); ok {
-		r0 = rf(ctx, idata)
+	var r0 *model.Approval
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Approval); ok {
+		r0 = rf(ctx, id)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]model.DeviceAuth)
+			r0 = ret.Get(0).(*model.Approval)
 		}
 	}
 
 	var r1 error
 	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
-		r1 = rf(ctx, idata)
+		r1 = rf(ctx, id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -120,60 +324,1079 @@ func (_m *DataStore) GetDeviceAuthsByIdentityData(ctx context.Context, idata str
 	return r0, r1
 }
 
-// InsertDeviceAuth provides a mock function with given fields: ctx, dev
-func (_m *DataStore) InsertDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
-	ret := _m.Called(ctx, dev)
+// GetApprovals provides a mock function with given fields: ctx, authID
+func (_m *DataStore) GetApprovals(ctx context.Context, authID model.AuthID) ([]model.Approval, error) {
+	ret := _m.Called(ctx, authID)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) error); ok {
-		r0 = rf(ctx, dev)
+	var r0 []model.Approval
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) []model.Approval); ok {
+		r0 = rf(ctx, authID)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Approval)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
+		r1 = rf(ctx, authID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// MigrateTenant provides a mock function with given fields: ctx, version, tenant
-func (_m *DataStore) MigrateTenant(ctx context.Context, version string, tenant string) error {
-	ret := _m.Called(ctx, version, tenant)
+// GetBlocklist provides a mock function with given fields: ctx
+func (_m *DataStore) GetBlocklist(ctx context.Context) ([]model.BlocklistEntry, error) {
+	ret := _m.Called(ctx)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
-		r0 = rf(ctx, version, tenant)
+	var r0 []model.BlocklistEntry
+	if rf, ok := ret.Get(0).(func(context.Context) []model.BlocklistEntry); ok {
+		r0 = rf(ctx)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.BlocklistEntry)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// PutDeviceAuth provides a mock function with given fields: ctx, dev
-func (_m *DataStore) PutDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
-	ret := _m.Called(ctx, dev)
+// GetBlocklistHits provides a mock function with given fields: ctx, skip, limit
+func (_m *DataStore) GetBlocklistHits(ctx context.Context, skip int, limit int) ([]model.BlocklistHit, error) {
+	ret := _m.Called(ctx, skip, limit)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) error); ok {
-		r0 = rf(ctx, dev)
+	var r0 []model.BlocklistHit
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) []model.BlocklistHit); ok {
+		r0 = rf(ctx, skip, limit)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.BlocklistHit)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
-// UpdateDeviceAuth provides a mock function with given fields: ctx, dev
-func (_m *DataStore) UpdateDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
-	ret := _m.Called(ctx, dev)
+// GetDeletedDeviceAuth provides a mock function with given fields: ctx, id
+func (_m *DataStore) GetDeletedDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error) {
+	ret := _m.Called(ctx, id)
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) error); ok {
-		r0 = rf(ctx, dev)
+	var r0 *model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) *model.DeviceAuth); ok {
+		r0 = rf(ctx, id)
 	} else {
-		r0 = ret.Error(0)
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeviceAuth)
+		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeletedDeviceAuths provides a mock function with given fields: ctx, skip, limit
+func (_m *DataStore) GetDeletedDeviceAuths(ctx context.Context, skip int, limit int) ([]model.DeviceAuth, error) {
+	ret := _m.Called(ctx, skip, limit)
+
+	var r0 []model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, int, int) []model.DeviceAuth); ok {
+		r0 = rf(ctx, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int) error); ok {
+		r1 = rf(ctx, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceAuth provides a mock function with given fields: ctx, id
+func (_m *DataStore) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) *model.DeviceAuth); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceAuths provides a mock function with given fields: ctx, skip, limit, filter
+func (_m *DataStore) GetDeviceAuths(ctx context.Context, skip int, limit int, filter store.Filter) ([]model.DeviceAuth, error) {
+	ret := _m.Called(ctx, skip, limit, filter)
+
+	var r0 []model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, int, int, store.Filter) []model.DeviceAuth); ok {
+		r0 = rf(ctx, skip, limit, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int, int, store.Filter) error); ok {
+		r1 = rf(ctx, skip, limit, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetDeviceAuthsByIdentityData provides a mock function with given fields: ctx, idata
+func (_m *DataStore) GetDeviceAuthsByIdentityData(ctx context.Context, idata string) ([]model.DeviceAuth, error) {
+	ret := _m.Called(ctx, idata)
+
+	var r0 []model.DeviceAuth
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.DeviceAuth); ok {
+		r0 = rf(ctx, idata)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DeviceAuth)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, idata)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetEnrollmentTokens provides a mock function with given fields: ctx
+func (_m *DataStore) GetEnrollmentTokens(ctx context.Context) ([]model.EnrollmentToken, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.EnrollmentToken
+	if rf, ok := ret.Get(0).(func(context.Context) []model.EnrollmentToken); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.EnrollmentToken)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetEvents provides a mock function with given fields: ctx, after, limit
+func (_m *DataStore) GetEvents(ctx context.Context, after int64, limit int) ([]model.Event, error) {
+	ret := _m.Called(ctx, after, limit)
+
+	var r0 []model.Event
+	if rf, ok := ret.Get(0).(func(context.Context, int64, int) []model.Event); ok {
+		r0 = rf(ctx, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Event)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, int64, int) error); ok {
+		r1 = rf(ctx, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetIdempotentRequest provides a mock function with given fields: ctx, key
+func (_m *DataStore) GetIdempotentRequest(ctx context.Context, key string) (*model.IdempotentRequest, error) {
+	ret := _m.Called(ctx, key)
+
+	var r0 *model.IdempotentRequest
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.IdempotentRequest); ok {
+		r0 = rf(ctx, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IdempotentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetIdentitySchema provides a mock function with given fields: ctx
+func (_m *DataStore) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.IdentitySchema
+	if rf, ok := ret.Get(0).(func(context.Context) *model.IdentitySchema); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.IdentitySchema)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLastEventID provides a mock function with given fields: ctx
+func (_m *DataStore) GetLastEventID(ctx context.Context) (int64, error) {
+	ret := _m.Called(ctx)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetManifestKeys provides a mock function with given fields: ctx
+func (_m *DataStore) GetManifestKeys(ctx context.Context) ([]model.ManifestKey, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.ManifestKey
+	if rf, ok := ret.Get(0).(func(context.Context) []model.ManifestKey); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.ManifestKey)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetNotes provides a mock function with given fields: ctx, authID
+func (_m *DataStore) GetNotes(ctx context.Context, authID model.AuthID) ([]model.Note, error) {
+	ret := _m.Called(ctx, authID)
+
+	var r0 []model.Note
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) []model.Note); ok {
+		r0 = rf(ctx, authID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Note)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, model.AuthID) error); ok {
+		r1 = rf(ctx, authID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPendingWebhookDeliveries provides a mock function with given fields: ctx, now
+func (_m *DataStore) GetPendingWebhookDeliveries(ctx context.Context, now time.Time) ([]model.WebhookDelivery, error) {
+	ret := _m.Called(ctx, now)
+
+	var r0 []model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) []model.WebhookDelivery); ok {
+		r0 = rf(ctx, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetQuota provides a mock function with given fields: ctx
+func (_m *DataStore) GetQuota(ctx context.Context) (*model.Quota, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.Quota
+	if rf, ok := ret.Get(0).(func(context.Context) *model.Quota); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Quota)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSettings provides a mock function with given fields: ctx
+func (_m *DataStore) GetSettings(ctx context.Context) (*model.TenantSettings, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *model.TenantSettings
+	if rf, ok := ret.Get(0).(func(context.Context) *model.TenantSettings); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.TenantSettings)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTenants provides a mock function with given fields: ctx
+func (_m *DataStore) GetTenants(ctx context.Context) ([]string, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(context.Context) []string); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTrustedCAs provides a mock function with given fields: ctx
+func (_m *DataStore) GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.TrustedCA
+	if rf, ok := ret.Get(0).(func(context.Context) []model.TrustedCA); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.TrustedCA)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhook provides a mock function with given fields: ctx, id
+func (_m *DataStore) GetWebhook(ctx context.Context, id string) (*model.Webhook, error) {
+	ret := _m.Called(ctx, id)
+
+	var r0 *model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context, string) *model.Webhook); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhookDeliveries provides a mock function with given fields: ctx, webhookID, skip, limit
+func (_m *DataStore) GetWebhookDeliveries(ctx context.Context, webhookID string, skip int, limit int) ([]model.WebhookDelivery, error) {
+	ret := _m.Called(ctx, webhookID, skip, limit)
+
+	var r0 []model.WebhookDelivery
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int) []model.WebhookDelivery); ok {
+		r0 = rf(ctx, webhookID, skip, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.WebhookDelivery)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, int) error); ok {
+		r1 = rf(ctx, webhookID, skip, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetWebhooks provides a mock function with given fields: ctx
+func (_m *DataStore) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []model.Webhook
+	if rf, ok := ret.Get(0).(func(context.Context) []model.Webhook); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Webhook)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InsertAllowlistEntries provides a mock function with given fields: ctx, entries
+func (_m *DataStore) InsertAllowlistEntries(ctx context.Context, entries []model.AllowlistEntry) error {
+	ret := _m.Called(ctx, entries)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []model.AllowlistEntry) error); ok {
+		r0 = rf(ctx, entries)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertApproval provides a mock function with given fields: ctx, approval
+func (_m *DataStore) InsertApproval(ctx context.Context, approval *model.Approval) error {
+	ret := _m.Called(ctx, approval)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Approval) error); ok {
+		r0 = rf(ctx, approval)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertBlocklistEntry provides a mock function with given fields: ctx, entry
+func (_m *DataStore) InsertBlocklistEntry(ctx context.Context, entry *model.BlocklistEntry) error {
+	ret := _m.Called(ctx, entry)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.BlocklistEntry) error); ok {
+		r0 = rf(ctx, entry)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertBlocklistHit provides a mock function with given fields: ctx, hit
+func (_m *DataStore) InsertBlocklistHit(ctx context.Context, hit *model.BlocklistHit) error {
+	ret := _m.Called(ctx, hit)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.BlocklistHit) error); ok {
+		r0 = rf(ctx, hit)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertDeviceAuth provides a mock function with given fields: ctx, dev
+func (_m *DataStore) InsertDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
+	ret := _m.Called(ctx, dev)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) error); ok {
+		r0 = rf(ctx, dev)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertEnrollmentToken provides a mock function with given fields: ctx, token
+func (_m *DataStore) InsertEnrollmentToken(ctx context.Context, token *model.EnrollmentToken) error {
+	ret := _m.Called(ctx, token)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.EnrollmentToken) error); ok {
+		r0 = rf(ctx, token)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertEvent provides a mock function with given fields: ctx, ev
+func (_m *DataStore) InsertEvent(ctx context.Context, ev *model.Event) error {
+	ret := _m.Called(ctx, ev)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Event) error); ok {
+		r0 = rf(ctx, ev)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertIdempotentRequest provides a mock function with given fields: ctx, req
+func (_m *DataStore) InsertIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error {
+	ret := _m.Called(ctx, req)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IdempotentRequest) error); ok {
+		r0 = rf(ctx, req)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertManifestKey provides a mock function with given fields: ctx, key
+func (_m *DataStore) InsertManifestKey(ctx context.Context, key *model.ManifestKey) error {
+	ret := _m.Called(ctx, key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.ManifestKey) error); ok {
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertManifestRecord provides a mock function with given fields: ctx, rec
+func (_m *DataStore) InsertManifestRecord(ctx context.Context, rec *model.ManifestRecord) error {
+	ret := _m.Called(ctx, rec)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.ManifestRecord) error); ok {
+		r0 = rf(ctx, rec)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertNote provides a mock function with given fields: ctx, note
+func (_m *DataStore) InsertNote(ctx context.Context, note *model.Note) error {
+	ret := _m.Called(ctx, note)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Note) error); ok {
+		r0 = rf(ctx, note)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertTrustedCA provides a mock function with given fields: ctx, ca
+func (_m *DataStore) InsertTrustedCA(ctx context.Context, ca *model.TrustedCA) error {
+	ret := _m.Called(ctx, ca)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TrustedCA) error); ok {
+		r0 = rf(ctx, ca)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertWebhook provides a mock function with given fields: ctx, hook
+func (_m *DataStore) InsertWebhook(ctx context.Context, hook *model.Webhook) error {
+	ret := _m.Called(ctx, hook)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Webhook) error); ok {
+		r0 = rf(ctx, hook)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// InsertWebhookDelivery provides a mock function with given fields: ctx, delivery
+func (_m *DataStore) InsertWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	ret := _m.Called(ctx, delivery)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.WebhookDelivery) error); ok {
+		r0 = rf(ctx, delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LockQuota provides a mock function with given fields: ctx, lock, expires, now
+func (_m *DataStore) LockQuota(ctx context.Context, lock string, expires time.Time, now time.Time) error {
+	ret := _m.Called(ctx, lock, expires, now)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time) error); ok {
+		r0 = rf(ctx, lock, expires, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MigrateTenant provides a mock function with given fields: ctx, version, tenant
+func (_m *DataStore) MigrateTenant(ctx context.Context, version string, tenant string) error {
+	ret := _m.Called(ctx, version, tenant)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, version, tenant)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PatchDeviceAuth provides a mock function with given fields: ctx, dev
+func (_m *DataStore) PatchDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
+	ret := _m.Called(ctx, dev)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) error); ok {
+		r0 = rf(ctx, dev)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PurgeDeletedDeviceAuths provides a mock function with given fields: ctx, before
+func (_m *DataStore) PurgeDeletedDeviceAuths(ctx context.Context, before time.Time) (int, error) {
+	ret := _m.Called(ctx, before)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, before)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, before)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PutDeviceAuth provides a mock function with given fields: ctx, dev
+func (_m *DataStore) PutDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
+	ret := _m.Called(ctx, dev)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) error); ok {
+		r0 = rf(ctx, dev)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PutIdentitySchema provides a mock function with given fields: ctx, schema
+func (_m *DataStore) PutIdentitySchema(ctx context.Context, schema *model.IdentitySchema) error {
+	ret := _m.Called(ctx, schema)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IdentitySchema) error); ok {
+		r0 = rf(ctx, schema)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PutQuota provides a mock function with given fields: ctx, quota
+func (_m *DataStore) PutQuota(ctx context.Context, quota *model.Quota) error {
+	ret := _m.Called(ctx, quota)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Quota) error); ok {
+		r0 = rf(ctx, quota)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// PutSettings provides a mock function with given fields: ctx, settings
+func (_m *DataStore) PutSettings(ctx context.Context, settings *model.TenantSettings) error {
+	ret := _m.Called(ctx, settings)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.TenantSettings) error); ok {
+		r0 = rf(ctx, settings)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ReleaseEnrollmentToken provides a mock function with given fields: ctx, id
+func (_m *DataStore) ReleaseEnrollmentToken(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RemoveDeviceAuthTags provides a mock function with given fields: ctx, ids, tags
+func (_m *DataStore) RemoveDeviceAuthTags(ctx context.Context, ids []model.AuthID, tags []string) (int, error) {
+	ret := _m.Called(ctx, ids, tags)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(context.Context, []model.AuthID, []string) int); ok {
+		r0 = rf(ctx, ids, tags)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []model.AuthID, []string) error); ok {
+		r1 = rf(ctx, ids, tags)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RenewIdempotentRequest provides a mock function with given fields: ctx, req, expires
+func (_m *DataStore) RenewIdempotentRequest(ctx context.Context, req *model.IdempotentRequest, expires time.Time) error {
+	ret := _m.Called(ctx, req, expires)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IdempotentRequest, time.Time) error); ok {
+		r0 = rf(ctx, req, expires)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RestoreDeviceAuth provides a mock function with given fields: ctx, id
+func (_m *DataStore) RestoreDeviceAuth(ctx context.Context, id model.AuthID) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RevokeEnrollmentToken provides a mock function with given fields: ctx, id
+func (_m *DataStore) RevokeEnrollmentToken(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SoftDeleteDeviceAuth provides a mock function with given fields: ctx, id, revision, ts
+func (_m *DataStore) SoftDeleteDeviceAuth(ctx context.Context, id model.AuthID, revision int, ts time.Time) error {
+	ret := _m.Called(ctx, id, revision, ts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.AuthID, int, time.Time) error); ok {
+		r0 = rf(ctx, id, revision, ts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SoftDeleteDeviceAuthByDevice provides a mock function with given fields: ctx, id, ts
+func (_m *DataStore) SoftDeleteDeviceAuthByDevice(ctx context.Context, id model.DeviceID, ts time.Time) error {
+	ret := _m.Called(ctx, id, ts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.DeviceID, time.Time) error); ok {
+		r0 = rf(ctx, id, ts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TakeOverIdempotentRequest provides a mock function with given fields: ctx, req, lease, expires
+func (_m *DataStore) TakeOverIdempotentRequest(ctx context.Context, req *model.IdempotentRequest, lease string, expires time.Time) error {
+	ret := _m.Called(ctx, req, lease, expires)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IdempotentRequest, string, time.Time) error); ok {
+		r0 = rf(ctx, req, lease, expires)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TakeRateLimitToken provides a mock function with given fields: ctx, key, limit, now
+func (_m *DataStore) TakeRateLimitToken(ctx context.Context, key string, limit model.RateLimit, now time.Time) (time.Duration, error) {
+	ret := _m.Called(ctx, key, limit, now)
+
+	var r0 time.Duration
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.RateLimit, time.Time) time.Duration); ok {
+		r0 = rf(ctx, key, limit, now)
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.RateLimit, time.Time) error); ok {
+		r1 = rf(ctx, key, limit, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UnlockQuota provides a mock function with given fields: ctx, lock
+func (_m *DataStore) UnlockQuota(ctx context.Context, lock string) error {
+	ret := _m.Called(ctx, lock)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, lock)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateDeviceAuth provides a mock function with given fields: ctx, dev
+func (_m *DataStore) UpdateDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
+	ret := _m.Called(ctx, dev)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.DeviceAuth) error); ok {
+		r0 = rf(ctx, dev)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateIdempotentRequest provides a mock function with given fields: ctx, req
+func (_m *DataStore) UpdateIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error {
+	ret := _m.Called(ctx, req)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.IdempotentRequest) error); ok {
+		r0 = rf(ctx, req)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateWebhookDelivery provides a mock function with given fields: ctx, delivery
+func (_m *DataStore) UpdateWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	ret := _m.Called(ctx, delivery)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *model.WebhookDelivery) error); ok {
+		r0 = rf(ctx, delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UseAllowlistEntry provides a mock function with given fields: ctx, id, authID, ts
+func (_m *DataStore) UseAllowlistEntry(ctx context.Context, id string, authID model.AuthID, ts time.Time) error {
+	ret := _m.Called(ctx, id, authID, ts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.AuthID, time.Time) error); ok {
+		r0 = rf(ctx, id, authID, ts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UseEnrollmentToken provides a mock function with given fields: ctx, hash, now
+func (_m *DataStore) UseEnrollmentToken(ctx context.Context, hash string, now time.Time) (*model.EnrollmentToken, error) {
+	ret := _m.Called(ctx, hash, now)
+
+	var r0 *model.EnrollmentToken
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) *model.EnrollmentToken); ok {
+		r0 = rf(ctx, hash, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.EnrollmentToken)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, hash, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // WithAutomigrate provides a mock function with given fields:
@@ -18,6 +18,8 @@ import (
 	"context"
 	"crypto/tls"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/mendersoftware/go-lib-micro/identity"
@@ -36,17 +38,84 @@ const (
 	DbVersion           = "1.1.0"
 	DbName              = "deviceadm"
 	DbDevicesColl       = "devices"
+	DbWebhooksColl      = "webhooks"
+	DbDeliveriesColl    = "webhook_deliveries"
+	DbEventsColl        = "events"
+	DbCountersColl      = "counters"
+	DbSettingsColl      = "settings"
+	DbApprovalsColl     = "approvals"
+	DbBlocklistColl     = "blocklist"
+	DbBlocklistHitsColl = "blocklist_hits"
+	DbAllowlistColl     = "allowlist"
+	DbEnrollTokensColl  = "enrollment_tokens"
+	DbTrustedCAsColl    = "trusted_cas"
+	DbManifestKeysColl  = "manifest_keys"
+	DbManifestsColl     = "manifests"
+	DbRateLimitsColl    = "rate_limits"
+	DbNotesColl         = "notes"
+	DbIdempotencyColl   = "idempotency_keys"
 	dbDeviceIdIndex     = "id"
 	dbDeviceIdIndexName = "uniqueDeviceIdIndex"
+	dbEventIdIndex      = "id"
+
+	dbApprovalIndexName = "uniqueApprovalIndex"
+
+	dbAllowlistAttrsIndex = "attribute_pairs"
+
+	dbEnrollTokenIndexName = "uniqueEnrollmentTokenIndex"
+
+	dbTrustedCAIndexName = "uniqueTrustedCAIndex"
+
+	dbManifestKeyIndexName = "uniqueManifestKeyIndex"
+	dbManifestIndexName    = "uniqueManifestIndex"
+
+	dbRateLimitExpiryIndexName = "rateLimitExpiryIndex"
+
+	dbIdempotencyExpiryIndexName = "idempotencyExpiryIndex"
+
+	// IDs of documents in settings collection
+	dbSettingsId = "settings"
+	dbQuotaId    = "quota"
+	dbSchemaId   = "identity_schema"
+
+	// default size of the capped event log collection
+	DefaultEventsCollSize = 10 * 1024 * 1024
+
+	// mongo error code returned when creating an existing collection
+	mgoErrCodeNamespaceExists = 48
 )
 
 type DataStoreMongo struct {
-	session     *mgo.Session
-	automigrate bool
+	session        *mgo.Session
+	automigrate    bool
+	eventsCollSize int
+	eventsColls    *dbSet
+}
+
+// dbSet is a set of database names safe for concurrent use
+type dbSet struct {
+	mtx sync.Mutex
+	dbs map[string]bool
+}
+
+func (s *dbSet) has(db string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.dbs[db]
+}
+
+func (s *dbSet) add(db string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.dbs[db] = true
 }
 
 func NewDataStoreMongoWithSession(s *mgo.Session) *DataStoreMongo {
-	return &DataStoreMongo{session: s}
+	return &DataStoreMongo{
+		session:        s,
+		eventsCollSize: DefaultEventsCollSize,
+		eventsColls:    &dbSet{dbs: map[string]bool{}},
+	}
 }
 
 type DataStoreMongoConfig struct {
@@ -60,6 +129,9 @@ type DataStoreMongoConfig struct {
 	// Overwrites credentials provided in connection string if provided
 	Username string
 	Password string
+
+	// size of the capped event log collection in bytes
+	EventsCollSize int
 }
 
 func NewDataStoreMongo(config DataStoreMongoConfig) (*DataStoreMongo, error) {
@@ -106,7 +178,11 @@ func NewDataStoreMongo(config DataStoreMongoConfig) (*DataStoreMongo, error) {
 		J: true,
 	})
 
-	return NewDataStoreMongoWithSession(masterSession), nil
+	db := NewDataStoreMongoWithSession(masterSession)
+	if config.EventsCollSize > 0 {
+		db.eventsCollSize = config.EventsCollSize
+	}
+	return db, nil
 }
 
 func (db *DataStoreMongo) GetDeviceAuths(ctx context.Context, skip, limit int, filter store.Filter) ([]model.DeviceAuth, error) {
@@ -115,12 +191,9 @@ func (db *DataStoreMongo) GetDeviceAuths(ctx context.Context, skip, limit int, f
 	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
 	res := []model.DeviceAuth{}
 
-	var dbFilter = &model.DeviceAuth{}
-	if filter.Status != "" {
-		dbFilter.Status = filter.Status
-	}
-	if filter.DeviceID != "" {
-		dbFilter.DeviceId = filter.DeviceID
+	dbFilter := deviceAuthsFilter(filter)
+	if filter.After != "" {
+		dbFilter["id"] = bson.M{"$gt": filter.After}
 	}
 
 	err := c.Find(dbFilter).Sort("id").Skip(skip).Limit(limit).All(&res)
@@ -131,12 +204,40 @@ func (db *DataStoreMongo) GetDeviceAuths(ctx context.Context, skip, limit int, f
 	return res, nil
 }
 
+func (db *DataStoreMongo) CountDeviceAuths(ctx context.Context, filter store.Filter) (int, error) {
+	s := db.session.Copy()
+	defer s.Close()
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	n, err := c.Find(deviceAuthsFilter(filter)).Count()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count devices")
+	}
+	return n, nil
+}
+
+// deviceAuthsFilter translates 'filter' to a query, except for
+// filter.After
+func deviceAuthsFilter(filter store.Filter) bson.M {
+	dbFilter := notDeleted(bson.M{})
+	if filter.Status != "" {
+		dbFilter["status"] = filter.Status
+	}
+	if filter.DeviceID != "" {
+		dbFilter["deviceid"] = filter.DeviceID
+	}
+	if len(filter.Tags) != 0 {
+		dbFilter["tags"] = bson.M{"$all": filter.Tags}
+	}
+	return dbFilter
+}
+
 func (db *DataStoreMongo) GetDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error) {
 	s := db.session.Copy()
 	defer s.Close()
 	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
 
-	filter := bson.M{"id": id}
+	filter := notDeleted(bson.M{"id": id})
 	res := model.DeviceAuth{}
 
 	err := c.Find(filter).One(&res)
@@ -162,12 +263,14 @@ func (db *DataStoreMongo) DeleteDeviceAuth(ctx context.Context, id model.AuthID)
 
 	switch err {
 	case nil:
-		return nil
+		break
 	case mgo.ErrNotFound:
 		return store.ErrNotFound
 	default:
 		return errors.Wrap(err, "failed to delete device")
 	}
+
+	return db.deleteNotes(ctx, s, []model.AuthID{id})
 }
 
 func (db *DataStoreMongo) DeleteDeviceAuthByDevice(ctx context.Context, id model.DeviceID) error {
@@ -175,17 +278,181 @@ func (db *DataStoreMongo) DeleteDeviceAuthByDevice(ctx context.Context, id model
 	defer s.Close()
 
 	filter := model.DeviceAuth{DeviceId: id}
-	ci, err := s.DB(ctx_store.DbFromContext(ctx, DbName)).
-		C(DbDevicesColl).RemoveAll(filter)
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	var ids []model.AuthID
+	err := c.Find(filter).Distinct("id", &ids)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch auth sets of device")
+	}
+
+	ci, err := c.RemoveAll(filter)
 
 	switch {
 	case err != nil:
-		return nil
+		return errors.Wrap(err, "failed to delete auth sets of device")
 	case ci != nil && ci.Removed == 0:
 		return store.ErrNotFound
 	default:
+		return db.deleteNotes(ctx, s, ids)
+	}
+}
+
+// notDeleted restricts auth set query `filter` to auth sets which are not
+// in the trash
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+func (db *DataStoreMongo) SoftDeleteDeviceAuth(ctx context.Context, id model.AuthID, revision int, ts time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	return updateDeviceAuthRevision(c, id, revision, bson.M{
+		"$set": bson.M{"deleted_at": ts},
+		"$inc": bson.M{"revision": 1},
+	})
+}
+
+func (db *DataStoreMongo) SoftDeleteDeviceAuthByDevice(ctx context.Context, id model.DeviceID, ts time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	info, err := c.UpdateAll(notDeleted(bson.M{"deviceid": id}), bson.M{
+		"$set": bson.M{"deleted_at": ts},
+		"$inc": bson.M{"revision": 1},
+	})
+	switch {
+	case err != nil:
 		return errors.Wrap(err, "failed to delete device")
+	case info.Matched == 0:
+		return store.ErrNotFound
+	default:
+		return nil
+	}
+}
+
+func (db *DataStoreMongo) GetDeletedDeviceAuths(ctx context.Context, skip, limit int) ([]model.DeviceAuth, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+	res := []model.DeviceAuth{}
+
+	err := c.Find(bson.M{"deleted_at": bson.M{"$exists": true}}).
+		Sort("-deleted_at", "id").Skip(skip).Limit(limit).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch deleted devices")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) GetDeletedDeviceAuth(ctx context.Context, id model.AuthID) (*model.DeviceAuth, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+	res := model.DeviceAuth{}
+
+	err := c.Find(bson.M{"id": id, "deleted_at": bson.M{"$exists": true}}).One(&res)
+	switch err {
+	case nil:
+		return &res, nil
+	case mgo.ErrNotFound:
+		return nil, store.ErrNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to fetch deleted device")
+	}
+}
+
+func (db *DataStoreMongo) RestoreDeviceAuth(ctx context.Context, id model.AuthID) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	err := c.Update(bson.M{"id": id, "deleted_at": bson.M{"$exists": true}}, bson.M{
+		"$unset": bson.M{"deleted_at": ""},
+		"$inc":   bson.M{"revision": 1},
+	})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to restore device")
+	}
+}
+
+func (db *DataStoreMongo) PurgeDeletedDeviceAuths(ctx context.Context, before time.Time) (int, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	filter := bson.M{"deleted_at": bson.M{"$lt": before}}
+
+	var ids []model.AuthID
+	err := c.Find(filter).Distinct("id", &ids)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch deleted devices")
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// auth sets deleted meanwhile are left for the next purge
+	info, err := c.RemoveAll(bson.M{
+		"id":         bson.M{"$in": ids},
+		"deleted_at": bson.M{"$lt": before},
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge deleted devices")
+	}
+
+	// notes are kept for auth sets which were taken out of the trash
+	// meanwhile
+	var left []model.AuthID
+	err = c.Find(bson.M{"id": bson.M{"$in": ids}}).Distinct("id", &left)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to fetch purged devices")
+	}
+	kept := map[model.AuthID]bool{}
+	for _, id := range left {
+		kept[id] = true
+	}
+	var purged []model.AuthID
+	for _, id := range ids {
+		if !kept[id] {
+			purged = append(purged, id)
+		}
+	}
+
+	if err := db.deleteNotes(ctx, s, purged); err != nil {
+		return 0, err
+	}
+	return info.Removed, nil
+}
+
+// deleteNotes removes notes of given auth sets
+func (db *DataStoreMongo) deleteNotes(ctx context.Context, s *mgo.Session, ids []model.AuthID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := s.DB(ctx_store.DbFromContext(ctx, DbName)).
+		C(DbNotesColl).RemoveAll(bson.M{"auth_id": bson.M{"$in": ids}})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete notes")
 	}
+	return nil
 }
 
 // produce a DeviceAuth wrapper suitable for passing in an Upsert() as
@@ -209,7 +476,9 @@ func genDeviceAuthUpdate(dev *model.DeviceAuth) *model.DeviceAuth {
 		updev.DeviceIdentity = dev.DeviceIdentity
 	}
 
-	// TODO: should attributes be merged?
+	// attributes are replaced as a whole, a submission carries the
+	// complete decoded identity; this also drops corrections made with
+	// PatchDeviceAuth, which last only until the device submits again
 	if len(dev.Attributes) != 0 {
 		updev.Attributes = dev.Attributes
 	}
@@ -218,6 +487,14 @@ func genDeviceAuthUpdate(dev *model.DeviceAuth) *model.DeviceAuth {
 		updev.RequestTime = dev.RequestTime
 	}
 
+	if dev.CertificateInfo != nil {
+		updev.CertificateInfo = dev.CertificateInfo
+	}
+
+	if dev.SchemaViolation {
+		updev.SchemaViolation = true
+	}
+
 	return &updev
 }
 
@@ -234,8 +511,21 @@ func (db *DataStoreMongo) PutDeviceAuth(ctx context.Context, dev *model.DeviceAu
 
 	filter := bson.M{"id": dev.ID}
 
-	// use $set operator so that fields values are replaced
-	data := bson.M{"$set": genDeviceAuthUpdate(dev)}
+	// use $set operator so that fields values are replaced; storing an
+	// auth set deleted meanwhile, e.g. resubmitted by devauth, takes it
+	// out of the trash
+	unset := bson.M{"deleted_at": ""}
+	data := bson.M{
+		"$set":   genDeviceAuthUpdate(dev),
+		"$unset": unset,
+		"$inc":   bson.M{"revision": 1},
+	}
+
+	// schema violation is re-evaluated whenever identity attributes are
+	// submitted
+	if len(dev.Attributes) != 0 && !dev.SchemaViolation {
+		unset["schema_violation"] = ""
+	}
 
 	// does insert or update
 	_, err := c.Upsert(filter, data)
@@ -255,18 +545,116 @@ func (db *DataStoreMongo) UpdateDeviceAuth(ctx context.Context, dev *model.Devic
 
 	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
 
-	data := bson.M{"$set": genDeviceAuthUpdate(dev)}
-	filter := bson.M{"id": dev.ID}
+	data := bson.M{
+		"$set": genDeviceAuthUpdate(dev),
+		"$inc": bson.M{"revision": 1},
+	}
+	return updateDeviceAuthRevision(c, dev.ID, dev.Revision, data)
+}
+
+func (db *DataStoreMongo) PatchDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	if err := db.EnsureIndexes(ctx, s); err != nil {
+		return err
+	}
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	set := bson.M{}
+	unset := bson.M{}
+	if len(dev.Attributes) != 0 {
+		set["attributes"] = dev.Attributes
+	} else {
+		unset["attributes"] = ""
+	}
+	if len(dev.Tags) != 0 {
+		set["tags"] = dev.Tags
+	} else {
+		unset["tags"] = ""
+	}
+	if dev.ExpiresAt != nil {
+		set["expires_ts"] = dev.ExpiresAt
+	} else {
+		unset["expires_ts"] = ""
+	}
+
+	data := bson.M{
+		"$inc": bson.M{"revision": 1},
+	}
+	if len(set) != 0 {
+		data["$set"] = set
+	}
+	if len(unset) != 0 {
+		data["$unset"] = unset
+	}
+	return updateDeviceAuthRevision(c, dev.ID, dev.Revision, data)
+}
+
+// updateDeviceAuthRevision applies 'data' to auth set 'id' if it is still
+// at 'revision', or unconditionally for store.AnyRevision
+func updateDeviceAuthRevision(c *mgo.Collection, id model.AuthID, revision int, data bson.M) error {
+	filter := notDeleted(bson.M{"id": id})
+	switch revision {
+	case store.AnyRevision:
+		break
+	case 0:
+		// stored before revisions were introduced
+		filter["revision"] = bson.M{"$in": []interface{}{nil, 0}}
+	default:
+		filter["revision"] = revision
+	}
 
 	err := c.Update(filter, data)
 	switch err {
 	case nil:
 		return nil
 	case mgo.ErrNotFound:
-		return store.ErrNotFound
+		break
 	default:
 		return errors.Wrap(err, "failed to update auth set")
 	}
+
+	if revision == store.AnyRevision {
+		return store.ErrNotFound
+	}
+
+	// tell a missing auth set from a modified one
+	n, err := c.Find(notDeleted(bson.M{"id": id})).Count()
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "failed to update auth set")
+	case n == 0:
+		return store.ErrNotFound
+	default:
+		return store.ErrModified
+	}
+}
+
+func (db *DataStoreMongo) AddDeviceAuthTags(ctx context.Context, ids []model.AuthID, tags []string) (int, error) {
+	return db.updateDeviceAuthTags(ctx, ids,
+		bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}})
+}
+
+func (db *DataStoreMongo) RemoveDeviceAuthTags(ctx context.Context, ids []model.AuthID, tags []string) (int, error) {
+	return db.updateDeviceAuthTags(ctx, ids,
+		bson.M{"$pullAll": bson.M{"tags": tags}})
+}
+
+func (db *DataStoreMongo) updateDeviceAuthTags(ctx context.Context, ids []model.AuthID, update bson.M) (int, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	update["$inc"] = bson.M{"revision": 1}
+
+	info, err := c.UpdateAll(notDeleted(bson.M{"id": bson.M{"$in": ids}}), update)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to update tags")
+	}
+	return info.Matched, nil
 }
 
 func (db *DataStoreMongo) InsertDeviceAuth(ctx context.Context, dev *model.DeviceAuth) error {
@@ -283,6 +671,8 @@ func (db *DataStoreMongo) InsertDeviceAuth(ctx context.Context, dev *model.Devic
 
 	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
 
+	dev.Revision = 1
+
 	err := c.Insert(dev)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert device")
@@ -354,10 +744,75 @@ func (db *DataStoreMongo) Migrate(ctx context.Context, version string) error {
 	return nil
 }
 
+// Purge removes auth sets deleted before 'before' for good, in the
+// databases of all tenants
+func (db *DataStoreMongo) Purge(ctx context.Context, before time.Time) error {
+	l := log.FromContext(ctx)
+
+	dbs, err := migrate.GetTenantDbs(db.session, ctx_store.IsTenantDb(DbName))
+	if err != nil {
+		return errors.Wrap(err, "failed go retrieve tenant DBs")
+	}
+
+	if len(dbs) == 0 {
+		dbs = []string{DbName}
+	}
+
+	for _, d := range dbs {
+		l.Infof("purging %s", d)
+
+		tenant := ctx_store.TenantFromDbName(d, DbName)
+
+		if err := db.PurgeTenant(ctx, before, tenant); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetTenants lists tenants having a database, in single tenant setups the
+// only tenant is ""
+func (db *DataStoreMongo) GetTenants(ctx context.Context) ([]string, error) {
+	dbs, err := migrate.GetTenantDbs(db.session, ctx_store.IsTenantDb(DbName))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed go retrieve tenant DBs")
+	}
+
+	if len(dbs) == 0 {
+		return []string{""}, nil
+	}
+
+	tenants := make([]string, len(dbs))
+	for i, d := range dbs {
+		tenants[i] = ctx_store.TenantFromDbName(d, DbName)
+	}
+	return tenants, nil
+}
+
+// PurgeTenant removes auth sets of tenant 'tenant' deleted before 'before'
+// for good
+func (db *DataStoreMongo) PurgeTenant(ctx context.Context, before time.Time, tenant string) error {
+	tenantCtx := identity.WithContext(ctx, &identity.Identity{
+		Tenant: tenant,
+	})
+
+	n, err := db.PurgeDeletedDeviceAuths(tenantCtx, before)
+	if err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Infof("removed %d auth sets deleted before %s",
+		n, before.Format(time.RFC3339))
+	return nil
+}
+
 func (db *DataStoreMongo) WithAutomigrate() store.DataStore {
 	return &DataStoreMongo{
-		session:     db.session,
-		automigrate: true,
+		session:        db.session,
+		automigrate:    true,
+		eventsCollSize: db.eventsCollSize,
+		eventsColls:    db.eventsColls,
 	}
 }
 
@@ -380,11 +835,1254 @@ func (db *DataStoreMongo) GetDeviceAuthsByIdentityData(ctx context.Context, idat
 
 	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
 
-	filter := &model.DeviceAuth{
-		DeviceIdentity: idata,
-	}
+	filter := notDeleted(bson.M{"deviceidentity": idata})
 	res := []model.DeviceAuth{}
 
 	err := c.Find(filter).All(&res)
 	return res, errors.Wrap(err, "failed to fetch device")
 }
+
+func (db *DataStoreMongo) InsertWebhook(ctx context.Context, hook *model.Webhook) error {
+	hook.ID = bson.NewObjectId().Hex()
+
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbWebhooksColl)
+
+	err := c.Insert(hook)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert webhook")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbWebhooksColl)
+	res := []model.Webhook{}
+
+	err := c.Find(nil).Sort("id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch webhooks")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) GetWebhook(ctx context.Context, id string) (*model.Webhook, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbWebhooksColl)
+	res := model.Webhook{}
+
+	err := c.Find(bson.M{"id": id}).One(&res)
+	switch err {
+	case nil:
+		return &res, nil
+	case mgo.ErrNotFound:
+		return nil, store.ErrNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to fetch webhook")
+	}
+}
+
+func (db *DataStoreMongo) DeleteWebhook(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	database := s.DB(ctx_store.DbFromContext(ctx, DbName))
+
+	err := database.C(DbWebhooksColl).Remove(bson.M{"id": id})
+	switch err {
+	case nil:
+		break
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete webhook")
+	}
+
+	_, err = database.C(DbDeliveriesColl).RemoveAll(bson.M{"webhook_id": id})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete webhook deliveries")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) InsertWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	delivery.ID = bson.NewObjectId().Hex()
+
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDeliveriesColl)
+
+	err := c.Insert(delivery)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert webhook delivery")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) UpdateWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDeliveriesColl)
+
+	data := bson.M{"$set": bson.M{
+		"status":      delivery.Status,
+		"attempts":    delivery.Attempts,
+		"status_code": delivery.StatusCode,
+		"error":       delivery.Error,
+		"updated_ts":  delivery.Updated,
+		"payload":     delivery.Payload,
+	}}
+
+	err := c.Update(bson.M{"id": delivery.ID}, data)
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to update webhook delivery")
+	}
+}
+
+func (db *DataStoreMongo) GetWebhookDeliveries(ctx context.Context, webhookID string, skip, limit int) ([]model.WebhookDelivery, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDeliveriesColl)
+	res := []model.WebhookDelivery{}
+
+	err := c.Find(bson.M{"webhook_id": webhookID}).
+		Sort("-created_ts", "-id").Skip(skip).Limit(limit).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch webhook deliveries")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) GetPendingWebhookDeliveries(ctx context.Context, now time.Time) ([]model.WebhookDelivery, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDeliveriesColl)
+	res := []model.WebhookDelivery{}
+
+	err := c.Find(bson.M{
+		"status": model.WebhookDeliveryPending,
+		"$or": []bson.M{
+			{"claim_expires_ts": bson.M{"$exists": false}},
+			{"claim_expires_ts": bson.M{"$lte": now}},
+		},
+	}).Sort("created_ts", "id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch webhook deliveries")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) ClaimWebhookDelivery(ctx context.Context, delivery *model.WebhookDelivery, claim string, expires, now time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDeliveriesColl)
+
+	err := c.Update(
+		bson.M{
+			"id":     delivery.ID,
+			"status": model.WebhookDeliveryPending,
+			"$or": []bson.M{
+				{"claim": claim},
+				{"claim_expires_ts": bson.M{"$exists": false}},
+				{"claim_expires_ts": bson.M{"$lte": now}},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"claim":            claim,
+			"claim_expires_ts": expires,
+		}})
+	switch err {
+	case nil:
+		delivery.Claim = claim
+		delivery.ClaimExpires = &expires
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrModified
+	default:
+		return errors.Wrap(err, "failed to claim webhook delivery")
+	}
+}
+
+// ensureEventsColl creates the capped event log collection if needed; this
+// is done once per tenant database and service instance
+func (db *DataStoreMongo) ensureEventsColl(ctx context.Context, s *mgo.Session) error {
+	name := ctx_store.DbFromContext(ctx, DbName)
+	if db.eventsColls.has(name) {
+		return nil
+	}
+	c := s.DB(name).C(DbEventsColl)
+
+	err := c.Create(&mgo.CollectionInfo{
+		Capped:   true,
+		MaxBytes: db.eventsCollSize,
+	})
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == mgoErrCodeNamespaceExists {
+		err = nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to create event log")
+	}
+
+	if err := c.EnsureIndexKey(dbEventIdIndex); err != nil {
+		return err
+	}
+	db.eventsColls.add(name)
+	return nil
+}
+
+func (db *DataStoreMongo) InsertEvent(ctx context.Context, ev *model.Event) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	if err := db.ensureEventsColl(ctx, s); err != nil {
+		return err
+	}
+
+	database := s.DB(ctx_store.DbFromContext(ctx, DbName))
+
+	// sequence numbers come from a counter shared by all service
+	// instances, so that streams can be resumed on any of them; as the
+	// number is taken before the insert, concurrent inserts may complete
+	// out of order, which readers must account for (see
+	// devadm.WatchEvents)
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	_, err := database.C(DbCountersColl).FindId(DbEventsColl).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &counter)
+	if err != nil {
+		return errors.Wrap(err, "failed to obtain event sequence number")
+	}
+	ev.ID = counter.Seq
+
+	err = database.C(DbEventsColl).Insert(ev)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert event")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetEvents(ctx context.Context, after int64, limit int) ([]model.Event, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	// nothing to read before the event log is created by an insert
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbEventsColl)
+	res := []model.Event{}
+
+	err := c.Find(bson.M{"id": bson.M{"$gt": after}}).
+		Sort("id").Limit(limit).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch events")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) GetLastEventID(ctx context.Context) (int64, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbCountersColl)
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := c.FindId(DbEventsColl).One(&counter)
+	switch err {
+	case nil:
+		return counter.Seq, nil
+	case mgo.ErrNotFound:
+		return 0, nil
+	default:
+		return 0, errors.Wrap(err, "failed to fetch event sequence number")
+	}
+}
+
+func (db *DataStoreMongo) GetSettings(ctx context.Context) (*model.TenantSettings, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+	res := model.TenantSettings{}
+
+	err := c.FindId(dbSettingsId).One(&res)
+	switch err {
+	case nil, mgo.ErrNotFound:
+		return &res, nil
+	default:
+		return nil, errors.Wrap(err, "failed to fetch settings")
+	}
+}
+
+func (db *DataStoreMongo) PutSettings(ctx context.Context, settings *model.TenantSettings) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+
+	_, err := c.UpsertId(dbSettingsId, bson.M{"$set": settings})
+	if err != nil {
+		return errors.Wrap(err, "failed to store settings")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) InsertApproval(ctx context.Context, approval *model.Approval) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbApprovalsColl)
+
+	// a user can approve an auth set only once
+	err := c.EnsureIndex(mgo.Index{
+		Key:    []string{"auth_id", "approver"},
+		Unique: true,
+		Name:   dbApprovalIndexName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create approvals index")
+	}
+
+	approval.ID = bson.NewObjectId().Hex()
+
+	err = c.Insert(approval)
+	switch {
+	case err == nil:
+		return nil
+	case mgo.IsDup(err):
+		return store.ErrDuplicate
+	default:
+		return errors.Wrap(err, "failed to insert approval")
+	}
+}
+
+func (db *DataStoreMongo) GetApprovals(ctx context.Context, authID model.AuthID) ([]model.Approval, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbApprovalsColl)
+	res := []model.Approval{}
+
+	filter := bson.M{}
+	if authID != "" {
+		filter["auth_id"] = authID
+	}
+
+	err := c.Find(filter).Sort("auth_id", "id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch approvals")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) GetApproval(ctx context.Context, id string) (*model.Approval, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbApprovalsColl)
+	res := model.Approval{}
+
+	err := c.Find(bson.M{"id": id}).One(&res)
+	switch err {
+	case nil:
+		return &res, nil
+	case mgo.ErrNotFound:
+		return nil, store.ErrNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to fetch approval")
+	}
+}
+
+func (db *DataStoreMongo) DeleteApproval(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbApprovalsColl)
+
+	err := c.Remove(bson.M{"id": id})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete approval")
+	}
+}
+
+func (db *DataStoreMongo) DeleteApprovalsByAuthSet(ctx context.Context, authID model.AuthID) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbApprovalsColl)
+
+	_, err := c.RemoveAll(bson.M{"auth_id": authID})
+	if err != nil {
+		return errors.Wrap(err, "failed to delete approvals")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) InsertNote(ctx context.Context, note *model.Note) error {
+	note.ID = bson.NewObjectId().Hex()
+
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbNotesColl)
+
+	err := c.Insert(note)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert note")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetNotes(ctx context.Context, authID model.AuthID) ([]model.Note, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbNotesColl)
+	res := []model.Note{}
+
+	err := c.Find(bson.M{"auth_id": authID}).Sort("created_ts", "id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch notes")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) InsertBlocklistEntry(ctx context.Context, entry *model.BlocklistEntry) error {
+	entry.ID = bson.NewObjectId().Hex()
+
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbBlocklistColl)
+
+	err := c.Insert(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert blocklist entry")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetBlocklist(ctx context.Context) ([]model.BlocklistEntry, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbBlocklistColl)
+	res := []model.BlocklistEntry{}
+
+	err := c.Find(nil).Sort("id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch blocklist")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) DeleteBlocklistEntry(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbBlocklistColl)
+
+	err := c.Remove(bson.M{"id": id})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete blocklist entry")
+	}
+}
+
+func (db *DataStoreMongo) InsertBlocklistHit(ctx context.Context, hit *model.BlocklistHit) error {
+	hit.ID = bson.NewObjectId().Hex()
+
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbBlocklistHitsColl)
+
+	err := c.Insert(hit)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert blocklist hit")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetBlocklistHits(ctx context.Context, skip, limit int) ([]model.BlocklistHit, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbBlocklistHitsColl)
+	res := []model.BlocklistHit{}
+
+	err := c.Find(nil).Sort("-created_ts", "-id").Skip(skip).Limit(limit).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch blocklist hits")
+	}
+
+	return res, nil
+}
+
+// allowlistEntryDoc is the stored form of an allowlist entry; identity
+// attributes are duplicated as an array of encoded key/value pairs, so
+// that entries with a subset of device's attributes can be queried for
+type allowlistEntryDoc struct {
+	model.AllowlistEntry `bson:",inline"`
+
+	AttributePairs []string `bson:"attribute_pairs"`
+}
+
+func attributePairs(attrs model.DeviceAuthAttributes) []string {
+	pairs := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		pairs = append(pairs, strconv.Quote(k)+":"+strconv.Quote(v))
+	}
+	return pairs
+}
+
+func (db *DataStoreMongo) InsertAllowlistEntries(ctx context.Context, entries []model.AllowlistEntry) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbAllowlistColl)
+
+	err := c.EnsureIndex(mgo.Index{
+		Key: []string{dbAllowlistAttrsIndex},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create allowlist index")
+	}
+
+	docs := make([]interface{}, len(entries))
+	for i := range entries {
+		entries[i].ID = bson.NewObjectId().Hex()
+		docs[i] = allowlistEntryDoc{
+			AllowlistEntry: entries[i],
+			AttributePairs: attributePairs(entries[i].Attributes),
+		}
+	}
+
+	err = c.Insert(docs...)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert allowlist entries")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetAllowlist(ctx context.Context, batch string, skip, limit int) ([]model.AllowlistEntry, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbAllowlistColl)
+	res := []model.AllowlistEntry{}
+
+	filter := bson.M{}
+	if batch != "" {
+		filter["batch"] = batch
+	}
+
+	err := c.Find(filter).Sort("id").Skip(skip).Limit(limit).All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch allowlist")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) DeleteAllowlistEntry(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbAllowlistColl)
+
+	err := c.Remove(bson.M{"id": id})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete allowlist entry")
+	}
+}
+
+func (db *DataStoreMongo) FindAllowlistEntries(ctx context.Context, attrs model.DeviceAuthAttributes, authID model.AuthID) ([]model.AllowlistEntry, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbAllowlistColl)
+	res := []model.AllowlistEntry{}
+
+	pairs := attributePairs(attrs)
+	filter := bson.M{
+		// at least one attribute matches...
+		"attribute_pairs": bson.M{"$in": pairs},
+		// ...and none of them differs
+		"$nor": []bson.M{
+			{"attribute_pairs": bson.M{
+				"$elemMatch": bson.M{"$nin": pairs}},
+			},
+		},
+		"$or": []bson.M{
+			{"single_use": false},
+			{"used_by": bson.M{"$exists": false}},
+			{"used_by": authID},
+		},
+	}
+
+	err := c.Find(filter).Sort("id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch allowlist entries")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) UseAllowlistEntry(ctx context.Context, id string, authID model.AuthID, ts time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbAllowlistColl)
+
+	filter := bson.M{
+		"id": id,
+		"$or": []bson.M{
+			{"used_by": bson.M{"$exists": false}},
+			{"used_by": authID},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"used_by": authID,
+			"used_ts": ts,
+		},
+	}
+
+	err := c.Update(filter, update)
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to update allowlist entry")
+	}
+}
+
+func (db *DataStoreMongo) InsertEnrollmentToken(ctx context.Context, token *model.EnrollmentToken) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbEnrollTokensColl)
+
+	err := c.EnsureIndex(mgo.Index{
+		Key:    []string{"hash"},
+		Unique: true,
+		Name:   dbEnrollTokenIndexName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create enrollment tokens index")
+	}
+
+	token.ID = bson.NewObjectId().Hex()
+
+	err = c.Insert(token)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert enrollment token")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetEnrollmentTokens(ctx context.Context) ([]model.EnrollmentToken, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbEnrollTokensColl)
+	res := []model.EnrollmentToken{}
+
+	err := c.Find(nil).Sort("id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch enrollment tokens")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) RevokeEnrollmentToken(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbEnrollTokensColl)
+
+	err := c.Update(bson.M{"id": id}, bson.M{"$set": bson.M{"revoked": true}})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to revoke enrollment token")
+	}
+}
+
+func (db *DataStoreMongo) UseEnrollmentToken(ctx context.Context, hash string, now time.Time) (*model.EnrollmentToken, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbEnrollTokensColl)
+	res := model.EnrollmentToken{}
+
+	filter := bson.M{
+		"hash":      hash,
+		"revoked":   false,
+		"uses_left": bson.M{"$gt": 0},
+		"$or": []bson.M{
+			{"expires_ts": bson.M{"$exists": false}},
+			{"expires_ts": bson.M{"$gt": now}},
+		},
+	}
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"uses_left": -1}},
+		ReturnNew: true,
+	}
+
+	_, err := c.Find(filter).Apply(change, &res)
+	switch err {
+	case nil:
+		return &res, nil
+	case mgo.ErrNotFound:
+		return nil, store.ErrNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to use enrollment token")
+	}
+}
+
+func (db *DataStoreMongo) ReleaseEnrollmentToken(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbEnrollTokensColl)
+
+	err := c.Update(bson.M{"id": id}, bson.M{"$inc": bson.M{"uses_left": 1}})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to release enrollment token")
+	}
+}
+
+func (db *DataStoreMongo) InsertTrustedCA(ctx context.Context, ca *model.TrustedCA) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbTrustedCAsColl)
+
+	err := c.EnsureIndex(mgo.Index{
+		Key:    []string{"fingerprint"},
+		Unique: true,
+		Name:   dbTrustedCAIndexName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create trusted CAs index")
+	}
+
+	ca.ID = bson.NewObjectId().Hex()
+
+	err = c.Insert(ca)
+	switch {
+	case err == nil:
+		return nil
+	case mgo.IsDup(err):
+		return store.ErrDuplicate
+	default:
+		return errors.Wrap(err, "failed to insert trusted CA")
+	}
+}
+
+func (db *DataStoreMongo) GetTrustedCAs(ctx context.Context) ([]model.TrustedCA, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbTrustedCAsColl)
+	res := []model.TrustedCA{}
+
+	err := c.Find(nil).Sort("id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch trusted CAs")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) DeleteTrustedCA(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbTrustedCAsColl)
+
+	err := c.Remove(bson.M{"id": id})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete trusted CA")
+	}
+}
+
+func (db *DataStoreMongo) InsertManifestKey(ctx context.Context, key *model.ManifestKey) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbManifestKeysColl)
+
+	err := c.EnsureIndex(mgo.Index{
+		Key:    []string{"fingerprint"},
+		Unique: true,
+		Name:   dbManifestKeyIndexName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create manifest keys index")
+	}
+
+	key.ID = bson.NewObjectId().Hex()
+
+	err = c.Insert(key)
+	switch {
+	case err == nil:
+		return nil
+	case mgo.IsDup(err):
+		return store.ErrDuplicate
+	default:
+		return errors.Wrap(err, "failed to insert manifest key")
+	}
+}
+
+func (db *DataStoreMongo) GetManifestKeys(ctx context.Context) ([]model.ManifestKey, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbManifestKeysColl)
+	res := []model.ManifestKey{}
+
+	err := c.Find(nil).Sort("id").All(&res)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch manifest keys")
+	}
+
+	return res, nil
+}
+
+func (db *DataStoreMongo) DeleteManifestKey(ctx context.Context, id string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbManifestKeysColl)
+
+	err := c.Remove(bson.M{"id": id})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete manifest key")
+	}
+}
+
+func (db *DataStoreMongo) InsertManifestRecord(ctx context.Context, rec *model.ManifestRecord) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbManifestsColl)
+
+	err := c.EnsureIndex(mgo.Index{
+		Key:    []string{"id"},
+		Unique: true,
+		Name:   dbManifestIndexName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create manifests index")
+	}
+
+	err = c.Insert(rec)
+	switch {
+	case err == nil:
+		return nil
+	case mgo.IsDup(err):
+		return store.ErrDuplicate
+	default:
+		return errors.Wrap(err, "failed to insert manifest record")
+	}
+}
+
+func (db *DataStoreMongo) GetQuota(ctx context.Context) (*model.Quota, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+	res := model.Quota{}
+
+	err := c.FindId(dbQuotaId).One(&res)
+	switch err {
+	case nil, mgo.ErrNotFound:
+		return &res, nil
+	default:
+		return nil, errors.Wrap(err, "failed to fetch quota")
+	}
+}
+
+func (db *DataStoreMongo) PutQuota(ctx context.Context, quota *model.Quota) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+
+	_, err := c.UpsertId(dbQuotaId, bson.M{"$set": quota})
+	if err != nil {
+		return errors.Wrap(err, "failed to store quota")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) GetIdentitySchema(ctx context.Context) (*model.IdentitySchema, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+	res := model.IdentitySchema{}
+
+	err := c.FindId(dbSchemaId).One(&res)
+	switch err {
+	case nil:
+		return &res, nil
+	case mgo.ErrNotFound:
+		return nil, nil
+	default:
+		return nil, errors.Wrap(err, "failed to fetch identity schema")
+	}
+}
+
+func (db *DataStoreMongo) PutIdentitySchema(ctx context.Context, schema *model.IdentitySchema) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+
+	_, err := c.UpsertId(dbSchemaId, bson.M{"$set": schema})
+	if err != nil {
+		return errors.Wrap(err, "failed to store identity schema")
+	}
+	return nil
+}
+
+func (db *DataStoreMongo) DeleteIdentitySchema(ctx context.Context) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+
+	err := c.RemoveId(dbSchemaId)
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete identity schema")
+	}
+}
+
+func (db *DataStoreMongo) CountAcceptedDevices(ctx context.Context) (int, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbDevicesColl)
+
+	pipe := c.Pipe([]bson.M{
+		{"$match": notDeleted(bson.M{"status": model.DevStatusAccepted})},
+		{"$group": bson.M{"_id": "$deviceid"}},
+		{"$group": bson.M{"_id": nil, "count": bson.M{"$sum": 1}}},
+	})
+
+	var res struct {
+		Count int `bson:"count"`
+	}
+	err := pipe.One(&res)
+	switch err {
+	case nil, mgo.ErrNotFound:
+		return res.Count, nil
+	default:
+		return 0, errors.Wrap(err, "failed to count accepted devices")
+	}
+}
+
+func (db *DataStoreMongo) LockQuota(ctx context.Context, lock string, expires, now time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+
+	// a held lock makes the upsert collide with the existing document
+	_, err := c.Upsert(
+		bson.M{
+			"_id": dbQuotaId,
+			"$or": []bson.M{
+				{"lock": bson.M{"$exists": false}},
+				{"lock_expires_ts": bson.M{"$lte": now}},
+			},
+		},
+		bson.M{"$set": bson.M{
+			"lock":            lock,
+			"lock_expires_ts": expires,
+		}})
+	switch {
+	case err == nil:
+		return nil
+	case mgo.IsDup(err):
+		return store.ErrModified
+	default:
+		return errors.Wrap(err, "failed to lock quota")
+	}
+}
+
+func (db *DataStoreMongo) UnlockQuota(ctx context.Context, lock string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbSettingsColl)
+
+	err := c.Update(
+		bson.M{"_id": dbQuotaId, "lock": lock},
+		bson.M{"$unset": bson.M{
+			"lock":            "",
+			"lock_expires_ts": "",
+		}})
+	if err != nil && err != mgo.ErrNotFound {
+		return errors.Wrap(err, "failed to unlock quota")
+	}
+	return nil
+}
+
+// attempts at updating a rate limit bucket before giving up due to
+// concurrent updates
+const rateLimitMaxAttempts = 5
+
+func (db *DataStoreMongo) TakeRateLimitToken(ctx context.Context, key string, limit model.RateLimit, now time.Time) (time.Duration, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbRateLimitsColl)
+
+	// full buckets are the same as no buckets
+	err := c.EnsureIndex(mgo.Index{
+		Key:         []string{"expires_ts"},
+		ExpireAfter: time.Second,
+		Name:        dbRateLimitExpiryIndexName,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to create rate limits index")
+	}
+
+	// buckets are updated only if not modified in the meantime by
+	// another instance, otherwise the update is retried with fresh state
+	for i := 0; i < rateLimitMaxAttempts; i++ {
+		var bucket model.TokenBucket
+		err := c.FindId(key).One(&bucket)
+		switch err {
+		case nil:
+		case mgo.ErrNotFound:
+			bucket = *model.NewTokenBucket(key, limit, now)
+		default:
+			return 0, errors.Wrap(err, "failed to fetch rate limit bucket")
+		}
+
+		rev := bucket.Rev
+		ok, wait := bucket.Take(limit, now)
+		if !ok {
+			return wait, nil
+		}
+
+		if rev == 0 {
+			err = c.Insert(&bucket)
+			if mgo.IsDup(err) {
+				continue
+			}
+		} else {
+			err = c.Update(bson.M{"_id": key, "rev": rev}, &bucket)
+			if err == mgo.ErrNotFound {
+				continue
+			}
+		}
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to update rate limit bucket")
+		}
+		return 0, nil
+	}
+
+	// heavy contention means the bucket is being drained quickly
+	return limit.Interval, nil
+}
+
+func (db *DataStoreMongo) InsertIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbIdempotencyColl)
+
+	err := c.EnsureIndex(mgo.Index{
+		Key:         []string{"expires_ts"},
+		ExpireAfter: time.Second,
+		Name:        dbIdempotencyExpiryIndexName,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create idempotency keys index")
+	}
+
+	err = c.Insert(req)
+	switch {
+	case err == nil:
+		return nil
+	case mgo.IsDup(err):
+		return store.ErrDuplicate
+	default:
+		return errors.Wrap(err, "failed to insert idempotency key")
+	}
+}
+
+func (db *DataStoreMongo) GetIdempotentRequest(ctx context.Context, key string) (*model.IdempotentRequest, error) {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbIdempotencyColl)
+
+	var req model.IdempotentRequest
+	err := c.FindId(key).One(&req)
+	switch err {
+	case nil:
+		return &req, nil
+	case mgo.ErrNotFound:
+		return nil, store.ErrNotFound
+	default:
+		return nil, errors.Wrap(err, "failed to fetch idempotency key")
+	}
+}
+
+func (db *DataStoreMongo) TakeOverIdempotentRequest(ctx context.Context, req *model.IdempotentRequest, lease string, expires time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbIdempotencyColl)
+
+	err := c.Update(
+		bson.M{
+			"_id":         req.Key,
+			"status_code": 0,
+			"expires_ts":  req.Expires,
+		},
+		bson.M{"$set": bson.M{
+			"lease":      lease,
+			"expires_ts": expires,
+		}})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrModified
+	default:
+		return errors.Wrap(err, "failed to take over idempotency key")
+	}
+}
+
+func (db *DataStoreMongo) RenewIdempotentRequest(ctx context.Context, req *model.IdempotentRequest, expires time.Time) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbIdempotencyColl)
+
+	err := c.Update(
+		bson.M{
+			"_id":         req.Key,
+			"lease":       req.Lease,
+			"status_code": 0,
+		},
+		bson.M{"$set": bson.M{"expires_ts": expires}})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to renew idempotency key")
+	}
+}
+
+func (db *DataStoreMongo) UpdateIdempotentRequest(ctx context.Context, req *model.IdempotentRequest) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbIdempotencyColl)
+
+	err := c.Update(bson.M{"_id": req.Key, "lease": req.Lease}, req)
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to update idempotency key")
+	}
+}
+
+func (db *DataStoreMongo) DeleteIdempotentRequest(ctx context.Context, key, lease string) error {
+	s := db.session.Copy()
+	defer s.Close()
+
+	c := s.DB(ctx_store.DbFromContext(ctx, DbName)).C(DbIdempotencyColl)
+
+	err := c.Remove(bson.M{"_id": key, "lease": lease})
+	switch err {
+	case nil:
+		return nil
+	case mgo.ErrNotFound:
+		return store.ErrNotFound
+	default:
+		return errors.Wrap(err, "failed to delete idempotency key")
+	}
+}
@@ -17,8 +17,10 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"reflect"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -176,6 +178,17 @@ func TestMongoGetDevices(t *testing.T) {
 			},
 			tenant: "acme",
 		},
+		{
+			limit:  5,
+			filter: store.Filter{After: "0002-0003"},
+		},
+		{
+			limit: 5,
+			filter: store.Filter{
+				DeviceID: "devid-0003",
+				After:    "0003-0001",
+			},
+		},
 	}
 
 	// 30 devauths, 6 for every device
@@ -236,10 +249,63 @@ func TestMongoGetDevices(t *testing.T) {
 					assert.Equal(t, tc.filter.DeviceID, d.DeviceId)
 				}
 			}
+			if tc.filter.After != "" {
+				for _, d := range dbdevs {
+					assert.True(t, d.ID > tc.filter.After)
+				}
+			}
 		})
 	}
 }
 
+func TestMongoCountDevices(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoCountDevices in short mode.")
+	}
+
+	ctx := context.Background()
+
+	d := getMigratedDb(t, ctx)
+	defer d.session.Close()
+
+	// 30 devauths, 6 for every device
+	devs := makeDevs(5, 6)
+	devs[0].Status = model.DevStatusAccepted
+	devs[0].Tags = []string{"lab"}
+	err := setUp(ctx, d, devs)
+	assert.NoError(t, err, "failed to setup input data")
+
+	n, err := d.CountDeviceAuths(ctx, store.Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 30, n)
+
+	n, err = d.CountDeviceAuths(ctx, store.Filter{DeviceID: "devid-0001"})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	// paging by cursor does not affect the total
+	n, err = d.CountDeviceAuths(ctx, store.Filter{
+		DeviceID: "devid-0001",
+		After:    "0001-0003",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+
+	n, err = d.CountDeviceAuths(ctx, store.Filter{
+		Status: model.DevStatusAccepted,
+		Tags:   []string{"lab"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	err = d.SoftDeleteDeviceAuth(ctx, devs[0].ID, store.AnyRevision, time.Now())
+	assert.NoError(t, err)
+
+	n, err = d.CountDeviceAuths(ctx, store.Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 29, n)
+}
+
 func TestMongoGetDevice(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping TestMongoGetDevice in short mode.")
@@ -335,6 +401,10 @@ func TestMongoPutDevice(t *testing.T) {
 		assert.NotNil(t, dbdev, "expected to device of ID %s to be found",
 			dev.ID)
 
+		// stored by setUp() without a revision, PutDeviceAuth() bumped it
+		assert.Equal(t, 1, dbdev.Revision)
+		dev.Revision = dbdev.Revision
+
 		// obviously the found device should be identical
 		assert.True(t, reflect.DeepEqual(dev, *dbdev), "expected dev %+v to be equal to %+v",
 			dbdev, dev)
@@ -383,6 +453,25 @@ func TestMongoPutDevice(t *testing.T) {
 	dbdev, err = d.GetDeviceAuth(tenCtx, devs[0].ID)
 	assert.Equal(t, "rejected", dbdev.Status)
 
+	// certificate info is stored along with the status
+	info := &model.CertificateInfo{
+		Subject:   "CN=device",
+		Serial:    "1234",
+		ExpiresAt: time.Now().UTC().Truncate(time.Millisecond),
+		CAID:      "ca-1",
+	}
+	err = d.PutDeviceAuth(tenCtx, &model.DeviceAuth{
+		Status:          "accepted",
+		ID:              devs[0].ID,
+		CertificateInfo: info,
+	})
+	assert.NoError(t, err)
+	dbdev, err = d.GetDeviceAuth(tenCtx, devs[0].ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, dbdev.CertificateInfo) {
+		assert.Equal(t, info.Serial, dbdev.CertificateInfo.Serial)
+		assert.Equal(t, info.CAID, dbdev.CertificateInfo.CAID)
+	}
 }
 
 func TestMongoUpdateDeviceAuth(t *testing.T) {
@@ -404,6 +493,7 @@ func TestMongoUpdateDeviceAuth(t *testing.T) {
 			DeviceIdentity: "foo-1",
 			Key:            "key1",
 			Status:         model.DevStatusPending,
+			Revision:       3,
 		},
 	}
 
@@ -428,6 +518,7 @@ func TestMongoUpdateDeviceAuth(t *testing.T) {
 					DeviceIdentity: "foo-1",
 					Key:            "key1",
 					Status:         model.DevStatusAccepted,
+					Revision:       1,
 				},
 				{
 					ID:             "12",
@@ -435,6 +526,7 @@ func TestMongoUpdateDeviceAuth(t *testing.T) {
 					DeviceIdentity: "foo-1",
 					Key:            "key1",
 					Status:         model.DevStatusPending,
+					Revision:       3,
 				},
 			},
 		},
@@ -452,6 +544,7 @@ func TestMongoUpdateDeviceAuth(t *testing.T) {
 					DeviceIdentity: "foo-1",
 					Key:            "key1",
 					Status:         model.DevStatusAccepted,
+					Revision:       1,
 				},
 				{
 					ID:             "12",
@@ -459,9 +552,61 @@ func TestMongoUpdateDeviceAuth(t *testing.T) {
 					DeviceIdentity: "foo-1",
 					Key:            "key1",
 					Status:         model.DevStatusPending,
+					Revision:       3,
+				},
+			},
+		},
+		"ok, revision": {
+			update: &model.DeviceAuth{
+				ID:       "12",
+				Status:   model.DevStatusAccepted,
+				Revision: 3,
+			},
+
+			out: []model.DeviceAuth{
+				{
+					ID:             "11",
+					DeviceId:       model.DeviceID("1"),
+					DeviceIdentity: "foo-1",
+					Key:            "key1",
+					Status:         model.DevStatusPending,
+				},
+				{
+					ID:             "12",
+					DeviceId:       model.DeviceID("1"),
+					DeviceIdentity: "foo-1",
+					Key:            "key1",
+					Status:         model.DevStatusAccepted,
+					Revision:       4,
 				},
 			},
 		},
+		"error: modified": {
+			update: &model.DeviceAuth{
+				ID:       "12",
+				Status:   model.DevStatusAccepted,
+				Revision: 2,
+			},
+
+			err: store.ErrModified,
+		},
+		"error: modified, no revision": {
+			update: &model.DeviceAuth{
+				ID:     "12",
+				Status: model.DevStatusAccepted,
+			},
+
+			err: store.ErrModified,
+		},
+		"error: not found, revision": {
+			update: &model.DeviceAuth{
+				ID:       "13",
+				Status:   model.DevStatusAccepted,
+				Revision: 2,
+			},
+
+			err: store.ErrNotFound,
+		},
 		"error: not found": {
 			update: &model.DeviceAuth{
 				ID:     "13",
@@ -482,6 +627,7 @@ func TestMongoUpdateDeviceAuth(t *testing.T) {
 					DeviceIdentity: "foo-1",
 					Key:            "key1",
 					Status:         model.DevStatusPending,
+					Revision:       3,
 				},
 			},
 
@@ -913,3 +1059,1451 @@ func TestMongoInsertAndGetDeviceAuthsByIdentityData(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(authSets))
 }
+
+func TestMongoWebhooks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoWebhooks in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	hooks, err := dbstore.GetWebhooks(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, hooks, 0)
+
+	hook := model.Webhook{
+		URL:    "http://example.com/hook",
+		Events: []string{model.WebhookEventStatusChanged},
+		Secret: "secret",
+	}
+	err = dbstore.InsertWebhook(ctx, &hook)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, hook.ID)
+
+	found, err := dbstore.GetWebhook(ctx, hook.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", found.Secret)
+	assert.Equal(t, hook.Events, found.Events)
+
+	_, err = dbstore.GetWebhook(ctx, "missing")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		created := now.Add(time.Duration(i) * time.Second)
+		delivery := model.WebhookDelivery{
+			WebhookID: hook.ID,
+			Event:     model.WebhookEventStatusChanged,
+			AuthID:    model.AuthID(fmt.Sprintf("auth-%d", i)),
+			Status:    model.WebhookDeliveryPending,
+			Created:   &created,
+		}
+		err = dbstore.InsertWebhookDelivery(ctx, &delivery)
+		assert.NoError(t, err)
+
+		delivery.Payload = []byte(`{"id":"` + delivery.ID + `"}`)
+		if i > 0 {
+			delivery.Status = model.WebhookDeliveryDelivered
+			delivery.Attempts = 1
+			delivery.StatusCode = 200
+		}
+		err = dbstore.UpdateWebhookDelivery(ctx, &delivery)
+		assert.NoError(t, err)
+	}
+
+	pending, err := dbstore.GetPendingWebhookDeliveries(ctx, now)
+	assert.NoError(t, err)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, model.AuthID("auth-0"), pending[0].AuthID)
+		assert.Equal(t, `{"id":"`+pending[0].ID+`"}`, string(pending[0].Payload))
+	}
+
+	// a claimed delivery is left alone until the claim expires
+	claimed := pending[0]
+	err = dbstore.ClaimWebhookDelivery(ctx, &claimed, "claim-1", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "claim-1", claimed.Claim)
+
+	pending, err = dbstore.GetPendingWebhookDeliveries(ctx, now)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	err = dbstore.ClaimWebhookDelivery(ctx, &claimed, "claim-2", now.Add(time.Minute), now)
+	assert.Equal(t, store.ErrModified, err)
+	// renewed by the holder
+	err = dbstore.ClaimWebhookDelivery(ctx, &claimed, "claim-1", now.Add(2*time.Minute), now)
+	assert.NoError(t, err)
+
+	later := now.Add(3 * time.Minute)
+	pending, err = dbstore.GetPendingWebhookDeliveries(ctx, later)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 1)
+	err = dbstore.ClaimWebhookDelivery(ctx, &claimed, "claim-2", later.Add(time.Minute), later)
+	assert.NoError(t, err)
+	assert.Equal(t, "claim-2", claimed.Claim)
+
+	// unknown deliveries can't be claimed
+	unknown := model.WebhookDelivery{ID: "missing"}
+	err = dbstore.ClaimWebhookDelivery(ctx, &unknown, "claim-1", now.Add(time.Minute), now)
+	assert.Equal(t, store.ErrModified, err)
+
+	tenants, err := dbstore.GetTenants(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, tenants, "bar")
+
+	err = dbstore.UpdateWebhookDelivery(ctx, &model.WebhookDelivery{ID: "missing"})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	deliveries, err := dbstore.GetWebhookDeliveries(ctx, hook.ID, 0, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, deliveries, 2) {
+		// most recent first
+		assert.Equal(t, model.AuthID("auth-2"), deliveries[0].AuthID)
+		assert.Equal(t, model.AuthID("auth-1"), deliveries[1].AuthID)
+		assert.Equal(t, model.WebhookDeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, 200, deliveries[0].StatusCode)
+	}
+
+	err = dbstore.DeleteWebhook(ctx, hook.ID)
+	assert.NoError(t, err)
+
+	err = dbstore.DeleteWebhook(ctx, hook.ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	deliveries, err = dbstore.GetWebhookDeliveries(ctx, hook.ID, 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 0)
+}
+
+func TestMongoEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoEvents in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+	otherCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "baz",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	last, err := dbstore.GetLastEventID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), last)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 5; i++ {
+		ev := model.Event{
+			Type:      model.EventStatusChanged,
+			Timestamp: now,
+			AuthSet: &model.DeviceAuth{
+				ID:     model.AuthID(fmt.Sprintf("auth-%d", i)),
+				Status: model.DevStatusAccepted,
+			},
+		}
+		err = dbstore.InsertEvent(ctx, &ev)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(i+1), ev.ID)
+	}
+
+	// other tenant has its own sequence
+	ev := model.Event{Type: model.EventAuthSetCreated, Timestamp: now}
+	err = dbstore.InsertEvent(otherCtx, &ev)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), ev.ID)
+
+	last, err = dbstore.GetLastEventID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), last)
+
+	evs, err := dbstore.GetEvents(ctx, 2, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, evs, 2) {
+		assert.Equal(t, int64(3), evs[0].ID)
+		assert.Equal(t, int64(4), evs[1].ID)
+		assert.Equal(t, model.AuthID("auth-2"), evs[0].AuthSet.ID)
+		assert.Equal(t, now, evs[0].Timestamp.UTC())
+	}
+
+	evs, err = dbstore.GetEvents(ctx, 5, 10)
+	assert.NoError(t, err)
+	assert.Len(t, evs, 0)
+}
+
+func TestMongoEventsConcurrentInserts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoEventsConcurrentInserts in short mode.")
+	}
+
+	const count = 50
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ev := model.Event{
+				Type:      model.EventStatusChanged,
+				Timestamp: time.Now(),
+			}
+			assert.NoError(t, dbstore.InsertEvent(ctx, &ev))
+		}()
+	}
+	wg.Wait()
+
+	// each event got its own sequence number, without gaps
+	evs, err := dbstore.GetEvents(ctx, 0, count+1)
+	assert.NoError(t, err)
+	if assert.Len(t, evs, count) {
+		for i, ev := range evs {
+			assert.Equal(t, int64(i+1), ev.ID)
+		}
+	}
+
+	last, err := dbstore.GetLastEventID(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(count), last)
+}
+
+func TestMongoSettings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoSettings in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	settings, err := dbstore.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TenantSettings{}, settings)
+
+	err = dbstore.PutSettings(ctx, &model.TenantSettings{RequiredApprovals: 2})
+	assert.NoError(t, err)
+
+	settings, err = dbstore.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, settings.RequiredApprovals)
+
+	err = dbstore.PutSettings(ctx, &model.TenantSettings{RequiredApprovals: 3})
+	assert.NoError(t, err)
+
+	settings, err = dbstore.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, settings.RequiredApprovals)
+
+	transitions := model.Transitions{
+		model.DevStatusPending: {model.DevStatusAccepted},
+	}
+	err = dbstore.PutSettings(ctx, &model.TenantSettings{Transitions: transitions})
+	assert.NoError(t, err)
+
+	settings, err = dbstore.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, transitions, settings.Transitions)
+
+	// updates without transitions restore the default table
+	err = dbstore.PutSettings(ctx, &model.TenantSettings{RequiredApprovals: 2})
+	assert.NoError(t, err)
+
+	settings, err = dbstore.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, settings.Transitions)
+	assert.Equal(t, model.DefaultTransitions, settings.GetTransitions())
+}
+
+func TestMongoApprovals(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoApprovals in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	approvals := []model.Approval{
+		{AuthID: "auth1", Approver: "alice"},
+		{AuthID: "auth1", Approver: "bob"},
+		{AuthID: "auth2", Approver: "alice"},
+	}
+	for i := range approvals {
+		err := dbstore.InsertApproval(ctx, &approvals[i])
+		assert.NoError(t, err)
+		assert.NotEmpty(t, approvals[i].ID)
+	}
+
+	err := dbstore.InsertApproval(ctx, &model.Approval{
+		AuthID:   "auth1",
+		Approver: "bob",
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	all, err := dbstore.GetApprovals(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	auth1, err := dbstore.GetApprovals(ctx, "auth1")
+	assert.NoError(t, err)
+	assert.Len(t, auth1, 2)
+
+	found, err := dbstore.GetApproval(ctx, approvals[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", found.Approver)
+
+	err = dbstore.DeleteApproval(ctx, approvals[1].ID)
+	assert.NoError(t, err)
+
+	_, err = dbstore.GetApproval(ctx, approvals[1].ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = dbstore.DeleteApproval(ctx, approvals[1].ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = dbstore.DeleteApprovalsByAuthSet(ctx, "auth1")
+	assert.NoError(t, err)
+
+	all, err = dbstore.GetApprovals(ctx, "")
+	assert.NoError(t, err)
+	if assert.Len(t, all, 1) {
+		assert.Equal(t, model.AuthID("auth2"), all[0].AuthID)
+	}
+}
+
+func TestMongoBlocklist(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoBlocklist in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	entries := []model.BlocklistEntry{
+		{
+			Attributes: model.DeviceAuthAttributes{"sn": "0001"},
+			Reason:     "stolen",
+		},
+		{
+			KeyFingerprint: model.KeyFingerprint("key"),
+		},
+	}
+	for i := range entries {
+		err := dbstore.InsertBlocklistEntry(ctx, &entries[i])
+		assert.NoError(t, err)
+		assert.NotEmpty(t, entries[i].ID)
+	}
+
+	found, err := dbstore.GetBlocklist(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	err = dbstore.DeleteBlocklistEntry(ctx, entries[0].ID)
+	assert.NoError(t, err)
+
+	err = dbstore.DeleteBlocklistEntry(ctx, entries[0].ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	found, err = dbstore.GetBlocklist(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, entries[1].KeyFingerprint, found[0].KeyFingerprint)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		created := now.Add(time.Duration(i) * time.Second)
+		hit := model.BlocklistHit{
+			EntryID: entries[1].ID,
+			AuthID:  model.AuthID(fmt.Sprintf("auth-%d", i)),
+			Created: &created,
+		}
+		err = dbstore.InsertBlocklistHit(ctx, &hit)
+		assert.NoError(t, err)
+	}
+
+	hits, err := dbstore.GetBlocklistHits(ctx, 1, 5)
+	assert.NoError(t, err)
+	if assert.Len(t, hits, 2) {
+		assert.Equal(t, model.AuthID("auth-1"), hits[0].AuthID)
+		assert.Equal(t, model.AuthID("auth-0"), hits[1].AuthID)
+	}
+}
+
+func TestMongoAllowlist(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoAllowlist in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	entries := []model.AllowlistEntry{
+		{
+			Batch:      "batch1",
+			Attributes: model.DeviceAuthAttributes{"sn": "0001", "mac": "00:01"},
+			SingleUse:  true,
+		},
+		{
+			Batch:      "batch1",
+			Attributes: model.DeviceAuthAttributes{"sn": "0002"},
+			SingleUse:  true,
+		},
+		{
+			Batch:      "batch2",
+			Attributes: model.DeviceAuthAttributes{"sn": "0002", "mac": "00:02"},
+		},
+	}
+	err := dbstore.InsertAllowlistEntries(ctx, entries)
+	assert.NoError(t, err)
+	for _, e := range entries {
+		assert.NotEmpty(t, e.ID)
+	}
+
+	found, err := dbstore.GetAllowlist(ctx, "batch1", 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	found, err = dbstore.GetAllowlist(ctx, "", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	// entries need all their attributes to match
+	found, err = dbstore.FindAllowlistEntries(ctx,
+		model.DeviceAuthAttributes{"sn": "0001", "foo": "bar"}, "auth1")
+	assert.NoError(t, err)
+	assert.Len(t, found, 0)
+
+	found, err = dbstore.FindAllowlistEntries(ctx,
+		model.DeviceAuthAttributes{"sn": "0002", "mac": "00:02"}, "auth1")
+	assert.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, entries[1].ID, found[0].ID)
+		assert.Equal(t, entries[2].ID, found[1].ID)
+	}
+
+	// single use entries admit one auth set only
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	err = dbstore.UseAllowlistEntry(ctx, entries[1].ID, "auth1", now)
+	assert.NoError(t, err)
+	err = dbstore.UseAllowlistEntry(ctx, entries[1].ID, "auth1", now)
+	assert.NoError(t, err)
+	err = dbstore.UseAllowlistEntry(ctx, entries[1].ID, "auth2", now)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	found, err = dbstore.FindAllowlistEntries(ctx,
+		model.DeviceAuthAttributes{"sn": "0002"}, "auth1")
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, model.AuthID("auth1"), found[0].UsedBy)
+		assert.Equal(t, now, found[0].UsedAt.UTC())
+	}
+
+	found, err = dbstore.FindAllowlistEntries(ctx,
+		model.DeviceAuthAttributes{"sn": "0002"}, "auth2")
+	assert.NoError(t, err)
+	assert.Len(t, found, 0)
+
+	err = dbstore.DeleteAllowlistEntry(ctx, entries[0].ID)
+	assert.NoError(t, err)
+
+	err = dbstore.DeleteAllowlistEntry(ctx, entries[0].ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = dbstore.UseAllowlistEntry(ctx, entries[0].ID, "auth1", now)
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestMongoEnrollmentTokens(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoEnrollmentTokens in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	expired := now.Add(-time.Hour)
+
+	tokens := []model.EnrollmentToken{
+		{
+			Hash:     model.HashEnrollmentToken("twice"),
+			MaxUses:  2,
+			UsesLeft: 2,
+		},
+		{
+			Hash:      model.HashEnrollmentToken("expired"),
+			MaxUses:   1,
+			UsesLeft:  1,
+			ExpiresAt: &expired,
+		},
+		{
+			Hash:     model.HashEnrollmentToken("revoked"),
+			MaxUses:  1,
+			UsesLeft: 1,
+		},
+	}
+	for i := range tokens {
+		err := dbstore.InsertEnrollmentToken(ctx, &tokens[i])
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tokens[i].ID)
+	}
+
+	// digests are unique
+	err := dbstore.InsertEnrollmentToken(ctx, &model.EnrollmentToken{
+		Hash: model.HashEnrollmentToken("twice"),
+	})
+	assert.Error(t, err)
+
+	err = dbstore.RevokeEnrollmentToken(ctx, tokens[2].ID)
+	assert.NoError(t, err)
+	err = dbstore.RevokeEnrollmentToken(ctx, "foo")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	for _, left := range []int{1, 0} {
+		token, err := dbstore.UseEnrollmentToken(ctx, tokens[0].Hash, now)
+		assert.NoError(t, err)
+		if assert.NotNil(t, token) {
+			assert.Equal(t, left, token.UsesLeft)
+		}
+	}
+
+	for _, hash := range []string{
+		tokens[0].Hash, tokens[1].Hash, tokens[2].Hash,
+		model.HashEnrollmentToken("unknown"),
+	} {
+		_, err := dbstore.UseEnrollmentToken(ctx, hash, now)
+		assert.Equal(t, store.ErrNotFound, err)
+	}
+
+	// a released use can be taken again
+	err = dbstore.ReleaseEnrollmentToken(ctx, tokens[0].ID)
+	assert.NoError(t, err)
+	token, err := dbstore.UseEnrollmentToken(ctx, tokens[0].Hash, now)
+	assert.NoError(t, err)
+	if assert.NotNil(t, token) {
+		assert.Equal(t, 0, token.UsesLeft)
+	}
+	err = dbstore.ReleaseEnrollmentToken(ctx, "foo")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	found, err := dbstore.GetEnrollmentTokens(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 3) {
+		assert.Equal(t, 0, found[0].UsesLeft)
+		assert.True(t, found[2].Revoked)
+	}
+}
+
+func TestMongoTrustedCAs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoTrustedCAs in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	cas := []model.TrustedCA{
+		{
+			Certificate: "ca1",
+			Subject:     "CN=ca1",
+			Fingerprint: "0001",
+			ExpiresAt:   now,
+		},
+		{
+			Certificate: "ca2",
+			Subject:     "CN=ca2",
+			Fingerprint: "0002",
+			ExpiresAt:   now,
+		},
+	}
+	for i := range cas {
+		err := dbstore.InsertTrustedCA(ctx, &cas[i])
+		assert.NoError(t, err)
+		assert.NotEmpty(t, cas[i].ID)
+	}
+
+	err := dbstore.InsertTrustedCA(ctx, &model.TrustedCA{
+		Certificate: "ca1",
+		Fingerprint: "0001",
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	found, err := dbstore.GetTrustedCAs(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 2) {
+		assert.Equal(t, cas[0].Fingerprint, found[0].Fingerprint)
+		assert.Equal(t, cas[0].Certificate, found[0].Certificate)
+		assert.True(t, cas[0].ExpiresAt.Equal(found[0].ExpiresAt))
+	}
+
+	err = dbstore.DeleteTrustedCA(ctx, cas[0].ID)
+	assert.NoError(t, err)
+	err = dbstore.DeleteTrustedCA(ctx, cas[0].ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	found, err = dbstore.GetTrustedCAs(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, cas[1].ID, found[0].ID)
+	}
+}
+
+func TestMongoManifests(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoManifests in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	keys := []model.ManifestKey{
+		{PublicKey: "key1", Fingerprint: model.KeyFingerprint("key1")},
+		{PublicKey: "key2", Fingerprint: model.KeyFingerprint("key2")},
+	}
+	for i := range keys {
+		err := dbstore.InsertManifestKey(ctx, &keys[i])
+		assert.NoError(t, err)
+		assert.NotEmpty(t, keys[i].ID)
+	}
+
+	err := dbstore.InsertManifestKey(ctx, &model.ManifestKey{
+		PublicKey:   "key1",
+		Fingerprint: model.KeyFingerprint("key1"),
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	err = dbstore.DeleteManifestKey(ctx, keys[0].ID)
+	assert.NoError(t, err)
+	err = dbstore.DeleteManifestKey(ctx, keys[0].ID)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	found, err := dbstore.GetManifestKeys(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, keys[1].Fingerprint, found[0].Fingerprint)
+	}
+
+	rec := model.ManifestRecord{ID: "manifest-1", KeyID: keys[1].ID, Devices: 2}
+	err = dbstore.InsertManifestRecord(ctx, &rec)
+	assert.NoError(t, err)
+	err = dbstore.InsertManifestRecord(ctx, &rec)
+	assert.Equal(t, store.ErrDuplicate, err)
+}
+
+func TestMongoQuota(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoQuota in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	quota, err := dbstore.GetQuota(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Quota{}, quota)
+
+	err = dbstore.PutQuota(ctx, &model.Quota{MaxDevices: 10})
+	assert.NoError(t, err)
+
+	quota, err = dbstore.GetQuota(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, quota.MaxDevices)
+
+	// quota does not interfere with settings
+	settings, err := dbstore.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TenantSettings{}, settings)
+
+	count, err := dbstore.CountAcceptedDevices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	for _, dev := range []model.DeviceAuth{
+		{ID: "1", DeviceId: "dev-1", Status: model.DevStatusAccepted},
+		{ID: "2", DeviceId: "dev-1", Status: model.DevStatusAccepted},
+		{ID: "3", DeviceId: "dev-2", Status: model.DevStatusAccepted},
+		{ID: "4", DeviceId: "dev-2", Status: model.DevStatusPending},
+		{ID: "5", DeviceId: "dev-3", Status: model.DevStatusPending},
+	} {
+		err = dbstore.InsertDeviceAuth(ctx, &dev)
+		assert.NoError(t, err)
+	}
+
+	count, err = dbstore.CountAcceptedDevices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestMongoQuotaLock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoQuotaLock in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// the lock can be taken before any quota is set
+	err := dbstore.LockQuota(ctx, "a", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+
+	err = dbstore.LockQuota(ctx, "b", now.Add(time.Minute), now)
+	assert.Equal(t, store.ErrModified, err)
+
+	// the lock does not interfere with the quota
+	err = dbstore.PutQuota(ctx, &model.Quota{MaxDevices: 10})
+	assert.NoError(t, err)
+	quota, err := dbstore.GetQuota(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Quota{MaxDevices: 10}, quota)
+
+	// only the holder releases the lock
+	err = dbstore.UnlockQuota(ctx, "b")
+	assert.NoError(t, err)
+	err = dbstore.LockQuota(ctx, "b", now.Add(time.Minute), now)
+	assert.Equal(t, store.ErrModified, err)
+
+	err = dbstore.UnlockQuota(ctx, "a")
+	assert.NoError(t, err)
+	err = dbstore.LockQuota(ctx, "b", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+
+	// an expired lock is taken over
+	later := now.Add(2 * time.Minute)
+	err = dbstore.LockQuota(ctx, "c", later.Add(time.Minute), later)
+	assert.NoError(t, err)
+	err = dbstore.UnlockQuota(ctx, "b")
+	assert.NoError(t, err)
+	err = dbstore.LockQuota(ctx, "d", later.Add(time.Minute), later)
+	assert.Equal(t, store.ErrModified, err)
+
+	quota, err = dbstore.GetQuota(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.Quota{MaxDevices: 10}, quota)
+}
+
+func TestMongoTakeRateLimitToken(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoTakeRateLimitToken in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	limit := model.RateLimit{Burst: 2, Interval: time.Minute}
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// full bucket admits a burst
+	for i := 0; i < limit.Burst; i++ {
+		wait, err := dbstore.TakeRateLimitToken(ctx, "foo", limit, now)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+
+	wait, err := dbstore.TakeRateLimitToken(ctx, "foo", limit, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, wait)
+
+	// other buckets are not affected
+	wait, err = dbstore.TakeRateLimitToken(ctx, "bar", limit, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// partially refilled
+	now = now.Add(45 * time.Second)
+	wait, err = dbstore.TakeRateLimitToken(ctx, "foo", limit, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Second, wait)
+
+	now = now.Add(15 * time.Second)
+	wait, err = dbstore.TakeRateLimitToken(ctx, "foo", limit, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), wait)
+
+	wait, err = dbstore.TakeRateLimitToken(ctx, "foo", limit, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, wait)
+}
+
+func TestMongoIdentitySchema(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoIdentitySchema in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	schema, err := dbstore.GetIdentitySchema(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, schema)
+
+	err = dbstore.DeleteIdentitySchema(ctx)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	in := &model.IdentitySchema{
+		Attributes: []model.AttributeSchema{
+			{Name: "mac", Required: true, Pattern: "[0-9a-f:]+"},
+			{Name: "sku", MaxLength: 32},
+		},
+		AllowExtra: true,
+	}
+	err = dbstore.PutIdentitySchema(ctx, in)
+	assert.NoError(t, err)
+
+	schema, err = dbstore.GetIdentitySchema(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, in.Attributes, schema.Attributes)
+	assert.True(t, schema.AllowExtra)
+
+	// schema does not interfere with settings
+	settings, err := dbstore.GetSettings(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, &model.TenantSettings{}, settings)
+
+	err = dbstore.DeleteIdentitySchema(ctx)
+	assert.NoError(t, err)
+
+	schema, err = dbstore.GetIdentitySchema(ctx)
+	assert.NoError(t, err)
+	assert.Nil(t, schema)
+
+	// violation flag follows submissions, and survives status changes
+	dev := model.DeviceAuth{
+		ID:              "1",
+		DeviceId:        "dev-1",
+		Status:          model.DevStatusPending,
+		Attributes:      model.DeviceAuthAttributes{"mac": "foo"},
+		SchemaViolation: true,
+	}
+	err = dbstore.PutDeviceAuth(ctx, &dev)
+	assert.NoError(t, err)
+
+	err = dbstore.PutDeviceAuth(ctx, &model.DeviceAuth{
+		ID:     "1",
+		Status: model.DevStatusRejected,
+	})
+	assert.NoError(t, err)
+
+	out, err := dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.True(t, out.SchemaViolation)
+
+	dev.SchemaViolation = false
+	err = dbstore.PutDeviceAuth(ctx, &dev)
+	assert.NoError(t, err)
+
+	out, err = dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.False(t, out.SchemaViolation)
+}
+
+func TestMongoDeviceAuthTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoDeviceAuthTags in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := context.Background()
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	for _, id := range []model.AuthID{"1", "2", "3"} {
+		err := dbstore.PutDeviceAuth(ctx, &model.DeviceAuth{
+			ID:       id,
+			DeviceId: model.DeviceID("dev-" + id),
+			Status:   model.DevStatusPending,
+		})
+		assert.NoError(t, err)
+	}
+
+	n, err := dbstore.AddDeviceAuthTags(ctx,
+		[]model.AuthID{"1", "2", "missing"}, []string{"lab", "rma"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// adding again does not duplicate tags
+	n, err = dbstore.AddDeviceAuthTags(ctx,
+		[]model.AuthID{"1"}, []string{"lab"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = dbstore.RemoveDeviceAuthTags(ctx,
+		[]model.AuthID{"2"}, []string{"rma"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = dbstore.AddDeviceAuthTags(ctx,
+		[]model.AuthID{"missing"}, []string{"lab"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	out, err := dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lab", "rma"}, out.Tags)
+	// stored, then tagged twice
+	assert.Equal(t, 3, out.Revision)
+
+	// tags survive status changes
+	err = dbstore.PutDeviceAuth(ctx, &model.DeviceAuth{
+		ID:     "1",
+		Status: model.DevStatusAccepted,
+	})
+	assert.NoError(t, err)
+
+	devs, err := dbstore.GetDeviceAuths(ctx, 0, 10,
+		store.Filter{Tags: []string{"lab"}})
+	assert.NoError(t, err)
+	assert.Len(t, devs, 2)
+
+	devs, err = dbstore.GetDeviceAuths(ctx, 0, 10,
+		store.Filter{Tags: []string{"lab", "rma"}})
+	assert.NoError(t, err)
+	if assert.Len(t, devs, 1) {
+		assert.Equal(t, model.AuthID("1"), devs[0].ID)
+		assert.Equal(t, model.DevStatusAccepted, devs[0].Status)
+	}
+
+	devs, err = dbstore.GetDeviceAuths(ctx, 0, 10,
+		store.Filter{Tags: []string{"lab"}, Status: model.DevStatusPending})
+	assert.NoError(t, err)
+	if assert.Len(t, devs, 1) {
+		assert.Equal(t, model.AuthID("2"), devs[0].ID)
+	}
+}
+
+func TestMongoNotes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoNotes in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := context.Background()
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	devs := []model.DeviceAuth{
+		{ID: "1", DeviceId: "dev-1", Status: model.DevStatusPending},
+		{ID: "2", DeviceId: "dev-2", Status: model.DevStatusPending},
+		{ID: "3", DeviceId: "dev-2", Status: model.DevStatusPending},
+	}
+	for i := range devs {
+		err := dbstore.PutDeviceAuth(ctx, &devs[i])
+		assert.NoError(t, err)
+	}
+
+	notes, err := dbstore.GetNotes(ctx, "1")
+	assert.NoError(t, err)
+	assert.Len(t, notes, 0)
+
+	base := time.Unix(1500000000, 0).UTC()
+	for i, authID := range []model.AuthID{"1", "1", "2", "3"} {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		note := model.Note{
+			AuthID:  authID,
+			Author:  "user-1",
+			Text:    fmt.Sprintf("note %d", i),
+			Created: &ts,
+		}
+		err = dbstore.InsertNote(ctx, &note)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, note.ID)
+	}
+
+	notes, err = dbstore.GetNotes(ctx, "1")
+	assert.NoError(t, err)
+	if assert.Len(t, notes, 2) {
+		assert.Equal(t, "note 0", notes[0].Text)
+		assert.Equal(t, "note 1", notes[1].Text)
+		assert.Equal(t, "user-1", notes[0].Author)
+		assert.Equal(t, base, notes[0].Created.UTC())
+	}
+
+	// notes are removed with their auth set
+	err = dbstore.DeleteDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+
+	notes, err = dbstore.GetNotes(ctx, "1")
+	assert.NoError(t, err)
+	assert.Len(t, notes, 0)
+
+	err = dbstore.DeleteDeviceAuthByDevice(ctx, "dev-2")
+	assert.NoError(t, err)
+
+	for _, authID := range []model.AuthID{"2", "3"} {
+		notes, err = dbstore.GetNotes(ctx, authID)
+		assert.NoError(t, err)
+		assert.Len(t, notes, 0)
+	}
+}
+
+func TestMongoTrash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoTrash in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := context.Background()
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	devs := []model.DeviceAuth{
+		{ID: "1", DeviceId: "dev-1", DeviceIdentity: "id-1", Status: model.DevStatusAccepted},
+		{ID: "2", DeviceId: "dev-2", DeviceIdentity: "id-2", Status: model.DevStatusPending},
+		{ID: "3", DeviceId: "dev-2", DeviceIdentity: "id-2", Status: model.DevStatusAccepted},
+	}
+	for i := range devs {
+		err := dbstore.PutDeviceAuth(ctx, &devs[i])
+		assert.NoError(t, err)
+	}
+
+	base := time.Unix(1500000000, 0).UTC()
+
+	// only at the revision given
+	err := dbstore.SoftDeleteDeviceAuth(ctx, "1", 2, base)
+	assert.Equal(t, store.ErrModified, err)
+
+	err = dbstore.SoftDeleteDeviceAuth(ctx, "1", 1, base)
+	assert.NoError(t, err)
+
+	// deleting twice finds nothing
+	err = dbstore.SoftDeleteDeviceAuth(ctx, "1", store.AnyRevision, base)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = dbstore.SoftDeleteDeviceAuthByDevice(ctx, "dev-2", base.Add(time.Hour))
+	assert.NoError(t, err)
+
+	err = dbstore.SoftDeleteDeviceAuthByDevice(ctx, "dev-2", base.Add(time.Hour))
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// deleted auth sets are excluded from normal queries
+	_, err = dbstore.GetDeviceAuth(ctx, "1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	live, err := dbstore.GetDeviceAuths(ctx, 0, 10, store.Filter{})
+	assert.NoError(t, err)
+	assert.Len(t, live, 0)
+
+	byIdentity, err := dbstore.GetDeviceAuthsByIdentityData(ctx, "id-2")
+	assert.NoError(t, err)
+	assert.Len(t, byIdentity, 0)
+
+	count, err := dbstore.CountAcceptedDevices(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	n, err := dbstore.AddDeviceAuthTags(ctx, []model.AuthID{"1"}, []string{"lab"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// trash lists most recently deleted first
+	trash, err := dbstore.GetDeletedDeviceAuths(ctx, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, trash, 3) {
+		assert.Equal(t, model.AuthID("2"), trash[0].ID)
+		assert.Equal(t, model.AuthID("3"), trash[1].ID)
+		assert.Equal(t, model.AuthID("1"), trash[2].ID)
+		assert.Equal(t, base, trash[2].DeletedAt.UTC())
+	}
+
+	dev, err := dbstore.GetDeletedDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.DevStatusAccepted, dev.Status)
+
+	err = dbstore.RestoreDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+
+	err = dbstore.RestoreDeviceAuth(ctx, "1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = dbstore.GetDeletedDeviceAuth(ctx, "1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	dev, err = dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.Nil(t, dev.DeletedAt)
+
+	// resubmission takes an auth set out of the trash
+	err = dbstore.PutDeviceAuth(ctx, &model.DeviceAuth{
+		ID:     "2",
+		Status: model.DevStatusPending,
+	})
+	assert.NoError(t, err)
+
+	_, err = dbstore.GetDeviceAuth(ctx, "2")
+	assert.NoError(t, err)
+
+	// only auth sets deleted before the cutoff are purged, with notes
+	note := model.Note{AuthID: "3", Author: "user-1", Text: "rma"}
+	err = dbstore.InsertNote(ctx, &note)
+	assert.NoError(t, err)
+
+	err = dbstore.SoftDeleteDeviceAuth(ctx, "1", store.AnyRevision, base.Add(2*time.Hour))
+	assert.NoError(t, err)
+
+	n, err = dbstore.PurgeDeletedDeviceAuths(ctx, base.Add(90*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = dbstore.GetDeletedDeviceAuth(ctx, "3")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	notes, err := dbstore.GetNotes(ctx, "3")
+	assert.NoError(t, err)
+	assert.Len(t, notes, 0)
+
+	trash, err = dbstore.GetDeletedDeviceAuths(ctx, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, trash, 1) {
+		assert.Equal(t, model.AuthID("1"), trash[0].ID)
+	}
+
+	n, err = dbstore.PurgeDeletedDeviceAuths(ctx, base)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestMongoIdempotentRequests(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoIdempotentRequests in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "bar",
+	})
+	otherCtx := identity.WithContext(context.Background(), &identity.Identity{
+		Subject: "foo",
+		Tenant:  "baz",
+	})
+
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	_, err := dbstore.GetIdempotentRequest(ctx, "key-1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = dbstore.InsertIdempotentRequest(ctx, &model.IdempotentRequest{
+		Key:     "key-1",
+		Hash:    "hash-1",
+		Lease:   "lease-1",
+		Expires: expires,
+	})
+	assert.NoError(t, err)
+
+	err = dbstore.InsertIdempotentRequest(ctx, &model.IdempotentRequest{
+		Key:     "key-1",
+		Hash:    "hash-2",
+		Expires: expires,
+	})
+	assert.Equal(t, store.ErrDuplicate, err)
+
+	// keys are per tenant
+	err = dbstore.InsertIdempotentRequest(otherCtx, &model.IdempotentRequest{
+		Key:     "key-1",
+		Hash:    "hash-2",
+		Lease:   "lease-2",
+		Expires: expires,
+	})
+	assert.NoError(t, err)
+
+	req, err := dbstore.GetIdempotentRequest(ctx, "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash-1", req.Hash)
+	assert.Equal(t, "lease-1", req.Lease)
+	assert.False(t, req.Done())
+
+	// only the attempt holding the key renews it and records the response
+	err = dbstore.RenewIdempotentRequest(ctx, &model.IdempotentRequest{
+		Key:   "key-1",
+		Lease: "lease-other",
+	}, expires.Add(time.Minute))
+	assert.Equal(t, store.ErrNotFound, err)
+	err = dbstore.RenewIdempotentRequest(ctx, req, expires.Add(time.Minute))
+	assert.NoError(t, err)
+
+	err = dbstore.UpdateIdempotentRequest(ctx, &model.IdempotentRequest{
+		Key:        "key-1",
+		Hash:       "hash-1",
+		Lease:      "lease-other",
+		StatusCode: 201,
+	})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	err = dbstore.UpdateIdempotentRequest(ctx, &model.IdempotentRequest{
+		Key:        "key-1",
+		Hash:       "hash-1",
+		Lease:      "lease-1",
+		StatusCode: 201,
+		Header: http.Header{
+			"Location": []string{"devices/1"},
+		},
+		Body:    []byte(`{"id":"1"}`),
+		Expires: expires.Add(time.Hour),
+	})
+	assert.NoError(t, err)
+
+	req, err = dbstore.GetIdempotentRequest(ctx, "key-1")
+	assert.NoError(t, err)
+	assert.True(t, req.Done())
+	assert.Equal(t, 201, req.StatusCode)
+	assert.Equal(t, "devices/1", req.Header.Get("Location"))
+	assert.Equal(t, []byte(`{"id":"1"}`), req.Body)
+	assert.True(t, expires.Add(time.Hour).Equal(req.Expires))
+
+	req, err = dbstore.GetIdempotentRequest(otherCtx, "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash-2", req.Hash)
+
+	err = dbstore.UpdateIdempotentRequest(ctx, &model.IdempotentRequest{
+		Key:  "key-2",
+		Hash: "hash-1",
+	})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// only a request in progress can be taken over, and only once
+	req, err = dbstore.GetIdempotentRequest(otherCtx, "key-1")
+	assert.NoError(t, err)
+	err = dbstore.TakeOverIdempotentRequest(otherCtx, req, "lease-3", expires.Add(time.Hour))
+	assert.NoError(t, err)
+	err = dbstore.TakeOverIdempotentRequest(otherCtx, req, "lease-4", expires.Add(time.Hour))
+	assert.Equal(t, store.ErrModified, err)
+
+	stale := req
+	req, err = dbstore.GetIdempotentRequest(otherCtx, "key-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "lease-3", req.Lease)
+	assert.True(t, expires.Add(time.Hour).Equal(req.Expires))
+
+	// the attempt which lost the key does not release it
+	err = dbstore.DeleteIdempotentRequest(otherCtx, "key-1", stale.Lease)
+	assert.Equal(t, store.ErrNotFound, err)
+	err = dbstore.DeleteIdempotentRequest(otherCtx, "key-1", "lease-3")
+	assert.NoError(t, err)
+
+	req, err = dbstore.GetIdempotentRequest(ctx, "key-1")
+	assert.NoError(t, err)
+	err = dbstore.TakeOverIdempotentRequest(ctx, req, "lease-5", expires.Add(2*time.Hour))
+	assert.Equal(t, store.ErrModified, err)
+
+	err = dbstore.DeleteIdempotentRequest(ctx, "key-1", "lease-1")
+	assert.NoError(t, err)
+
+	err = dbstore.DeleteIdempotentRequest(ctx, "key-1", "lease-1")
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = dbstore.GetIdempotentRequest(ctx, "key-1")
+	assert.Equal(t, store.ErrNotFound, err)
+}
+
+func TestMongoPatchDeviceAuth(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestMongoPatchDeviceAuth in short mode.")
+	}
+
+	db.Wipe()
+	session := db.Session()
+	defer session.Close()
+
+	ctx := context.Background()
+	dbstore := NewDataStoreMongoWithSession(session)
+
+	err := dbstore.PutDeviceAuth(ctx, &model.DeviceAuth{
+		ID:         "1",
+		DeviceId:   "dev-1",
+		Key:        "key-1",
+		Status:     model.DevStatusPending,
+		Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01"},
+	})
+	assert.NoError(t, err)
+
+	dev, err := dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dev.Revision)
+
+	expires := time.Unix(1600000000, 0).UTC()
+	dev.Attributes["sn"] = "0001"
+	dev.Tags = []string{"a", "b"}
+	dev.ExpiresAt = &expires
+	err = dbstore.PatchDeviceAuth(ctx, dev)
+	assert.NoError(t, err)
+
+	dev, err = dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.DeviceAuthAttributes{"mac": "00:00:00:01", "sn": "0001"},
+		dev.Attributes)
+	assert.Equal(t, []string{"a", "b"}, dev.Tags)
+	assert.True(t, expires.Equal(*dev.ExpiresAt))
+	assert.Equal(t, model.DevStatusPending, dev.Status)
+	assert.Equal(t, "key-1", dev.Key)
+	assert.Equal(t, 2, dev.Revision)
+
+	// stale revision
+	err = dbstore.PatchDeviceAuth(ctx, &model.DeviceAuth{ID: "1", Revision: 1})
+	assert.Equal(t, store.ErrModified, err)
+
+	// empty fields are removed
+	err = dbstore.PatchDeviceAuth(ctx, &model.DeviceAuth{ID: "1", Revision: 2})
+	assert.NoError(t, err)
+
+	dev, err = dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.Empty(t, dev.Attributes)
+	assert.Empty(t, dev.Tags)
+	assert.Nil(t, dev.ExpiresAt)
+	assert.Equal(t, model.DevStatusPending, dev.Status)
+	assert.Equal(t, 3, dev.Revision)
+
+	// a submission by the device replaces corrected attributes, other
+	// patched fields are kept
+	err = dbstore.PatchDeviceAuth(ctx, &model.DeviceAuth{
+		ID:         "1",
+		Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01", "sn": "0001"},
+		Tags:       []string{"a"},
+		Revision:   3,
+	})
+	assert.NoError(t, err)
+	err = dbstore.PutDeviceAuth(ctx, &model.DeviceAuth{
+		ID:         "1",
+		DeviceId:   "dev-1",
+		Key:        "key-1",
+		Status:     model.DevStatusPending,
+		Attributes: model.DeviceAuthAttributes{"mac": "00:00:00:01"},
+	})
+	assert.NoError(t, err)
+
+	dev, err = dbstore.GetDeviceAuth(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, model.DeviceAuthAttributes{"mac": "00:00:00:01"}, dev.Attributes)
+	assert.Equal(t, []string{"a"}, dev.Tags)
+	assert.Equal(t, 5, dev.Revision)
+
+	err = dbstore.PatchDeviceAuth(ctx, &model.DeviceAuth{ID: "2", Revision: 1})
+	assert.Equal(t, store.ErrNotFound, err)
+}
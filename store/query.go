@@ -24,4 +24,9 @@ type Filter struct {
 	DeviceID model.DeviceID
 	// List auth sets with this status
 	Status string
+	// List auth sets having all of these tags
+	Tags []string
+	// List auth sets with IDs sorting after this one, for paging by
+	// cursor
+	After model.AuthID
 }
@@ -17,6 +17,7 @@ import "time"
 
 type Clock interface {
 	Now() time.Time
+	After(d time.Duration) <-chan time.Time
 }
 
 func NewClock() Clock {
@@ -28,3 +29,7 @@ type clock struct{}
 func (c *clock) Now() time.Time {
 	return time.Now()
 }
+
+func (c *clock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
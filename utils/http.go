@@ -43,14 +43,15 @@ const (
 
 //dev status constants
 const (
-	StatusName     = "status"
-	StatusPending  = "pending"
-	StatusRejected = "rejected"
-	StatusAccepted = "accepted"
-	StatusPreauth  = "preauthorized"
+	StatusName      = "status"
+	StatusPending   = "pending"
+	StatusRejected  = "rejected"
+	StatusAccepted  = "accepted"
+	StatusPreauth   = "preauthorized"
+	StatusSuspended = "suspended"
 )
 
-var DevStatuses = []string{StatusPending, StatusRejected, StatusAccepted, StatusPreauth}
+var DevStatuses = []string{StatusPending, StatusRejected, StatusAccepted, StatusPreauth, StatusSuspended}
 
 //error msgs
 func MsgQueryParmInvalid(name string) string {
@@ -110,6 +111,25 @@ func ParseQueryParmStr(r *rest.Request, name string, required bool, allowed []st
 	return val, nil
 }
 
+func ParseQueryParmBool(r *rest.Request, name string, required bool, def bool) (bool, error) {
+	strVal := r.URL.Query().Get(name)
+
+	if strVal == "" {
+		if required {
+			return false, errors.New(MsgQueryParmMissing(name))
+		} else {
+			return def, nil
+		}
+	}
+
+	boolVal, err := strconv.ParseBool(strVal)
+	if err != nil {
+		return false, errors.New(MsgQueryParmInvalid(name))
+	}
+
+	return boolVal, nil
+}
+
 //pagination helpers
 func ParsePagination(r *rest.Request) (uint64, uint64, error) {
 	page, err := ParseQueryParmUInt(r, PageName, false, PageMin, math.MaxUint64, PageDefault)
@@ -137,6 +137,36 @@ func TestParseQueryParmStrNotOneOf(t *testing.T) {
 	assert.NotNil(t, err)
 }
 
+func TestParseQueryParmBool(t *testing.T) {
+	url := "https://localhost:8080/resource?test=true"
+	req := mockRequest(url, true)
+	val, err := ParseQueryParmBool(req, "test", true, false)
+	assert.Nil(t, err)
+	assert.Equal(t, true, val)
+}
+
+func TestParseQueryParmBoolMissing(t *testing.T) {
+	url := "https://localhost:8080/resource"
+	req := mockRequest(url, true)
+	_, err := ParseQueryParmBool(req, "test", true, false)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryParmBoolInvalid(t *testing.T) {
+	url := "https://localhost:8080/resource?test=asdf"
+	req := mockRequest(url, true)
+	_, err := ParseQueryParmBool(req, "test", true, false)
+	assert.NotNil(t, err)
+}
+
+func TestParseQueryParmBoolDefault(t *testing.T) {
+	url := "https://localhost:8080/resource"
+	req := mockRequest(url, true)
+	val, err := ParseQueryParmBool(req, "test", false, true)
+	assert.Nil(t, err)
+	assert.Equal(t, true, val)
+}
+
 func TestParsePagination(t *testing.T) {
 	url := "https://localhost:8080/resource"
 	req := mockPageRequest(url, "1", "10")